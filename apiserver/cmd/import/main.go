@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ImportConfig struct {
+	server   string // API server URL
+	file     string // JSON or GraphML app document
+	format   string // json or graphml, from file extension by default
+	app      string // app id overriding the document app id
+	org      string // tenant org, the API key org by default
+	group    string // tenant group, the default group by default
+	dryRun   bool   // report changes without writing them
+	insecure bool   // skip TLS server cert verification
+	apiKey   string // API key of ingest scope
+	header   string // request header carrying the API key
+}
+
+const (
+	DEFAULT_SERVER      = "https://localhost:8443"
+	DEFAULT_TIMEOUT     = 60 * time.Second
+	DEFAULT_AUTH_HEADER = "Authorization"
+	API_KEY_ENV         = "ZENTARIS_API_KEY"
+)
+
+var (
+	importCfg *ImportConfig
+)
+
+// parse command-line arguments
+func parseCmdLine() {
+	importCfg = &ImportConfig{}
+	flag.StringVar(&importCfg.server, "server", DEFAULT_SERVER, "API Server URL")
+	flag.StringVar(&importCfg.file, "file", "", "JSON or GraphML App File")
+	flag.StringVar(&importCfg.format, "format", "", "Import Format, json or graphml")
+	flag.StringVar(&importCfg.app, "app", "", "App ID")
+	flag.StringVar(&importCfg.org, "org", "", "Tenant Org")
+	flag.StringVar(&importCfg.group, "group", "", "Tenant Group")
+	flag.BoolVar(&importCfg.dryRun, "dryRun", false, "Report Changes without Importing")
+	flag.BoolVar(&importCfg.insecure, "insecure", false, "Skip TLS Certificate Verification")
+	flag.StringVar(&importCfg.apiKey, "apiKey", os.Getenv(API_KEY_ENV), "API Key, default from "+API_KEY_ENV)
+	flag.StringVar(&importCfg.header, "authHeader", DEFAULT_AUTH_HEADER, "API Key Request Header")
+	flag.Parse()
+}
+
+// importApp posts the app document to the API server, and prints its report
+func importApp() error {
+	data, err := os.ReadFile(importCfg.file)
+	if err != nil {
+		return err
+	}
+	format := importCfg.format
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(importCfg.file)), ".")
+	}
+
+	query := url.Values{}
+	query.Set("format", format)
+	if importCfg.app != "" {
+		query.Set("app", importCfg.app)
+	}
+	if importCfg.org != "" {
+		query.Set("org", importCfg.org)
+	}
+	if importCfg.group != "" {
+		query.Set("group", importCfg.group)
+	}
+	if importCfg.dryRun {
+		query.Set("dryRun", "true")
+	}
+	client := &http.Client{
+		Timeout: DEFAULT_TIMEOUT,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: importCfg.insecure},
+		},
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(importCfg.server, "/")+"/v1/import?"+query.Encode(),
+		bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if importCfg.apiKey != "" {
+		key := importCfg.apiKey
+		if strings.EqualFold(importCfg.header, DEFAULT_AUTH_HEADER) {
+			key = "Bearer " + key
+		}
+		req.Header.Set(importCfg.header, key)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// pretty print JSON report, or print error as is
+	var out bytes.Buffer
+	if json.Indent(&out, body, "", "  ") != nil {
+		out.Reset()
+		out.Write(body)
+	}
+	fmt.Println(strings.TrimSpace(out.String()))
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("import failed: %v", resp.Status)
+	}
+	return nil
+}
+
+// main
+func main() {
+	// parse command-line
+	parseCmdLine()
+	if importCfg.file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := importApp(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -5,20 +5,38 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/zetafence/zentaris/apiserver/internal/server/auth"
 	"github.com/zetafence/zentaris/apiserver/internal/server/handler"
+	"github.com/zetafence/zentaris/apiserver/internal/server/notify"
+	"github.com/zetafence/zentaris/apiserver/internal/server/rpc"
 )
 
 type ServerConfig struct {
 	httpsPort, grpcPort int    // HTTPS, gRPC ports
 	cert, key           string // TLS server cert and keys
+	attackBundle        string // MITRE ATT&CK STIX bundle
+	cvssFile, epssFile  string // CVSS vectors and EPSS scores
+	kevFile             string // CISA known exploited vulnerabilities
+	cloudTrail          string // CloudTrail logs directory
+	notify              notify.Config
+	authHeader          string // request header carrying API keys
+	adminKeyFile        string // bootstrap admin API key
 }
 
 const (
 	DEFAULT_HTTPS_PORT    = 8443
+	DEFAULT_GRPC_PORT     = 8444
 	DEFAULT_TLS_CERT_PATH = "/etc/certs/server.crt"
 	DEFAULT_TLS_KEY_PATH  = "/etc/certs/server.key"
 	DEFAULT_AUTH_HEADER   = "Authorization"
+	DEFAULT_ATTACK_BUNDLE = "/etc/zentaris/enterprise-attack.json"
+	DEFAULT_CLOUDTRAIL    = "/var/log/cloudtrail"
+	DEFAULT_SYSLOG_PROTO  = "udp"
+	DEFAULT_ADMIN_KEY     = "/etc/zentaris/admin.key"
 )
 
 var (
@@ -29,14 +47,73 @@ var (
 func parseCmdLine() {
 	serverCfg = &ServerConfig{}
 	flag.IntVar(&serverCfg.httpsPort, "httpsPort", DEFAULT_HTTPS_PORT, "HTTPS Port")
+	flag.IntVar(&serverCfg.grpcPort, "grpcPort", DEFAULT_GRPC_PORT, "gRPC Port, 0 to disable")
 	flag.StringVar(&serverCfg.cert, "cert", DEFAULT_TLS_CERT_PATH, "TLS Server Certificate")
 	flag.StringVar(&serverCfg.key, "key", DEFAULT_TLS_KEY_PATH, "TLS Key")
+	flag.StringVar(&serverCfg.attackBundle, "attackBundle", DEFAULT_ATTACK_BUNDLE, "MITRE ATT&CK STIX Bundle")
+	flag.StringVar(&serverCfg.cvssFile, "cvssFile", "", "CVSS Vectors CSV File")
+	flag.StringVar(&serverCfg.epssFile, "epssFile", "", "FIRST EPSS CSV File")
+	flag.StringVar(&serverCfg.kevFile, "kevFile", "", "CISA Known Exploited Vulnerabilities JSON File")
+	flag.StringVar(&serverCfg.cloudTrail, "cloudTrail", DEFAULT_CLOUDTRAIL, "CloudTrail Logs Directory")
+	flag.StringVar(&serverCfg.notify.Severity, "notifySeverity", notify.DEFAULT_SEVERITY, "Minimum Risk of Forwarded Findings")
+	flag.StringVar(&serverCfg.notify.SyslogAddr, "syslogAddr", "", "Syslog CEF Receiver host:port")
+	flag.StringVar(&serverCfg.notify.SyslogProto, "syslogProto", DEFAULT_SYSLOG_PROTO, "Syslog Protocol, udp, tcp or tls")
+	flag.StringVar(&serverCfg.notify.WebhookURL, "webhookURL", "", "Findings Webhook URL")
+	flag.StringVar(&serverCfg.notify.WebhookSecret, "webhookSecret", "", "Webhook HMAC Signing Secret")
+	flag.BoolVar(&serverCfg.notify.Insecure, "notifyInsecure", false, "Skip TLS Verification of Syslog and Webhook Receivers")
+	flag.StringVar(&serverCfg.authHeader, "authHeader", DEFAULT_AUTH_HEADER, "API Key Request Header")
+	flag.StringVar(&serverCfg.adminKeyFile, "adminKeyFile", DEFAULT_ADMIN_KEY, "Admin API Key File, generated if missing")
 	flag.Parse()
 }
 
+// loadAdminKey reads the admin API key, generating it on first start
+func loadAdminKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	key, err := auth.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return "", err
+	}
+	fmt.Printf("generated admin API key in %v\n", path)
+	return key, nil
+}
+
 // HandlerMain registers REST handlers
 func HandlerMain() {
-	r := handler.RegisterHandlers()
+	adminKey, err := loadAdminKey(serverCfg.adminKeyFile)
+	if err != nil {
+		log.Fatalf("unable to load admin API key: %v", err)
+	}
+	r := handler.RegisterHandlers(handler.Config{
+		AttackBundle: serverCfg.attackBundle,
+		CVSSFile:     serverCfg.cvssFile,
+		EPSSFile:     serverCfg.epssFile,
+		KEVFile:      serverCfg.kevFile,
+		CloudTrail:   serverCfg.cloudTrail,
+		Notify:       serverCfg.notify,
+		AuthHeader:   serverCfg.authHeader,
+		AdminKey:     adminKey,
+	})
+
+	// start TLS gRPC service, transcoding calls onto REST handlers
+	if serverCfg.grpcPort != 0 {
+		go func() {
+			fmt.Printf("starting gRPC service on :%v\n", serverCfg.grpcPort)
+			log.Fatal(http.ListenAndServeTLS(fmt.Sprintf(":%d", serverCfg.grpcPort),
+				serverCfg.cert, serverCfg.key, rpc.NewServer(r)))
+		}()
+	}
 
 	// start TLS REST service
 	fmt.Printf("starting HTTPS service on :%v\n", serverCfg.httpsPort)
@@ -0,0 +1,448 @@
+package activity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+const (
+	// entity attributes carrying recent activity
+	ATTR_LAST_USED                  = "LastUsed"
+	ATTR_ACTIVITY_EVENTS            = "ActivityEvents"
+	ATTR_FAILED_LOGINS              = "FailedLogins"
+	ATTR_CONSOLE_LOGINS             = "ConsoleLogins"
+	ATTR_CONSOLE_LOGINS_WITHOUT_MFA = "ConsoleLoginsWithoutMFA"
+	ATTR_ACCESS_DENIED              = "AccessDenied"
+	ATTR_ACCESS_DENIED_BURSTS       = "AccessDeniedBursts"
+	ATTR_SOURCE_IPS                 = "SourceIPs"
+	ATTR_UNUSUAL_SOURCE_IPS         = "UnusualSourceIPs"
+	ATTR_MONITORED                  = "Monitored"
+
+	// monitored by ingested CloudTrail logs
+	MONITORED_CLOUDTRAIL = "cloudtrail"
+
+	// last used value of principals without activity over the dormancy period
+	LAST_USED_NEVER = "never"
+
+	// principals unused for longer are dormant
+	DORMANT_DAYS = 90
+
+	// access denied errors within the window make a burst
+	ACCESS_DENIED_BURST        = 10
+	ACCESS_DENIED_BURST_WINDOW = 5 * time.Minute
+
+	// source IPs below the share of a principal's events are unusual, once
+	// the principal has a baseline of events
+	UNUSUAL_IP_SHARE    = 0.05
+	MIN_BASELINE_EVENTS = 20
+)
+
+// entity attributes identifying principals and resources in CloudTrail
+var identityAttributes = []string{"Arn", "UserName", "RoleName", "AccessKeyId", "AccessKeys", "InstanceId", "BucketName"}
+
+// NewActivity returns a new Activity object reading logs under logDir
+func NewActivity(db db.Db, logDir string) *Activity {
+	return &Activity{
+		db:     db,
+		logDir: logDir,
+	}
+}
+
+// isPrincipal returns true for user and role entities
+func isPrincipal(e graph.Entity) bool {
+	return strings.Contains(e.Kind, "user") || strings.Contains(e.Kind, "role")
+}
+
+// indexEntities maps principal and resource identifiers onto entity ids
+func indexEntities(h *graph.Hypergraph) map[string]string {
+	ids := make([]string, 0, len(h.Entities))
+	for id := range h.Entities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	index := map[string]string{}
+	add := func(key, eid string) {
+		key = strings.TrimSpace(key)
+		if _, ok := index[key]; key != "" && !ok {
+			index[key] = eid
+		}
+	}
+	for _, id := range ids {
+		add(id, id)
+	}
+	for _, id := range ids {
+		e := h.Entities[id]
+		add(e.Name, id)
+		for _, attr := range identityAttributes {
+			for _, v := range strings.Split(e.Attributes[attr], ",") {
+				add(v, id)
+			}
+		}
+	}
+	return index
+}
+
+// matchEntities returns distinct entity ids matching any of the keys
+func matchEntities(index map[string]string, keys []string) []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, key := range keys {
+		if eid, ok := index[key]; ok && !seen[eid] {
+			seen[eid] = true
+			ids = append(ids, eid)
+		}
+	}
+	return ids
+}
+
+// tracker accumulates activity of one entity
+type tracker struct {
+	summary Summary
+	last    time.Time
+	denied  []time.Time
+	ips     map[string]int
+}
+
+// add accounts a record onto the entity activity
+func (t *tracker) add(rec Record, ts time.Time, principal bool) {
+	t.summary.Events++
+	if ts.After(t.last) {
+		t.last = ts
+	}
+	if rec.isAccessDenied() {
+		t.summary.AccessDenied++
+		t.denied = append(t.denied, ts)
+	}
+	if !principal {
+		return
+	}
+	if rec.isConsoleLogin() {
+		t.summary.ConsoleLogins++
+	}
+	if rec.isFailedLogin() {
+		t.summary.FailedLogins++
+	}
+	if rec.isLoginWithoutMFA() {
+		t.summary.ConsoleLoginsWithoutMFA++
+	}
+	// AWS services show up as host names instead of IPs
+	if net.ParseIP(rec.SourceIPAddress) != nil {
+		t.ips[rec.SourceIPAddress]++
+	}
+}
+
+// bursts counts non-overlapping windows with a burst of access denied errors
+func bursts(denied []time.Time) int {
+	sort.Slice(denied, func(i, j int) bool { return denied[i].Before(denied[j]) })
+	count := 0
+	for start, end := 0, 0; end < len(denied); end++ {
+		for denied[end].Sub(denied[start]) > ACCESS_DENIED_BURST_WINDOW {
+			start++
+		}
+		if end-start+1 >= ACCESS_DENIED_BURST {
+			count++
+			start = end + 1
+		}
+	}
+	return count
+}
+
+// finish completes the entity activity summary
+func (t *tracker) finish() Summary {
+	s := t.summary
+	s.LastUsed = t.last.UTC().Format(time.RFC3339)
+	s.AccessDeniedBursts = bursts(t.denied)
+	s.SourceIPs = []string{}
+	s.UnusualSourceIPs = []string{}
+	total := 0
+	for _, n := range t.ips {
+		total += n
+	}
+	for ip, n := range t.ips {
+		s.SourceIPs = append(s.SourceIPs, ip)
+		if total >= MIN_BASELINE_EVENTS && float64(n) < UNUSUAL_IP_SHARE*float64(total) {
+			s.UnusualSourceIPs = append(s.UnusualSourceIPs, ip)
+		}
+	}
+	sort.Strings(s.SourceIPs)
+	sort.Strings(s.UnusualSourceIPs)
+	return s
+}
+
+// Summarize summarizes activity of CloudTrail records onto the principals and
+// resources of a hypergraph
+func Summarize(h *graph.Hypergraph, records []Record) IngestResult {
+	var (
+		index    = indexEntities(h)
+		trackers = map[string]*tracker{}
+		from, to time.Time
+		result   = IngestResult{App: h.AppID, Records: len(records), Entities: []Summary{}}
+	)
+	track := func(eid string) *tracker {
+		t, ok := trackers[eid]
+		if !ok {
+			t = &tracker{summary: Summary{Entity: eid}, ips: map[string]int{}}
+			trackers[eid] = t
+		}
+		return t
+	}
+
+	for _, rec := range records {
+		ts, err := time.Parse(time.RFC3339, rec.EventTime)
+		if err != nil {
+			result.Unmatched++
+			continue
+		}
+		if from.IsZero() || ts.Before(from) {
+			from = ts
+		}
+		if ts.After(to) {
+			to = ts
+		}
+		principals := matchEntities(index, rec.principalKeys())
+		resources := matchEntities(index, rec.resourceKeys())
+		if len(principals) == 0 && len(resources) == 0 {
+			result.Unmatched++
+			continue
+		}
+		for _, eid := range principals {
+			track(eid).add(rec, ts, true)
+		}
+		for _, eid := range resources {
+			track(eid).add(rec, ts, false)
+		}
+	}
+
+	ids := make([]string, 0, len(trackers))
+	for eid := range trackers {
+		ids = append(ids, eid)
+	}
+	sort.Strings(ids)
+	for _, eid := range ids {
+		result.Entities = append(result.Entities, trackers[eid].finish())
+	}
+	if !from.IsZero() {
+		result.From = from.UTC().Format(time.RFC3339)
+		result.To = to.UTC().Format(time.RFC3339)
+	}
+	return result
+}
+
+// setCount sets a count attribute, dropping zero counts
+func setCount(attrs map[string]string, attr string, n int) {
+	if n == 0 {
+		delete(attrs, attr)
+		return
+	}
+	attrs[attr] = strconv.Itoa(n)
+}
+
+// apply records an activity summary onto entity attributes
+func apply(e graph.Entity, s Summary) graph.Entity {
+	attrs := make(map[string]string, len(e.Attributes))
+	for k, v := range e.Attributes {
+		attrs[k] = v
+	}
+	// keep the most recent use across ingestions
+	prev, err := time.Parse(time.RFC3339, attrs[ATTR_LAST_USED])
+	if cur, cerr := time.Parse(time.RFC3339, s.LastUsed); err != nil || (cerr == nil && cur.After(prev)) {
+		attrs[ATTR_LAST_USED] = s.LastUsed
+	}
+	attrs[ATTR_ACTIVITY_EVENTS] = strconv.Itoa(s.Events)
+	attrs[ATTR_MONITORED] = MONITORED_CLOUDTRAIL
+	setCount(attrs, ATTR_FAILED_LOGINS, s.FailedLogins)
+	setCount(attrs, ATTR_CONSOLE_LOGINS, s.ConsoleLogins)
+	setCount(attrs, ATTR_CONSOLE_LOGINS_WITHOUT_MFA, s.ConsoleLoginsWithoutMFA)
+	setCount(attrs, ATTR_ACCESS_DENIED, s.AccessDenied)
+	setCount(attrs, ATTR_ACCESS_DENIED_BURSTS, s.AccessDeniedBursts)
+	attrs[ATTR_SOURCE_IPS] = strings.Join(s.SourceIPs, ",")
+	attrs[ATTR_UNUSUAL_SOURCE_IPS] = strings.Join(s.UnusualSourceIPs, ",")
+	e.Attributes = attrs
+	return e
+}
+
+// Ingest reads CloudTrail logs of a path under the log directory and records
+// activity onto the entities of an app. Principals without any activity are
+// marked never used when logs span the dormancy period.
+func (a *Activity) Ingest(aid, path string) (IngestResult, error) {
+	records, files, err := ReadLogs(filepath.Join(a.logDir, filepath.Clean("/"+path)))
+	if err != nil {
+		return IngestResult{}, err
+	}
+	h := graph.LoadHypergraph(a.db, aid)
+	result := Summarize(h, records)
+	result.Files = files
+
+	active := map[string]bool{}
+	for _, s := range result.Entities {
+		ekey := graph.GetEntityKey(aid, s.Entity)
+		e, err := graph.Entities(a.db).Get(ekey)
+		if errors.Is(err, db.ErrRecord) {
+			return result, err
+		}
+		if err != nil {
+			continue // nested entity
+		}
+		graph.Entities(a.db).Put(ekey, apply(e, s))
+		active[s.Entity] = true
+	}
+
+	from, _ := time.Parse(time.RFC3339, result.From)
+	to, _ := time.Parse(time.RFC3339, result.To)
+	if to.Sub(from) < DORMANT_DAYS*24*time.Hour {
+		return result, nil
+	}
+	for eid, e := range h.Entities {
+		ekey := graph.GetEntityKey(aid, eid)
+		if active[eid] || !isPrincipal(e) || e.Attributes[ATTR_LAST_USED] != "" {
+			continue
+		}
+		if _, err := a.db.Get(graph.DB_TABLE_ENTITIES, ekey); err != nil {
+			continue
+		}
+		e.ID = ekey
+		graph.Entities(a.db).Put(ekey, apply(e, Summary{LastUsed: LAST_USED_NEVER}))
+	}
+	return result, nil
+}
+
+// IsDormant returns true for principals unused for the dormancy period
+func IsDormant(e graph.Entity, now time.Time) bool {
+	lastUsed, ok := e.Attributes[ATTR_LAST_USED]
+	if !ok {
+		return false
+	}
+	if lastUsed == LAST_USED_NEVER {
+		return true
+	}
+	ts, err := time.Parse(time.RFC3339, lastUsed)
+	return err == nil && now.Sub(ts) > DORMANT_DAYS*24*time.Hour
+}
+
+// count returns a count attribute of an entity
+func count(e graph.Entity, attr string) int {
+	n, _ := strconv.Atoi(e.Attributes[attr])
+	return n
+}
+
+// Likelihood scores the likelihood of an entity being attacked from its
+// observed activity on a 0-10 scale, 0 if no activity was ingested
+func Likelihood(e graph.Entity) float64 {
+	if _, ok := e.Attributes[ATTR_ACTIVITY_EVENTS]; !ok {
+		return 0
+	}
+	score := 2.5
+	for _, signal := range []bool{
+		count(e, ATTR_FAILED_LOGINS) > 0,
+		count(e, ATTR_CONSOLE_LOGINS_WITHOUT_MFA) > 0,
+		count(e, ATTR_ACCESS_DENIED_BURSTS) > 0,
+		e.Attributes[ATTR_UNUSUAL_SOURCE_IPS] != "",
+	} {
+		if signal {
+			score += 2.5
+		}
+	}
+	if score > 10 {
+		score = 10
+	}
+	return score
+}
+
+// splitList splits a comma separated attribute
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// EntitySummary returns the activity recorded on an entity
+func EntitySummary(eid string, e graph.Entity) (Summary, bool) {
+	if _, ok := e.Attributes[ATTR_ACTIVITY_EVENTS]; !ok {
+		return Summary{}, false
+	}
+	return Summary{
+		Entity:                  eid,
+		Events:                  count(e, ATTR_ACTIVITY_EVENTS),
+		LastUsed:                e.Attributes[ATTR_LAST_USED],
+		FailedLogins:            count(e, ATTR_FAILED_LOGINS),
+		ConsoleLogins:           count(e, ATTR_CONSOLE_LOGINS),
+		ConsoleLoginsWithoutMFA: count(e, ATTR_CONSOLE_LOGINS_WITHOUT_MFA),
+		AccessDenied:            count(e, ATTR_ACCESS_DENIED),
+		AccessDeniedBursts:      count(e, ATTR_ACCESS_DENIED_BURSTS),
+		SourceIPs:               splitList(e.Attributes[ATTR_SOURCE_IPS]),
+		UnusualSourceIPs:        splitList(e.Attributes[ATTR_UNUSUAL_SOURCE_IPS]),
+	}, true
+}
+
+// IngestRequest names CloudTrail logs to ingest, a file or a directory
+// relative to the server log directory
+type IngestRequest struct {
+	Path string `json:"path"`
+}
+
+// IngestCloudTrail is POST handler to ingest CloudTrail logs onto an app
+func (a *Activity) IngestCloudTrail(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  IngestRequest
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := a.db.Get(graph.DB_TABLE_GRAPH, aid); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := a.Ingest(aid, req.Path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to ingest CloudTrail logs: %v", err), http.StatusBadRequest)
+		return
+	}
+	log.Printf("new CloudTrail activity of %v entities of app %v\n", len(result.Entities), aid)
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetActivity is GET handler to retrieve recorded activity of app entities
+func (a *Activity) GetActivity(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	h := graph.LoadHypergraph(a.db, aid)
+	summaries := []Summary{}
+	for eid, e := range h.Entities {
+		if s, ok := EntitySummary(eid, e); ok {
+			summaries = append(summaries, s)
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Entity < summaries[j].Entity })
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
@@ -0,0 +1,168 @@
+package activity
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+// record returns a CloudTrail record of a user from a source IP
+func record(t *testing.T, ts time.Time, user, ip, extra string) Record {
+	t.Helper()
+	data := fmt.Sprintf(`{"eventTime": %q, "sourceIPAddress": %q, "userIdentity": {"type": "IAMUser", "userName": %q}%s}`,
+		ts.Format(time.RFC3339), ip, user, extra)
+	var rec Record
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestSummarize(t *testing.T) {
+	d := db.NewMemoryDb(graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS)
+	for _, e := range []graph.Entity{
+		{ID: "u1", Kind: "iam:user", Attributes: map[string]string{"UserName": "alice"}},
+		{ID: "r1", Kind: "iam:role", Attributes: map[string]string{"Arn": "arn:aws:iam::1:role/r1"}},
+		{ID: "b1", Kind: "s3:bucket", Attributes: map[string]string{"BucketName": "logs"}},
+	} {
+		key := graph.GetEntityKey("a1", e.ID)
+		e.ID = key
+		graph.Entities(d).Put(key, e)
+	}
+	h := graph.LoadHypergraph(d, "a1")
+
+	start := time.Date(2024, 11, 1, 10, 0, 0, 0, time.UTC)
+	records := []Record{}
+
+	// alice: ten access denied errors within the burst window, a baseline
+	// of events from one IP, a console login without MFA, and a failed login
+	// from an unusual IP
+	for i := 0; i < 19; i++ {
+		extra := ""
+		if i < ACCESS_DENIED_BURST {
+			extra = `, "errorCode": "AccessDenied"`
+		}
+		records = append(records, record(t, start.Add(time.Duration(i)*time.Second), "alice", "10.0.0.1", extra))
+	}
+	records = append(records,
+		record(t, start.Add(time.Hour), "alice", "10.0.0.1",
+			`, "eventName": "ConsoleLogin", "responseElements": {"ConsoleLogin": "Success"}, "additionalEventData": {"MFAUsed": "No"}`),
+		record(t, start.Add(2*time.Hour), "alice", "203.0.113.9",
+			`, "eventName": "ConsoleLogin", "responseElements": {"ConsoleLogin": "Failure"}, "additionalEventData": {"MFAUsed": "No"}`))
+
+	// an assumed role reading a bucket from an AWS service
+	records = append(records, record(t, start.Add(-time.Hour), "", "s3.amazonaws.com",
+		`, "userIdentity": {"type": "AssumedRole", "sessionContext": {"sessionIssuer": {"arn": "arn:aws:iam::1:role/r1"}}},
+		"eventName": "GetObject", "requestParameters": {"bucketName": "logs"}`))
+
+	// unknown principals and bad times are unmatched
+	records = append(records, record(t, start, "bob", "10.0.0.2", ""))
+	bad := record(t, start, "alice", "10.0.0.1", "")
+	bad.EventTime = "yesterday"
+	records = append(records, bad)
+
+	result := Summarize(h, records)
+	if result.Records != len(records) || result.Unmatched != 2 {
+		t.Fatalf("expecting %v records, 2 unmatched, got %+v", len(records), result)
+	}
+	if result.From != "2024-11-01T09:00:00Z" || result.To != "2024-11-01T12:00:00Z" {
+		t.Fatalf("expecting time span of matched records, got %v %v", result.From, result.To)
+	}
+	want := []Summary{
+		{Entity: "b1", Events: 1, LastUsed: "2024-11-01T09:00:00Z", SourceIPs: []string{}, UnusualSourceIPs: []string{}},
+		{Entity: "r1", Events: 1, LastUsed: "2024-11-01T09:00:00Z", SourceIPs: []string{}, UnusualSourceIPs: []string{}},
+		{Entity: "u1", Events: 21, LastUsed: "2024-11-01T12:00:00Z", FailedLogins: 1, ConsoleLogins: 2, ConsoleLoginsWithoutMFA: 1,
+			AccessDenied: 10, AccessDeniedBursts: 1, SourceIPs: []string{"10.0.0.1", "203.0.113.9"}, UnusualSourceIPs: []string{"203.0.113.9"}},
+	}
+	if !reflect.DeepEqual(result.Entities, want) {
+		t.Fatalf("expecting %+v, got %+v", want, result.Entities)
+	}
+
+	// summaries round trip through entity attributes
+	e := apply(h.Entities["u1"], want[2])
+	if s, ok := EntitySummary("u1", e); !ok || !reflect.DeepEqual(s, want[2]) {
+		t.Fatalf("expecting summary recorded on the entity, got %+v", s)
+	}
+	if l := Likelihood(e); l != 10 {
+		t.Fatalf("expecting maximal likelihood, got %v", l)
+	}
+}
+
+func TestBursts(t *testing.T) {
+	start := time.Date(2024, 11, 1, 10, 0, 0, 0, time.UTC)
+	at := func(offsets ...time.Duration) []time.Time {
+		ts := []time.Time{}
+		for _, o := range offsets {
+			ts = append(ts, start.Add(o))
+		}
+		return ts
+	}
+	every := func(n int, step time.Duration) []time.Duration {
+		offsets := []time.Duration{}
+		for i := 0; i < n; i++ {
+			offsets = append(offsets, time.Duration(i)*step)
+		}
+		return offsets
+	}
+	tests := []struct {
+		name   string
+		denied []time.Time
+		count  int
+	}{
+		{"none", nil, 0},
+		{"below threshold", at(every(ACCESS_DENIED_BURST-1, time.Second)...), 0},
+		{"one burst", at(every(ACCESS_DENIED_BURST, time.Second)...), 1},
+		{"spread out", at(every(ACCESS_DENIED_BURST, time.Minute)...), 0},
+		{"two bursts", at(every(2*ACCESS_DENIED_BURST, time.Second)...), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if n := bursts(tt.denied); n != tt.count {
+				t.Fatalf("expecting %v bursts, got %v", tt.count, n)
+			}
+		})
+	}
+}
+
+func TestIsDormant(t *testing.T) {
+	now := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		lastUsed string
+		dormant  bool
+	}{
+		{"not monitored", "", false},
+		{"never used", LAST_USED_NEVER, true},
+		{"recently used", now.Add(-24 * time.Hour).Format(time.RFC3339), false},
+		{"used at the dormancy period", now.Add(-DORMANT_DAYS * 24 * time.Hour).Format(time.RFC3339), false},
+		{"unused for longer", now.Add(-(DORMANT_DAYS + 1) * 24 * time.Hour).Format(time.RFC3339), true},
+		{"malformed", "last year", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := graph.Entity{Attributes: map[string]string{}}
+			if tt.lastUsed != "" {
+				e.Attributes[ATTR_LAST_USED] = tt.lastUsed
+			}
+			if got := IsDormant(e, now); got != tt.dormant {
+				t.Fatalf("expecting dormant %v, got %v", tt.dormant, got)
+			}
+		})
+	}
+}
+
+func TestApplyKeepsLatestUse(t *testing.T) {
+	e := graph.Entity{Attributes: map[string]string{ATTR_LAST_USED: "2024-11-02T00:00:00Z", ATTR_FAILED_LOGINS: "3"}}
+	e = apply(e, Summary{Events: 1, LastUsed: "2024-11-01T00:00:00Z"})
+	if e.Attributes[ATTR_LAST_USED] != "2024-11-02T00:00:00Z" {
+		t.Fatalf("expecting most recent use kept, got %v", e.Attributes[ATTR_LAST_USED])
+	}
+	if _, ok := e.Attributes[ATTR_FAILED_LOGINS]; ok || e.Attributes[ATTR_MONITORED] != MONITORED_CLOUDTRAIL {
+		t.Fatalf("expecting zero counts dropped and entity monitored, got %v", e.Attributes)
+	}
+}
@@ -0,0 +1,139 @@
+package activity
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isLogFile returns true for CloudTrail JSON log files, plain or gzipped
+func isLogFile(path string) bool {
+	return strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".json.gz") ||
+		strings.HasSuffix(path, ".gz")
+}
+
+// readLogFile reads CloudTrail records of a single log file
+func readLogFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var logFile struct {
+		Records []Record `json:"Records"`
+	}
+	if err := json.NewDecoder(r).Decode(&logFile); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return logFile.Records, nil
+}
+
+// ReadLogs reads CloudTrail records of a log file or of all log files under
+// a directory, in file name order
+func ReadLogs(path string) ([]Record, int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files = []string{}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isLogFile(p) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		sort.Strings(files)
+	}
+
+	records := []Record{}
+	for _, file := range files {
+		recs, err := readLogFile(file)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, recs...)
+	}
+	return records, len(files), nil
+}
+
+// principalKeys returns identifiers of the principal of a record, i.e.
+// user, assumed role issuer and access key
+func (rec Record) principalKeys() []string {
+	id := rec.UserIdentity
+	issuer := id.SessionContext.SessionIssuer
+	keys := []string{id.Arn, id.UserName, issuer.Arn, issuer.UserName, id.AccessKeyID}
+	if id.Type == "Root" {
+		keys = append(keys, "root")
+	}
+	return keys
+}
+
+// resourceKeys returns identifiers of the resources accessed by a record
+func (rec Record) resourceKeys() []string {
+	keys := []string{}
+	for _, res := range rec.Resources {
+		keys = append(keys, res.ARN)
+	}
+	for _, param := range []string{"bucketName", "instanceId", "roleName", "userName", "functionName"} {
+		if v, ok := rec.RequestParameters[param].(string); ok {
+			keys = append(keys, v)
+		}
+	}
+	return keys
+}
+
+// isConsoleLogin returns true for console sign-in records
+func (rec Record) isConsoleLogin() bool {
+	return rec.EventName == "ConsoleLogin"
+}
+
+// isFailedLogin returns true for failed console sign-in records
+func (rec Record) isFailedLogin() bool {
+	if !rec.isConsoleLogin() {
+		return false
+	}
+	result, _ := rec.ResponseElements["ConsoleLogin"].(string)
+	return result == "Failure" || rec.ErrorMessage == "Failed authentication"
+}
+
+// isLoginWithoutMFA returns true for successful console sign-ins without MFA
+func (rec Record) isLoginWithoutMFA() bool {
+	if !rec.isConsoleLogin() || rec.isFailedLogin() {
+		return false
+	}
+	mfa, _ := rec.AdditionalEventData["MFAUsed"].(string)
+	return mfa == "No"
+}
+
+// isAccessDenied returns true for records denied by authorization
+func (rec Record) isAccessDenied() bool {
+	switch rec.ErrorCode {
+	case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation", "Client.UnauthorizedOperation":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package activity
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFile writes a log file under a directory, gzipped for .gz files
+func writeFile(t *testing.T, dir, name, data string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if filepath.Ext(name) != ".gz" {
+		f.WriteString(data)
+		return
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(data))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadLogs(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "b/2.json.gz", `{"Records": [{"eventName": "GetObject"}, {"eventName": "PutObject"}]}`)
+	writeFile(t, dir, "a.json", `{"Records": [{"eventName": "ConsoleLogin", "userIdentity": {"type": "Root"}}]}`)
+	writeFile(t, dir, "b/notes.txt", `not a log`)
+
+	// log files are read in file name order, other files are skipped
+	records, files, err := ReadLogs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, rec := range records {
+		names = append(names, rec.EventName)
+	}
+	if files != 2 || len(names) != 3 || names[0] != "ConsoleLogin" || names[2] != "PutObject" {
+		t.Fatalf("expecting 3 records of 2 files in order, got %v of %v", names, files)
+	}
+	if keys := records[0].principalKeys(); keys[len(keys)-1] != "root" {
+		t.Fatalf("expecting root principal, got %v", keys)
+	}
+
+	// single files are read too
+	records, files, err = ReadLogs(filepath.Join(dir, "a.json"))
+	if err != nil || files != 1 || len(records) != 1 {
+		t.Fatalf("expecting one record of one file, got %v of %v %v", len(records), files, err)
+	}
+}
+
+func TestReadLogsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		raw  bool
+	}{
+		{"malformed", "1.json", `{"Records": [{"eventName": }]}`, false},
+		{"wrong types", "1.json", `{"Records": {"eventName": "GetObject"}}`, false},
+		{"truncated", "1.json", `{"Records": [`, false},
+		{"not gzipped", "1.json.gz", `{"Records": []}`, true},
+		{"truncated gzip", "1.json.gz", "\x1f\x8b\x08", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.raw {
+				os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.data), 0600)
+			} else {
+				writeFile(t, dir, tt.file, tt.data)
+			}
+			// a bad file fails the whole read, not to ingest partial logs
+			writeFile(t, dir, "0.json", `{"Records": [{"eventName": "GetObject"}]}`)
+			if records, _, err := ReadLogs(dir); err == nil {
+				t.Fatalf("expecting error, got %v records", len(records))
+			}
+		})
+	}
+	if _, _, err := ReadLogs(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("expecting missing path to fail")
+	}
+}
@@ -0,0 +1,68 @@
+package activity
+
+import (
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Activity represents ingestion of CloudTrail activity logs onto app entities
+type Activity struct {
+	db db.Db
+
+	// local directory CloudTrail log paths are resolved under
+	logDir string
+}
+
+// Record is a single CloudTrail event record
+type Record struct {
+	EventTime       string `json:"eventTime"`
+	EventSource     string `json:"eventSource"`
+	EventName       string `json:"eventName"`
+	AwsRegion       string `json:"awsRegion"`
+	SourceIPAddress string `json:"sourceIPAddress"`
+	ErrorCode       string `json:"errorCode"`
+	ErrorMessage    string `json:"errorMessage"`
+	UserIdentity    struct {
+		Type           string `json:"type"`
+		PrincipalID    string `json:"principalId"`
+		Arn            string `json:"arn"`
+		UserName       string `json:"userName"`
+		AccessKeyID    string `json:"accessKeyId"`
+		SessionContext struct {
+			SessionIssuer struct {
+				Arn      string `json:"arn"`
+				UserName string `json:"userName"`
+			} `json:"sessionIssuer"`
+		} `json:"sessionContext"`
+	} `json:"userIdentity"`
+	RequestParameters   map[string]interface{} `json:"requestParameters"`
+	ResponseElements    map[string]interface{} `json:"responseElements"`
+	AdditionalEventData map[string]interface{} `json:"additionalEventData"`
+	Resources           []struct {
+		ARN string `json:"ARN"`
+	} `json:"resources"`
+}
+
+// Summary is the recent activity of a principal or resource entity
+type Summary struct {
+	Entity                  string   `json:"entity"`
+	Events                  int      `json:"events"`
+	LastUsed                string   `json:"lastUsed"`
+	FailedLogins            int      `json:"failedLogins"`
+	ConsoleLogins           int      `json:"consoleLogins"`
+	ConsoleLoginsWithoutMFA int      `json:"consoleLoginsWithoutMFA"`
+	AccessDenied            int      `json:"accessDenied"`
+	AccessDeniedBursts      int      `json:"accessDeniedBursts"`
+	SourceIPs               []string `json:"sourceIPs"`
+	UnusualSourceIPs        []string `json:"unusualSourceIPs"`
+}
+
+// IngestResult summarizes a CloudTrail ingestion
+type IngestResult struct {
+	App       string    `json:"app"`
+	Files     int       `json:"files"`
+	Records   int       `json:"records"`
+	Unmatched int       `json:"unmatched"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Entities  []Summary `json:"entities"`
+}
@@ -0,0 +1,386 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/auth"
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/tenant"
+)
+
+const (
+	// system DB table of audit log entries keyed by sequence number
+	DB_TABLE_AUDIT = "audit"
+
+	// request header carrying request ids, generated when missing
+	HEADER_REQUEST_ID = "X-Request-ID"
+
+	// changes of DB entries
+	OP_CREATE = "create"
+	OP_UPDATE = "update"
+	OP_DELETE = "delete"
+
+	// actor of changes made outside of requests
+	ACTOR_SYSTEM = "system"
+
+	// default number of entries returned by queries
+	DEFAULT_LIMIT = 100
+)
+
+// endpoints not scoped by tenant
+var systemPaths = []string{"/v1/apiKey", "/v1/notifications", "/v1/tenants", "/v1/audit"}
+
+// context key of the scope of a request
+type contextKey struct{}
+
+// NewAudit returns a new audit log stored on a given DB, not recording changes
+// of ignored tables
+func NewAudit(db db.Db, ignore ...string) *Audit {
+	a := &Audit{
+		db:      db,
+		ignored: map[string]bool{},
+	}
+	for _, t := range ignore {
+		a.ignored[t] = true
+	}
+	return a
+}
+
+// getEntryKey returns a sortable key of an audit log entry
+func getEntryKey(seq int) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+// hashValue returns the hex SHA-256 hash of a DB value, empty if absent
+func hashValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%v", v))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashEntry returns the chained hash of an entry, i.e. the hex SHA-256 of the
+// previous hash, a newline and the JSON entry without its hash
+func hashEntry(e Entry) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(append([]byte(e.PrevHash+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRequestId returns a random request id
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// append chains and stores an entry, the caller holds the mutex
+func (a *Audit) append(e *Entry) {
+	a.seq++
+	e.Seq = a.seq
+	e.PrevHash = a.last
+	e.Hash = hashEntry(*e)
+	a.last = e.Hash
+	a.db.Add(DB_TABLE_AUDIT, getEntryKey(e.Seq), *e)
+}
+
+// record returns recorded changes of DB changes, sorted by table and key
+func (a *Audit) record(changes []db.Change) []Change {
+	recorded := []Change{}
+	for _, c := range changes {
+		if a.ignored[c.Table] {
+			continue
+		}
+		op := OP_UPDATE
+		switch {
+		case c.Before == nil:
+			op = OP_CREATE
+		case c.After == nil:
+			op = OP_DELETE
+		}
+		recorded = append(recorded, Change{
+			Table:  c.Table,
+			Key:    c.Key,
+			Op:     op,
+			Before: hashValue(c.Before),
+			After:  hashValue(c.After),
+		})
+	}
+	sort.Slice(recorded, func(i, j int) bool {
+		if recorded[i].Table != recorded[j].Table {
+			return recorded[i].Table < recorded[j].Table
+		}
+		return recorded[i].Key < recorded[j].Key
+	})
+	return recorded
+}
+
+// observe returns a DB observer recording changes on the entry of a request
+// in progress, or on their own entry outside of requests, including those
+// made once the request completed
+func (a *Audit) observe(s *scope) func(changes []db.Change) {
+	return func(changes []db.Change) {
+		recorded := a.record(changes)
+		if len(recorded) == 0 {
+			return
+		}
+
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		if s != nil && !s.done {
+			s.entry.Changes = append(s.entry.Changes, recorded...)
+			return
+		}
+		a.append(&Entry{
+			Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+			Actor:     ACTOR_SYSTEM,
+			ActorName: ACTOR_SYSTEM,
+			Changes:   recorded,
+		})
+	}
+}
+
+// Observe returns a DB observer recording changes made outside of requests on
+// their own entry
+func (a *Audit) Observe() func(changes []db.Change) {
+	return a.observe(nil)
+}
+
+// ObserveRequest returns a DB observer recording changes on the entry of a
+// mutating request, so that changes of concurrent requests are never
+// attributed to one another
+func (a *Audit) ObserveRequest(r *http.Request) func(changes []db.Change) {
+	s, _ := r.Context().Value(contextKey{}).(*scope)
+	return a.observe(s)
+}
+
+// statusWriter records the status of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// isSystemPath returns true for endpoints not scoped by tenant
+func isSystemPath(path string) bool {
+	for _, p := range systemPaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestApp returns the app id of /v1/app/{id} paths, with or without
+// tenant prefix
+func requestApp(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "app" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+// Middleware tags requests with a request id, and records an entry for each
+// mutating request with the DB changes it made on DBs scoped to it
+func (a *Audit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqId := r.Header.Get(HEADER_REQUEST_ID)
+		if reqId == "" {
+			reqId = newRequestId()
+		}
+		w.Header().Set(HEADER_REQUEST_ID, reqId)
+		if auth.ReadOnly(r) || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		k, _ := auth.FromContext(r.Context())
+		e := &Entry{
+			RequestID: reqId,
+			Actor:     k.ID,
+			ActorName: k.Name,
+			Org:       k.Org,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			App:       requestApp(r.URL.Path),
+			Changes:   []Change{},
+		}
+		if !isSystemPath(r.URL.Path) {
+			e.Org, e.Group = tenant.Requested(r, k)
+		}
+
+		s := &scope{entry: e}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		s.done = true
+		e.Status = sw.status
+		e.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+		if e.App == "" {
+			for _, c := range e.Changes {
+				if c.Table == graph.DB_TABLE_GRAPH {
+					e.App = c.Key
+					break
+				}
+			}
+		}
+		a.append(e)
+	})
+}
+
+// Entries returns all entries, oldest first
+func (a *Audit) Entries() []Entry {
+	keys := a.db.Keys(DB_TABLE_AUDIT)
+	sort.Strings(keys)
+	entries := []Entry{}
+	for _, key := range keys {
+		if v, err := a.db.Get(DB_TABLE_AUDIT, key); err == nil {
+			if e, ok := v.(Entry); ok {
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries
+}
+
+// Verify walks the chain, reporting the first entry whose hash or link to
+// its previous entry does not match
+func (a *Audit) Verify() Verification {
+	entries := a.Entries()
+	v := Verification{Valid: true, Entries: len(entries)}
+	prev := ""
+	for i, e := range entries {
+		switch {
+		case e.Seq != i+1:
+			v.Error = fmt.Sprintf("entry %v missing", i+1)
+		case e.PrevHash != prev:
+			v.Error = fmt.Sprintf("entry %v not linked to entry %v", e.Seq, i)
+		case hashEntry(e) != e.Hash:
+			v.Error = fmt.Sprintf("entry %v hash mismatch", e.Seq)
+		}
+		if v.Error != "" {
+			v.Valid = false
+			v.BrokenAt = i + 1
+			return v
+		}
+		prev = e.Hash
+	}
+	v.Head = prev
+	return v
+}
+
+// filter returns entries of a request, among those of groups granted to its
+// key, along with entries of its org without a group, such as key changes
+func (a *Audit) filter(r *http.Request) ([]Entry, error) {
+	k, _ := auth.FromContext(r.Context())
+	query := r.URL.Query()
+	var from, to time.Time
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if s := query.Get(name); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v time %v", name, s)
+			}
+			*t = parsed
+		}
+	}
+
+	match := func(name, value string) bool {
+		want := query.Get(name)
+		return want == "" || want == value
+	}
+	entries := []Entry{}
+	for _, e := range a.Entries() {
+		if e.Group == "" && !k.IsSystem() && e.Org != k.Org {
+			continue
+		}
+		if e.Group != "" && !k.CanAccess(e.Org, e.Group) {
+			continue
+		}
+		if !match("org", e.Org) || !match("group", e.Group) || !match("actor", e.Actor) ||
+			!match("app", e.App) || !match("method", e.Method) || !match("requestId", e.RequestID) {
+			continue
+		}
+		ts, _ := time.Parse(time.RFC3339Nano, e.Timestamp)
+		if (!from.IsZero() && ts.Before(from)) || (!to.IsZero() && ts.After(to)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// GetAudit is GET handler to query the audit log, returning the most recent
+// matching entries up to limit
+func (a *Audit) GetAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := a.filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := DEFAULT_LIMIT
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	list := EntryList{Total: len(entries), Entries: entries}
+	if len(entries) > limit {
+		list.Entries = entries[len(entries)-limit:]
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// ExportAudit is GET handler to export matching entries as JSON lines
+func (a *Audit) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := a.filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// return JSON lines response
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		enc.Encode(e)
+	}
+}
+
+// VerifyAudit is GET handler to verify the audit log chain
+func (a *Audit) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Verify())
+}
@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/auth"
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+func TestVerify(t *testing.T) {
+	a := NewAudit(db.NewMemoryDb(DB_TABLE_AUDIT))
+	d := db.NewObservedDb(db.NewMemoryDb("t"), a.Observe())
+	d.Add("t", "k1", "v1")
+	d.Add("t", "k1", "v2")
+	d.Del("t", "k1")
+	d.Del("t", "k1")
+
+	v := a.Verify()
+	if !v.Valid || v.Entries != 3 || v.Head == "" {
+		t.Fatalf("expecting a valid chain of 3 entries, got %+v", v)
+	}
+	ops := []string{}
+	for _, e := range a.Entries() {
+		ops = append(ops, e.Changes[0].Op)
+	}
+	if got := strings.Join(ops, ","); got != "create,update,delete" {
+		t.Fatalf("expecting create,update,delete, got %v", got)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(e *Entry)
+		broken int
+	}{
+		{"changed entry", func(e *Entry) { e.Changes[0].After = hashValue("v3") }, 2},
+		{"relinked entry", func(e *Entry) { e.PrevHash = ""; e.Hash = hashEntry(*e) }, 2},
+		{"renumbered entry", func(e *Entry) { e.Seq = 5 }, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := a.Entries()
+			saved := entries[tt.broken-1]
+			defer a.db.Add(DB_TABLE_AUDIT, getEntryKey(saved.Seq), saved)
+
+			e := saved
+			e.Changes = append([]Change{}, saved.Changes...)
+			tt.tamper(&e)
+			a.db.Add(DB_TABLE_AUDIT, getEntryKey(saved.Seq), e)
+			if v := a.Verify(); v.Valid || v.BrokenAt != tt.broken {
+				t.Fatalf("expecting chain broken at %v, got %+v", tt.broken, v)
+			}
+		})
+	}
+	if v := a.Verify(); !v.Valid {
+		t.Fatalf("expecting restored chain to be valid, got %+v", v)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	a := NewAudit(db.NewMemoryDb(DB_TABLE_AUDIT))
+	d := db.NewObservedDb(db.NewMemoryDb("t"), a.Observe())
+
+	// the slow request writes before and after the fast one completes
+	started := make(chan bool)
+	fast := make(chan bool)
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scoped := d.With(a.ObserveRequest(r))
+		key := strings.TrimPrefix(r.URL.Path, "/v1/")
+		if key == "slow" {
+			scoped.Add("t", "slow1", 1)
+			close(started)
+			<-fast
+			scoped.Add("t", "slow2", 2)
+			return
+		}
+		<-started
+		scoped.Add("t", key, 3)
+	}))
+
+	var wg sync.WaitGroup
+	for _, path := range []string{"/v1/slow", "/v1/fast"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+			if path == "/v1/fast" {
+				close(fast)
+			}
+		}(path)
+	}
+	wg.Wait()
+
+	want := map[string]string{
+		"/v1/fast": "fast",
+		"/v1/slow": "slow1,slow2",
+	}
+	entries := a.Entries()
+	if len(entries) != 2 || entries[0].Path != "/v1/fast" {
+		t.Fatalf("expecting entries of the fast then slow request, got %+v", entries)
+	}
+	for _, e := range entries {
+		keys := []string{}
+		for _, c := range e.Changes {
+			keys = append(keys, c.Key)
+		}
+		if got := strings.Join(keys, ","); got != want[e.Path] {
+			t.Errorf("%v: expecting changes of %v, got %v", e.Path, want[e.Path], got)
+		}
+	}
+
+	// changes made once a request completed get their own entry
+	late := httptest.NewRequest(http.MethodPost, "/v1/late", nil)
+	var scoped *db.ObservedDb
+	a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scoped = d.With(a.ObserveRequest(r))
+	})).ServeHTTP(httptest.NewRecorder(), late)
+	scoped.Add("t", "late", 4)
+	entries = a.Entries()
+	if e := entries[len(entries)-1]; e.Actor != ACTOR_SYSTEM || len(e.Changes) != 1 || e.Changes[0].Key != "late" {
+		t.Fatalf("expecting a system entry of the late change, got %+v", e)
+	}
+	if v := a.Verify(); !v.Valid {
+		t.Fatalf("expecting a valid chain, got %+v", v)
+	}
+}
+
+func TestFilterByKey(t *testing.T) {
+	a := NewAudit(db.NewMemoryDb(DB_TABLE_AUDIT))
+	for i, e := range []Entry{
+		{Org: "acme", Group: "prod", Path: "acme/prod"},
+		{Org: "acme", Group: "dev", Path: "acme/dev"},
+		{Org: "acme", Path: "acme"},
+		{Org: "other", Group: "prod", Path: "other/prod"},
+	} {
+		e.Seq = i + 1
+		a.db.Add(DB_TABLE_AUDIT, getEntryKey(e.Seq), e)
+	}
+
+	tests := []struct {
+		name  string
+		key   auth.APIKey
+		paths string
+	}{
+		{"system", auth.APIKey{Scope: auth.SCOPE_ADMIN, Org: auth.ORG_ALL}, "acme/prod,acme/dev,acme,other/prod"},
+		{"org admin", auth.APIKey{Scope: auth.SCOPE_ADMIN, Org: "acme"}, "acme/prod,acme/dev,acme"},
+		{"group admin", auth.APIKey{Scope: auth.SCOPE_ADMIN, Org: "acme", Groups: []string{"prod"}}, "acme/prod,acme"},
+		{"other org", auth.APIKey{Scope: auth.SCOPE_ADMIN, Org: "other", Groups: []string{"dev"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, export := range []bool{false, true} {
+				handler := a.GetAudit
+				if export {
+					handler = a.ExportAudit
+				}
+				r := httptest.NewRequest(http.MethodGet, "/v1/audit", nil)
+				w := httptest.NewRecorder()
+				handler(w, r.WithContext(auth.NewContext(r.Context(), tt.key)))
+
+				// exports are JSON lines of entries
+				var list EntryList
+				dec := json.NewDecoder(w.Body)
+				for export && dec.More() {
+					var e Entry
+					if err := dec.Decode(&e); err != nil {
+						t.Fatal(err)
+					}
+					list.Entries = append(list.Entries, e)
+				}
+				if !export {
+					if err := dec.Decode(&list); err != nil {
+						t.Fatal(err)
+					}
+				}
+				paths := []string{}
+				for _, e := range list.Entries {
+					paths = append(paths, e.Path)
+				}
+				if got := strings.Join(paths, ","); got != tt.paths {
+					t.Fatalf("expecting entries %v, got %v", tt.paths, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"sync"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Audit represents the append-only, hash-chained audit log of all mutations
+type Audit struct {
+	// system DB holding the log, never observed itself
+	db db.Db
+
+	// tables whose changes are not recorded
+	ignored map[string]bool
+
+	// serializes appends to the chain, and to entries of requests
+	mutex sync.Mutex
+	seq   int
+	last  string
+}
+
+// scope is the entry of a mutating request in progress, recording the changes
+// made on DBs of the request until it is appended to the chain
+type scope struct {
+	entry *Entry
+	done  bool
+}
+
+// Change is a DB entry created, updated or deleted, with hashes of its value
+// before and after
+type Change struct {
+	Table  string `json:"table"`
+	Key    string `json:"key"`
+	Op     string `json:"op"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// Entry is an audit log record of a mutating request, or of changes made
+// outside of requests
+type Entry struct {
+	Seq       int      `json:"seq"`
+	Timestamp string   `json:"timestamp"`
+	RequestID string   `json:"requestId"`
+	Actor     string   `json:"actor"`
+	ActorName string   `json:"actorName"`
+	Org       string   `json:"org"`
+	Group     string   `json:"group"`
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	App       string   `json:"app"`
+	Status    int      `json:"status"`
+	Changes   []Change `json:"changes"`
+	PrevHash  string   `json:"prevHash"`
+	Hash      string   `json:"hash"`
+}
+
+// list of audit log entries, oldest first, and number of matching entries
+type EntryList struct {
+	Total   int     `json:"total"`
+	Entries []Entry `json:"entries"`
+}
+
+// Verification is the result of verifying the audit log chain
+type Verification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	Head     string `json:"head"`
+	BrokenAt int    `json:"brokenAt,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,415 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+const (
+	// DB tables of API keys and of their last use, keyed by key hash
+	DB_TABLE_API_KEYS      = "apiKeys"
+	DB_TABLE_API_KEY_USAGE = "apiKeyUsage"
+
+	// API key scopes, each granting all lower scopes
+	SCOPE_READ_ONLY = "read-only"
+	SCOPE_INGEST    = "ingest"
+	SCOPE_ADMIN     = "admin"
+
+	// prefix of generated API keys
+	KEY_PREFIX = "ztk_"
+
+	// number of leading key characters kept to recognize keys
+	KEY_PREFIX_LEN = 12
+
+	// default request header carrying API keys
+	DEFAULT_HEADER = "Authorization"
+
+	// authorization scheme of keys in the Authorization header
+	BEARER = "Bearer "
+
+	// name of the bootstrap admin key
+	ADMIN_KEY_NAME = "admin"
+
+	// org of system keys, granted all orgs
+	ORG_ALL = "*"
+)
+
+// Scopes lists API key scopes, lowest first
+var Scopes = []string{SCOPE_READ_ONLY, SCOPE_INGEST, SCOPE_ADMIN}
+
+// endpoints requiring the admin scope, whatever the method
+var adminPaths = []string{"/v1/apiKey", "/v1/notifications", "/v1/audit"}
+
+// endpoints posting read-only queries, with or without tenant prefix
+var queryPaths = []string{"/graphql", "/query"}
+
+// valid org and group names
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// context key of the authenticated API key
+type contextKey struct{}
+
+// NewAuth returns a new API key authentication element
+func NewAuth(db db.Db, header string) *Auth {
+	if header == "" {
+		header = DEFAULT_HEADER
+	}
+	return &Auth{
+		db:     db,
+		header: header,
+		mutex:  &sync.Mutex{},
+	}
+}
+
+// On returns the authentication element on another DB holding the same API
+// keys, such as one audited for a request
+func (a *Auth) On(d db.Db) *Auth {
+	return &Auth{
+		db:     d,
+		header: a.header,
+		mutex:  a.mutex,
+	}
+}
+
+// scopeRank returns position of a scope, -1 if unknown
+func scopeRank(scope string) int {
+	for i, s := range Scopes {
+		if s == scope {
+			return i
+		}
+	}
+	return -1
+}
+
+// Hash returns the hex SHA-256 hash of an API key
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateKey returns a new random API key
+func GenerateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return KEY_PREFIX + hex.EncodeToString(b), nil
+}
+
+// newKeyId returns a random API key id
+func newKeyId() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// keyPrefix returns leading characters of a key, none of short keys
+func keyPrefix(key string) string {
+	if len(key) >= 2*KEY_PREFIX_LEN {
+		return key[:KEY_PREFIX_LEN]
+	}
+	return ""
+}
+
+// ValidName returns true for valid org and group names
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// IsSystem returns true for keys granted all orgs
+func (k APIKey) IsSystem() bool {
+	return k.Org == ORG_ALL
+}
+
+// CanAccess returns true if a key may access a group of an org
+func (k APIKey) CanAccess(org, group string) bool {
+	if k.IsSystem() {
+		return true
+	}
+	if k.Org != org {
+		return false
+	}
+	if len(k.Groups) == 0 {
+		return true
+	}
+	for _, g := range k.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// AddKey stores the hash of an API key with the name, scope, org and groups
+// of a request
+func (a *Auth) AddKey(key string, req APIKeyRequest, createdBy string) (APIKey, error) {
+	if scopeRank(req.Scope) < 0 {
+		return APIKey{}, fmt.Errorf("invalid scope %v, expecting one of %v", req.Scope, strings.Join(Scopes, ", "))
+	}
+	if req.Org != ORG_ALL && !ValidName(req.Org) {
+		return APIKey{}, fmt.Errorf("invalid org %q", req.Org)
+	}
+	for _, g := range req.Groups {
+		if !ValidName(g) {
+			return APIKey{}, fmt.Errorf("invalid group %q", g)
+		}
+	}
+	if key == "" {
+		return APIKey{}, fmt.Errorf("empty API key")
+	}
+	id, err := newKeyId()
+	if err != nil {
+		return APIKey{}, err
+	}
+	k := APIKey{
+		ID:        id,
+		Name:      req.Name,
+		Scope:     req.Scope,
+		Org:       req.Org,
+		Groups:    req.Groups,
+		Prefix:    keyPrefix(key),
+		Hash:      Hash(key),
+		Created:   time.Now().UTC().Format(time.RFC3339),
+		CreatedBy: createdBy,
+	}
+	if err := a.db.Add(DB_TABLE_API_KEYS, k.Hash, k); err != nil {
+		return APIKey{}, err
+	}
+	log.Printf("new API key %v (%v), scope %v on org %v\n", k.ID, k.Name, k.Scope, k.Org)
+	return k, nil
+}
+
+// Bootstrap stores the system admin key API keys are managed with
+func (a *Auth) Bootstrap(key string) error {
+	_, err := a.AddKey(key, APIKeyRequest{Name: ADMIN_KEY_NAME, Scope: SCOPE_ADMIN, Org: ORG_ALL}, "")
+	return err
+}
+
+// requestKey returns the API key of a request, with or without Bearer scheme
+func (a *Auth) requestKey(r *http.Request) string {
+	key := strings.TrimSpace(r.Header.Get(a.header))
+	if len(key) > len(BEARER) && strings.EqualFold(key[:len(BEARER)], BEARER) {
+		key = strings.TrimSpace(key[len(BEARER):])
+	}
+	return key
+}
+
+// Authenticate returns the valid, non-revoked API key of a request
+func (a *Auth) Authenticate(r *http.Request) (APIKey, bool) {
+	key := a.requestKey(r)
+	if key == "" {
+		return APIKey{}, false
+	}
+	v, err := a.db.Get(DB_TABLE_API_KEYS, Hash(key))
+	if err != nil {
+		return APIKey{}, false
+	}
+	k, ok := v.(APIKey)
+	if !ok || k.Revoked != "" {
+		return APIKey{}, false
+	}
+	return k, true
+}
+
+// RequiredScope returns the scope a request requires: admin to manage API
+// keys and notifications, read-only to read, ingest otherwise
+func RequiredScope(r *http.Request) string {
+	for _, p := range adminPaths {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return SCOPE_ADMIN
+		}
+	}
+	if ReadOnly(r) {
+		return SCOPE_READ_ONLY
+	}
+	return SCOPE_INGEST
+}
+
+// ReadOnly returns true for requests not writing any data, i.e. GET and HEAD
+// requests, and queries
+func ReadOnly(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	if r.Method == http.MethodPost {
+		for _, p := range queryPaths {
+			if strings.HasSuffix(r.URL.Path, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allows returns true if an API key scope grants a required scope
+func Allows(scope, required string) bool {
+	return scopeRank(scope) >= scopeRank(required)
+}
+
+// NewContext returns a context of a request authenticated by an API key
+func NewContext(ctx context.Context, k APIKey) context.Context {
+	return context.WithValue(ctx, contextKey{}, k)
+}
+
+// FromContext returns the API key authenticating a request
+func FromContext(ctx context.Context) (APIKey, bool) {
+	k, ok := ctx.Value(contextKey{}).(APIKey)
+	return k, ok
+}
+
+// touch records the last use of an API key, apart from keys so that usage
+// is not audited as a key change
+func (a *Auth) touch(hash string) {
+	a.db.Add(DB_TABLE_API_KEY_USAGE, hash, time.Now().UTC().Format(time.RFC3339))
+}
+
+// SystemOnly rejects requests not authenticated by a system key
+func SystemOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if k, ok := FromContext(r.Context()); !ok || !k.IsSystem() {
+			http.Error(w, "Forbidden, system key required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Middleware rejects requests without a valid API key of the required scope
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, ok := a.Authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", strings.TrimSpace(BEARER))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if required := RequiredScope(r); !Allows(k.Scope, required) {
+			http.Error(w, fmt.Sprintf("Forbidden, %v scope required", required), http.StatusForbidden)
+			return
+		}
+
+		a.touch(k.Hash)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), k)))
+	})
+}
+
+// CreateAPIKey is POST handler to create an API key, returned only once
+func (a *Auth) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "API key name is required", http.StatusBadRequest)
+		return
+	}
+
+	// org admins create keys of their org and groups only
+	creator, _ := FromContext(r.Context())
+	if !creator.IsSystem() {
+		if req.Org == "" {
+			req.Org = creator.Org
+		}
+		if len(req.Groups) == 0 {
+			req.Groups = creator.Groups
+		}
+		granted := req.Org == creator.Org
+		for _, g := range req.Groups {
+			granted = granted && creator.CanAccess(req.Org, g)
+		}
+		if !granted {
+			http.Error(w, "Forbidden, org or group not granted", http.StatusForbidden)
+			return
+		}
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	k, err := a.AddKey(key, req, creator.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreatedAPIKey{Key: key, APIKey: k})
+}
+
+// manages returns true if a caller key may list and revoke a key
+func manages(caller, k APIKey) bool {
+	return caller.IsSystem() || (caller.Org == k.Org && !k.IsSystem())
+}
+
+// GetAPIKeys is GET handler to list API keys of the caller org, without keys
+// nor hashes
+func (a *Auth) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	caller, _ := FromContext(r.Context())
+	keys := []APIKey{}
+	for _, v := range a.db.List(DB_TABLE_API_KEYS) {
+		if k, ok := v.(APIKey); ok && manages(caller, k) {
+			if used, err := a.db.Get(DB_TABLE_API_KEY_USAGE, k.Hash); err == nil {
+				k.LastUsed, _ = used.(string)
+			}
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created != keys[j].Created {
+			return keys[i].Created < keys[j].Created
+		}
+		return keys[i].ID < keys[j].ID
+	})
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIKeyList{Keys: keys})
+}
+
+// RevokeAPIKey is DELETE handler to revoke an API key by id
+func (a *Auth) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	caller, _ := FromContext(r.Context())
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, v := range a.db.List(DB_TABLE_API_KEYS) {
+		k, ok := v.(APIKey)
+		if !ok || k.ID != id || !manages(caller, k) {
+			continue
+		}
+		if k.Revoked == "" {
+			k.Revoked = time.Now().UTC().Format(time.RFC3339)
+			a.db.Add(DB_TABLE_API_KEYS, k.Hash, k)
+			log.Printf("revoked API key %v (%v)\n", k.ID, k.Name)
+		}
+
+		// return JSON response
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(k)
+		return
+	}
+	http.Error(w, "Data not found", http.StatusNotFound)
+}
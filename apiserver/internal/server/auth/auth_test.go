@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// newTestAuth returns an authentication element with a key of each scope of
+// org o1, and a system admin key, named after their scope
+func newTestAuth(t *testing.T) (*Auth, map[string]APIKey) {
+	t.Helper()
+	a := NewAuth(db.NewMemoryDb(DB_TABLE_API_KEYS, DB_TABLE_API_KEY_USAGE), "")
+	keys := map[string]APIKey{}
+	for _, scope := range Scopes {
+		k, err := a.AddKey(scope, APIKeyRequest{Name: scope, Scope: scope, Org: "o1"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[scope] = k
+	}
+	if err := a.Bootstrap("system"); err != nil {
+		t.Fatal(err)
+	}
+	keys["system"], _ = a.Authenticate(withKey(httptest.NewRequest("GET", "/v1/app", nil), "system"))
+	return a, keys
+}
+
+// withKey sets the API key of a request
+func withKey(r *http.Request, key string) *http.Request {
+	if key != "" {
+		r.Header.Set(DEFAULT_HEADER, BEARER+key)
+	}
+	return r
+}
+
+func TestHash(t *testing.T) {
+	// keys are stored by their SHA-256 hash, never in clear
+	if h := Hash("abc"); h != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatalf("expecting SHA-256 hex digest, got %v", h)
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuth(db.NewMemoryDb(DB_TABLE_API_KEYS, DB_TABLE_API_KEY_USAGE), "")
+	k, err := a.AddKey(key, APIKeyRequest{Name: "k", Scope: SCOPE_INGEST, Org: "o1"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Hash != Hash(key) || k.Prefix != key[:KEY_PREFIX_LEN] {
+		t.Fatalf("expecting key hash and prefix, got %+v", k)
+	}
+	if _, err := a.db.Get(DB_TABLE_API_KEYS, key); err == nil {
+		t.Fatalf("expecting key not stored in clear")
+	}
+
+	// short keys have no prefix, not to disclose them
+	if p := keyPrefix("short"); p != "" {
+		t.Fatalf("expecting no prefix of a short key, got %v", p)
+	}
+}
+
+func TestAddKeyInvalid(t *testing.T) {
+	a := NewAuth(db.NewMemoryDb(DB_TABLE_API_KEYS, DB_TABLE_API_KEY_USAGE), "")
+	tests := []struct {
+		name string
+		key  string
+		req  APIKeyRequest
+	}{
+		{"scope", "k", APIKeyRequest{Scope: "owner", Org: "o1"}},
+		{"org", "k", APIKeyRequest{Scope: SCOPE_ADMIN, Org: "o/1"}},
+		{"group", "k", APIKeyRequest{Scope: SCOPE_ADMIN, Org: "o1", Groups: []string{""}}},
+		{"empty key", "", APIKeyRequest{Scope: SCOPE_ADMIN, Org: "o1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.AddKey(tt.key, tt.req, ""); err == nil {
+				t.Fatalf("expecting invalid %v to fail", tt.name)
+			}
+		})
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method   string
+		path     string
+		scope    string
+		readOnly bool
+	}{
+		{"GET", "/v1/app", SCOPE_READ_ONLY, true},
+		{"HEAD", "/v1/app/a1", SCOPE_READ_ONLY, true},
+		{"POST", "/v1/app", SCOPE_INGEST, false},
+		{"PUT", "/v1/app/a1", SCOPE_INGEST, false},
+		{"DELETE", "/v1/app/a1", SCOPE_INGEST, false},
+		{"POST", "/v1/graphql", SCOPE_READ_ONLY, true},
+		{"POST", "/v1/app/a1/query", SCOPE_READ_ONLY, true},
+		{"PUT", "/v1/graphql", SCOPE_INGEST, false},
+		{"POST", "/v1/graphql/schema", SCOPE_INGEST, false},
+		{"GET", "/v1/apiKey", SCOPE_ADMIN, true},
+		{"GET", "/v1/notifications", SCOPE_ADMIN, true},
+		{"GET", "/v1/audit", SCOPE_ADMIN, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if got := RequiredScope(r); got != tt.scope {
+				t.Errorf("expecting scope %v, got %v", tt.scope, got)
+			}
+			if got := ReadOnly(r); got != tt.readOnly {
+				t.Errorf("expecting read-only %v, got %v", tt.readOnly, got)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	a, _ := newTestAuth(t)
+	a.AddKey("revoked", APIKeyRequest{Name: "revoked", Scope: SCOPE_ADMIN, Org: "o1"}, "")
+	k, _ := a.Authenticate(withKey(httptest.NewRequest("GET", "/v1/app", nil), "revoked"))
+	k.Revoked = "2024-11-01T00:00:00Z"
+	a.db.Add(DB_TABLE_API_KEYS, k.Hash, k)
+
+	var authenticated APIKey
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticated, _ = FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		code   int
+	}{
+		{"no key", "GET", "/v1/app", "", http.StatusUnauthorized},
+		{"unknown key", "GET", "/v1/app", "unknown", http.StatusUnauthorized},
+		{"revoked key", "GET", "/v1/app", "revoked", http.StatusUnauthorized},
+		{"read-only read", "GET", "/v1/app", SCOPE_READ_ONLY, http.StatusOK},
+		{"read-only query", "POST", "/v1/graphql", SCOPE_READ_ONLY, http.StatusOK},
+		{"read-only write", "POST", "/v1/app", SCOPE_READ_ONLY, http.StatusForbidden},
+		{"read-only delete", "DELETE", "/v1/app/a1", SCOPE_READ_ONLY, http.StatusForbidden},
+		{"read-only admin", "GET", "/v1/apiKey", SCOPE_READ_ONLY, http.StatusForbidden},
+		{"ingest write", "POST", "/v1/app", SCOPE_INGEST, http.StatusOK},
+		{"ingest admin", "GET", "/v1/audit", SCOPE_INGEST, http.StatusForbidden},
+		{"admin", "POST", "/v1/apiKey", SCOPE_ADMIN, http.StatusOK},
+		{"system", "DELETE", "/v1/notifications/n1", "system", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticated = APIKey{}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, withKey(httptest.NewRequest(tt.method, tt.path, nil), tt.key))
+			if w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+			}
+			if tt.code == http.StatusOK && authenticated.Name != tt.key && !(tt.key == "system" && authenticated.IsSystem()) {
+				t.Fatalf("expecting key %v in context, got %+v", tt.key, authenticated)
+			}
+			if tt.code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Fatalf("expecting Bearer challenge, got %v", w.Header())
+			}
+		})
+	}
+
+	// keys are accepted without the Bearer scheme too, and their use recorded
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/v1/app", nil)
+	r.Header.Set(DEFAULT_HEADER, SCOPE_INGEST)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expecting key without scheme accepted, got %v", w.Code)
+	}
+	if _, err := a.db.Get(DB_TABLE_API_KEY_USAGE, Hash(SCOPE_INGEST)); err != nil {
+		t.Fatalf("expecting last use recorded, got %v", err)
+	}
+}
+
+func TestManages(t *testing.T) {
+	system := APIKey{Org: ORG_ALL}
+	admin := APIKey{Org: "o1", Groups: []string{"g1"}}
+	tests := []struct {
+		name   string
+		caller APIKey
+		k      APIKey
+		want   bool
+	}{
+		{"system manages system", system, APIKey{Org: ORG_ALL}, true},
+		{"system manages org keys", system, APIKey{Org: "o1"}, true},
+		{"org admin manages own org", admin, APIKey{Org: "o1"}, true},
+		{"org admin ignores other orgs", admin, APIKey{Org: "o2"}, false},
+		{"org admin ignores system keys", admin, APIKey{Org: ORG_ALL}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := manages(tt.caller, tt.k); got != tt.want {
+				t.Fatalf("expecting %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	a, keys := newTestAuth(t)
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/apiKey/{id}", a.RevokeAPIKey).Methods("DELETE")
+	h := a.Middleware(r)
+
+	tests := []struct {
+		name   string
+		caller string
+		id     string
+		code   int
+	}{
+		{"unknown key", SCOPE_ADMIN, "k0", http.StatusNotFound},
+		{"system key by org admin", SCOPE_ADMIN, keys["system"].ID, http.StatusNotFound},
+		{"org key", SCOPE_ADMIN, keys[SCOPE_INGEST].ID, http.StatusOK},
+		{"revoked again", "system", keys[SCOPE_INGEST].ID, http.StatusOK},
+		{"own key", SCOPE_ADMIN, keys[SCOPE_ADMIN].ID, http.StatusOK},
+		{"by a revoked key", SCOPE_ADMIN, keys[SCOPE_READ_ONLY].ID, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, withKey(httptest.NewRequest("DELETE", "/v1/apiKey/"+tt.id, nil), tt.caller))
+			if w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+			}
+		})
+	}
+
+	// revoked keys no longer authenticate
+	for _, scope := range []string{SCOPE_INGEST, SCOPE_ADMIN} {
+		if _, ok := a.Authenticate(withKey(httptest.NewRequest("GET", "/v1/app", nil), scope)); ok {
+			t.Fatalf("expecting revoked %v key rejected", scope)
+		}
+		v, _ := a.db.Get(DB_TABLE_API_KEYS, keys[scope].Hash)
+		if v.(APIKey).Revoked == "" {
+			t.Fatalf("expecting %v key revoked", scope)
+		}
+	}
+	if _, ok := a.Authenticate(withKey(httptest.NewRequest("GET", "/v1/app", nil), SCOPE_READ_ONLY)); !ok {
+		t.Fatalf("expecting read-only key kept")
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"sync"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Auth represents API key authentication of REST requests
+type Auth struct {
+	db db.Db
+
+	// request header carrying API keys
+	header string
+
+	// serializes updates of API key records, shared with Auths on other DBs
+	mutex *sync.Mutex
+}
+
+// APIKey is an API key, stored by hash, never in clear
+type APIKey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scope     string   `json:"scope"`  // role of the key in its org
+	Org       string   `json:"org"`    // tenant of the key, * for all
+	Groups    []string `json:"groups"` // groups of the org, empty for all
+	Prefix    string   `json:"prefix"` // leading characters of the key, to recognize it
+	Hash      string   `json:"-"`
+	Created   string   `json:"created"`
+	CreatedBy string   `json:"createdBy"`
+	LastUsed  string   `json:"lastUsed"`
+	Revoked   string   `json:"revoked,omitempty"`
+}
+
+// list of API keys
+type APIKeyList struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// APIKeyRequest is the request to create an API key
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scope  string   `json:"scope"`
+	Org    string   `json:"org"`
+	Groups []string `json:"groups"`
+}
+
+// CreatedAPIKey is a newly created API key, the only time its key is returned
+type CreatedAPIKey struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"apiKey"`
+}
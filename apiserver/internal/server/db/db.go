@@ -1,10 +1,76 @@
 package db
 
-// generic Db interface
+// generic Db interface, of tables of entries in key order, which MemoryDb
+// implements in memory and a persistent backend implements to store tenants.
+// Values are stored as given, records of typed repositories being read back
+// as JSON too. Backends implement Snapshotter and Committer for snapshot
+// isolated transactions with conflict detection, else transactions read
+// through and commit writes one by one; Batcher for atomic commits; and
+// Recorder for audit before-images read atomically with writes.
 type Db interface {
 	Ping() error
 	Add(table, key string, value interface{}) error
 	Del(table, key string) error
 	Get(table, key string) (interface{}, error)
 	List(table string) []interface{}
+	Keys(table string) []string
+
+	// Scan lists entries with keys starting with a prefix, in key order
+	Scan(table, prefix string) []KeyValue
+
+	// Range lists entries with keys from start up to but excluding end, in
+	// key order, an empty end being unbounded
+	Range(table, start, end string) []KeyValue
+
+	// CreateIndex creates a secondary index on a table, maintained on writes
+	CreateIndex(table, index string, fn IndexFunc) error
+
+	// Indexes returns index functions of secondary indexes on a table
+	Indexes(table string) map[string]IndexFunc
+
+	// Lookup lists entries with a given value in a secondary index, in key
+	// order
+	Lookup(table, index, value string) ([]KeyValue, error)
+
+	// Version returns the version of an entry, increasing on each write of it
+	Version(table, key string) (uint64, error)
+
+	// Watch streams events of entries of a table with keys starting with a
+	// prefix, in order of writes, until cancelled or lagging behind writes,
+	// when the channel is closed
+	Watch(table, prefix string) (<-chan Event, func())
+
+	// Begin begins a transaction reading a consistent snapshot of the DB
+	Begin() (Tx, error)
+}
+
+// KeyValue is an entry of a table
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// IndexFunc returns values an entry is indexed by, none to leave it out
+type IndexFunc func(key string, value interface{}) []string
+
+// PrefixEnd returns the first key after all keys starting with a prefix, ""
+// when unbounded
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// Values returns values of entries
+func Values(rows []KeyValue) []interface{} {
+	ret := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		ret = append(ret, row.Value)
+	}
+	return ret
 }
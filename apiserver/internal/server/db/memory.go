@@ -2,6 +2,8 @@ package db
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -15,13 +17,45 @@ type (
 
 		// mutex to ensure safe concurrent access
 		Mutex sync.RWMutex
+
+		// "table" -> sorted keys, rebuilt after keys are added or deleted
+		sorted map[string][]string
+
+		// "table" -> "index" -> secondary index
+		indexes map[string]map[string]*memoryIndex
+
+		// sequence number of the last write, and "table" -> key -> sequence
+		// number of its last write or delete
+		seq      uint64
+		versions map[string]map[string]uint64
+
+		// tables shared with live snapshots, copied before their next write
+		shared    map[string]bool
+		snapshots int
+
+		// snapshots are read-only
+		readOnly bool
+
+		// watchers of writes and deletes, by id
+		watchers    map[int]*watcher
+		nextWatcher int
+	}
+
+	// secondary index of a table
+	memoryIndex struct {
+		fn IndexFunc
+
+		// index value -> set of keys
+		keys map[string]map[string]bool
 	}
 )
 
 // NewMemoryDb creates a new in memory DB initialized with a list of tables
 func NewMemoryDb(tables ...string) *MemoryDb {
 	m := &MemoryDb{
-		Rows: make(map[string]MemoryEntry),
+		Rows:    make(map[string]MemoryEntry),
+		sorted:  make(map[string][]string),
+		indexes: make(map[string]map[string]*memoryIndex),
 	}
 	for _, t := range tables {
 		m.Rows[t] = make(map[string]interface{})
@@ -34,16 +68,180 @@ func (db *MemoryDb) Ping() error {
 	return nil
 }
 
+// add indexes a key of a table for a value
+func (idx *memoryIndex) add(key string, value interface{}) {
+	for _, v := range idx.fn(key, value) {
+		if _, ok := idx.keys[v]; !ok {
+			idx.keys[v] = make(map[string]bool)
+		}
+		idx.keys[v][key] = true
+	}
+}
+
+// remove unindexes a key of a table for a value
+func (idx *memoryIndex) remove(key string, value interface{}) {
+	for _, v := range idx.fn(key, value) {
+		delete(idx.keys[v], key)
+		if len(idx.keys[v]) == 0 {
+			delete(idx.keys, v)
+		}
+	}
+}
+
+// own copies a table, its versions and indexes when shared with snapshots,
+// before writing it with the write lock held
+func (db *MemoryDb) own(table string) {
+	if !db.shared[table] {
+		return
+	}
+	tab := make(MemoryEntry, len(db.Rows[table]))
+	for key, value := range db.Rows[table] {
+		tab[key] = value
+	}
+	db.Rows[table] = tab
+	if versions, ok := db.versions[table]; ok {
+		c := make(map[string]uint64, len(versions))
+		for key, v := range versions {
+			c[key] = v
+		}
+		db.versions[table] = c
+	}
+	for name, idx := range db.indexes[table] {
+		c := &memoryIndex{
+			fn:   idx.fn,
+			keys: make(map[string]map[string]bool, len(idx.keys)),
+		}
+		for v, keys := range idx.keys {
+			c.keys[v] = make(map[string]bool, len(keys))
+			for key := range keys {
+				c.keys[v][key] = true
+			}
+		}
+		db.indexes[table][name] = c
+	}
+	delete(db.shared, table)
+}
+
+// written records the sequence number of a write or delete of a key
+func (db *MemoryDb) written(table, key string) {
+	if db.versions == nil {
+		db.versions = make(map[string]map[string]uint64)
+	}
+	if _, ok := db.versions[table]; !ok {
+		db.versions[table] = make(map[string]uint64)
+	}
+	db.versions[table][key] = db.seq
+}
+
+// put writes an entry, maintaining sorted keys and indexes, with the write
+// lock held, returning its change
+func (db *MemoryDb) put(table, key string, value interface{}) Change {
+	db.own(table)
+	db.written(table, key)
+	tab := db.Rows[table]
+	old, ok := tab[key]
+	if !ok {
+		delete(db.sorted, table)
+	}
+	for _, idx := range db.indexes[table] {
+		if ok {
+			idx.remove(key, old)
+		}
+		idx.add(key, value)
+	}
+	tab[key] = value
+
+	ev := Event{Type: EVENT_CREATE, Table: table, Key: key, Version: db.seq, Value: value}
+	if ok {
+		ev.Type = EVENT_UPDATE
+	}
+	db.emit(ev)
+	return Change{Table: table, Key: key, Version: db.seq, Before: old, After: value}
+}
+
+// remove deletes an entry, maintaining sorted keys and indexes, with the
+// write lock held, returning its change if present
+func (db *MemoryDb) remove(table, key string) (Change, bool) {
+	old, ok := db.Rows[table][key]
+	if !ok {
+		return Change{}, false
+	}
+	db.own(table)
+	db.written(table, key)
+	tab := db.Rows[table]
+	delete(db.sorted, table)
+	for _, idx := range db.indexes[table] {
+		idx.remove(key, old)
+	}
+	delete(tab, key)
+	db.emit(Event{Type: EVENT_DELETE, Table: table, Key: key, Version: db.seq})
+	return Change{Table: table, Key: key, Version: db.seq, Before: old}, true
+}
+
+// emit sends an event to its watchers, dropping those whose buffer is full,
+// with the write lock held
+func (db *MemoryDb) emit(ev Event) {
+	for id, w := range db.watchers {
+		if w.table != ev.Table || !strings.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.events <- ev:
+		default:
+			close(w.events)
+			delete(db.watchers, id)
+		}
+	}
+}
+
+// Watch streams events of entries of a table with keys starting with a
+// prefix, in order of writes, until cancelled or lagging behind writes by
+// more than WATCH_BUFFER events, when the channel is closed
+func (db *MemoryDb) Watch(table, prefix string) (<-chan Event, func()) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	w := &watcher{
+		table:  table,
+		prefix: prefix,
+		events: make(chan Event, WATCH_BUFFER),
+	}
+	if db.readOnly {
+		// snapshots never change
+		var once sync.Once
+		return w.events, func() { once.Do(func() { close(w.events) }) }
+	}
+	if db.watchers == nil {
+		db.watchers = make(map[int]*watcher)
+	}
+	id := db.nextWatcher
+	db.nextWatcher++
+	db.watchers[id] = w
+
+	return w.events, func() {
+		db.Mutex.Lock()
+		defer db.Mutex.Unlock()
+
+		if _, ok := db.watchers[id]; ok {
+			close(w.events)
+			delete(db.watchers, id)
+		}
+	}
+}
+
 // Add adds a new entry on a given table using key
 func (db *MemoryDb) Add(table, key string, value interface{}) error {
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
 
-	tab, ok := db.Rows[table]
-	if !ok {
+	if db.readOnly {
+		return fmt.Errorf("Add: snapshot is read-only")
+	}
+	if _, ok := db.Rows[table]; !ok {
 		return fmt.Errorf("Add: unable to find table %v", table)
 	}
-	tab[key] = value
+	db.seq++
+	db.put(table, key, value)
 	return nil
 }
 
@@ -52,11 +250,14 @@ func (db *MemoryDb) Del(table, key string) error {
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
 
-	tab, ok := db.Rows[table]
-	if !ok {
+	if db.readOnly {
+		return fmt.Errorf("Del: snapshot is read-only")
+	}
+	if _, ok := db.Rows[table]; !ok {
 		return fmt.Errorf("Del: unable to find table %v", table)
 	}
-	delete(tab, key)
+	db.seq++
+	db.remove(table, key)
 	return nil
 }
 
@@ -76,6 +277,18 @@ func (db *MemoryDb) Get(table, key string) (interface{}, error) {
 	return val, nil
 }
 
+// Version returns the version of an entry, the sequence number of its last
+// write
+func (db *MemoryDb) Version(table, key string) (uint64, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if _, ok := db.Rows[table][key]; !ok {
+		return 0, fmt.Errorf("Version: unable to find key %v on table %v", key, table)
+	}
+	return db.versions[table][key], nil
+}
+
 // List all entries on a given table
 func (db *MemoryDb) List(table string) []interface{} {
 	db.Mutex.RLock()
@@ -91,3 +304,298 @@ func (db *MemoryDb) List(table string) []interface{} {
 	}
 	return ret
 }
+
+// Keys lists all keys on a given table
+func (db *MemoryDb) Keys(table string) []string {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	ret := make([]string, 0)
+	for key := range db.Rows[table] {
+		ret = append(ret, key)
+	}
+	return ret
+}
+
+// sortedKeys returns sorted keys of a table, rebuilding them when stale, with
+// the write lock held
+func (db *MemoryDb) sortedKeys(table string) []string {
+	if keys, ok := db.sorted[table]; ok {
+		return keys
+	}
+	keys := make([]string, 0, len(db.Rows[table]))
+	for key := range db.Rows[table] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if db.sorted == nil {
+		db.sorted = make(map[string][]string)
+	}
+	db.sorted[table] = keys
+	return keys
+}
+
+// scan returns entries of sorted keys selected by a function, holding the
+// lock throughout so all entries are read from one state of the table
+func (db *MemoryDb) scan(table string, sel func(keys []string) []string) []KeyValue {
+	db.Mutex.RLock()
+	keys, ok := db.sorted[table]
+	if !ok {
+		db.Mutex.RUnlock()
+		db.Mutex.Lock()
+		defer db.Mutex.Unlock()
+		keys = db.sortedKeys(table)
+	} else {
+		defer db.Mutex.RUnlock()
+	}
+
+	keys = sel(keys)
+	ret := make([]KeyValue, 0, len(keys))
+	for _, key := range keys {
+		ret = append(ret, KeyValue{Key: key, Value: db.Rows[table][key]})
+	}
+	return ret
+}
+
+// Scan lists entries with keys starting with a prefix, in key order
+func (db *MemoryDb) Scan(table, prefix string) []KeyValue {
+	return db.scan(table, func(keys []string) []string {
+		start := sort.SearchStrings(keys, prefix)
+		end := start
+		for end < len(keys) && strings.HasPrefix(keys[end], prefix) {
+			end++
+		}
+		return keys[start:end]
+	})
+}
+
+// Range lists entries with keys from start up to but excluding end, in key
+// order, an empty end being unbounded
+func (db *MemoryDb) Range(table, start, end string) []KeyValue {
+	return db.scan(table, func(keys []string) []string {
+		from := sort.SearchStrings(keys, start)
+		to := len(keys)
+		if end != "" {
+			to = sort.SearchStrings(keys, end)
+		}
+		if to < from {
+			to = from
+		}
+		return keys[from:to]
+	})
+}
+
+// CreateIndex creates a secondary index on a table, indexing its existing
+// entries
+func (db *MemoryDb) CreateIndex(table, index string, fn IndexFunc) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if db.readOnly {
+		return fmt.Errorf("CreateIndex: snapshot is read-only")
+	}
+	tab, ok := db.Rows[table]
+	if !ok {
+		return fmt.Errorf("CreateIndex: unable to find table %v", table)
+	}
+	idx := &memoryIndex{
+		fn:   fn,
+		keys: make(map[string]map[string]bool),
+	}
+	for key, value := range tab {
+		idx.add(key, value)
+	}
+	if db.indexes == nil {
+		db.indexes = make(map[string]map[string]*memoryIndex)
+	}
+	if _, ok := db.indexes[table]; !ok {
+		db.indexes[table] = make(map[string]*memoryIndex)
+	}
+	db.indexes[table][index] = idx
+	return nil
+}
+
+// Indexes returns index functions of secondary indexes on a table
+func (db *MemoryDb) Indexes(table string) map[string]IndexFunc {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	ret := make(map[string]IndexFunc)
+	for name, idx := range db.indexes[table] {
+		ret[name] = idx.fn
+	}
+	return ret
+}
+
+// Lookup lists entries with a given value in a secondary index, in key order
+func (db *MemoryDb) Lookup(table, index, value string) ([]KeyValue, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	idx, ok := db.indexes[table][index]
+	if !ok {
+		return nil, fmt.Errorf("Lookup: unable to find index %v on table %v", index, table)
+	}
+	ret := make([]KeyValue, 0, len(idx.keys[value]))
+	for key := range idx.keys[value] {
+		ret = append(ret, KeyValue{Key: key, Value: db.Rows[table][key]})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret, nil
+}
+
+// Apply atomically adds and deletes batches of entries on given tables
+func (db *MemoryDb) Apply(rows map[string]MemoryEntry, deleted map[string]map[string]bool) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	_, err := db.apply("Apply", rows, deleted)
+	return err
+}
+
+// ApplyChanges atomically adds and deletes batches of entries, returning their
+// changes along with the values they replaced
+func (db *MemoryDb) ApplyChanges(rows map[string]MemoryEntry, deleted map[string]map[string]bool) ([]Change, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	return db.apply("Apply", rows, deleted)
+}
+
+// apply adds and deletes batches of entries as one write, with the write lock
+// held, returning their changes
+func (db *MemoryDb) apply(op string, rows map[string]MemoryEntry, deleted map[string]map[string]bool) ([]Change, error) {
+	if db.readOnly {
+		return nil, fmt.Errorf("%v: snapshot is read-only", op)
+	}
+	for table := range rows {
+		if _, ok := db.Rows[table]; !ok {
+			return nil, fmt.Errorf("%v: unable to find table %v", op, table)
+		}
+	}
+	for table := range deleted {
+		if _, ok := db.Rows[table]; !ok {
+			return nil, fmt.Errorf("%v: unable to find table %v", op, table)
+		}
+	}
+	db.seq++
+	changes := []Change{}
+	for table, keys := range deleted {
+		for key := range keys {
+			if _, ok := rows[table][key]; ok {
+				continue
+			}
+			if c, ok := db.remove(table, key); ok {
+				changes = append(changes, c)
+			}
+		}
+	}
+	for table, tab := range rows {
+		for key, value := range tab {
+			changes = append(changes, db.put(table, key, value))
+		}
+	}
+	return changes, nil
+}
+
+// Snapshot returns a read-only view of the DB as of now, sharing its tables
+// until their next write, along with the sequence number of the last write
+func (db *MemoryDb) Snapshot() (Db, uint64) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if db.readOnly {
+		return db, db.seq
+	}
+	view := &MemoryDb{
+		Rows:     make(map[string]MemoryEntry, len(db.Rows)),
+		sorted:   make(map[string][]string, len(db.sorted)),
+		indexes:  make(map[string]map[string]*memoryIndex, len(db.indexes)),
+		versions: make(map[string]map[string]uint64, len(db.versions)),
+		seq:      db.seq,
+		readOnly: true,
+	}
+	if db.shared == nil {
+		db.shared = make(map[string]bool)
+	}
+	for table, tab := range db.Rows {
+		view.Rows[table] = tab
+		db.shared[table] = true
+	}
+	for table, keys := range db.sorted {
+		view.sorted[table] = keys
+	}
+	for table, versions := range db.versions {
+		view.versions[table] = versions
+	}
+	for table, indexes := range db.indexes {
+		view.indexes[table] = make(map[string]*memoryIndex, len(indexes))
+		for name, idx := range indexes {
+			view.indexes[table][name] = idx
+		}
+	}
+	db.snapshots++
+	return view, db.seq
+}
+
+// Release releases a snapshot, tables no longer being copied on writes once
+// no snapshot is live
+func (db *MemoryDb) Release(snapshot Db) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if snapshot == Db(db) || db.snapshots == 0 {
+		return
+	}
+	db.snapshots--
+	if db.snapshots == 0 {
+		db.shared = nil
+	}
+}
+
+// CommitAt atomically adds and deletes batches of entries, failing with
+// ErrConflict if any of them was written after a given sequence number, and
+// returns the sequence number of the commit
+func (db *MemoryDb) CommitAt(seq uint64, rows map[string]MemoryEntry, deleted map[string]map[string]bool) (uint64, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if _, err := db.commit(seq, rows, deleted); err != nil {
+		return 0, err
+	}
+	return db.seq, nil
+}
+
+// CommitChanges commits batches of entries as CommitAt does, returning their
+// changes along with the values they replaced
+func (db *MemoryDb) CommitChanges(seq uint64, rows map[string]MemoryEntry, deleted map[string]map[string]bool) ([]Change, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	return db.commit(seq, rows, deleted)
+}
+
+// commit commits batches of entries unless any of them was written after a
+// given sequence number, with the write lock held
+func (db *MemoryDb) commit(seq uint64, rows map[string]MemoryEntry, deleted map[string]map[string]bool) ([]Change, error) {
+	for table, tab := range rows {
+		for key := range tab {
+			if db.versions[table][key] > seq {
+				return nil, ErrConflict
+			}
+		}
+	}
+	for table, keys := range deleted {
+		for key := range keys {
+			if db.versions[table][key] > seq {
+				return nil, ErrConflict
+			}
+		}
+	}
+	return db.apply("CommitAt", rows, deleted)
+}
+
+// Begin begins a transaction reading a snapshot of the DB
+func (db *MemoryDb) Begin() (Tx, error) {
+	return NewTx(db), nil
+}
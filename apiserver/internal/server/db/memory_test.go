@@ -0,0 +1,104 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lookupKeys returns keys of entries with a given value in an index
+func lookupKeys(t *testing.T, d Db, index, value string) []string {
+	t.Helper()
+	rows, err := d.Lookup("t", index, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, kv := range rows {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func TestLookup(t *testing.T) {
+	d := NewMemoryDb("t")
+	d.Add("t", "prod/k1", "a")
+
+	// entries are indexed by the app prefix of their key and by value
+	if err := d.CreateIndex("t", "app", func(key string, _ interface{}) []string {
+		aid, _, _ := strings.Cut(key, "/")
+		return []string{aid}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateIndex("t", "value", func(_ string, value interface{}) []string {
+		if s, _ := value.(string); s != "" {
+			return []string{s}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		write func(t *testing.T)
+		index string
+		value string
+		keys  []string
+	}{
+		{"existing entries", func(t *testing.T) {}, "value", "a", []string{"prod/k1"}},
+		{"added", func(t *testing.T) {
+			d.Add("t", "prod/k2", "a")
+			d.Add("t", "prod2/k1", "b")
+		}, "value", "a", []string{"prod/k1", "prod/k2"}},
+		{"app prefix", func(t *testing.T) {}, "app", "prod", []string{"prod/k1", "prod/k2"}},
+		{"longer app prefix", func(t *testing.T) {}, "app", "prod2", []string{"prod2/k1"}},
+		{"updated out", func(t *testing.T) {
+			d.Add("t", "prod/k1", "b")
+		}, "value", "a", []string{"prod/k2"}},
+		{"updated in", func(t *testing.T) {}, "value", "b", []string{"prod/k1", "prod2/k1"}},
+		{"left out", func(t *testing.T) {
+			d.Add("t", "prod2/k1", "")
+		}, "value", "b", []string{"prod/k1"}},
+		{"deleted", func(t *testing.T) {
+			d.Del("t", "prod/k2")
+		}, "app", "prod", []string{"prod/k1"}},
+		{"committed", func(t *testing.T) {
+			tx, _ := d.Begin()
+			tx.Add("t", "prod/k3", "a")
+			tx.Add("t", "prod/k1", "a")
+			tx.Del("t", "prod2/k1")
+			if keys := lookupKeys(t, d, "value", "a"); len(keys) != 0 {
+				t.Fatalf("expecting uncommitted writes unindexed, got %v", keys)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}, "value", "a", []string{"prod/k1", "prod/k3"}},
+		{"committed deletes", func(t *testing.T) {}, "app", "prod2", []string{}},
+		{"committed updates", func(t *testing.T) {}, "value", "b", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.write(t)
+			if keys := lookupKeys(t, d, tt.index, tt.value); !reflect.DeepEqual(keys, tt.keys) {
+				t.Fatalf("expecting %v, got %v", tt.keys, keys)
+			}
+		})
+	}
+
+	// snapshots keep the index as of when they were taken
+	snap, _ := d.Snapshot()
+	d.Del("t", "prod/k3")
+	if keys := lookupKeys(t, snap, "value", "a"); !reflect.DeepEqual(keys, []string{"prod/k1", "prod/k3"}) {
+		t.Fatalf("expecting snapshot index unchanged, got %v", keys)
+	}
+	d.Release(snap)
+	if keys := lookupKeys(t, d, "value", "a"); !reflect.DeepEqual(keys, []string{"prod/k1"}) {
+		t.Fatalf("expecting deleted entry unindexed, got %v", keys)
+	}
+	if _, err := d.Lookup("t", "missing", "a"); err == nil {
+		t.Fatalf("expecting unknown index to fail")
+	}
+}
@@ -0,0 +1,242 @@
+package db
+
+// Change is a write or delete of an entry, Before and After are nil when the
+// entry is absent
+type Change struct {
+	Table   string
+	Key     string
+	Version uint64
+	Before  interface{}
+	After   interface{}
+}
+
+// Recorder is a DB applying batches of writes and deletes, and returning their
+// changes along with the values they replaced, read under the same lock as
+// the writes
+type Recorder interface {
+	ApplyChanges(rows map[string]MemoryEntry, deleted map[string]map[string]bool) ([]Change, error)
+	CommitChanges(seq uint64, rows map[string]MemoryEntry, deleted map[string]map[string]bool) ([]Change, error)
+}
+
+// observed DB, notifying an observer of writes and deletes on a base DB
+type ObservedDb struct {
+	Base Db
+
+	// called after each write, delete or batch
+	Observer func(changes []Change)
+}
+
+// NewObservedDb creates a new observed DB on top of a base DB
+func NewObservedDb(base Db, observer func(changes []Change)) *ObservedDb {
+	return &ObservedDb{
+		Base:     base,
+		Observer: observer,
+	}
+}
+
+// With returns an observed DB on the same base DB notifying another observer,
+// e.g. one attributing changes to a request
+func (db *ObservedDb) With(observer func(changes []Change)) *ObservedDb {
+	return NewObservedDb(db.Base, observer)
+}
+
+// before returns the current value of a key, nil if absent, for base DBs not
+// being Recorders
+func (db *ObservedDb) before(table, key string) interface{} {
+	v, err := db.Base.Get(table, key)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// Ping returns success if base DB is reachable
+func (db *ObservedDb) Ping() error {
+	return db.Base.Ping()
+}
+
+// Add adds a new entry on a given table using key
+func (db *ObservedDb) Add(table, key string, value interface{}) error {
+	if r, ok := db.Base.(Recorder); ok {
+		changes, err := r.ApplyChanges(map[string]MemoryEntry{table: {key: value}}, nil)
+		if err != nil {
+			return err
+		}
+		db.notify(changes)
+		return nil
+	}
+	before := db.before(table, key)
+	if err := db.Base.Add(table, key, value); err != nil {
+		return err
+	}
+	db.Observer([]Change{{Table: table, Key: key, Before: before, After: value}})
+	return nil
+}
+
+// Del deletes a given key on a given table
+func (db *ObservedDb) Del(table, key string) error {
+	if r, ok := db.Base.(Recorder); ok {
+		changes, err := r.ApplyChanges(nil, map[string]map[string]bool{table: {key: true}})
+		if err != nil {
+			return err
+		}
+		db.notify(changes)
+		return nil
+	}
+	before := db.before(table, key)
+	if err := db.Base.Del(table, key); err != nil {
+		return err
+	}
+	if before != nil {
+		db.Observer([]Change{{Table: table, Key: key, Before: before}})
+	}
+	return nil
+}
+
+// Get returns the value a given key on a given table
+func (db *ObservedDb) Get(table, key string) (interface{}, error) {
+	return db.Base.Get(table, key)
+}
+
+// Version returns the version of an entry
+func (db *ObservedDb) Version(table, key string) (uint64, error) {
+	return db.Base.Version(table, key)
+}
+
+// List all entries on a given table
+func (db *ObservedDb) List(table string) []interface{} {
+	return db.Base.List(table)
+}
+
+// Keys lists all keys on a given table
+func (db *ObservedDb) Keys(table string) []string {
+	return db.Base.Keys(table)
+}
+
+// Scan lists entries with keys starting with a prefix, in key order
+func (db *ObservedDb) Scan(table, prefix string) []KeyValue {
+	return db.Base.Scan(table, prefix)
+}
+
+// Range lists entries with keys from start up to but excluding end
+func (db *ObservedDb) Range(table, start, end string) []KeyValue {
+	return db.Base.Range(table, start, end)
+}
+
+// CreateIndex creates a secondary index on a table of the base DB
+func (db *ObservedDb) CreateIndex(table, index string, fn IndexFunc) error {
+	return db.Base.CreateIndex(table, index, fn)
+}
+
+// Indexes returns index functions of secondary indexes on a table
+func (db *ObservedDb) Indexes(table string) map[string]IndexFunc {
+	return db.Base.Indexes(table)
+}
+
+// Lookup lists entries with a given value in a secondary index
+func (db *ObservedDb) Lookup(table, index, value string) ([]KeyValue, error) {
+	return db.Base.Lookup(table, index, value)
+}
+
+// changes returns changes of batches of writes and deletes, for base DBs not
+// being Recorders
+func (db *ObservedDb) changes(rows map[string]MemoryEntry, deleted map[string]map[string]bool) []Change {
+	changes := []Change{}
+	for table, keys := range deleted {
+		for key := range keys {
+			if _, ok := rows[table][key]; !ok {
+				if before := db.before(table, key); before != nil {
+					changes = append(changes, Change{Table: table, Key: key, Before: before})
+				}
+			}
+		}
+	}
+	for table, tab := range rows {
+		for key, value := range tab {
+			changes = append(changes, Change{Table: table, Key: key, Before: db.before(table, key), After: value})
+		}
+	}
+	return changes
+}
+
+// notify notifies the observer of a batch of changes
+func (db *ObservedDb) notify(changes []Change) {
+	if len(changes) > 0 {
+		db.Observer(changes)
+	}
+}
+
+// Apply adds and deletes batches of entries, atomically when the base DB is a
+// Batcher, and notifies them as one batch
+func (db *ObservedDb) Apply(rows map[string]MemoryEntry, deleted map[string]map[string]bool) error {
+	if r, ok := db.Base.(Recorder); ok {
+		changes, err := r.ApplyChanges(rows, deleted)
+		if err != nil {
+			return err
+		}
+		db.notify(changes)
+		return nil
+	}
+	changes := db.changes(rows, deleted)
+	if err := apply(db.Base, rows, deleted); err != nil {
+		return err
+	}
+	db.notify(changes)
+	return nil
+}
+
+// Snapshot returns a snapshot of the base DB when it is a Snapshotter, else
+// the base DB itself
+func (db *ObservedDb) Snapshot() (Db, uint64) {
+	if s, ok := db.Base.(Snapshotter); ok {
+		return s.Snapshot()
+	}
+	return db.Base, 0
+}
+
+// Release releases a snapshot of the base DB
+func (db *ObservedDb) Release(snapshot Db) {
+	if s, ok := db.Base.(Snapshotter); ok {
+		s.Release(snapshot)
+	}
+}
+
+// CommitAt commits batches of writes and deletes onto the base DB, checking
+// conflicts when it is a Committer, and notifies them as one batch. The
+// sequence number of the commit is 0 when the base DB has none.
+func (db *ObservedDb) CommitAt(seq uint64, rows map[string]MemoryEntry, deleted map[string]map[string]bool) (uint64, error) {
+	if r, ok := db.Base.(Recorder); ok {
+		changes, err := r.CommitChanges(seq, rows, deleted)
+		if err != nil {
+			return 0, err
+		}
+		db.notify(changes)
+		committed := uint64(0)
+		for _, c := range changes {
+			committed = c.Version
+		}
+		return committed, nil
+	}
+	c, ok := db.Base.(Committer)
+	if !ok {
+		return 0, db.Apply(rows, deleted)
+	}
+	changes := db.changes(rows, deleted)
+	committed, err := c.CommitAt(seq, rows, deleted)
+	if err != nil {
+		return 0, err
+	}
+	db.notify(changes)
+	return committed, nil
+}
+
+// Watch streams events of entries of the base DB
+func (db *ObservedDb) Watch(table, prefix string) (<-chan Event, func()) {
+	return db.Base.Watch(table, prefix)
+}
+
+// Begin begins a transaction reading a snapshot of the base DB and notifying
+// its writes and deletes as one batch on commit
+func (db *ObservedDb) Begin() (Tx, error) {
+	return NewTx(db), nil
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestObservedBeforeImages(t *testing.T) {
+	// concurrent increments of a key each observe the value they replaced, so
+	// that every value is replaced exactly once
+	const writers = 8
+	const writes = 200
+	var mutex sync.Mutex
+	replaced := map[interface{}]int{}
+	d := NewObservedDb(NewMemoryDb("t"), func(changes []Change) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, c := range changes {
+			replaced[c.Before]++
+		}
+	})
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				d.Add("t", "k", w*writes+i)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if len(replaced) != writers*writes {
+		t.Fatalf("expecting %v distinct before-images, got %v", writers*writes, len(replaced))
+	}
+	for v, n := range replaced {
+		if n != 1 {
+			t.Fatalf("expecting value %v replaced once, got %v", v, n)
+		}
+	}
+}
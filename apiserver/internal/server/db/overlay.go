@@ -0,0 +1,263 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// copy-on-write overlay DB, reads fall through to a base DB while writes and
+// deletes stay in the overlay and never reach the base
+type OverlayDb struct {
+	Base Db
+
+	// "table" -> map{} of written entries
+	Rows map[string]MemoryEntry
+
+	// "table" -> set of deleted keys
+	Deleted map[string]map[string]bool
+
+	// mutex to ensure safe concurrent access
+	Mutex sync.RWMutex
+}
+
+// Batcher is a DB applying batches of writes and deletes atomically
+type Batcher interface {
+	Apply(rows map[string]MemoryEntry, deleted map[string]map[string]bool) error
+}
+
+// NewOverlayDb creates a new copy-on-write overlay on top of a base DB
+func NewOverlayDb(base Db) *OverlayDb {
+	return &OverlayDb{
+		Base:    base,
+		Rows:    make(map[string]MemoryEntry),
+		Deleted: make(map[string]map[string]bool),
+	}
+}
+
+// Ping returns success if base DB is reachable
+func (db *OverlayDb) Ping() error {
+	return db.Base.Ping()
+}
+
+// Add adds a new entry on a given table using key, in the overlay only
+func (db *OverlayDb) Add(table, key string, value interface{}) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	tab, ok := db.Rows[table]
+	if !ok {
+		tab = make(MemoryEntry)
+		db.Rows[table] = tab
+	}
+	tab[key] = value
+	delete(db.Deleted[table], key)
+	return nil
+}
+
+// Del hides a given key on a given table, in the overlay only
+func (db *OverlayDb) Del(table, key string) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	delete(db.Rows[table], key)
+	if _, ok := db.Deleted[table]; !ok {
+		db.Deleted[table] = make(map[string]bool)
+	}
+	db.Deleted[table][key] = true
+	return nil
+}
+
+// Get returns the value a given key on a given table
+func (db *OverlayDb) Get(table, key string) (interface{}, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if val, ok := db.Rows[table][key]; ok {
+		return val, nil
+	}
+	if db.Deleted[table][key] {
+		return nil, fmt.Errorf("Get: unable to find key %v on table %v", key, table)
+	}
+	return db.Base.Get(table, key)
+}
+
+// Version returns the version of an entry, an entry written in the overlay
+// being one version past the base entry
+func (db *OverlayDb) Version(table, key string) (uint64, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if db.Deleted[table][key] {
+		return 0, fmt.Errorf("Version: unable to find key %v on table %v", key, table)
+	}
+	version, err := db.Base.Version(table, key)
+	if _, ok := db.Rows[table][key]; ok {
+		return version + 1, nil
+	}
+	return version, err
+}
+
+// List all entries on a given table
+func (db *OverlayDb) List(table string) []interface{} {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	ret := make([]interface{}, 0)
+	for _, i := range db.Rows[table] {
+		ret = append(ret, i)
+	}
+	for _, key := range db.Base.Keys(table) {
+		if _, ok := db.Rows[table][key]; ok || db.Deleted[table][key] {
+			continue
+		}
+		if val, err := db.Base.Get(table, key); err == nil {
+			ret = append(ret, val)
+		}
+	}
+	return ret
+}
+
+// Keys lists all keys on a given table
+func (db *OverlayDb) Keys(table string) []string {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	ret := make([]string, 0)
+	for key := range db.Rows[table] {
+		ret = append(ret, key)
+	}
+	for _, key := range db.Base.Keys(table) {
+		if _, ok := db.Rows[table][key]; !ok && !db.Deleted[table][key] {
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
+// merge returns base entries not written or deleted in the overlay along
+// with overlay entries matching a filter, in key order, with the lock held
+func (db *OverlayDb) merge(table string, base []KeyValue, match func(key string, value interface{}) bool) []KeyValue {
+	ret := make([]KeyValue, 0, len(base))
+	for _, row := range base {
+		if _, ok := db.Rows[table][row.Key]; !ok && !db.Deleted[table][row.Key] {
+			ret = append(ret, row)
+		}
+	}
+	for key, value := range db.Rows[table] {
+		if match(key, value) {
+			ret = append(ret, KeyValue{Key: key, Value: value})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret
+}
+
+// Scan lists entries with keys starting with a prefix, in key order
+func (db *OverlayDb) Scan(table, prefix string) []KeyValue {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	return db.merge(table, db.Base.Scan(table, prefix), func(key string, value interface{}) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// Range lists entries with keys from start up to but excluding end, in key
+// order, an empty end being unbounded
+func (db *OverlayDb) Range(table, start, end string) []KeyValue {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	return db.merge(table, db.Base.Range(table, start, end), func(key string, value interface{}) bool {
+		return key >= start && (end == "" || key < end)
+	})
+}
+
+// CreateIndex creates a secondary index on a table of the base DB, also
+// applying to entries of the overlay
+func (db *OverlayDb) CreateIndex(table, index string, fn IndexFunc) error {
+	return db.Base.CreateIndex(table, index, fn)
+}
+
+// Indexes returns index functions of secondary indexes on a table
+func (db *OverlayDb) Indexes(table string) map[string]IndexFunc {
+	return db.Base.Indexes(table)
+}
+
+// Lookup lists entries with a given value in a secondary index of the base
+// DB, evaluating the index on entries of the overlay
+func (db *OverlayDb) Lookup(table, index, value string) ([]KeyValue, error) {
+	fn, ok := db.Base.Indexes(table)[index]
+	if !ok {
+		return nil, fmt.Errorf("Lookup: unable to find index %v on table %v", index, table)
+	}
+	base, err := db.Base.Lookup(table, index, value)
+	if err != nil {
+		return nil, err
+	}
+
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	return db.merge(table, base, func(key string, v interface{}) bool {
+		for _, iv := range fn(key, v) {
+			if iv == value {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// Apply atomically adds and deletes batches of entries, in the overlay only
+func (db *OverlayDb) Apply(rows map[string]MemoryEntry, deleted map[string]map[string]bool) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	for table, keys := range deleted {
+		if _, ok := db.Deleted[table]; !ok {
+			db.Deleted[table] = make(map[string]bool)
+		}
+		for key := range keys {
+			delete(db.Rows[table], key)
+			db.Deleted[table][key] = true
+		}
+	}
+	for table, tab := range rows {
+		if _, ok := db.Rows[table]; !ok {
+			db.Rows[table] = make(MemoryEntry)
+		}
+		for key, value := range tab {
+			db.Rows[table][key] = value
+			delete(db.Deleted[table], key)
+		}
+	}
+	return nil
+}
+
+// Watch streams events of entries of the base DB, writes and deletes in the
+// overlay being seen once committed
+func (db *OverlayDb) Watch(table, prefix string) (<-chan Event, func()) {
+	return db.Base.Watch(table, prefix)
+}
+
+// Begin begins a transaction committing into the overlay
+func (db *OverlayDb) Begin() (Tx, error) {
+	return NewTx(db), nil
+}
+
+// Commit applies writes and deletes of the overlay onto its base DB,
+// atomically when the base DB is a Batcher, and clears the overlay
+func (db *OverlayDb) Commit() error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if err := apply(db.Base, db.Rows, db.Deleted); err != nil {
+		return err
+	}
+	db.Rows = make(map[string]MemoryEntry)
+	db.Deleted = make(map[string]map[string]bool)
+	return nil
+}
@@ -0,0 +1,252 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrRecord is returned when a stored value is not a record of the expected
+// type, or cannot be migrated to its current schema
+var ErrRecord = errors.New("invalid record")
+
+// Record is a stored value of a record type, along with the schema version it
+// was written with. Data is the value as written, or its JSON document when
+// read back from an encoded form.
+type Record struct {
+	Type   string      `json:"type"`
+	Schema int         `json:"schema"`
+	Data   interface{} `json:"data"`
+}
+
+// Migration upgrades the JSON document of a record by one schema version
+type Migration func(doc map[string]interface{}) error
+
+// Schema of a record type, with its current version and migrations upgrading
+// documents of each older version to the next one
+type Schema struct {
+	Type       string
+	Version    int
+	Migrations map[int]Migration
+}
+
+// Codec encodes values of a record type into records, and decodes records
+// into values, migrating those of older schema versions
+type Codec[T any] struct {
+	Schema Schema
+}
+
+// Encode returns the record of a value at the current schema version
+func (c Codec[T]) Encode(v T) Record {
+	return Record{
+		Type:   c.Schema.Type,
+		Schema: c.Schema.Version,
+		Data:   v,
+	}
+}
+
+// record returns the record of a stored value, either as written or as a
+// JSON document decoded from an encoded form
+func record(value interface{}) (Record, bool) {
+	switch v := value.(type) {
+	case Record:
+		return v, true
+	case *Record:
+		if v != nil {
+			return *v, true
+		}
+	case map[string]interface{}:
+		rec := Record{Data: v["data"]}
+		rec.Type, _ = v["type"].(string)
+		schema, ok := v["schema"].(float64)
+		rec.Schema = int(schema)
+		return rec, ok && rec.Type != ""
+	case []byte:
+		rec := Record{}
+		err := json.Unmarshal(v, &rec)
+		return rec, err == nil && rec.Type != ""
+	}
+	return Record{}, false
+}
+
+// Decode returns the value of a stored record, migrating it to the current
+// schema version. Values of other types, newer schema versions and fields
+// the current type lacks fail with ErrRecord rather than being dropped.
+func (c Codec[T]) Decode(value interface{}) (T, error) {
+	var v T
+	rec, ok := record(value)
+	if !ok || rec.Type != c.Schema.Type {
+		return v, fmt.Errorf("%w: %T is not a %v record", ErrRecord, value, c.Schema.Type)
+	}
+	if rec.Schema == c.Schema.Version {
+		if data, ok := rec.Data.(T); ok {
+			return data, nil
+		}
+	}
+	if rec.Schema > c.Schema.Version {
+		return v, fmt.Errorf("%w: %v schema version %v is newer than %v", ErrRecord, c.Schema.Type, rec.Schema, c.Schema.Version)
+	}
+
+	// upgrade the JSON document one version at a time
+	data, err := json.Marshal(rec.Data)
+	if err != nil {
+		return v, fmt.Errorf("%w: %v", ErrRecord, err)
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return v, fmt.Errorf("%w: %v", ErrRecord, err)
+	}
+	for version := rec.Schema; version < c.Schema.Version; version++ {
+		m, ok := c.Schema.Migrations[version]
+		if !ok {
+			return v, fmt.Errorf("%w: no %v migration from schema version %v", ErrRecord, c.Schema.Type, version)
+		}
+		if err := m(doc); err != nil {
+			return v, fmt.Errorf("%w: %v migration from schema version %v: %v", ErrRecord, c.Schema.Type, version, err)
+		}
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return v, fmt.Errorf("%w: %v", ErrRecord, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return v, fmt.Errorf("%w: %v", ErrRecord, err)
+	}
+	return v, nil
+}
+
+// Repository stores typed records on a table of a DB
+type Repository[T any] struct {
+	Codec[T]
+	db    Db
+	table string
+}
+
+// NewRepository returns a new repository of records of a schema on a table
+func NewRepository[T any](d Db, table string, schema Schema) *Repository[T] {
+	return &Repository[T]{
+		Codec: Codec[T]{Schema: schema},
+		db:    d,
+		table: table,
+	}
+}
+
+// On returns the repository on another DB, such as a transaction
+func (r *Repository[T]) On(d Db) *Repository[T] {
+	return &Repository[T]{
+		Codec: r.Codec,
+		db:    d,
+		table: r.table,
+	}
+}
+
+// Table returns the table of the repository
+func (r *Repository[T]) Table() string {
+	return r.table
+}
+
+// decode returns the value of a stored record, with its key on errors
+func (r *Repository[T]) decode(key string, value interface{}) (T, error) {
+	v, err := r.Decode(value)
+	if err != nil {
+		return v, fmt.Errorf("%v %v: %w", r.table, key, err)
+	}
+	return v, nil
+}
+
+// DecodeRows returns values of entries of the table in order, along with
+// errors of those failing to decode
+func (r *Repository[T]) DecodeRows(rows []KeyValue) ([]T, error) {
+	ret := make([]T, 0, len(rows))
+	errs := []error{}
+	for _, row := range rows {
+		v, err := r.decode(row.Key, row.Value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ret = append(ret, v)
+	}
+	return ret, errors.Join(errs...)
+}
+
+// Get returns the record of a key
+func (r *Repository[T]) Get(key string) (T, error) {
+	value, err := r.db.Get(r.table, key)
+	if err != nil {
+		var v T
+		return v, err
+	}
+	return r.decode(key, value)
+}
+
+// Put writes the record of a key at the current schema version
+func (r *Repository[T]) Put(key string, v T) error {
+	return r.db.Add(r.table, key, r.Encode(v))
+}
+
+// Del deletes the record of a key
+func (r *Repository[T]) Del(key string) error {
+	return r.db.Del(r.table, key)
+}
+
+// List returns all records in key order, along with errors of records failing
+// to decode
+func (r *Repository[T]) List() ([]T, error) {
+	return r.DecodeRows(r.db.Range(r.table, "", ""))
+}
+
+// Scan returns records with keys starting with a prefix in key order, along
+// with errors of records failing to decode
+func (r *Repository[T]) Scan(prefix string) ([]T, error) {
+	return r.DecodeRows(r.db.Scan(r.table, prefix))
+}
+
+// Keys returns keys of records starting with a prefix in key order, without
+// decoding them
+func (r *Repository[T]) Keys(prefix string) []string {
+	keys := []string{}
+	for _, row := range r.db.Scan(r.table, prefix) {
+		keys = append(keys, row.Key)
+	}
+	return keys
+}
+
+// Lookup returns records with a given value in a secondary index in key
+// order, along with errors of records failing to decode
+func (r *Repository[T]) Lookup(index, value string) ([]T, error) {
+	rows, err := r.db.Lookup(r.table, index, value)
+	if err != nil {
+		return nil, err
+	}
+	return r.DecodeRows(rows)
+}
+
+// Migrate rewrites records of older schema versions, or read back from an
+// encoded form, at the current schema version all at once, returning the
+// number of records migrated
+func (r *Repository[T]) Migrate() (int, error) {
+	migrated := 0
+	err := RunTx(r.db, func(tx Tx) error {
+		migrated = 0
+		for _, row := range tx.Range(r.table, "", "") {
+			if rec, ok := row.Value.(Record); ok && rec.Schema == r.Schema.Version {
+				if _, ok := rec.Data.(T); ok {
+					continue
+				}
+			}
+			v, err := r.decode(row.Key, row.Value)
+			if err != nil {
+				return err
+			}
+			if err := tx.Add(r.table, row.Key, r.Encode(v)); err != nil {
+				return err
+			}
+			migrated++
+		}
+		return nil
+	})
+	return migrated, err
+}
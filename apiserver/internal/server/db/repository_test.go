@@ -0,0 +1,115 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// testHost is a record type at schema version 3, renamed from addr at
+// version 2 and given a port at version 3
+type testHost struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+var testSchema = Schema{
+	Type:    "host",
+	Version: 3,
+	Migrations: map[int]Migration{
+		1: func(doc map[string]interface{}) error {
+			doc["name"] = doc["addr"]
+			delete(doc, "addr")
+			return nil
+		},
+		2: func(doc map[string]interface{}) error {
+			if doc["name"] == "" {
+				return errors.New("name is required")
+			}
+			doc["port"] = 443
+			return nil
+		},
+	},
+}
+
+func TestDecode(t *testing.T) {
+	c := Codec[testHost]{Schema: testSchema}
+	tests := []struct {
+		name  string
+		value interface{}
+		want  testHost
+		err   string
+	}{
+		{"current", c.Encode(testHost{"h1", 80}), testHost{"h1", 80}, ""},
+		{"current pointer", &Record{Type: "host", Schema: 3, Data: testHost{"h1", 80}}, testHost{"h1", 80}, ""},
+		{"current as JSON", []byte(`{"type":"host","schema":3,"data":{"name":"h1","port":80}}`), testHost{"h1", 80}, ""},
+		{"migrated", Record{Type: "host", Schema: 1, Data: map[string]interface{}{"addr": "h1"}}, testHost{"h1", 443}, ""},
+		{"migrated as JSON document", map[string]interface{}{"type": "host", "schema": float64(2),
+			"data": map[string]interface{}{"name": "h1"}}, testHost{"h1", 443}, ""},
+		{"unknown field", []byte(`{"type":"host","schema":3,"data":{"name":"h1","owner":"x"}}`), testHost{},
+			`json: unknown field "owner"`},
+		{"newer schema", Record{Type: "host", Schema: 4, Data: testHost{}}, testHost{},
+			"host schema version 4 is newer than 3"},
+		{"missing migration", Record{Type: "host", Schema: 0, Data: testHost{}}, testHost{},
+			"no host migration from schema version 0"},
+		{"failed migration", Record{Type: "host", Schema: 2, Data: map[string]interface{}{"name": ""}}, testHost{},
+			"host migration from schema version 2: name is required"},
+		{"other type", Record{Type: "user", Schema: 3, Data: testHost{}}, testHost{}, "db.Record is not a host record"},
+		{"not a record", testHost{"h1", 80}, testHost{}, "db.testHost is not a host record"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Decode(tt.value)
+			if tt.err == "" {
+				if err != nil || got != tt.want {
+					t.Fatalf("expecting %+v, got %+v %v", tt.want, got, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrRecord) || err.Error() != fmt.Sprintf("%v: %v", ErrRecord, tt.err) {
+				t.Fatalf("expecting record error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	d := NewMemoryDb("hosts")
+	repo := NewRepository[testHost](d, "hosts", testSchema)
+	repo.Put("h0", testHost{"h0", 80})
+	d.Add("hosts", "h1", Record{Type: "host", Schema: 1, Data: map[string]interface{}{"addr": "h1"}})
+	d.Add("hosts", "h2", []byte(`{"type":"host","schema":3,"data":{"name":"h2","port":22}}`))
+
+	// records are rewritten at the current schema version, all at once
+	n, err := repo.Migrate()
+	if err != nil || n != 2 {
+		t.Fatalf("expecting 2 records migrated, got %v %v", n, err)
+	}
+	for key, want := range map[string]testHost{"h0": {"h0", 80}, "h1": {"h1", 443}, "h2": {"h2", 22}} {
+		v, _ := d.Get("hosts", key)
+		if !reflect.DeepEqual(v, repo.Encode(want)) {
+			t.Errorf("%v: expecting %+v, got %+v", key, repo.Encode(want), v)
+		}
+	}
+	if n, err := repo.Migrate(); err != nil || n != 0 {
+		t.Fatalf("expecting nothing left to migrate, got %v %v", n, err)
+	}
+
+	// a record failing to migrate fails the migration with its key, and no
+	// record is rewritten
+	d.Add("hosts", "h3", Record{Type: "host", Schema: 1, Data: map[string]interface{}{"addr": "h3"}})
+	d.Add("hosts", "h4", []byte(`{"type":"host","schema":5,"data":{}}`))
+	if _, err := repo.Migrate(); !errors.Is(err, ErrRecord) || err.Error()[:9] != "hosts h4:" {
+		t.Fatalf("expecting record error of h4, got %v", err)
+	}
+	if v, _ := d.Get("hosts", "h3"); v.(Record).Schema != 1 {
+		t.Fatalf("expecting h3 left at schema version 1, got %+v", v)
+	}
+
+	// listings leave out records failing to decode, reporting them
+	hosts, err := repo.List()
+	if len(hosts) != 4 || !errors.Is(err, ErrRecord) {
+		t.Fatalf("expecting 4 hosts and a record error, got %+v %v", hosts, err)
+	}
+}
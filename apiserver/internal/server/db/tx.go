@@ -0,0 +1,196 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// attempts of a transaction failing on conflicts
+	TX_ATTEMPTS = 3
+)
+
+// ErrConflict is returned by a commit when an entry written or deleted by a
+// transaction was written by another one since its snapshot
+var ErrConflict = errors.New("transaction conflict, entries changed concurrently")
+
+// Tx is a transaction reading a consistent snapshot of a DB, along with its
+// own writes and deletes, which reach the DB all at once on Commit or never on
+// Rollback
+type Tx interface {
+	Db
+	Commit() error
+	Rollback()
+
+	// Committed returns the sequence number of the commit, the version of
+	// entries it wrote, 0 before committing or when the DB has none
+	Committed() uint64
+}
+
+// Snapshotter is a DB taking consistent read-only snapshots of itself, along
+// with the sequence number of the last write they see
+type Snapshotter interface {
+	Snapshot() (Db, uint64)
+	Release(snapshot Db)
+}
+
+// Committer is a DB atomically applying batches of writes and deletes unless
+// any of their entries was written after a given sequence number, returning
+// the sequence number of the commit
+type Committer interface {
+	CommitAt(seq uint64, rows map[string]MemoryEntry, deleted map[string]map[string]bool) (uint64, error)
+}
+
+// transaction on a DB, staging writes and deletes in an overlay on top of a
+// snapshot of the DB
+type TxDb struct {
+	*OverlayDb
+
+	// DB to commit to
+	target Db
+
+	// snapshot read, and sequence number of the last write it sees
+	snapshot Db
+	seq      uint64
+
+	// sequence number of the commit
+	committed uint64
+
+	// set once committed or rolled back
+	done bool
+}
+
+// NewTx begins a transaction on a DB, reading a snapshot of it when it is a
+// Snapshotter, else reading through to it
+func NewTx(d Db) *TxDb {
+	tx := &TxDb{
+		target:   d,
+		snapshot: d,
+	}
+	if s, ok := d.(Snapshotter); ok {
+		tx.snapshot, tx.seq = s.Snapshot()
+	}
+	tx.OverlayDb = NewOverlayDb(tx.snapshot)
+	return tx
+}
+
+// apply adds and deletes batches of entries on a DB, atomically when the DB
+// is a Batcher
+func apply(d Db, rows map[string]MemoryEntry, deleted map[string]map[string]bool) error {
+	if b, ok := d.(Batcher); ok {
+		return b.Apply(rows, deleted)
+	}
+	for table, keys := range deleted {
+		for key := range keys {
+			if err := d.Del(table, key); err != nil {
+				return err
+			}
+		}
+	}
+	for table, tab := range rows {
+		for key, value := range tab {
+			if err := d.Add(table, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// release ends a transaction and releases its snapshot, with the lock held
+func (tx *TxDb) release() {
+	tx.done = true
+	if s, ok := tx.target.(Snapshotter); ok {
+		s.Release(tx.snapshot)
+	}
+}
+
+// Commit applies writes and deletes of the transaction onto its DB all at
+// once, failing with ErrConflict when the DB is a Committer and any of the
+// entries was written since the snapshot
+func (tx *TxDb) Commit() error {
+	tx.Mutex.Lock()
+	defer tx.Mutex.Unlock()
+
+	if tx.done {
+		return fmt.Errorf("Commit: transaction already finished")
+	}
+	tx.release()
+	if len(tx.Rows) == 0 && len(tx.Deleted) == 0 {
+		return nil
+	}
+	if c, ok := tx.target.(Committer); ok {
+		var err error
+		tx.committed, err = c.CommitAt(tx.seq, tx.Rows, tx.Deleted)
+		return err
+	}
+	return apply(tx.target, tx.Rows, tx.Deleted)
+}
+
+// Committed returns the sequence number of the commit of the transaction, 0
+// before committing, when it wrote nothing or when its DB is not a Committer
+func (tx *TxDb) Committed() uint64 {
+	tx.Mutex.Lock()
+	defer tx.Mutex.Unlock()
+
+	return tx.committed
+}
+
+// Rollback discards writes and deletes of the transaction, doing nothing once
+// committed
+func (tx *TxDb) Rollback() {
+	tx.Mutex.Lock()
+	defer tx.Mutex.Unlock()
+
+	if tx.done {
+		return
+	}
+	tx.release()
+	tx.Rows = make(map[string]MemoryEntry)
+	tx.Deleted = make(map[string]map[string]bool)
+}
+
+// CreateIndex creates a secondary index on a table of the DB of the
+// transaction
+func (tx *TxDb) CreateIndex(table, index string, fn IndexFunc) error {
+	return tx.target.CreateIndex(table, index, fn)
+}
+
+// Watch streams events of entries of the DB of the transaction, its own
+// writes and deletes being seen once committed
+func (tx *TxDb) Watch(table, prefix string) (<-chan Event, func()) {
+	return tx.target.Watch(table, prefix)
+}
+
+// Begin begins a nested transaction, committing into this one
+func (tx *TxDb) Begin() (Tx, error) {
+	return NewTx(tx), nil
+}
+
+// RunTx runs a function in a transaction on a DB, committing its writes and
+// deletes when it succeeds and rolling them back when it fails, retrying it
+// on conflicts
+func RunTx(d Db, fn func(tx Tx) error) error {
+	_, err := RunTxSeq(d, fn)
+	return err
+}
+
+// RunTxSeq runs a function in a transaction as RunTx does, returning the
+// sequence number of its commit, 0 when the DB has none
+func RunTxSeq(d Db, fn func(tx Tx) error) (uint64, error) {
+	var err error
+	for attempt := 0; attempt < TX_ATTEMPTS; attempt++ {
+		var tx Tx
+		if tx, err = d.Begin(); err != nil {
+			return 0, err
+		}
+		if err = fn(tx); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if err = tx.Commit(); !errors.Is(err, ErrConflict) {
+			return tx.Committed(), err
+		}
+	}
+	return 0, err
+}
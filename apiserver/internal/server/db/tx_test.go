@@ -0,0 +1,125 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTxConflict(t *testing.T) {
+	d := NewMemoryDb("t")
+	d.Add("t", "k1", 1)
+	d.Add("t", "k2", 1)
+
+	tx1, _ := d.Begin()
+	tx2, _ := d.Begin()
+	tx3, _ := d.Begin()
+	tx1.Add("t", "k1", 2)
+	tx2.Add("t", "k1", 3)
+	tx3.Del("t", "k2")
+
+	// writes are only seen by their own transaction until committed
+	if v, _ := d.Get("t", "k1"); v != 1 {
+		t.Fatalf("expecting uncommitted write unseen, got %v", v)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tx2.Get("t", "k1"); v != 3 {
+		t.Fatalf("expecting own write, got %v", v)
+	}
+	if v, _ := tx3.Get("t", "k1"); v != 1 {
+		t.Fatalf("expecting snapshot value, got %v", v)
+	}
+
+	// the second writer of a key conflicts, writers of other keys do not
+	if err := tx2.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expecting conflict, got %v", err)
+	}
+	if err := tx3.Commit(); err != nil {
+		t.Fatalf("expecting commit of another key, got %v", err)
+	}
+	if v, _ := d.Get("t", "k1"); v != 2 {
+		t.Fatalf("expecting first committed value, got %v", v)
+	}
+	if _, err := d.Get("t", "k2"); err == nil {
+		t.Fatalf("expecting deleted key")
+	}
+	if err := tx1.Commit(); err == nil {
+		t.Fatalf("expecting finished transaction to fail")
+	}
+}
+
+func TestRunTxRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int
+		attempts  int
+		err       error
+		value     int
+	}{
+		{"no conflict", 0, 1, nil, 1},
+		{"retried", 2, 3, nil, 3},
+		{"too many conflicts", TX_ATTEMPTS, TX_ATTEMPTS, ErrConflict, TX_ATTEMPTS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewMemoryDb("t")
+			d.Add("t", "k", 0)
+
+			// increment the key, while another writer increments it too on
+			// first attempts
+			attempts := 0
+			err := RunTx(d, func(tx Tx) error {
+				attempts++
+				v, _ := tx.Get("t", "k")
+				if attempts <= tt.conflicts {
+					d.Add("t", "k", v.(int)+1)
+				}
+				return tx.Add("t", "k", v.(int)+1)
+			})
+			if !errors.Is(err, tt.err) || attempts != tt.attempts {
+				t.Fatalf("expecting %v after %v attempts, got %v after %v", tt.err, tt.attempts, err, attempts)
+			}
+			if v, _ := d.Get("t", "k"); v != tt.value {
+				t.Fatalf("expecting value %v, got %v", tt.value, v)
+			}
+		})
+	}
+
+	// failing functions roll back and are not retried
+	d := NewMemoryDb("t")
+	attempts := 0
+	err := RunTx(d, func(tx Tx) error {
+		attempts++
+		tx.Add("t", "k", 1)
+		return errors.New("failed")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("expecting one failed attempt, got %v after %v", err, attempts)
+	}
+	if _, err := d.Get("t", "k"); err == nil {
+		t.Fatalf("expecting rolled back write")
+	}
+}
+
+func TestRunTxSeq(t *testing.T) {
+	d := NewMemoryDb("t")
+	d.Add("t", "k1", 1)
+
+	// the commit sequence number is the version of entries written, the
+	// staged version being one past the snapshot
+	var staged uint64
+	seq, err := RunTxSeq(d, func(tx Tx) error {
+		d.Add("t", "k2", 1)
+		tx.Add("t", "k1", 2)
+		staged, _ = tx.Version("t", "k1")
+		return nil
+	})
+	version, _ := d.Version("t", "k1")
+	if err != nil || seq != 3 || version != seq || staged != 2 {
+		t.Fatalf("expecting commit 3 staged at 2, got %v staged at %v, version %v, %v", seq, staged, version, err)
+	}
+	if seq, _ := RunTxSeq(d, func(tx Tx) error { return nil }); seq != 0 {
+		t.Fatalf("expecting no commit sequence number of a read, got %v", seq)
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrPrecondition is returned when an entry is not at a version it is
+// expected at
+var ErrPrecondition = errors.New("precondition failed, entry version does not match")
+
+// Precondition holds versions an entry is expected at before a write or
+// delete, as given by an If-Match header, Any matching any version of an
+// existing entry. None inverts it, as given by an If-None-Match header, the
+// entry being expected absent with Any, or at none of the versions.
+type Precondition struct {
+	Any      bool
+	None     bool
+	Versions []uint64
+}
+
+// ETag returns the entity tag of an entry version
+func ETag(version uint64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// parseTags parses a list of entity tags into a precondition, nil when the
+// header is empty, weak tags being left out unless compared weakly
+func parseTags(header string, weak bool) (*Precondition, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return nil, nil
+	}
+	if header == "*" {
+		return &Precondition{Any: true}, nil
+	}
+	p := &Precondition{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		v, err := strconv.ParseUint(strings.Trim(tag, "\""), 10, 64)
+		if err != nil || len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			return nil, fmt.Errorf("invalid entity tag %v", tag)
+		}
+		p.Versions = append(p.Versions, v)
+	}
+	return p, nil
+}
+
+// ParseIfMatch parses an If-Match header into a precondition, nil when the
+// header is empty. Weak tags never match, as If-Match compares strongly.
+func ParseIfMatch(header string) (*Precondition, error) {
+	return parseTags(header, false)
+}
+
+// ParseIfNoneMatch parses an If-None-Match header of a write or delete into
+// a precondition, nil when the header is empty, * creating entries only when
+// absent. Tags compare weakly.
+func ParseIfNoneMatch(header string) (*Precondition, error) {
+	p, err := parseTags(header, true)
+	if p != nil {
+		p.None = true
+	}
+	return p, err
+}
+
+// Check returns ErrPrecondition unless an entry meets the precondition,
+// which a nil precondition always does. Checked in a transaction, the entry
+// is still at the version on commit, or the commit fails with ErrConflict.
+func (p *Precondition) Check(d Db, table, key string) error {
+	if p == nil {
+		return nil
+	}
+	matched := false
+	version, err := d.Version(table, key)
+	if err == nil {
+		matched = p.Any
+		for _, v := range p.Versions {
+			matched = matched || v == version
+		}
+	}
+	if matched == p.None {
+		return ErrPrecondition
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package db
+
+const (
+	// types of change events
+	EVENT_CREATE = "create"
+	EVENT_UPDATE = "update"
+	EVENT_DELETE = "delete"
+
+	// events buffered for a watcher, which is dropped once it lags further
+	WATCH_BUFFER = 256
+)
+
+// Event is a create, update or delete of an entry, with its new version and
+// value, nil on delete
+type Event struct {
+	Type    string
+	Table   string
+	Key     string
+	Version uint64
+	Value   interface{}
+}
+
+// watcher of entries of a table with keys starting with a prefix
+type watcher struct {
+	table  string
+	prefix string
+	events chan Event
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+// received returns events buffered on a channel, and whether it is closed
+func received(events <-chan Event) ([]Event, bool) {
+	ret := []Event{}
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return ret, true
+			}
+			ret = append(ret, e)
+		default:
+			return ret, false
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	d := NewMemoryDb("t", "u")
+	events, cancel := d.Watch("t", "a1/")
+	d.Add("t", "a1/k", 1)
+	d.Add("t", "a1/k", 2)
+	d.Add("t", "a10/k", 1)
+	d.Add("u", "a1/k", 1)
+	d.Del("t", "a1/k")
+
+	// writes of a transaction are seen once committed
+	tx, _ := d.Begin()
+	tx.Add("t", "a1/x", 1)
+	want := []Event{
+		{Type: EVENT_CREATE, Table: "t", Key: "a1/k", Version: 1, Value: 1},
+		{Type: EVENT_UPDATE, Table: "t", Key: "a1/k", Version: 2, Value: 2},
+		{Type: EVENT_DELETE, Table: "t", Key: "a1/k", Version: 5},
+	}
+	if got, _ := received(events); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expecting events\n%+v\ngot\n%+v", want, got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	want = []Event{{Type: EVENT_CREATE, Table: "t", Key: "a1/x", Version: 6, Value: 1}}
+	if got, closed := received(events); !reflect.DeepEqual(got, want) || closed {
+		t.Fatalf("expecting events\n%+v\ngot\n%+v, closed %v", want, got, closed)
+	}
+
+	cancel()
+	cancel()
+	d.Add("t", "a1/k", 3)
+	if got, closed := received(events); len(got) != 0 || !closed {
+		t.Fatalf("expecting no events once cancelled, got %+v, closed %v", got, closed)
+	}
+}
+
+func TestWatchLagging(t *testing.T) {
+	d := NewMemoryDb("t")
+	lagging, cancel := d.Watch("t", "")
+	defer cancel()
+	reading, cancel := d.Watch("t", "")
+	defer cancel()
+
+	// a watcher falling more than WATCH_BUFFER events behind is dropped, its
+	// channel closed after the events buffered
+	for i := 0; i <= WATCH_BUFFER; i++ {
+		d.Add("t", "k", i)
+		if i%10 == 0 {
+			received(reading)
+		}
+	}
+	got, closed := received(lagging)
+	if len(got) != WATCH_BUFFER || !closed {
+		t.Fatalf("expecting %v events and a closed channel, got %v, closed %v", WATCH_BUFFER, len(got), closed)
+	}
+	if got[WATCH_BUFFER-1].Value != WATCH_BUFFER-1 {
+		t.Fatalf("expecting events in order of writes, got %+v", got[WATCH_BUFFER-1])
+	}
+	if _, closed := received(reading); closed {
+		t.Fatalf("expecting a watcher keeping up to stay open")
+	}
+}
@@ -0,0 +1,201 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
+)
+
+const (
+	// comment lines keeping idle streams open through proxies
+	HEARTBEAT_INTERVAL = 15 * time.Second
+
+	// reconnection delay of clients, in milliseconds
+	RETRY_MS = 3000
+
+	// event telling clients they missed events and should reload
+	EVENT_RESET = "reset"
+)
+
+// tables streamed by default
+var TABLES = []string{
+	graph.DB_TABLE_GRAPH,
+	graph.DB_TABLE_ENTITIES,
+	graph.DB_TABLE_ASSOCS,
+	graph.DB_TABLE_SNAPSHOTS,
+	risk.DB_TABLE_TRENDS,
+}
+
+// NewEvents returns a new event streamer
+func NewEvents(db db.Db) *Events {
+	return &Events{
+		db: db,
+	}
+}
+
+// parseTables returns tables given by table query parameters, all by default
+func parseTables(r *http.Request) ([]string, error) {
+	tables := r.URL.Query()["table"]
+	if len(tables) == 0 {
+		return TABLES, nil
+	}
+	for _, t := range tables {
+		found := false
+		for _, known := range TABLES {
+			found = found || t == known
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid table %v, expecting one of %v", t, strings.Join(TABLES, ", "))
+		}
+	}
+	return tables, nil
+}
+
+// event returns the event of a change, false when it is of another app.
+// Values of records are sent without their record envelope.
+func (s *stream) event(e db.Event) (Event, bool) {
+	ev := Event{
+		Type:    e.Type,
+		Table:   e.Table,
+		Version: e.Version,
+		Value:   e.Value,
+	}
+	if rec, ok := e.Value.(db.Record); ok {
+		ev.Value = rec.Data
+	}
+	if e.Table == graph.DB_TABLE_GRAPH {
+		ev.App, ev.ID = e.Key, e.Key
+
+		// attack graphs belong to their scanned app
+		if a, err := graph.Apps(nil).Decode(e.Value); err == nil && a.Type == graph.APP_TYPE_ATTACK_GRAPH {
+			s.attackGraphs[e.Key], _ = a.Attributes[graph.ATTR_SOURCE_APP].(string)
+		}
+		if src, ok := s.attackGraphs[e.Key]; ok {
+			ev.App = src
+			if e.Type == db.EVENT_DELETE {
+				delete(s.attackGraphs, e.Key)
+			}
+		}
+	} else {
+		ev.App, ev.ID, _ = strings.Cut(e.Key, "/")
+		switch e.Table {
+		case graph.DB_TABLE_ENTITIES:
+			if v, err := graph.Entities(nil).Decode(e.Value); err == nil {
+				v.ID = ev.ID
+				ev.Value = v
+			}
+		case graph.DB_TABLE_ASSOCS:
+			if v, err := graph.Assocs(nil).Decode(e.Value); err == nil {
+				v.ID = ev.ID
+				ev.Value = v
+			}
+		case graph.DB_TABLE_SNAPSHOTS:
+			// snapshots are fetched by version instead
+			ev.Value = nil
+		}
+	}
+	return ev, s.app == "" || ev.App == s.app
+}
+
+// StreamEvents is GET handler to stream creates, updates and deletes of
+// records as Server-Sent Events, of one app and its attack graphs when given
+func (ev *Events) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	s := &stream{
+		app:          r.URL.Query().Get("app"),
+		attackGraphs: make(map[string]string),
+	}
+	if s.app != "" {
+		if _, err := ev.db.Get(graph.DB_TABLE_GRAPH, s.app); err != nil {
+			http.Error(w, "Data not found", http.StatusNotFound)
+			return
+		}
+	}
+	tables, err := parseTables(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// watch before listing attack graphs so that none is missed, merging
+	// events of all tables until any watcher lags behind
+	var (
+		done   = r.Context().Done()
+		merged = make(chan db.Event, db.WATCH_BUFFER)
+		lagged = make(chan bool, 1)
+	)
+	for _, table := range tables {
+		prefix := ""
+		if s.app != "" && table != graph.DB_TABLE_GRAPH {
+			prefix = graph.GetAppPrefix(s.app)
+		}
+		events, cancel := ev.db.Watch(table, prefix)
+		defer cancel()
+		go func() {
+			for e := range events {
+				select {
+				case merged <- e:
+				case <-done:
+					return
+				}
+			}
+			select {
+			case lagged <- true:
+			default:
+			}
+		}()
+	}
+	apps, err := graph.Apps(ev.db).List()
+	if err != nil {
+		log.Printf("skipped records: %v\n", err)
+	}
+	for _, a := range apps {
+		if a.Type == graph.APP_TYPE_ATTACK_GRAPH {
+			s.attackGraphs[a.ID], _ = a.Attributes[graph.ATTR_SOURCE_APP].(string)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", RETRY_MS)
+	f.Flush()
+
+	heartbeat := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-lagged:
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", EVENT_RESET)
+			f.Flush()
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			f.Flush()
+		case e := <-merged:
+			out, ok := s.event(e)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(out)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", out.Version, out.Type, data)
+			f.Flush()
+		}
+	}
+}
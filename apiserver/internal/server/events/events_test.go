@@ -0,0 +1,192 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
+)
+
+// newTestDb returns a DB of streamed tables, with apps a1 and a2
+func newTestDb(t *testing.T) *db.MemoryDb {
+	t.Helper()
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS, graph.DB_TABLE_SNAPSHOTS,
+		risk.DB_TABLE_TRENDS)
+	if err := graph.CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	for _, aid := range []string{"a1", "a2"} {
+		putApp(t, d, graph.AppData{ID: aid, Name: aid})
+	}
+	return d
+}
+
+// putApp stores an app
+func putApp(t *testing.T, d db.Db, a graph.AppData) {
+	t.Helper()
+	if err := graph.Apps(d).Put(a.ID, a); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putEntity stores an entity of an app
+func putEntity(t *testing.T, d db.Db, aid, eid string) {
+	t.Helper()
+	key := graph.GetEntityKey(aid, eid)
+	if err := graph.Entities(d).Put(key, graph.Entity{ID: key, Name: eid, Kind: "vm"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sse is a Server-Sent Event as received
+type sse struct {
+	id    string
+	event string
+	data  string
+}
+
+// open streams events of a DB, returning the response once the stream is
+// watching and a function reading the next event, false once the stream ends
+func open(t *testing.T, d db.Db, query string) (*http.Response, func() (sse, bool)) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(NewEvents(d).StreamEvents))
+	t.Cleanup(srv.Close)
+	resp, err := http.Get(srv.URL + "/v1/events" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	r := bufio.NewReader(resp.Body)
+	next := func() (sse, bool) {
+		ev := sse{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return ev, false
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && ev.event != "":
+				return ev, true
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+	if resp.StatusCode == http.StatusOK {
+		// the retry field is sent once watching
+		if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, "retry: ") {
+			t.Fatalf("expecting retry field first, got %q", line)
+		}
+	}
+	return resp, next
+}
+
+func TestStreamEvents(t *testing.T) {
+	d := newTestDb(t)
+	resp, next := open(t, d, "?app=a1")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expecting an event stream, got %v", ct)
+	}
+
+	// writes of other apps are not streamed, attack graphs of the app are
+	putEntity(t, d, "a2", "e1")
+	putEntity(t, d, "a1", "e1")
+	putApp(t, d, graph.AppData{ID: "ag1", Type: graph.APP_TYPE_ATTACK_GRAPH,
+		Attributes: map[string]interface{}{graph.ATTR_SOURCE_APP: "a1"}})
+	d.Del(graph.DB_TABLE_ENTITIES, graph.GetEntityKey("a1", "e1"))
+	d.Del(graph.DB_TABLE_GRAPH, "ag1")
+
+	tests := []struct {
+		event string
+		table string
+		id    string
+		value bool
+	}{
+		{db.EVENT_CREATE, graph.DB_TABLE_ENTITIES, "e1", true},
+		{db.EVENT_CREATE, graph.DB_TABLE_GRAPH, "ag1", true},
+		{db.EVENT_DELETE, graph.DB_TABLE_ENTITIES, "e1", false},
+		{db.EVENT_DELETE, graph.DB_TABLE_GRAPH, "ag1", false},
+	}
+
+	// events of several tables are merged, so are compared in write order
+	got := make([]Event, len(tests))
+	for range tests {
+		ev, ok := next()
+		if !ok {
+			t.Fatalf("expecting %v events, stream ended", len(tests))
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(ev.data), &e); err != nil {
+			t.Fatal(err)
+		}
+		if ev.event != e.Type || ev.id != fmt.Sprint(e.Version) {
+			t.Fatalf("expecting event named by type with version id, got %+v", ev)
+		}
+		if e.Version < 4 || int(e.Version) >= 4+len(tests) {
+			t.Fatalf("expecting events of app a1, got %+v", e)
+		}
+		got[e.Version-4] = e
+	}
+	for i, tt := range tests {
+		e := got[i]
+		if e.Type != tt.event || e.Table != tt.table || e.App != "a1" || e.ID != tt.id || (e.Value != nil) != tt.value {
+			t.Fatalf("expecting %v of %v %v, got %+v", tt.event, tt.table, tt.id, e)
+		}
+	}
+}
+
+func TestStreamEventsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{"unknown app", "?app=a3", http.StatusNotFound},
+		{"unknown table", "?table=users", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp, _ := open(t, newTestDb(t), tt.query); resp.StatusCode != tt.code {
+				t.Fatalf("expecting %v, got %v", tt.code, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// laggingDb drops watchers of a table as soon as they watch, as if lagging
+type laggingDb struct {
+	*db.MemoryDb
+	table string
+}
+
+func (d *laggingDb) Watch(table, prefix string) (<-chan db.Event, func()) {
+	events, cancel := d.MemoryDb.Watch(table, prefix)
+	if table == d.table {
+		cancel()
+	}
+	return events, cancel
+}
+
+func TestStreamEventsLagging(t *testing.T) {
+	// clients lagging behind are told to reset, and the stream ends
+	_, next := open(t, &laggingDb{newTestDb(t), risk.DB_TABLE_TRENDS}, "")
+	if ev, ok := next(); !ok || ev.event != EVENT_RESET {
+		t.Fatalf("expecting reset event, got %+v", ev)
+	}
+	if ev, ok := next(); ok {
+		t.Fatalf("expecting stream end, got %+v", ev)
+	}
+}
@@ -0,0 +1,30 @@
+package events
+
+import (
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Events streams changes of apps, their entities, assocs, snapshots, risk
+// trends and attack graphs as Server-Sent Events
+type Events struct {
+	db db.Db
+}
+
+// Event is a create, update or delete of a record of an app, streamed with the
+// change type as event name and the record version as event id
+type Event struct {
+	Type    string      `json:"type"`
+	Table   string      `json:"table"`
+	App     string      `json:"app"`
+	ID      string      `json:"id"`
+	Version uint64      `json:"version"`
+	Value   interface{} `json:"value,omitempty"`
+}
+
+// stream of events of an app, or of all apps when empty
+type stream struct {
+	app string
+
+	// attack graph id -> scanned app id
+	attackGraphs map[string]string
+}
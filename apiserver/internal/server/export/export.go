@@ -0,0 +1,55 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+// NewExport returns a new Export object
+func NewExport(db db.Db) *Export {
+	return &Export{
+		db: db,
+	}
+}
+
+// getApp returns an app or attack graph
+func (x *Export) getApp(aid string) (graph.AppData, error) {
+	return graph.Apps(x.db).Get(aid)
+}
+
+// getAttackGraph returns an attack graph, or the latest attack graph of an app
+func (x *Export) getAttackGraph(aid string) (graph.AppData, error) {
+	app, err := x.getApp(aid)
+	if err != nil {
+		return graph.AppData{}, err
+	}
+	if app.Type == scenarios.APP_TYPE_ATTACK_GRAPH {
+		return app, nil
+	}
+	ag, ok := scenarios.NewScenario(x.db).LatestAttackGraph(aid)
+	if !ok {
+		return graph.AppData{}, fmt.Errorf("no attack graph of app %v", aid)
+	}
+	return ag, nil
+}
+
+// getEntity returns an entity of an app, logging records failing to decode
+func (x *Export) getEntity(aid, eid string) (graph.Entity, bool) {
+	e, err := graph.Entities(x.db).Get(graph.GetEntityKey(aid, eid))
+	if errors.Is(err, db.ErrRecord) {
+		log.Printf("skipped entity: %v\n", err)
+	}
+	return e, err == nil
+}
+
+// setDownload sets headers of a downloadable export
+func setDownload(w http.ResponseWriter, contentType, filename string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+}
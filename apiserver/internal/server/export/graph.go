@@ -0,0 +1,327 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+// node is an entity or an intermediate hyperedge node of an exported graph
+type node struct {
+	ID         string
+	Label      string
+	Kind       string
+	Type       string
+	Attributes map[string]string
+}
+
+// edge connects an entity with an intermediate hyperedge node, or a parent
+// entity with a nested entity
+type edge struct {
+	ID     string
+	Source string
+	Target string
+	Role   string
+}
+
+// flatGraph is a hypergraph flattened into a simple directed graph, where
+// each hyperedge becomes a node connected to its From/To/Other members
+type flatGraph struct {
+	App   string
+	Nodes []node
+	Edges []edge
+}
+
+// assocAttributes returns hyperedge attributes as strings
+func assocAttributes(a graph.Assoc) map[string]string {
+	attrs := make(map[string]string, len(a.Attributes))
+	for k, v := range a.Attributes {
+		if s, ok := v.(string); ok {
+			attrs[k] = s
+			continue
+		}
+		b, _ := json.Marshal(v)
+		attrs[k] = string(b)
+	}
+	return attrs
+}
+
+// flatten flattens a hypergraph without losing multi-way hyperedges
+func flatten(h *graph.Hypergraph) flatGraph {
+	fg := flatGraph{App: h.AppID, Nodes: []node{}, Edges: []edge{}}
+	known := map[string]bool{}
+	addEdge := func(source, target, role string) {
+		fg.Edges = append(fg.Edges, edge{ID: fmt.Sprintf("e%d", len(fg.Edges)), Source: source, Target: target, Role: role})
+	}
+
+	ids := make([]string, 0, len(h.Entities))
+	for eid := range h.Entities {
+		ids = append(ids, eid)
+	}
+	sort.Strings(ids)
+	for _, eid := range ids {
+		e := h.Entities[eid]
+		label := e.Name
+		if label == "" {
+			label = eid
+		}
+		fg.Nodes = append(fg.Nodes, node{ID: eid, Label: label, Kind: e.Kind, Type: graph.NODE_ENTITY, Attributes: e.Attributes})
+		known[eid] = true
+	}
+	for _, eid := range ids {
+		for _, child := range h.Entities[eid].Entities {
+			addEdge(eid, child.ID, graph.ROLE_CONTAINS)
+		}
+	}
+
+	sids := make([]string, 0, len(h.Assocs))
+	for sid := range h.Assocs {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+	for _, sid := range sids {
+		a := h.Assocs[sid]
+		hid := graph.HYPEREDGE_PREFIX + sid
+		label := a.Name
+		if label == "" {
+			label = sid
+		}
+		fg.Nodes = append(fg.Nodes, node{ID: hid, Label: label, Kind: a.Label, Type: graph.NODE_HYPEREDGE, Attributes: assocAttributes(a)})
+
+		member := func(m string) string {
+			m = graph.TrimAppPrefix(h.AppID, m)
+			// members missing from the app are kept as bare nodes
+			if !known[m] {
+				fg.Nodes = append(fg.Nodes, node{ID: m, Label: m, Type: graph.NODE_ENTITY, Attributes: map[string]string{}})
+				known[m] = true
+			}
+			return m
+		}
+		for _, m := range a.FromEntities {
+			addEdge(member(m), hid, graph.ROLE_FROM)
+		}
+		for _, m := range a.ToEntities {
+			addEdge(hid, member(m), graph.ROLE_TO)
+		}
+		for _, m := range a.OtherEntities {
+			addEdge(hid, member(m), graph.ROLE_OTHER)
+		}
+	}
+	return fg
+}
+
+// attributeNames returns sorted names of node attributes across a graph
+func (fg flatGraph) attributeNames() []string {
+	names := map[string]bool{}
+	for _, n := range fg.Nodes {
+		for k := range n.Attributes {
+			names[k] = true
+		}
+	}
+	ret := make([]string, 0, len(names))
+	for k := range names {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// xmlEscape escapes text and attribute values of XML documents
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;",
+		"\n", "&#10;", "\r", "&#13;", "\t", "&#9;").Replace(s)
+}
+
+// GraphML renders a flattened hypergraph as GraphML
+func (fg flatGraph) GraphML() string {
+	var b strings.Builder
+	attrs := fg.attributeNames()
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://graphml.graphdrawing.org/xmlns http://graphml.graphdrawing.org/xmlns/1.0/graphml.xsd">` + "\n")
+	b.WriteString(`  <key id="label" for="node" attr.name="label" attr.type="string"/>` + "\n")
+	b.WriteString(`  <key id="kind" for="node" attr.name="kind" attr.type="string"/>` + "\n")
+	b.WriteString(`  <key id="type" for="node" attr.name="type" attr.type="string"/>` + "\n")
+	b.WriteString(`  <key id="role" for="edge" attr.name="role" attr.type="string"/>` + "\n")
+	for i, name := range attrs {
+		fmt.Fprintf(&b, "  <key id=\"a%d\" for=\"node\" attr.name=\"%s\" attr.type=\"string\"/>\n", i, xmlEscape(graph.AttributeColumn(name)))
+	}
+	fmt.Fprintf(&b, "  <graph id=\"%s\" edgedefault=\"directed\">\n", xmlEscape(fg.App))
+	for _, n := range fg.Nodes {
+		fmt.Fprintf(&b, "    <node id=\"%s\">\n", xmlEscape(n.ID))
+		fmt.Fprintf(&b, "      <data key=\"label\">%s</data>\n", xmlEscape(n.Label))
+		fmt.Fprintf(&b, "      <data key=\"kind\">%s</data>\n", xmlEscape(n.Kind))
+		fmt.Fprintf(&b, "      <data key=\"type\">%s</data>\n", n.Type)
+		for i, name := range attrs {
+			if v, ok := n.Attributes[name]; ok {
+				fmt.Fprintf(&b, "      <data key=\"a%d\">%s</data>\n", i, xmlEscape(v))
+			}
+		}
+		b.WriteString("    </node>\n")
+	}
+	for _, e := range fg.Edges {
+		fmt.Fprintf(&b, "    <edge id=\"%s\" source=\"%s\" target=\"%s\">\n", e.ID, xmlEscape(e.Source), xmlEscape(e.Target))
+		fmt.Fprintf(&b, "      <data key=\"role\">%s</data>\n", e.Role)
+		b.WriteString("    </edge>\n")
+	}
+	b.WriteString("  </graph>\n</graphml>\n")
+	return b.String()
+}
+
+// GEXF renders a flattened hypergraph as GEXF 1.3
+func (fg flatGraph) GEXF() string {
+	var b strings.Builder
+	attrs := fg.attributeNames()
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<gexf xmlns="http://gexf.net/1.3" version="1.3">` + "\n")
+	fmt.Fprintf(&b, "  <meta>\n    <creator>%s</creator>\n    <description>%s</description>\n  </meta>\n", TOOL_NAME, xmlEscape(fg.App))
+	b.WriteString(`  <graph defaultedgetype="directed" mode="static">` + "\n")
+	b.WriteString(`    <attributes class="node">` + "\n")
+	b.WriteString(`      <attribute id="kind" title="kind" type="string"/>` + "\n")
+	b.WriteString(`      <attribute id="type" title="type" type="string"/>` + "\n")
+	for i, name := range attrs {
+		fmt.Fprintf(&b, "      <attribute id=\"a%d\" title=\"%s\" type=\"string\"/>\n", i, xmlEscape(graph.AttributeColumn(name)))
+	}
+	b.WriteString("    </attributes>\n")
+	b.WriteString(`    <attributes class="edge">` + "\n")
+	b.WriteString(`      <attribute id="role" title="role" type="string"/>` + "\n")
+	b.WriteString("    </attributes>\n")
+	b.WriteString("    <nodes>\n")
+	for _, n := range fg.Nodes {
+		fmt.Fprintf(&b, "      <node id=\"%s\" label=\"%s\">\n        <attvalues>\n", xmlEscape(n.ID), xmlEscape(n.Label))
+		fmt.Fprintf(&b, "          <attvalue for=\"kind\" value=\"%s\"/>\n", xmlEscape(n.Kind))
+		fmt.Fprintf(&b, "          <attvalue for=\"type\" value=\"%s\"/>\n", n.Type)
+		for i, name := range attrs {
+			if v, ok := n.Attributes[name]; ok {
+				fmt.Fprintf(&b, "          <attvalue for=\"a%d\" value=\"%s\"/>\n", i, xmlEscape(v))
+			}
+		}
+		b.WriteString("        </attvalues>\n      </node>\n")
+	}
+	b.WriteString("    </nodes>\n    <edges>\n")
+	for _, e := range fg.Edges {
+		fmt.Fprintf(&b, "      <edge id=\"%s\" source=\"%s\" target=\"%s\" label=\"%s\">\n", e.ID, xmlEscape(e.Source), xmlEscape(e.Target), e.Role)
+		fmt.Fprintf(&b, "        <attvalues>\n          <attvalue for=\"role\" value=\"%s\"/>\n        </attvalues>\n      </edge>\n", e.Role)
+	}
+	b.WriteString("    </edges>\n  </graph>\n</gexf>\n")
+	return b.String()
+}
+
+// dotQuote quotes a Graphviz DOT identifier
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// DOT renders a flattened hypergraph as a Graphviz DOT digraph, drawing
+// hyperedge nodes as diamonds
+func (fg flatGraph) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(fg.App))
+	b.WriteString("  rankdir=LR;\n  node [shape=box];\n")
+	for _, n := range fg.Nodes {
+		label := n.Label
+		if n.Kind != "" {
+			label += "\n" + n.Kind
+		}
+		shape := "box"
+		if n.Type == graph.NODE_HYPEREDGE {
+			shape = "diamond"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s];\n", dotQuote(n.ID), dotQuote(label), shape)
+	}
+	for _, e := range fg.Edges {
+		style := "solid"
+		if e.Role == graph.ROLE_OTHER || e.Role == graph.ROLE_CONTAINS {
+			style = "dashed"
+		}
+		fmt.Fprintf(&b, "  %s -> %s [label=%s, style=%s];\n", dotQuote(e.Source), dotQuote(e.Target), dotQuote(e.Role), style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// cypherString quotes a Cypher string literal
+func cypherString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`).Replace(s) + "'"
+}
+
+// cypherKey quotes a Cypher property key
+func cypherKey(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
+
+// cypherLabel returns the Neo4j label of a node type
+func cypherLabel(typ string) string {
+	if typ == graph.NODE_HYPEREDGE {
+		return "Hyperedge"
+	}
+	return "Entity"
+}
+
+// Cypher renders a flattened hypergraph as an idempotent Cypher script,
+// with entities and hyperedges as :Entity and :Hyperedge nodes
+func (fg flatGraph) Cypher() string {
+	var b strings.Builder
+	types := map[string]string{}
+	app := cypherString(fg.App)
+	for _, n := range fg.Nodes {
+		types[n.ID] = n.Type
+		fmt.Fprintf(&b, "MERGE (n:%s {app: %s, id: %s}) SET n.name = %s, n.kind = %s",
+			cypherLabel(n.Type), app, cypherString(n.ID), cypherString(n.Label), cypherString(n.Kind))
+		keys := make([]string, 0, len(n.Attributes))
+		for k := range n.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, ", n.%s = %s", cypherKey(graph.AttributeColumn(k)), cypherString(n.Attributes[k]))
+		}
+		b.WriteString(";\n")
+	}
+	for _, e := range fg.Edges {
+		fmt.Fprintf(&b, "MATCH (a:%s {app: %s, id: %s}), (b:%s {app: %s, id: %s}) MERGE (a)-[:`%s`]->(b);\n",
+			cypherLabel(types[e.Source]), app, cypherString(e.Source),
+			cypherLabel(types[e.Target]), app, cypherString(e.Target), strings.ToUpper(e.Role))
+	}
+	return b.String()
+}
+
+// graph export formats, content type and renderer
+var graphFormats = map[string]struct {
+	contentType string
+	render      func(flatGraph) string
+}{
+	"graphml": {"application/graphml+xml", flatGraph.GraphML},
+	"gexf":    {"application/gexf+xml", flatGraph.GEXF},
+	"dot":     {"text/vnd.graphviz", flatGraph.DOT},
+	"cypher":  {"text/plain", flatGraph.Cypher},
+}
+
+// GetGraph is GET handler to download an app hypergraph as GraphML, GEXF,
+// DOT or a Cypher script
+func (x *Export) GetGraph(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars   = mux.Vars(r)
+		aid    = vars["id"]
+		format = vars["format"]
+	)
+
+	f, ok := graphFormats[format]
+	if !ok {
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+	if _, err := x.getApp(aid); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+
+	// return graph response
+	setDownload(w, f.contentType, aid+"."+format)
+	fmt.Fprint(w, f.render(flatten(graph.LoadHypergraph(x.db, aid))))
+}
@@ -0,0 +1,177 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+// tricky is a value needing escaping in every export format
+const tricky = "a<b>&\"c'\\d\ne\tf"
+
+// newGraphDb returns a DB of app a1 with a nested entity, a multi-way
+// hyperedge of a member missing from the app, and values needing escaping
+func newGraphDb(t *testing.T) *db.MemoryDb {
+	t.Helper()
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS)
+	if err := graph.CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	graph.Apps(d).Put("a1", graph.AppData{ID: "a1", Name: "a1"})
+	for _, e := range []graph.Entity{
+		{ID: "vpc", Kind: "vpc", Attributes: map[string]string{"name": "clash"},
+			Entities: []graph.Entity{{ID: "vm", Kind: "ec2"}}},
+		{ID: "vm", Name: tricky, Kind: "ec2", Attributes: map[string]string{"Note": tricky}},
+	} {
+		key := graph.GetEntityKey("a1", e.ID)
+		e.ID = key
+		graph.Entities(d).Put(key, e)
+	}
+	key := graph.GetEntityKey("a1", "s1")
+	graph.Assocs(d).Put(key, graph.Assoc{ID: key, Label: "ssh", FromEntities: []string{"vm"}, ToEntities: []string{"vpc"},
+		OtherEntities: []string{"ghost"}, Attributes: map[string]interface{}{"Port": 22.0, "Proto": "tcp"}})
+	return d
+}
+
+func TestFlatten(t *testing.T) {
+	fg := flatten(graph.LoadHypergraph(newGraphDb(t), "a1"))
+	want := flatGraph{
+		App: "a1",
+		Nodes: []node{
+			{ID: "vm", Label: tricky, Kind: "ec2", Type: graph.NODE_ENTITY, Attributes: map[string]string{"Note": tricky}},
+			{ID: "vpc", Label: "vpc", Kind: "vpc", Type: graph.NODE_ENTITY, Attributes: map[string]string{"name": "clash"}},
+			{ID: "hyperedge:s1", Label: "s1", Kind: "ssh", Type: graph.NODE_HYPEREDGE, Attributes: map[string]string{"Port": "22", "Proto": "tcp"}},
+			{ID: "ghost", Label: "ghost", Type: graph.NODE_ENTITY, Attributes: map[string]string{}},
+		},
+		Edges: []edge{
+			{ID: "e0", Source: "vpc", Target: "vm", Role: graph.ROLE_CONTAINS},
+			{ID: "e1", Source: "vm", Target: "hyperedge:s1", Role: graph.ROLE_FROM},
+			{ID: "e2", Source: "hyperedge:s1", Target: "vpc", Role: graph.ROLE_TO},
+			{ID: "e3", Source: "hyperedge:s1", Target: "ghost", Role: graph.ROLE_OTHER},
+		},
+	}
+	if !reflect.DeepEqual(fg, want) {
+		t.Fatalf("expecting %+v, got %+v", want, fg)
+	}
+}
+
+func TestGraphMLEscaping(t *testing.T) {
+	fg := flatten(graph.LoadHypergraph(newGraphDb(t), "a1"))
+	for _, format := range []string{"graphml", "gexf"} {
+		t.Run(format, func(t *testing.T) {
+			// documents are well formed, and escaped values decode unchanged
+			doc := graphFormats[format].render(fg)
+			dec := xml.NewDecoder(strings.NewReader(doc))
+			values := map[string]bool{}
+			for {
+				tok, err := dec.Token()
+				if err != nil {
+					if err != io.EOF {
+						t.Fatalf("expecting well formed XML, got %v", err)
+					}
+					break
+				}
+				switch tok := tok.(type) {
+				case xml.CharData:
+					values[string(tok)] = true
+				case xml.StartElement:
+					for _, attr := range tok.Attr {
+						values[attr.Value] = true
+					}
+				}
+			}
+			if !values[tricky] {
+				t.Fatalf("expecting %q decoded from %v", tricky, doc)
+			}
+
+			// attribute columns clashing with node properties are prefixed
+			if !values[graph.ATTRIBUTE_COLUMN_PREFIX+"name"] {
+				t.Fatalf("expecting prefixed name column, got %v", doc)
+			}
+		})
+	}
+}
+
+func TestDOTEscaping(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"vm", `"vm"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`c:\dir`, `"c:\\dir"`},
+		{"two\nlines", `"two\nlines"`},
+		{`\"`, `"\\\""`},
+	}
+	for _, tt := range tests {
+		if got := dotQuote(tt.in); got != tt.want {
+			t.Errorf("%q: expecting %v, got %v", tt.in, tt.want, got)
+		}
+	}
+
+	dot := flatten(graph.LoadHypergraph(newGraphDb(t), "a1")).DOT()
+	for _, line := range []string{
+		`  "vm" [label="a<b>&\"c'\\d\ne` + "\t" + `f\nec2", shape=box];`,
+		`  "hyperedge:s1" [label="s1\nssh", shape=diamond];`,
+		`  "hyperedge:s1" -> "ghost" [label="other", style=dashed];`,
+		`  "vpc" -> "vm" [label="contains", style=dashed];`,
+	} {
+		if !strings.Contains(dot, line+"\n") {
+			t.Fatalf("expecting %v in %v", line, dot)
+		}
+	}
+}
+
+func TestCypherEscaping(t *testing.T) {
+	if got := cypherString(`it's \ here`); got != `'it\'s \\ here'` {
+		t.Fatalf("expecting escaped string, got %v", got)
+	}
+	if got := cypherKey("a`b"); got != "`a``b`" {
+		t.Fatalf("expecting escaped key, got %v", got)
+	}
+	cypher := flatten(graph.LoadHypergraph(newGraphDb(t), "a1")).Cypher()
+	for _, stmt := range []string{
+		"MERGE (n:Entity {app: 'a1', id: 'vpc'}) SET n.name = 'vpc', n.kind = 'vpc', n.`attr_name` = 'clash';",
+		"MATCH (a:Hyperedge {app: 'a1', id: 'hyperedge:s1'}), (b:Entity {app: 'a1', id: 'ghost'}) MERGE (a)-[:`OTHER`]->(b);",
+	} {
+		if !strings.Contains(cypher, stmt+"\n") {
+			t.Fatalf("expecting %v in %v", stmt, cypher)
+		}
+	}
+}
+
+func TestGetGraph(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/app/{id}/export/{format}", NewExport(newGraphDb(t)).GetGraph).Methods("GET")
+	tests := []struct {
+		path        string
+		code        int
+		contentType string
+	}{
+		{"/v1/app/a1/export/graphml", http.StatusOK, "application/graphml+xml"},
+		{"/v1/app/a1/export/dot", http.StatusOK, "text/vnd.graphviz"},
+		{"/v1/app/a1/export/svg", http.StatusBadRequest, ""},
+		{"/v1/app/a2/export/gexf", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v", tt.code, w.Code)
+			}
+			if tt.contentType != "" && w.Header().Get("Content-Type") != tt.contentType {
+				t.Fatalf("expecting %v, got %v", tt.contentType, w.Header())
+			}
+		})
+	}
+}
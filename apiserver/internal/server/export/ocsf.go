@@ -0,0 +1,128 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	OCSF_VERSION = "1.1.0"
+
+	// Findings category, Detection Finding class
+	OCSF_CATEGORY_FINDINGS       = 2
+	OCSF_CLASS_DETECTION_FINDING = 2004
+	OCSF_ACTIVITY_CREATE         = 1
+	OCSF_STATUS_NEW              = 1
+	OCSF_ANALYTIC_RULE           = 1
+	OCSF_SEVERITY_LOW            = 2
+	OCSF_SEVERITY_MEDIUM         = 3
+	OCSF_SEVERITY_HIGH           = 4
+	OCSF_SEVERITY_CRITICAL       = 5
+	OCSF_CATEGORY_NAME           = "Findings"
+	OCSF_CLASS_NAME              = "Detection Finding"
+)
+
+// ocsfSeverity maps a finding risk onto an OCSF severity
+func ocsfSeverity(risk string) (int, string) {
+	switch risk {
+	case scenarios.RISK_CRITICAL:
+		return OCSF_SEVERITY_CRITICAL, "Critical"
+	case scenarios.RISK_HIGH:
+		return OCSF_SEVERITY_HIGH, "High"
+	case scenarios.RISK_MEDIUM:
+		return OCSF_SEVERITY_MEDIUM, "Medium"
+	}
+	return OCSF_SEVERITY_LOW, "Low"
+}
+
+// Ocsf renders findings of an attack graph as OCSF Detection Finding events
+func (x *Export) Ocsf(ag graph.AppData) []OcsfDetectionFinding {
+	aid := fmt.Sprint(ag.Attributes[scenarios.ATTR_SOURCE_APP])
+	created := time.Now()
+	if t, err := time.Parse(time.RFC3339Nano, ag.Created); err == nil {
+		created = t
+	}
+
+	events := []OcsfDetectionFinding{}
+	for _, f := range scenarios.NewScenario(x.db).Findings(ag.ID) {
+		severityID, severity := ocsfSeverity(f.Risk)
+		uid := ag.ID + "/" + f.ID
+		ev := OcsfDetectionFinding{
+			ActivityID:   OCSF_ACTIVITY_CREATE,
+			ActivityName: "Create",
+			CategoryUID:  OCSF_CATEGORY_FINDINGS,
+			CategoryName: OCSF_CATEGORY_NAME,
+			ClassUID:     OCSF_CLASS_DETECTION_FINDING,
+			ClassName:    OCSF_CLASS_NAME,
+			TypeUID:      OCSF_CLASS_DETECTION_FINDING*100 + OCSF_ACTIVITY_CREATE,
+			TypeName:     OCSF_CLASS_NAME + ": Create",
+			Time:         created.UnixMilli(),
+			SeverityID:   severityID,
+			Severity:     severity,
+			StatusID:     OCSF_STATUS_NEW,
+			Status:       "New",
+			Message:      fmt.Sprintf("%v on %v", f.Title, f.Entity),
+			Metadata: OcsfMetadata{
+				Version: OCSF_VERSION,
+				Product: OcsfProduct{Name: TOOL_NAME, VendorName: TOOL_NAME, URL: TOOL_URI},
+				UID:     uid,
+			},
+			FindingInfo: OcsfFindingInfo{
+				UID:         uid,
+				Title:       f.Title,
+				Desc:        fmt.Sprintf("%v detected on %v of app %v", f.Title, f.Entity, aid),
+				CreatedTime: created.UnixMilli(),
+				Analytic: OcsfAnalytic{
+					UID:    scenarios.RuleID(f.Title),
+					Name:   f.Title,
+					TypeID: OCSF_ANALYTIC_RULE,
+					Type:   "Rule",
+				},
+			},
+			Resources:   []OcsfResource{{UID: f.Entity, Name: f.Entity}},
+			Remediation: OcsfRemediation{Desc: f.Remediation},
+		}
+		if f.TechniqueID != "" {
+			ev.FindingInfo.Attacks = []OcsfAttack{{
+				Tactic:    OcsfAttackRef{UID: f.TacticID, Name: f.Tactic},
+				Technique: OcsfAttackRef{UID: f.TechniqueID, Name: f.Technique.Technique},
+			}}
+		}
+		if e, ok := x.getEntity(aid, f.Entity); ok {
+			if e.Name != "" {
+				ev.Resources[0].Name = e.Name
+			}
+			ev.Resources[0].Type = e.Kind
+			ev.Resources[0].Data = e.Attributes
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// GetOcsf is GET handler to download findings of an attack graph as OCSF
+// Detection Finding events, given an attack graph or an app to export its
+// latest attack graph
+func (x *Export) GetOcsf(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	ag, err := x.getAttackGraph(aid)
+	if err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+
+	// return OCSF response
+	setDownload(w, "application/json", ag.ID+".ocsf.json")
+	json.NewEncoder(w).Encode(x.Ocsf(ag))
+}
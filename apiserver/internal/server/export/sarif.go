@@ -0,0 +1,177 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	SARIF_VERSION = "2.1.0"
+	SARIF_SCHEMA  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	TOOL_NAME = "Zentaris"
+	TOOL_URI  = "https://github.com/zetafence/zentaris"
+
+	// entity attributes of entities imported from Terraform/IaC, locating
+	// their definition
+	ATTR_SOURCE_FILE       = "SourceFile"
+	ATTR_SOURCE_LINE       = "SourceLine"
+	ATTR_SOURCE_END_LINE   = "SourceEndLine"
+	ATTR_TERRAFORM_ADDRESS = "TerraformAddress"
+)
+
+// sarifLevel maps a finding risk onto a SARIF level
+func sarifLevel(risk string) string {
+	switch risk {
+	case scenarios.RISK_CRITICAL, scenarios.RISK_HIGH:
+		return "error"
+	case scenarios.RISK_MEDIUM:
+		return "warning"
+	}
+	return "note"
+}
+
+// securitySeverity maps a finding risk onto a numeric security severity, as
+// used by code scanning tools to rank results
+func securitySeverity(risk string) string {
+	switch risk {
+	case scenarios.RISK_CRITICAL:
+		return "9.5"
+	case scenarios.RISK_HIGH:
+		return "8.0"
+	case scenarios.RISK_MEDIUM:
+		return "5.5"
+	}
+	return "2.0"
+}
+
+// fingerprint returns a stable fingerprint of a finding across evaluations
+func fingerprint(ruleID, entity string) string {
+	sum := sha256.Sum256([]byte(ruleID + "|" + entity))
+	return hex.EncodeToString(sum[:16])
+}
+
+// sarifLocation locates an entity, in its IaC source file when known
+func (x *Export) sarifLocation(aid, eid string) SarifLocation {
+	loc := SarifLocation{
+		LogicalLocations: []SarifLogicalLocation{{
+			Name:               eid,
+			FullyQualifiedName: aid + "/" + eid,
+			Kind:               "resource",
+		}},
+	}
+	e, ok := x.getEntity(aid, eid)
+	if !ok {
+		return loc
+	}
+	if addr := e.Attributes[ATTR_TERRAFORM_ADDRESS]; addr != "" {
+		loc.LogicalLocations[0].FullyQualifiedName = addr
+	}
+	if e.Kind != "" {
+		loc.LogicalLocations[0].Kind = e.Kind
+	}
+	file := e.Attributes[ATTR_SOURCE_FILE]
+	if file == "" {
+		return loc
+	}
+	loc.PhysicalLocation = &SarifPhysicalLocation{
+		ArtifactLocation: SarifArtifactLocation{URI: file},
+	}
+	if start, err := strconv.Atoi(e.Attributes[ATTR_SOURCE_LINE]); err == nil && start > 0 {
+		loc.PhysicalLocation.Region = &SarifRegion{StartLine: start}
+		if end, err := strconv.Atoi(e.Attributes[ATTR_SOURCE_END_LINE]); err == nil && end >= start {
+			loc.PhysicalLocation.Region.EndLine = end
+		}
+	}
+	return loc
+}
+
+// Sarif renders findings of the latest attack graph of an app as SARIF
+func (x *Export) Sarif(aid string) SarifLog {
+	sc := scenarios.NewScenario(x.db)
+	run := SarifRun{
+		Tool: SarifTool{Driver: SarifDriver{
+			Name:           TOOL_NAME,
+			InformationURI: TOOL_URI,
+			Rules:          []SarifRule{},
+		}},
+		AutomationDetails: SarifAutomationDetails{ID: aid + "/"},
+		Results:           []SarifResult{},
+	}
+	if ag, ok := sc.LatestAttackGraph(aid); ok {
+		run.AutomationDetails.ID = aid + "/" + ag.ID
+	}
+
+	rules := map[string]int{}
+	for _, f := range sc.LatestFindings(aid) {
+		ruleID := scenarios.RuleID(f.Title)
+		index, ok := rules[ruleID]
+		if !ok {
+			tags := []string{"security"}
+			if f.TechniqueID != "" {
+				tags = append(tags, "external/mitre/"+f.TechniqueID)
+			}
+			index = len(run.Tool.Driver.Rules)
+			rules[ruleID] = index
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, SarifRule{
+				ID:                   ruleID,
+				Name:                 f.Title,
+				ShortDescription:     SarifMessage{Text: f.Title},
+				Help:                 SarifMessage{Text: f.Remediation},
+				DefaultConfiguration: SarifConfiguration{Level: sarifLevel(f.Risk)},
+				Properties: map[string]interface{}{
+					"tags":              tags,
+					"security-severity": securitySeverity(f.Risk),
+					"tactic":            f.Tactic,
+					"technique":         f.Technique.Technique,
+				},
+			})
+		}
+		run.Results = append(run.Results, SarifResult{
+			RuleID:    ruleID,
+			RuleIndex: index,
+			Level:     sarifLevel(f.Risk),
+			Message:   SarifMessage{Text: fmt.Sprintf("%v on %v. %v", f.Title, f.Entity, f.Remediation)},
+			Locations: []SarifLocation{x.sarifLocation(aid, f.Entity)},
+			PartialFingerprints: map[string]string{
+				"zentarisFinding/v1": fingerprint(ruleID, f.Entity),
+			},
+			Properties: map[string]interface{}{
+				"risk":              f.Risk,
+				"security-severity": securitySeverity(f.Risk),
+				"entity":            f.Entity,
+				"remediation":       f.Remediation,
+			},
+		})
+	}
+	return SarifLog{
+		Schema:  SARIF_SCHEMA,
+		Version: SARIF_VERSION,
+		Runs:    []SarifRun{run},
+	}
+}
+
+// GetSarif is GET handler to download app findings as a SARIF 2.1.0 log
+func (x *Export) GetSarif(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	if _, err := x.getApp(aid); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+
+	// return SARIF response
+	setDownload(w, "application/sarif+json", aid+".sarif")
+	json.NewEncoder(w).Encode(x.Sarif(aid))
+}
@@ -0,0 +1,170 @@
+package export
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+// newTestDb returns a DB of app a1 with a console user u1 defined in a
+// Terraform file and a user u3, both lacking MFA, and their attack graph
+func newTestDb(t *testing.T) *db.MemoryDb {
+	t.Helper()
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS)
+	if err := graph.CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	graph.Apps(d).Put("a1", graph.AppData{ID: "a1", Name: "a1"})
+	for _, e := range []graph.Entity{
+		{ID: "u1", Name: "alice", Kind: "user", Attributes: map[string]string{
+			"ConsoleAccess": "true", "AccessKeys": "none",
+			ATTR_SOURCE_FILE: "iam/main.tf", ATTR_SOURCE_LINE: "10", ATTR_SOURCE_END_LINE: "20",
+			ATTR_TERRAFORM_ADDRESS: "aws_iam_user.alice"}},
+		{ID: "u3", Kind: "user", Attributes: map[string]string{}},
+	} {
+		key := graph.GetEntityKey("a1", e.ID)
+		e.ID = key
+		graph.Entities(d).Put(key, e)
+	}
+	scan(t, d)
+	return d
+}
+
+// scan builds a new attack graph of app a1
+func scan(t *testing.T, d db.Db) {
+	t.Helper()
+	if _, err := scenarios.NewScenario(d).BuildAppScenarios("a1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSarif(t *testing.T) {
+	d := newTestDb(t)
+	x := NewExport(d)
+	log := x.Sarif("a1")
+	if log.Version != SARIF_VERSION || len(log.Runs) != 1 {
+		t.Fatalf("expecting one SARIF %v run, got %+v", SARIF_VERSION, log)
+	}
+	run := log.Runs[0]
+
+	// one rule per finding title, one result per finding
+	levels := map[string]string{}
+	for _, res := range run.Results {
+		rule := run.Tool.Driver.Rules[res.RuleIndex]
+		if rule.ID != res.RuleID || rule.DefaultConfiguration.Level != res.Level {
+			t.Errorf("expecting result of rule %v, got %+v", rule.ID, res)
+		}
+		levels[res.RuleID+" "+res.Properties["entity"].(string)] = res.Level
+	}
+	want := map[string]string{
+		"credential-access-access-via-brute-force u1": "error",
+		"credential-access-access-via-brute-force u3": "error",
+		"initial-access-via-valid-accounts u1":        "warning",
+	}
+	if len(run.Tool.Driver.Rules) != 2 || !reflect.DeepEqual(levels, want) {
+		t.Fatalf("expecting results %v of 2 rules, got %v of %v", want, levels, len(run.Tool.Driver.Rules))
+	}
+
+	// fingerprints are stable across attack graphs
+	fingerprints := map[string]string{}
+	for _, res := range run.Results {
+		fingerprints[res.RuleID+" "+res.Properties["entity"].(string)] = res.PartialFingerprints["zentarisFinding/v1"]
+	}
+	scan(t, d)
+	next := x.Sarif("a1").Runs[0]
+	if next.AutomationDetails.ID == run.AutomationDetails.ID {
+		t.Fatalf("expecting run id of the new attack graph, got %v", next.AutomationDetails.ID)
+	}
+	for _, res := range next.Results {
+		if fp := fingerprints[res.RuleID+" "+res.Properties["entity"].(string)]; fp != res.PartialFingerprints["zentarisFinding/v1"] {
+			t.Fatalf("expecting stable fingerprint %v, got %v", fp, res.PartialFingerprints)
+		}
+	}
+}
+
+func TestSarifLevels(t *testing.T) {
+	tests := []struct {
+		risk     string
+		level    string
+		severity string
+	}{
+		{scenarios.RISK_CRITICAL, "error", "9.5"},
+		{scenarios.RISK_HIGH, "error", "8.0"},
+		{scenarios.RISK_MEDIUM, "warning", "5.5"},
+		{scenarios.RISK_LOW, "note", "2.0"},
+		{"", "note", "2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.risk, func(t *testing.T) {
+			if level, severity := sarifLevel(tt.risk), securitySeverity(tt.risk); level != tt.level || severity != tt.severity {
+				t.Fatalf("expecting %v %v, got %v %v", tt.level, tt.severity, level, severity)
+			}
+		})
+	}
+}
+
+func TestSarifLocation(t *testing.T) {
+	d := newTestDb(t)
+	put := func(eid string, attrs map[string]string) {
+		key := graph.GetEntityKey("a1", eid)
+		graph.Entities(d).Put(key, graph.Entity{ID: key, Kind: "vm", Attributes: attrs})
+	}
+	put("no-line", map[string]string{ATTR_SOURCE_FILE: "main.tf", ATTR_SOURCE_LINE: "x"})
+	put("bad-end", map[string]string{ATTR_SOURCE_FILE: "main.tf", ATTR_SOURCE_LINE: "7", ATTR_SOURCE_END_LINE: "3"})
+	put("no-file", map[string]string{ATTR_SOURCE_LINE: "7"})
+
+	file := func(uri string, region *SarifRegion) *SarifPhysicalLocation {
+		return &SarifPhysicalLocation{ArtifactLocation: SarifArtifactLocation{URI: uri}, Region: region}
+	}
+	tests := []struct {
+		eid      string
+		physical *SarifPhysicalLocation
+		logical  SarifLogicalLocation
+	}{
+		{"u1", file("iam/main.tf", &SarifRegion{StartLine: 10, EndLine: 20}),
+			SarifLogicalLocation{Name: "u1", FullyQualifiedName: "aws_iam_user.alice", Kind: "user"}},
+		{"no-line", file("main.tf", nil), SarifLogicalLocation{Name: "no-line", FullyQualifiedName: "a1/no-line", Kind: "vm"}},
+		{"bad-end", file("main.tf", &SarifRegion{StartLine: 7}), SarifLogicalLocation{Name: "bad-end", FullyQualifiedName: "a1/bad-end", Kind: "vm"}},
+		{"no-file", nil, SarifLogicalLocation{Name: "no-file", FullyQualifiedName: "a1/no-file", Kind: "vm"}},
+		{"missing", nil, SarifLogicalLocation{Name: "missing", FullyQualifiedName: "a1/missing", Kind: "resource"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.eid, func(t *testing.T) {
+			loc := NewExport(d).sarifLocation("a1", tt.eid)
+			if !reflect.DeepEqual(loc.PhysicalLocation, tt.physical) || !reflect.DeepEqual(loc.LogicalLocations, []SarifLogicalLocation{tt.logical}) {
+				t.Fatalf("expecting %+v %+v, got %+v %+v", tt.physical, tt.logical, loc.PhysicalLocation, loc.LogicalLocations)
+			}
+		})
+	}
+}
+
+func TestGetSarif(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/app/{id}/export/sarif", NewExport(newTestDb(t)).GetSarif).Methods("GET")
+	tests := []struct {
+		app  string
+		code int
+	}{
+		{"a1", http.StatusOK},
+		{"a2", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.app, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/v1/app/"+tt.app+"/export/sarif", nil))
+			if w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v", tt.code, w.Code)
+			}
+			if tt.code == http.StatusOK && w.Header().Get("Content-Type") != "application/sarif+json" {
+				t.Fatalf("expecting SARIF content, got %v", w.Header())
+			}
+		})
+	}
+}
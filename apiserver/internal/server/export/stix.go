@@ -0,0 +1,209 @@
+package export
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	STIX_SPEC_VERSION = "2.1"
+	STIX_TIME_FORMAT  = "2006-01-02T15:04:05.000Z"
+
+	// STIX namespace of deterministic identifiers
+	STIX_NAMESPACE = "00abedb4-aa42-466c-9c01-fed23315a9b7"
+
+	MITRE_ATTACK_SOURCE = "mitre-attack"
+	MITRE_ATTACK_URL    = "https://attack.mitre.org/techniques/"
+)
+
+// uuid5 returns a name based UUID version 5 within a namespace UUID
+func uuid5(namespace, name string) string {
+	ns, _ := hex.DecodeString(strings.ReplaceAll(namespace, "-", ""))
+	h := sha1.New()
+	h.Write(ns)
+	h.Write([]byte(name))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// stixId returns a deterministic STIX identifier of an object type and name,
+// so that objects keep their identifiers across exports
+func stixId(typ, name string) string {
+	return typ + "--" + uuid5(STIX_NAMESPACE, typ+"|"+name)
+}
+
+// stixTime formats an RFC3339 timestamp as a STIX timestamp
+func stixTime(ts string) string {
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		t = time.Now()
+	}
+	return t.UTC().Format(STIX_TIME_FORMAT)
+}
+
+// phaseName returns the ATT&CK kill chain phase name of a tactic, e.g.
+// "credential-access"
+func phaseName(tactic string) string {
+	return strings.ReplaceAll(strings.ToLower(tactic), " ", "-")
+}
+
+// techniqueURL returns the ATT&CK page of a technique or sub-technique
+func techniqueURL(id string) string {
+	return MITRE_ATTACK_URL + strings.ReplaceAll(id, ".", "/") + "/"
+}
+
+// Stix renders an attack graph, i.e. entities and hyperedges of its source
+// app and its findings, as a STIX 2.1 bundle
+func (x *Export) Stix(ag graph.AppData) StixBundle {
+	var (
+		aid     = fmt.Sprint(ag.Attributes[scenarios.ATTR_SOURCE_APP])
+		h       = graph.LoadHypergraph(x.db, aid)
+		ts      = stixTime(ag.Created)
+		objects = []StixObject{}
+		infra   = map[string]string{} // entity id -> STIX id
+	)
+	object := func(typ, name string) StixObject {
+		return StixObject{
+			Type:        typ,
+			SpecVersion: STIX_SPEC_VERSION,
+			ID:          stixId(typ, name),
+			Created:     ts,
+			Modified:    ts,
+		}
+	}
+	relationship := func(typ, source, target, desc string) StixObject {
+		rel := object("relationship", source+"|"+typ+"|"+target)
+		rel.RelationshipType = typ
+		rel.SourceRef = source
+		rel.TargetRef = target
+		rel.Description = desc
+		return rel
+	}
+
+	// vertices
+	ids := make([]string, 0, len(h.Entities))
+	for eid := range h.Entities {
+		ids = append(ids, eid)
+	}
+	sort.Strings(ids)
+	for _, eid := range ids {
+		e := h.Entities[eid]
+		o := object("infrastructure", aid+"|"+eid)
+		o.Name = eid
+		if e.Name != "" {
+			o.Name = e.Name
+		}
+		o.Description = e.Description
+		o.InfrastructureTypes = []string{"unknown"}
+		o.XZentarisKind = e.Kind
+		o.XZentarisAttributes = e.Attributes
+		infra[eid] = o.ID
+		objects = append(objects, o)
+	}
+
+	// hyperedges, as groupings of their members
+	refs := func(members []string) []string {
+		ret := []string{}
+		for _, m := range members {
+			if id, ok := infra[m]; ok {
+				ret = append(ret, id)
+			}
+		}
+		return ret
+	}
+	ids = ids[:0]
+	for sid := range h.Assocs {
+		ids = append(ids, sid)
+	}
+	sort.Strings(ids)
+	for _, sid := range ids {
+		a := h.Assocs[sid]
+		members := refs(a.Members())
+		if len(members) == 0 {
+			continue
+		}
+		o := object("grouping", aid+"|"+sid)
+		o.Name = sid
+		if a.Name != "" {
+			o.Name = a.Name
+		}
+		o.Description = a.Description
+		o.Context = "unspecified"
+		o.ObjectRefs = members
+		o.XZentarisFromRefs = refs(a.FromEntities)
+		o.XZentarisToRefs = refs(a.ToEntities)
+		o.XZentarisOtherRefs = refs(a.OtherEntities)
+		objects = append(objects, o)
+	}
+
+	// findings, as attack patterns mitigated by courses of action
+	patterns := map[string]string{}
+	for _, f := range scenarios.NewScenario(x.db).Findings(ag.ID) {
+		pid, ok := patterns[f.Title]
+		if !ok {
+			ap := object("attack-pattern", f.Title)
+			ap.Name = f.Title
+			if f.TechniqueID != "" {
+				ap.ExternalReferences = []StixExternalReference{{
+					SourceName: MITRE_ATTACK_SOURCE,
+					ExternalID: f.TechniqueID,
+					URL:        techniqueURL(f.TechniqueID),
+				}}
+				ap.KillChainPhases = []StixKillChainPhase{{
+					KillChainName: MITRE_ATTACK_SOURCE,
+					PhaseName:     phaseName(f.Tactic),
+				}}
+			}
+			coa := object("course-of-action", f.Title)
+			coa.Name = "Remediate " + f.Title
+			coa.Description = f.Remediation
+			pid = ap.ID
+			patterns[f.Title] = pid
+			objects = append(objects, ap, coa, relationship("mitigates", coa.ID, ap.ID, ""))
+		}
+		if target, ok := infra[f.Entity]; ok {
+			rel := relationship("related-to", pid, target, fmt.Sprintf("%v on %v", f.Title, f.Entity))
+			rel.XZentarisRisk = f.Risk
+			rel.Labels = []string{"finding"}
+			objects = append(objects, rel)
+		}
+	}
+
+	return StixBundle{
+		Type:    "bundle",
+		ID:      "bundle--" + uuid5(STIX_NAMESPACE, "bundle|"+ag.ID),
+		Objects: objects,
+	}
+}
+
+// GetStix is GET handler to download an attack graph as a STIX 2.1 bundle,
+// given an attack graph or an app to export its latest attack graph
+func (x *Export) GetStix(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	ag, err := x.getAttackGraph(aid)
+	if err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+
+	// return STIX response
+	setDownload(w, "application/stix+json;version=2.1", ag.ID+".stix.json")
+	json.NewEncoder(w).Encode(x.Stix(ag))
+}
@@ -0,0 +1,150 @@
+package export
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+func TestUuid5(t *testing.T) {
+	// RFC 4122 name based UUID of www.example.com in the DNS namespace
+	if u := uuid5("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "www.example.com"); u != "2ed6657d-e927-568b-95e1-2665a8aea6a2" {
+		t.Fatalf("expecting UUID version 5, got %v", u)
+	}
+	if u := techniqueURL("T1078.004"); u != "https://attack.mitre.org/techniques/T1078/004/" {
+		t.Fatalf("expecting sub-technique page, got %v", u)
+	}
+}
+
+func TestStix(t *testing.T) {
+	d := newTestDb(t)
+	for _, a := range []graph.Assoc{
+		{ID: "s1", Name: "login", FromEntities: []string{"u1"}, ToEntities: []string{"u3"}, OtherEntities: []string{"ghost"}},
+		{ID: "s2", FromEntities: []string{"ghost"}},
+	} {
+		key := graph.GetEntityKey("a1", a.ID)
+		a.ID = key
+		graph.Assocs(d).Put(key, a)
+	}
+	x := NewExport(d)
+	ag, err := x.getAttackGraph("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := x.Stix(ag)
+
+	objects := map[string]StixObject{}
+	types := map[string]int{}
+	for _, o := range bundle.Objects {
+		if _, ok := objects[o.ID]; ok {
+			t.Fatalf("expecting unique object ids, got %v twice", o.ID)
+		}
+		if o.SpecVersion != STIX_SPEC_VERSION {
+			t.Fatalf("expecting STIX %v objects, got %+v", STIX_SPEC_VERSION, o)
+		}
+		objects[o.ID] = o
+		types[o.Type]++
+		if o.Type == "relationship" {
+			types[o.RelationshipType]++
+		}
+	}
+
+	// entities, hyperedges with known members, findings and their remediations
+	want := map[string]int{
+		"infrastructure": 2, "grouping": 1, "attack-pattern": 2, "course-of-action": 2,
+		"relationship": 5, "mitigates": 2, "related-to": 3,
+	}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("expecting objects %v, got %v", want, types)
+	}
+
+	// every reference resolves to an object of the bundle
+	for _, o := range bundle.Objects {
+		refs := append([]string{}, o.ObjectRefs...)
+		refs = append(refs, o.XZentarisFromRefs...)
+		refs = append(refs, o.XZentarisToRefs...)
+		refs = append(refs, o.XZentarisOtherRefs...)
+		if o.Type == "relationship" {
+			refs = append(refs, o.SourceRef, o.TargetRef)
+		}
+		for _, ref := range refs {
+			if _, ok := objects[ref]; !ok {
+				t.Fatalf("%v: expecting reference %v in the bundle", o.ID, ref)
+			}
+		}
+		switch {
+		case o.Type == "grouping" && (o.Name != "login" || len(o.ObjectRefs) != 2 || len(o.XZentarisOtherRefs) != 0):
+			t.Fatalf("expecting grouping of known members, got %+v", o)
+		case o.RelationshipType == "mitigates" && (objects[o.SourceRef].Type != "course-of-action" || objects[o.TargetRef].Type != "attack-pattern"):
+			t.Fatalf("expecting courses of action mitigating attack patterns, got %+v", o)
+		case o.RelationshipType == "related-to" && (objects[o.SourceRef].Type != "attack-pattern" || objects[o.TargetRef].Type != "infrastructure" || o.XZentarisRisk == ""):
+			t.Fatalf("expecting findings relating attack patterns to entities, got %+v", o)
+		case o.Type == "attack-pattern" && (len(o.ExternalReferences) != 1 || o.ExternalReferences[0].SourceName != MITRE_ATTACK_SOURCE || len(o.KillChainPhases) != 1):
+			t.Fatalf("expecting ATT&CK references of attack patterns, got %+v", o)
+		}
+	}
+	if id := stixId("infrastructure", "a1|u1"); objects[id].Name != "alice" || objects[id].XZentarisKind != "user" {
+		t.Fatalf("expecting entity u1 named alice, got %+v", objects[id])
+	}
+
+	// identifiers are deterministic across exports
+	if again := x.Stix(ag); !reflect.DeepEqual(again, bundle) {
+		t.Fatalf("expecting identical bundles of one attack graph")
+	}
+
+	// attack graphs are exported by id too, apps without any are not found
+	if got, err := x.getAttackGraph(ag.ID); err != nil || got.ID != ag.ID {
+		t.Fatalf("expecting attack graph %v, got %v %v", ag.ID, got.ID, err)
+	}
+	graph.Apps(d).Put("a2", graph.AppData{ID: "a2"})
+	for _, aid := range []string{"a2", "a3"} {
+		if _, err := x.getAttackGraph(aid); err == nil {
+			t.Fatalf("expecting no attack graph of %v", aid)
+		}
+	}
+}
+
+func TestOcsf(t *testing.T) {
+	x := NewExport(newTestDb(t))
+	ag, err := x.getAttackGraph("a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	severities := map[string]int{}
+	for _, ev := range x.Ocsf(ag) {
+		if ev.ClassUID != OCSF_CLASS_DETECTION_FINDING || ev.TypeUID != 200401 || ev.Metadata.UID != ev.FindingInfo.UID {
+			t.Fatalf("expecting a Detection Finding, got %+v", ev)
+		}
+		if len(ev.FindingInfo.Attacks) != 1 || ev.FindingInfo.Attacks[0].Technique.UID == "" {
+			t.Fatalf("expecting ATT&CK technique of the finding, got %+v", ev.FindingInfo)
+		}
+		res := ev.Resources[0]
+		severities[res.UID+" "+ev.FindingInfo.Analytic.UID] = ev.SeverityID
+		if res.UID == "u1" && (res.Name != "alice" || res.Type != "user" || res.Data["ConsoleAccess"] != "true") {
+			t.Fatalf("expecting resource of entity u1, got %+v", res)
+		}
+	}
+	want := map[string]int{
+		"u1 credential-access-access-via-brute-force": OCSF_SEVERITY_CRITICAL,
+		"u3 credential-access-access-via-brute-force": OCSF_SEVERITY_CRITICAL,
+		"u1 initial-access-via-valid-accounts":        OCSF_SEVERITY_MEDIUM,
+	}
+	if !reflect.DeepEqual(severities, want) {
+		t.Fatalf("expecting severities %v, got %v", want, severities)
+	}
+
+	for _, tt := range []struct {
+		risk string
+		id   int
+	}{
+		{scenarios.RISK_HIGH, OCSF_SEVERITY_HIGH},
+		{scenarios.RISK_LOW, OCSF_SEVERITY_LOW},
+		{"", OCSF_SEVERITY_LOW},
+	} {
+		if id, _ := ocsfSeverity(tt.risk); id != tt.id {
+			t.Errorf("%v: expecting severity %v, got %v", tt.risk, tt.id, id)
+		}
+	}
+}
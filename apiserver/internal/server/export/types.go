@@ -0,0 +1,209 @@
+package export
+
+import (
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Export represents exports of app hypergraphs and findings onto external
+// formats
+type Export struct {
+	db db.Db
+}
+
+// SARIF 2.1.0 log, see https://docs.oasis-open.org/sarif/sarif/v2.1.0/
+type SarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []SarifRun `json:"runs"`
+}
+
+type SarifRun struct {
+	Tool              SarifTool              `json:"tool"`
+	AutomationDetails SarifAutomationDetails `json:"automationDetails"`
+	Results           []SarifResult          `json:"results"`
+}
+
+type SarifTool struct {
+	Driver SarifDriver `json:"driver"`
+}
+
+type SarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []SarifRule `json:"rules"`
+}
+
+type SarifMessage struct {
+	Text string `json:"text"`
+}
+
+type SarifRule struct {
+	ID                   string                 `json:"id"`
+	Name                 string                 `json:"name"`
+	ShortDescription     SarifMessage           `json:"shortDescription"`
+	Help                 SarifMessage           `json:"help"`
+	DefaultConfiguration SarifConfiguration     `json:"defaultConfiguration"`
+	Properties           map[string]interface{} `json:"properties"`
+}
+
+type SarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type SarifAutomationDetails struct {
+	ID string `json:"id"`
+}
+
+type SarifResult struct {
+	RuleID              string                 `json:"ruleId"`
+	RuleIndex           int                    `json:"ruleIndex"`
+	Level               string                 `json:"level"`
+	Message             SarifMessage           `json:"message"`
+	Locations           []SarifLocation        `json:"locations"`
+	PartialFingerprints map[string]string      `json:"partialFingerprints"`
+	Properties          map[string]interface{} `json:"properties"`
+}
+
+type SarifLocation struct {
+	PhysicalLocation *SarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []SarifLogicalLocation `json:"logicalLocations"`
+}
+
+type SarifPhysicalLocation struct {
+	ArtifactLocation SarifArtifactLocation `json:"artifactLocation"`
+	Region           *SarifRegion          `json:"region,omitempty"`
+}
+
+type SarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type SarifRegion struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine,omitempty"`
+}
+
+type SarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// STIX 2.1 bundle, see https://docs.oasis-open.org/cti/stix/v2.1/
+type StixBundle struct {
+	Type    string       `json:"type"`
+	ID      string       `json:"id"`
+	Objects []StixObject `json:"objects"`
+}
+
+// StixObject is a STIX domain or relationship object, properties not used
+// by an object type are left empty
+type StixObject struct {
+	Type                string                  `json:"type"`
+	SpecVersion         string                  `json:"spec_version"`
+	ID                  string                  `json:"id"`
+	Created             string                  `json:"created"`
+	Modified            string                  `json:"modified"`
+	Name                string                  `json:"name,omitempty"`
+	Description         string                  `json:"description,omitempty"`
+	InfrastructureTypes []string                `json:"infrastructure_types,omitempty"`
+	Context             string                  `json:"context,omitempty"`
+	ObjectRefs          []string                `json:"object_refs,omitempty"`
+	RelationshipType    string                  `json:"relationship_type,omitempty"`
+	SourceRef           string                  `json:"source_ref,omitempty"`
+	TargetRef           string                  `json:"target_ref,omitempty"`
+	ExternalReferences  []StixExternalReference `json:"external_references,omitempty"`
+	KillChainPhases     []StixKillChainPhase    `json:"kill_chain_phases,omitempty"`
+	Labels              []string                `json:"labels,omitempty"`
+
+	// custom properties
+	XZentarisKind       string            `json:"x_zentaris_kind,omitempty"`
+	XZentarisRisk       string            `json:"x_zentaris_risk,omitempty"`
+	XZentarisAttributes map[string]string `json:"x_zentaris_attributes,omitempty"`
+	XZentarisFromRefs   []string          `json:"x_zentaris_from_refs,omitempty"`
+	XZentarisToRefs     []string          `json:"x_zentaris_to_refs,omitempty"`
+	XZentarisOtherRefs  []string          `json:"x_zentaris_other_refs,omitempty"`
+}
+
+type StixExternalReference struct {
+	SourceName string `json:"source_name"`
+	ExternalID string `json:"external_id,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+type StixKillChainPhase struct {
+	KillChainName string `json:"kill_chain_name"`
+	PhaseName     string `json:"phase_name"`
+}
+
+// OCSF Detection Finding event, see https://schema.ocsf.io/classes/detection_finding
+type OcsfDetectionFinding struct {
+	ActivityID   int             `json:"activity_id"`
+	ActivityName string          `json:"activity_name"`
+	CategoryUID  int             `json:"category_uid"`
+	CategoryName string          `json:"category_name"`
+	ClassUID     int             `json:"class_uid"`
+	ClassName    string          `json:"class_name"`
+	TypeUID      int             `json:"type_uid"`
+	TypeName     string          `json:"type_name"`
+	Time         int64           `json:"time"`
+	SeverityID   int             `json:"severity_id"`
+	Severity     string          `json:"severity"`
+	StatusID     int             `json:"status_id"`
+	Status       string          `json:"status"`
+	Message      string          `json:"message"`
+	Metadata     OcsfMetadata    `json:"metadata"`
+	FindingInfo  OcsfFindingInfo `json:"finding_info"`
+	Resources    []OcsfResource  `json:"resources"`
+	Remediation  OcsfRemediation `json:"remediation"`
+}
+
+type OcsfMetadata struct {
+	Version string      `json:"version"`
+	Product OcsfProduct `json:"product"`
+	UID     string      `json:"uid"`
+}
+
+type OcsfProduct struct {
+	Name       string `json:"name"`
+	VendorName string `json:"vendor_name"`
+	URL        string `json:"url_string"`
+}
+
+type OcsfFindingInfo struct {
+	UID         string       `json:"uid"`
+	Title       string       `json:"title"`
+	Desc        string       `json:"desc"`
+	CreatedTime int64        `json:"created_time"`
+	Analytic    OcsfAnalytic `json:"analytic"`
+	Attacks     []OcsfAttack `json:"attacks,omitempty"`
+}
+
+type OcsfAnalytic struct {
+	UID    string `json:"uid"`
+	Name   string `json:"name"`
+	TypeID int    `json:"type_id"`
+	Type   string `json:"type"`
+}
+
+type OcsfAttack struct {
+	Version   string        `json:"version,omitempty"`
+	Tactic    OcsfAttackRef `json:"tactic"`
+	Technique OcsfAttackRef `json:"technique"`
+}
+
+type OcsfAttackRef struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+}
+
+type OcsfResource struct {
+	UID  string            `json:"uid"`
+	Name string            `json:"name"`
+	Type string            `json:"type"`
+	Data map[string]string `json:"data,omitempty"`
+}
+
+type OcsfRemediation struct {
+	Desc string `json:"desc"`
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -17,8 +19,25 @@ const (
 	DB_TABLE_GRAPH    = "graph"
 	DB_TABLE_ENTITIES = "entities"
 	DB_TABLE_ASSOCS   = "assocs"
+
+	// attack graph attribute linking back to the scanned app
+	ATTR_SOURCE_APP = "SourceApp"
+)
+
+var (
+	// DB tables of other packages keyed by app ids or prefixed by them
+	appTables      = []string{}
+	appTablesMutex sync.RWMutex
 )
 
+// RegisterAppTables registers DB tables whose rows are keyed by app ids or
+// prefixed by them, so that they are deleted along with their apps
+func RegisterAppTables(tables ...string) {
+	appTablesMutex.Lock()
+	defer appTablesMutex.Unlock()
+	appTables = append(appTables, tables...)
+}
+
 // NewGraph returns a new graph element
 func NewGraph(db db.Db) *Graph {
 	return &Graph{
@@ -26,6 +45,18 @@ func NewGraph(db db.Db) *Graph {
 	}
 }
 
+// validAppId returns an error unless an app id may prefix keys of its
+// entities and assocs, i.e. is set and has no /
+func validAppId(aid string) error {
+	if aid == "" {
+		return fmt.Errorf("app id is required")
+	}
+	if strings.Contains(aid, "/") {
+		return fmt.Errorf("app id %v must not contain /", aid)
+	}
+	return nil
+}
+
 // CreateAppData is POST handler to accept JSON input and store it in the key-value store
 func (g *Graph) CreateAppData(w http.ResponseWriter, r *http.Request) {
 	var appData AppData
@@ -34,8 +65,15 @@ func (g *Graph) CreateAppData(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := validAppId(appData.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	g.db.Add(DB_TABLE_GRAPH, appData.ID, appData)
+	if err := Apps(g.db).Put(appData.ID, appData); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	log.Printf("new app %v\n", appData.ID)
 
 	w.WriteHeader(http.StatusCreated)
@@ -47,9 +85,12 @@ func (g *Graph) GetAppData(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	appData, err := g.db.Get(DB_TABLE_GRAPH, id)
+	appData, version, err := readRecord(g, Apps(g.db), id)
 	if err != nil {
-		http.Error(w, "Data not found", http.StatusNotFound)
+		RecordError(w, err)
+		return
+	}
+	if setETag(w, r, version) {
 		return
 	}
 
@@ -58,39 +99,52 @@ func (g *Graph) GetAppData(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(appData)
 }
 
-// GetAllApps is GET Handler to retrieve a list of all entities
+// GetAllApps is GET Handler to retrieve a page of apps, sorted and filtered
+// by list parameters
 func (g *Graph) GetAllApps(w http.ResponseWriter, r *http.Request) {
-	// collect all assocs from the DB into a slice (array)
-	appList := AppsList{
-		Apps: []AppData{},
+	g.listApps(w, r, func(a AppData) bool { return a.Type != "attackGraph" }, g.appRisk)
+}
+
+// listApps lists a page of apps or attack graphs, with a given risk level of
+// each
+func (g *Graph) listApps(w http.ResponseWriter, r *http.Request, include func(AppData) bool, risk func(string) string) {
+	params, err := g.parseListParams(r, SORT_NAME, SORT_CREATED, SORT_SCORE)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	for _, app := range g.db.List(DB_TABLE_GRAPH) {
-		if a, ok := app.(AppData); ok {
-			if a.Type != "attackGraph" {
-				appList.Apps = append(appList.Apps, a)
-			}
+
+	// collect matching apps from the DB into a slice (array)
+	apps := []AppData{}
+	items := []listItem{}
+	all, err := Apps(g.db).List()
+	logErrors(err)
+	for _, a := range all {
+		if !include(a) || !params.matchAttrs(anyAttrs(a.Attributes)) {
+			continue
+		}
+		if params.risk != "" && !g.matchRisk(params, risk(a.ID)) {
+			continue
 		}
+		apps = append(apps, a)
+		items = append(items, listItem{id: a.ID, name: a.Name, created: a.Created, score: a.Stats.LastScore})
 	}
 
+	appList := AppsList{
+		Apps:  []AppData{},
+		Total: len(apps),
+	}
+	page, next := params.page(items)
+	for _, i := range page {
+		appList.Apps = append(appList.Apps, apps[i])
+	}
+	appList.NextCursor = next
+
 	// return slice as JSON
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(appList)
 }
 
-// EvalAppData is POST eval handler to accept JSON input and store it in the key-value store
-func (g *Graph) EvalAppData(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-	log.Printf("evaluating app %v\n", id)
-
-	// return the slice as JSON
-	w.Header().Set("Content-Type", "application/json")
-	evalResp := Response{
-		Status: fmt.Sprintf("attack risk evaluated success for %v, see attack graphs", id),
-	}
-	json.NewEncoder(w).Encode(evalResp)
-}
-
 // GetRiskData is POST risk handler to accept JSON input and store it in the key-value store
 func (g *Graph) GetRiskData(w http.ResponseWriter, r *http.Request) {
 	// Return the slice as JSON
@@ -99,14 +153,114 @@ func (g *Graph) GetRiskData(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// ParseTime returns the time of an RFC 3339 timestamp, with or without
+// fractional seconds, the zero time if invalid. Timestamps are compared as
+// times, as their strings do not sort in time order.
+func ParseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
 func GetEntityKey(aid, eid string) string {
 	return fmt.Sprintf("%s/%s", aid, eid)
 }
 
-// CreateEntities is POST handler to accept JSON input and store it in the key-value store
+// deleteEntity deletes an entity of an app along with its assocs
+func deleteEntity(d db.Db, aid, ekey string) error {
+	if err := d.Del(DB_TABLE_ENTITIES, ekey); err != nil {
+		return err
+	}
+	for _, a := range AssocsOfMember(d, aid, ekey) {
+		if err := d.Del(DB_TABLE_ASSOCS, a.ID); err != nil {
+			return err
+		}
+		log.Printf("removed assoc %v of entity %v\n", a.ID, ekey)
+	}
+	return nil
+}
+
+// removeStale deletes entries of an app on a given table not in keep, along
+// with assocs of deleted entities
+func removeStale(d db.Db, table, aid string, keep map[string]bool) error {
+	for _, row := range d.Scan(table, GetAppPrefix(aid)) {
+		if keep[row.Key] {
+			continue
+		}
+		var err error
+		if table == DB_TABLE_ENTITIES {
+			err = deleteEntity(d, aid, row.Key)
+		} else {
+			err = d.Del(table, row.Key)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("removed stale %v %v\n", table, row.Key)
+	}
+	return nil
+}
+
+// deleteApp deletes an app with its entities, assocs and snapshots, rows of
+// registered app tables, along with attack graphs built from it
+func deleteApp(d db.Db, aid string) error {
+	apps := []string{aid}
+	all, err := Apps(d).List()
+	logErrors(err)
+	for _, a := range all {
+		if a.Type == APP_TYPE_ATTACK_GRAPH && a.Attributes[ATTR_SOURCE_APP] == aid {
+			apps = append(apps, a.ID)
+		}
+	}
+	for _, id := range apps {
+		if err := d.Del(DB_TABLE_GRAPH, id); err != nil {
+			return err
+		}
+		for _, table := range []string{DB_TABLE_ENTITIES, DB_TABLE_ASSOCS, DB_TABLE_SNAPSHOTS} {
+			for _, row := range d.Scan(table, GetAppPrefix(id)) {
+				if err := d.Del(table, row.Key); err != nil {
+					return err
+				}
+			}
+		}
+		if err := deleteAppRows(d, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAppRows deletes rows of an app in registered app tables
+func deleteAppRows(d db.Db, aid string) error {
+	appTablesMutex.RLock()
+	defer appTablesMutex.RUnlock()
+
+	for _, table := range appTables {
+		keys := []string{}
+		if _, err := d.Get(table, aid); err == nil {
+			keys = append(keys, aid)
+		}
+		for _, row := range d.Scan(table, GetAppPrefix(aid)) {
+			keys = append(keys, row.Key)
+		}
+		for _, key := range keys {
+			if err := d.Del(table, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CreateEntities is POST handler to accept JSON input and store it in the
+// key-value store, all at once along with a new snapshot version, or the one
+// of its discovery run
 func (g *Graph) CreateEntities(w http.ResponseWriter, r *http.Request) {
 	var (
 		newEntities EntityList
+		version     int
 		vars        = mux.Vars(r)
 		aid         = vars["id"]
 	)
@@ -117,35 +271,87 @@ func (g *Graph) CreateEntities(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = db.RunTx(g.db, func(tx db.Tx) error {
+		keep := map[string]bool{}
+		for _, entity := range newEntities.Entities {
+			ekey := GetEntityKey(aid, entity.ID)
+			entity.ID = ekey
+			if err := Entities(tx).Put(ekey, entity); err != nil {
+				return err
+			}
+			keep[ekey] = true
+		}
+
+		// re-run discovery replaces all entities of the app
+		if r.URL.Query().Get("replace") == "true" {
+			if err := removeStale(tx, DB_TABLE_ENTITIES, aid, keep); err != nil {
+				return err
+			}
+		}
+		var err error
+		version, err = recordSnapshot(tx, aid, r.URL.Query().Get("run"))
+		return err
+	})
+	if err != nil {
+		txError(w, err)
+		return
+	}
 	for _, entity := range newEntities.Entities {
-		ekey := GetEntityKey(aid, entity.ID)
-		entity.ID = ekey
-		g.db.Add(DB_TABLE_ENTITIES, ekey, entity)
-		log.Printf("new app entity %v\n", ekey)
+		log.Printf("new app entity %v\n", GetEntityKey(aid, entity.ID))
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "entities for app %s created", aid)
+	fmt.Fprintf(w, "entities for app %s created, version %d", aid, version)
 }
 
-// GetAllEntities is GET handler to retrieve a list of all entities
+// GetAllEntities is GET handler to retrieve a page of entities, sorted and
+// filtered by list parameters
 func (g *Graph) GetAllEntities(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	aid := vars["id"]
 
-	// collect entities from the DB into a slice
+	params, err := g.parseListParams(r, SORT_NAME, SORT_CREATED, SORT_SCORE)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	risks := map[string]string{}
+	if params.risk != "" || params.sort == SORT_SCORE {
+		risks = g.risks(aid)
+	}
+
+	// collect matching entities from the DB into a slice, looking up an
+	// exact kind in the kind index
+	candidates := AppEntities(g.db, aid)
+	if params.kind != "" && !isPattern(params.kind) {
+		candidates = EntitiesOfKind(g.db, aid, params.kind)
+	}
+	entities := []Entity{}
+	items := []listItem{}
+	for _, e := range candidates {
+		e.ID = TrimAppPrefix(aid, e.ID)
+		if !match(params.kind, e.Kind) || !params.matchAttrs(e.Attributes) ||
+			!g.matchRisk(params, risks[e.ID]) {
+			continue
+		}
+		entities = append(entities, e)
+		items = append(items, listItem{
+			id:      e.ID,
+			name:    e.Name,
+			created: e.Created,
+			score:   float64(g.riskRank(risks[e.ID]) + 1),
+		})
+	}
+
 	entList := EntityList{
 		Entities: []Entity{},
+		Total:    len(entities),
 	}
-	for _, entity := range g.db.List(DB_TABLE_ENTITIES) {
-		if e, ok := entity.(Entity); ok {
-			if strings.HasPrefix(e.ID, aid) {
-				parts := strings.Split(e.ID, "/")
-				e.ID = parts[len(parts)-1]
-				entList.Entities = append(entList.Entities, e)
-			}
-		}
+	page, next := params.page(items)
+	for _, i := range page {
+		entList.Entities = append(entList.Entities, entities[i])
 	}
+	entList.NextCursor = next
 
 	// return slice as JSON
 	w.Header().Set("Content-Type", "application/json")
@@ -156,21 +362,28 @@ func (g *Graph) GetAllEntities(w http.ResponseWriter, r *http.Request) {
 func (g *Graph) GetEntityData(w http.ResponseWriter, r *http.Request) {
 	var (
 		vars = mux.Vars(r)
+		aid  = vars["aid"]
 		eid  = vars["eid"]
 	)
 
-	entity, err := g.db.Get(DB_TABLE_ENTITIES, eid)
+	entity, version, err := readRecord(g, Entities(g.db), GetEntityKey(aid, eid))
 	if err != nil {
-		http.Error(w, "Data not found", http.StatusNotFound)
+		RecordError(w, err)
+		return
+	}
+	if setETag(w, r, version) {
 		return
 	}
+	entity.ID = eid
 
 	// return JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(entity)
 }
 
-// CreateAssocData is POST handler to accept JSON input and store it in the key-value store
+// CreateAssocData is POST handler to accept JSON input and store it in the
+// key-value store, all at once along with a new snapshot version, or the one
+// of its discovery run
 func (g *Graph) CreateAssocData(w http.ResponseWriter, r *http.Request) {
 	var (
 		assocList AssocList
@@ -188,74 +401,109 @@ func (g *Graph) CreateAssocData(w http.ResponseWriter, r *http.Request) {
 		return fmt.Sprintf("%s/%s", aid, sid)
 	}
 
+	var version int
+	err = db.RunTx(g.db, func(tx db.Tx) error {
+		keep := map[string]bool{}
+		for _, assoc := range assocList.Assocs {
+			skey := getAssocKey(aid, assoc.ID)
+			assoc.ID = skey
+			if err := Assocs(tx).Put(assoc.ID, assoc); err != nil {
+				return err
+			}
+			keep[skey] = true
+		}
+
+		// re-run discovery replaces all assocs of the app
+		if r.URL.Query().Get("replace") == "true" {
+			if err := removeStale(tx, DB_TABLE_ASSOCS, aid, keep); err != nil {
+				return err
+			}
+		}
+		var err error
+		version, err = recordSnapshot(tx, aid, r.URL.Query().Get("run"))
+		return err
+	})
+	if err != nil {
+		txError(w, err)
+		return
+	}
 	for _, assoc := range assocList.Assocs {
-		skey := getAssocKey(aid, assoc.ID)
-		assoc.ID = skey
-		g.db.Add(DB_TABLE_ASSOCS, assoc.ID, assoc)
-		log.Printf("new app assoc %v\n", skey)
+		log.Printf("new app assoc %v\n", getAssocKey(aid, assoc.ID))
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "assocs for app %s created", aid)
+	fmt.Fprintf(w, "assocs for app %s created, version %d", aid, version)
 }
 
 // GetAssocData is GET handler to fetch JSON by ID from the key-value store
 func (g *Graph) GetAssocData(w http.ResponseWriter, r *http.Request) {
 	var (
 		vars = mux.Vars(r)
+		aid  = vars["aid"]
 		sid  = vars["sid"]
 	)
 
-	assoc, err := g.db.Get(DB_TABLE_ASSOCS, sid)
+	assoc, version, err := readRecord(g, Assocs(g.db), GetEntityKey(aid, sid))
 	if err != nil {
-		http.Error(w, "Data not found", http.StatusNotFound)
+		RecordError(w, err)
 		return
 	}
+	if setETag(w, r, version) {
+		return
+	}
+	assoc.ID = sid
 
 	// return JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assoc)
 }
 
-// GetAllAssocs is GET Handler to retrieve a list of all entities
+// GetAllAssocs is GET Handler to retrieve a page of assocs, sorted and
+// filtered by list parameters
 func (g *Graph) GetAllAssocs(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	aid := vars["id"]
 
-	// collect assocs from the DB into a slice
+	params, err := g.parseListParams(r, SORT_NAME, SORT_CREATED)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// collect matching assocs from the DB into a slice, looking up an exact
+	// label in the kind index
+	candidates := AppAssocs(g.db, aid)
+	if params.label != "" && !isPattern(params.label) {
+		candidates = AssocsOfLabel(g.db, aid, params.label)
+	}
+	assocs := []Assoc{}
+	items := []listItem{}
+	for _, a := range candidates {
+		a.ID = TrimAppPrefix(aid, a.ID)
+		if !match(params.label, a.Label) || !params.matchAttrs(anyAttrs(a.Attributes)) {
+			continue
+		}
+		assocs = append(assocs, a)
+		items = append(items, listItem{id: a.ID, name: a.Name, created: a.Created})
+	}
+
 	assocList := AssocList{
 		Assocs: []Assoc{},
+		Total:  len(assocs),
 	}
-	for _, assoc := range g.db.List(DB_TABLE_ASSOCS) {
-		if a, ok := assoc.(Assoc); ok {
-			if strings.HasPrefix(a.ID, aid) {
-				parts := strings.Split(a.ID, "/")
-				a.ID = parts[len(parts)-1]
-				assocList.Assocs = append(assocList.Assocs, a)
-			}
-		}
+	page, next := params.page(items)
+	for _, i := range page {
+		assocList.Assocs = append(assocList.Assocs, assocs[i])
 	}
+	assocList.NextCursor = next
 
 	// return slice as JSON
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assocList)
 }
 
-// GetAllAttackGraphs is GET Handler to retrieve a list of all attack graphs
+// GetAllAttackGraphs is GET Handler to retrieve a page of attack graphs,
+// sorted and filtered by list parameters
 func (g *Graph) GetAllAttackGraphs(w http.ResponseWriter, r *http.Request) {
-	// collect all assocs from the DB into a slice (array)
-	appList := AppsList{
-		Apps: []AppData{},
-	}
-	for _, app := range g.db.List(DB_TABLE_GRAPH) {
-		if a, ok := app.(AppData); ok {
-			if a.Type == "attackGraph" {
-				appList.Apps = append(appList.Apps, a)
-			}
-		}
-	}
-
-	// return slice as JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(appList)
+	g.listApps(w, r, func(a AppData) bool { return a.Type == "attackGraph" }, g.attackGraphRisk)
 }
@@ -0,0 +1,89 @@
+package graph
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+func TestDeleteAppCascade(t *testing.T) {
+	const table = "notes"
+	RegisterAppTables(table)
+	d := db.NewMemoryDb(DB_TABLE_GRAPH, DB_TABLE_ENTITIES, DB_TABLE_ASSOCS, DB_TABLE_SNAPSHOTS, table)
+	if err := CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(d)
+
+	serve(r, "POST", "/v1/app", `{"id":"a1","name":"app"}`)
+	serve(r, "POST", "/v1/app", `{"id":"a10","name":"other app"}`)
+	serve(r, "POST", "/v1/app", `{"id":"ag1","type":"attackGraph","attributes":{"SourceApp":"a1"}}`)
+	serve(r, "POST", "/v1/app/a1/entity", `{"entities":[{"id":"e1","kind":"vm"}]}`)
+	serve(r, "POST", "/v1/app/ag1/entity", `{"entities":[{"id":"e1","kind":"finding"}]}`)
+	for _, key := range []string{"a1", "a1/x", "ag1/x", "a10", "a10/x"} {
+		d.Add(table, key, key)
+	}
+
+	if w := serve(r, "DELETE", "/v1/app/a1", ``); w.Code != http.StatusOK {
+		t.Fatalf("expecting app delete, got %v %v", w.Code, w.Body)
+	}
+	tests := []struct {
+		table string
+		keys  []string
+	}{
+		{DB_TABLE_GRAPH, []string{"a10"}},
+		{DB_TABLE_ENTITIES, []string{}},
+		{DB_TABLE_SNAPSHOTS, []string{}},
+		{table, []string{"a10", "a10/x"}},
+	}
+	for _, tt := range tests {
+		keys := []string{}
+		for _, row := range d.Scan(tt.table, "") {
+			keys = append(keys, row.Key)
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%v: expecting keys %v, got %v", tt.table, tt.keys, keys)
+		}
+	}
+}
+
+func TestAppIds(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"created", "POST", "/v1/app", `{"id":"a1","name":"app"}`, http.StatusCreated},
+		{"created with /", "POST", "/v1/app", `{"id":"a/b","name":"app"}`, http.StatusBadRequest},
+		{"created without id", "POST", "/v1/app", `{"name":"app"}`, http.StatusBadRequest},
+		{"updated", "PUT", "/v1/app/a1", `{"name":"renamed"}`, http.StatusOK},
+	}
+	d := newTestDb(t)
+	r := newTestRouter(d)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(r, tt.method, tt.path, tt.body); w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+			}
+		})
+	}
+
+	// routes do not match a / in app ids, updates reject them still
+	req := mux.SetURLVars(httptest.NewRequest("PUT", "/v1/app/a%2Fb", strings.NewReader(`{"name":"app"}`)), map[string]string{"id": "a/b"})
+	w := httptest.NewRecorder()
+	NewGraph(d).UpdateAppData(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expecting 400, got %v %v", w.Code, w.Body)
+	}
+	if _, err := d.Get(DB_TABLE_GRAPH, "a/b"); err == nil {
+		t.Fatalf("expecting no app a/b")
+	}
+}
@@ -0,0 +1,198 @@
+package graph
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+const (
+	// node types of flattened hypergraphs
+	NODE_ENTITY    = "entity"
+	NODE_HYPEREDGE = "hyperedge"
+
+	// edge roles of flattened hypergraphs
+	ROLE_FROM     = "from"
+	ROLE_TO       = "to"
+	ROLE_OTHER    = "other"
+	ROLE_CONTAINS = "contains"
+
+	// id prefix of intermediate hyperedge nodes
+	HYPEREDGE_PREFIX = "hyperedge:"
+
+	// prefix of attribute columns clashing with node properties
+	ATTRIBUTE_COLUMN_PREFIX = "attr_"
+)
+
+// node property names of flattened hypergraphs, not usable as attribute columns
+var nodeProperties = map[string]bool{"id": true, "app": true, "label": true, "name": true, "kind": true, "type": true, "role": true, "description": true}
+
+// AttributeColumn returns the column name of an attribute in flattened
+// hypergraphs, prefixed when clashing with node properties
+func AttributeColumn(attr string) string {
+	if nodeProperties[strings.ToLower(attr)] {
+		return ATTRIBUTE_COLUMN_PREFIX + attr
+	}
+	return attr
+}
+
+// columnAttribute reverses AttributeColumn
+func columnAttribute(column string) string {
+	if attr := strings.TrimPrefix(column, ATTRIBUTE_COLUMN_PREFIX); attr != column && nodeProperties[strings.ToLower(attr)] {
+		return attr
+	}
+	return column
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlDoc struct {
+	Keys []struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+	} `xml:"key"`
+	Graph struct {
+		ID    string `xml:"id,attr"`
+		Nodes []struct {
+			ID   string        `xml:"id,attr"`
+			Data []graphmlData `xml:"data"`
+		} `xml:"node"`
+		Edges []struct {
+			ID     string        `xml:"id,attr"`
+			Source string        `xml:"source,attr"`
+			Target string        `xml:"target,attr"`
+			Data   []graphmlData `xml:"data"`
+		} `xml:"edge"`
+	} `xml:"graph"`
+}
+
+// nodeName returns the name of a node, empty when labeled by its id as
+// exports label unnamed nodes
+func nodeName(id, label string) string {
+	if label == strings.TrimPrefix(id, HYPEREDGE_PREFIX) {
+		return ""
+	}
+	return label
+}
+
+// ParseGraphML parses a GraphML document into an app import. Nodes typed as
+// hyperedges become assocs of their from/to/other members, other edges
+// become binary assocs and contains edges nest entities.
+func ParseGraphML(data []byte) (AppImport, error) {
+	var doc graphmlDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return AppImport{}, fmt.Errorf("invalid GraphML: %v", err)
+	}
+	names := map[string]string{}
+	for _, k := range doc.Keys {
+		names[k.ID] = k.Name
+		if k.Name == "" {
+			names[k.ID] = k.ID
+		}
+	}
+	values := func(data []graphmlData) map[string]string {
+		ret := map[string]string{}
+		for _, d := range data {
+			name, ok := names[d.Key]
+			if !ok {
+				name = d.Key
+			}
+			ret[name] = d.Value
+		}
+		return ret
+	}
+
+	imp := AppImport{App: AppData{ID: doc.Graph.ID, Name: doc.Graph.ID}}
+	entities := map[string]*Entity{}
+	assocs := map[string]*Assoc{}
+	order := []string{}
+	for _, n := range doc.Graph.Nodes {
+		props := values(n.Data)
+		if props["type"] == NODE_HYPEREDGE {
+			a := &Assoc{
+				ID:          strings.TrimPrefix(n.ID, HYPEREDGE_PREFIX),
+				Name:        nodeName(n.ID, props["label"]),
+				Description: props["description"],
+				Label:       props["kind"],
+				Attributes:  map[string]interface{}{},
+			}
+			for k, v := range props {
+				if !nodeProperties[strings.ToLower(k)] {
+					a.Attributes[columnAttribute(k)] = v
+				}
+			}
+			assocs[n.ID] = a
+			continue
+		}
+		e := &Entity{
+			ID:          n.ID,
+			Name:        nodeName(n.ID, props["label"]),
+			Description: props["description"],
+			Kind:        props["kind"],
+			Attributes:  map[string]string{},
+		}
+		if e.Name == "" {
+			e.Name = props["name"]
+		}
+		for k, v := range props {
+			if !nodeProperties[strings.ToLower(k)] {
+				e.Attributes[columnAttribute(k)] = v
+			}
+		}
+		entities[n.ID] = e
+		order = append(order, n.ID)
+	}
+
+	nested := map[string]bool{}
+	children := map[string][]string{}
+	for i, ed := range doc.Graph.Edges {
+		role := values(ed.Data)["role"]
+		switch {
+		case assocs[ed.Target] != nil && (role == ROLE_FROM || role == ""):
+			assocs[ed.Target].FromEntities = append(assocs[ed.Target].FromEntities, ed.Source)
+		case assocs[ed.Source] != nil && role == ROLE_OTHER:
+			assocs[ed.Source].OtherEntities = append(assocs[ed.Source].OtherEntities, ed.Target)
+		case assocs[ed.Source] != nil:
+			assocs[ed.Source].ToEntities = append(assocs[ed.Source].ToEntities, ed.Target)
+		case role == ROLE_CONTAINS && entities[ed.Source] != nil && entities[ed.Target] != nil:
+			children[ed.Source] = append(children[ed.Source], ed.Target)
+			nested[ed.Target] = true
+		default:
+			id := ed.ID
+			if id == "" {
+				id = fmt.Sprintf("e%d", i)
+			}
+			imp.Assocs = append(imp.Assocs, Assoc{
+				ID:           id,
+				Label:        role,
+				FromEntities: []string{ed.Source},
+				ToEntities:   []string{ed.Target},
+			})
+		}
+	}
+
+	// nest contained entities, innermost first
+	var nest func(eid string) Entity
+	nest = func(eid string) Entity {
+		e := *entities[eid]
+		for _, child := range children[eid] {
+			e.Entities = append(e.Entities, nest(child))
+		}
+		return e
+	}
+	for _, eid := range order {
+		if !nested[eid] {
+			imp.Entities = append(imp.Entities, nest(eid))
+		}
+	}
+	for _, n := range doc.Graph.Nodes {
+		if a, ok := assocs[n.ID]; ok {
+			imp.Assocs = append(imp.Assocs, *a)
+		}
+	}
+	return imp, nil
+}
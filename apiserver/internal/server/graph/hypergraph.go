@@ -0,0 +1,126 @@
+package graph
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Hypergraph is an in-memory view of a single app's entities and hyperedges
+type Hypergraph struct {
+	AppID    string
+	Entities map[string]Entity // entity id (without app prefix) -> entity
+	Assocs   map[string]Assoc  // assoc id (without app prefix) -> assoc
+
+	// entity id -> hops leaving that entity
+	hops map[string][]Hop
+}
+
+// Hop is a single traversal step from an entity to another entity, either
+// through a hyperedge or through entity containment (Assoc is empty)
+type Hop struct {
+	Assoc string `json:"assoc"`
+	To    string `json:"to"`
+}
+
+// GetAppPrefix returns the key prefix of all entities and assocs of an app
+func GetAppPrefix(aid string) string {
+	return aid + "/"
+}
+
+// TrimAppPrefix strips the app prefix from an entity or assoc key
+func TrimAppPrefix(aid, key string) string {
+	return strings.TrimPrefix(key, GetAppPrefix(aid))
+}
+
+// LoadHypergraph loads all entities and assocs of a given app from DB
+func LoadHypergraph(d db.Db, aid string) *Hypergraph {
+	h := &Hypergraph{
+		AppID:    aid,
+		Entities: make(map[string]Entity),
+		Assocs:   make(map[string]Assoc),
+		hops:     make(map[string][]Hop),
+	}
+
+	for _, e := range AppEntities(d, aid) {
+		e.ID = TrimAppPrefix(aid, e.ID)
+		h.Entities[e.ID] = e
+
+		// nested entities are reachable from their parent
+		for _, child := range e.Entities {
+			if _, ok := h.Entities[child.ID]; !ok {
+				h.Entities[child.ID] = child
+			}
+			h.hops[e.ID] = append(h.hops[e.ID], Hop{To: child.ID})
+		}
+	}
+
+	for _, a := range AppAssocs(d, aid) {
+		a.ID = TrimAppPrefix(aid, a.ID)
+		h.Assocs[a.ID] = a
+
+		// from entities reach both to and other members of the hyperedge
+		for _, from := range a.FromEntities {
+			from = TrimAppPrefix(aid, from)
+			for _, to := range append(append([]string{}, a.ToEntities...), a.OtherEntities...) {
+				to = TrimAppPrefix(aid, to)
+				if to != from {
+					h.hops[from] = append(h.hops[from], Hop{Assoc: a.ID, To: to})
+				}
+			}
+		}
+	}
+
+	// stable traversal order regardless of DB iteration order
+	for eid := range h.hops {
+		hops := h.hops[eid]
+		sort.Slice(hops, func(i, j int) bool {
+			if hops[i].To != hops[j].To {
+				return hops[i].To < hops[j].To
+			}
+			return hops[i].Assoc < hops[j].Assoc
+		})
+	}
+	return h
+}
+
+// Next returns hops leaving a given entity
+func (h *Hypergraph) Next(eid string) []Hop {
+	return h.hops[eid]
+}
+
+// Members returns all entity ids of a hyperedge
+func (a Assoc) Members() []string {
+	members := make([]string, 0, len(a.FromEntities)+len(a.ToEntities)+len(a.OtherEntities))
+	members = append(members, a.FromEntities...)
+	members = append(members, a.ToEntities...)
+	members = append(members, a.OtherEntities...)
+	return members
+}
+
+// Permissions returns permissions granted by a hyperedge, read from the
+// "Permissions" attribute and falling back to the hyperedge label
+func (a Assoc) Permissions() []string {
+	perms := []string{}
+	switch p := a.Attributes["Permissions"].(type) {
+	case string:
+		for _, s := range strings.Split(p, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				perms = append(perms, s)
+			}
+		}
+	case []interface{}:
+		for _, s := range p {
+			if str, ok := s.(string); ok {
+				perms = append(perms, str)
+			}
+		}
+	case []string:
+		perms = append(perms, p...)
+	}
+	if len(perms) == 0 && a.Label != "" {
+		perms = append(perms, a.Label)
+	}
+	return perms
+}
@@ -0,0 +1,310 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+const (
+	// bulk import formats
+	IMPORT_FORMAT_JSON    = "json"
+	IMPORT_FORMAT_GRAPHML = "graphml"
+
+	// app type of attack graphs, which are generated and never imported
+	APP_TYPE_ATTACK_GRAPH = "attackGraph"
+)
+
+// ParseImport parses a JSON or GraphML app document, detecting the format
+// when empty. GraphML documents take the app id from the graph id unless aid
+// is given.
+func ParseImport(format, aid string, data []byte) (AppImport, string, error) {
+	if format == "" {
+		format = IMPORT_FORMAT_JSON
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+			format = IMPORT_FORMAT_GRAPHML
+		}
+	}
+
+	var (
+		imp AppImport
+		err error
+	)
+	switch strings.ToLower(format) {
+	case IMPORT_FORMAT_JSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(&imp); err != nil {
+			err = fmt.Errorf("invalid JSON: %v", err)
+		}
+	case IMPORT_FORMAT_GRAPHML:
+		imp, err = ParseGraphML(data)
+	default:
+		err = fmt.Errorf("unsupported import format %v", format)
+	}
+	if err != nil {
+		return AppImport{}, format, err
+	}
+	if aid != "" {
+		imp.App.ID = aid
+	}
+	return imp, strings.ToLower(format), nil
+}
+
+// Validate returns errors of an app import, empty if valid
+func (imp AppImport) Validate() []string {
+	errs := []string{}
+	if err := validAppId(imp.App.ID); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if imp.App.Type == APP_TYPE_ATTACK_GRAPH {
+		errs = append(errs, "attack graphs cannot be imported")
+	}
+
+	// all entities, including nested ones, share the id space of the app
+	ids := map[string]bool{}
+	var check func(path string, e Entity)
+	check = func(path string, e Entity) {
+		switch {
+		case e.ID == "":
+			errs = append(errs, fmt.Sprintf("%v: entity id is required", path))
+		case strings.Contains(e.ID, "/"):
+			errs = append(errs, fmt.Sprintf("%v: entity id %v must not contain /", path, e.ID))
+		case ids[e.ID]:
+			errs = append(errs, fmt.Sprintf("%v: duplicate entity id %v", path, e.ID))
+		}
+		ids[e.ID] = true
+		for i, child := range e.Entities {
+			check(fmt.Sprintf("%v.entities[%d]", path, i), child)
+		}
+	}
+	for i, e := range imp.Entities {
+		check(fmt.Sprintf("entities[%d]", i), e)
+	}
+
+	assocs := map[string]bool{}
+	for i, a := range imp.Assocs {
+		path := fmt.Sprintf("assocs[%d]", i)
+		switch {
+		case a.ID == "":
+			errs = append(errs, fmt.Sprintf("%v: assoc id is required", path))
+		case strings.Contains(a.ID, "/"):
+			errs = append(errs, fmt.Sprintf("%v: assoc id %v must not contain /", path, a.ID))
+		case assocs[a.ID]:
+			errs = append(errs, fmt.Sprintf("%v: duplicate assoc id %v", path, a.ID))
+		}
+		assocs[a.ID] = true
+		if len(a.Members()) == 0 {
+			errs = append(errs, fmt.Sprintf("%v: assoc %v has no members", path, a.ID))
+		}
+		for _, m := range a.Members() {
+			if !ids[m] {
+				errs = append(errs, fmt.Sprintf("%v: assoc %v member %v is not an entity", path, a.ID, m))
+			}
+		}
+	}
+	return errs
+}
+
+// newImportChanges returns empty import changes
+func newImportChanges() ImportChanges {
+	return ImportChanges{Created: []string{}, Updated: []string{}, Removed: []string{}}
+}
+
+// normalizeEntity sets empty attributes of an entity and its nested entities,
+// so that unchanged entities compare equal across imports
+func normalizeEntity(e Entity) Entity {
+	if e.Attributes == nil {
+		e.Attributes = map[string]string{}
+	}
+	for i, child := range e.Entities {
+		e.Entities[i] = normalizeEntity(child)
+	}
+	return e
+}
+
+// diffTable computes created, updated and removed keys of an app table,
+// records failing to decode being updated
+func diffTable[T any](repo *db.Repository[T], aid string, rows map[string]T) ImportChanges {
+	changes := newImportChanges()
+	for key, row := range rows {
+		old, err := repo.Get(key)
+		switch {
+		case errors.Is(err, db.ErrRecord):
+			changes.Updated = append(changes.Updated, TrimAppPrefix(aid, key))
+		case err != nil:
+			changes.Created = append(changes.Created, TrimAppPrefix(aid, key))
+		case !reflect.DeepEqual(old, row):
+			changes.Updated = append(changes.Updated, TrimAppPrefix(aid, key))
+		}
+	}
+	for _, key := range repo.Keys(GetAppPrefix(aid)) {
+		if _, ok := rows[key]; !ok {
+			changes.Removed = append(changes.Removed, TrimAppPrefix(aid, key))
+		}
+	}
+	sort.Strings(changes.Created)
+	sort.Strings(changes.Updated)
+	sort.Strings(changes.Removed)
+	return changes
+}
+
+// importedApp returns the app of an import, keeping evaluation history and
+// creation time of an existing app, and whether the app is new
+func importedApp(d db.Db, imp AppImport) (AppData, bool, error) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	app := imp.App
+	created := false
+	old, err := Apps(d).Get(app.ID)
+	switch {
+	case errors.Is(err, db.ErrRecord):
+		// an existing app failing to decode is not replaced, keeping its history
+		return app, false, err
+	case err != nil:
+		created = true
+		if app.Created == "" {
+			app.Created = now
+		}
+	default:
+		// evaluation history is kept across imports
+		app.Stats = old.Stats
+		if app.Created == "" {
+			app.Created = old.Created
+		}
+	}
+	app.LastModified = now
+	return app, created, nil
+}
+
+// Import validates an app import and, unless a dry run, atomically replaces
+// the app, its entities and its assocs. Invalid imports are reported, while
+// reads and writes failing, such as a transaction conflicting on every
+// retry, are returned as errors.
+func (g *Graph) Import(imp AppImport, dryRun bool) (ImportReport, error) {
+	aid := imp.App.ID
+	report := ImportReport{
+		App:      aid,
+		DryRun:   dryRun,
+		Errors:   imp.Validate(),
+		Entities: newImportChanges(),
+		Assocs:   newImportChanges(),
+	}
+	report.Valid = len(report.Errors) == 0
+	if !report.Valid {
+		return report, nil
+	}
+
+	entities := map[string]Entity{}
+	for _, e := range imp.Entities {
+		e = normalizeEntity(e)
+		e.ID = GetEntityKey(aid, e.ID)
+		entities[e.ID] = e
+	}
+	assocs := map[string]Assoc{}
+	for _, a := range imp.Assocs {
+		if a.Attributes == nil {
+			a.Attributes = map[string]interface{}{}
+		}
+		a.ID = GetEntityKey(aid, a.ID)
+		assocs[a.ID] = a
+	}
+	if dryRun {
+		var err error
+		if _, report.AppCreated, err = importedApp(g.db, imp); err != nil {
+			return report, err
+		}
+		report.Entities = diffTable(Entities(g.db), aid, entities)
+		report.Assocs = diffTable(Assocs(g.db), aid, assocs)
+		return report, nil
+	}
+
+	// stage all writes along with the new snapshot version, then commit them
+	// at once, diffing against the snapshot read by the transaction
+	err := db.RunTx(g.db, func(tx db.Tx) error {
+		app, created, err := importedApp(tx, imp)
+		if err != nil {
+			return err
+		}
+		report.AppCreated = created
+		report.Entities = diffTable(Entities(tx), aid, entities)
+		report.Assocs = diffTable(Assocs(tx), aid, assocs)
+		if err := Apps(tx).Put(aid, app); err != nil {
+			return err
+		}
+		for key, e := range entities {
+			if err := Entities(tx).Put(key, e); err != nil {
+				return err
+			}
+		}
+		for key, a := range assocs {
+			if err := Assocs(tx).Put(key, a); err != nil {
+				return err
+			}
+		}
+		for _, eid := range report.Entities.Removed {
+			if err := tx.Del(DB_TABLE_ENTITIES, GetEntityKey(aid, eid)); err != nil {
+				return err
+			}
+		}
+		for _, sid := range report.Assocs.Removed {
+			if err := tx.Del(DB_TABLE_ASSOCS, GetEntityKey(aid, sid)); err != nil {
+				return err
+			}
+		}
+		report.Version, err = recordSnapshot(tx, aid, "")
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+	log.Printf("new app %v imported, %v entities, %v assocs\n", aid, len(entities), len(assocs))
+	return report, nil
+}
+
+// ImportApp is POST handler to import a whole app from one JSON or GraphML
+// document
+func (g *Graph) ImportApp(w http.ResponseWriter, r *http.Request) {
+	var (
+		query  = r.URL.Query()
+		dryRun = query.Get("dryRun") == "true"
+	)
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	imp, format, err := ParseImport(query.Get("format"), query.Get("app"), data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := g.Import(imp, dryRun)
+	if err != nil {
+		log.Printf("unable to import app %v: %v\n", imp.App.ID, err)
+		txError(w, err)
+		return
+	}
+	report.Format = format
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case !report.Valid:
+		w.WriteHeader(http.StatusBadRequest)
+	case !dryRun:
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,293 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+func TestParseImport(t *testing.T) {
+	const (
+		doc     = `{"app":{"id":"a1"},"entities":[{"id":"e1"}]}`
+		graphml = `<graphml><graph id="a1"><node id="e1"/></graph></graphml>`
+	)
+	tests := []struct {
+		name   string
+		format string
+		aid    string
+		data   string
+		want   string
+		app    string
+		err    bool
+	}{
+		{"json", "", "", doc, IMPORT_FORMAT_JSON, "a1", false},
+		{"graphml detected", "", "", "\n  " + graphml, IMPORT_FORMAT_GRAPHML, "a1", false},
+		{"format case", "GraphML", "", graphml, IMPORT_FORMAT_GRAPHML, "a1", false},
+		{"app id given", "", "a2", graphml, IMPORT_FORMAT_GRAPHML, "a2", false},
+		{"unknown field", "", "", `{"app":{"id":"a1"},"nodes":[]}`, "", "", true},
+		{"malformed json", "", "", `{"app":`, "", "", true},
+		{"malformed graphml", "", "", `<graphml><graph>`, "", "", true},
+		{"json as graphml", IMPORT_FORMAT_GRAPHML, "", doc, "", "", true},
+		{"unsupported format", "csv", "", doc, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imp, format, err := ParseImport(tt.format, tt.aid, []byte(tt.data))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expecting error, got %+v", imp)
+				}
+				return
+			}
+			if err != nil || format != tt.want || imp.App.ID != tt.app || len(imp.Entities) != 1 {
+				t.Fatalf("expecting %v app %v, got %v app %+v, %v", tt.want, tt.app, format, imp, err)
+			}
+		})
+	}
+}
+
+func TestParseGraphML(t *testing.T) {
+	// nodes and edges as exported, with a hyperedge node, a contains edge,
+	// a plain edge and an attribute clashing with node properties
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<graphml>
+  <key id="d0" for="node" attr.name="label"/>
+  <key id="d1" for="node" attr.name="kind"/>
+  <key id="d2" for="node" attr.name="type"/>
+  <key id="d3" for="node" attr.name="attr_name"/>
+  <key id="d4" for="node" attr.name="region"/>
+  <key id="d5" for="edge" attr.name="role"/>
+  <graph id="a1" edgedefault="directed">
+    <node id="vpc"><data key="d0">vpc</data><data key="d1">ec2:vpc</data><data key="d2">entity</data></node>
+    <node id="web"><data key="d0">Web</data><data key="d1">ec2:instance</data><data key="d2">entity</data><data key="d3">web-1</data><data key="d4">us-east-1</data></node>
+    <node id="db"><data key="d0">db</data><data key="d1">rds:instance</data><data key="d2">entity</data></node>
+    <node id="hyperedge:s1"><data key="d0">s1</data><data key="d1">connects</data><data key="d2">hyperedge</data><data key="d4">us-east-1</data></node>
+    <edge source="web" target="hyperedge:s1"><data key="d5">from</data></edge>
+    <edge source="hyperedge:s1" target="db"><data key="d5">to</data></edge>
+    <edge source="hyperedge:s1" target="vpc"><data key="d5">other</data></edge>
+    <edge source="vpc" target="web"><data key="d5">contains</data></edge>
+    <edge id="p1" source="db" target="web"><data key="d5">replicates</data></edge>
+  </graph>
+</graphml>`
+	imp, err := ParseGraphML([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AppImport{
+		App: AppData{ID: "a1", Name: "a1"},
+		Entities: []Entity{
+			{ID: "vpc", Kind: "ec2:vpc", Attributes: map[string]string{}, Entities: []Entity{
+				{ID: "web", Name: "Web", Kind: "ec2:instance", Attributes: map[string]string{"name": "web-1", "region": "us-east-1"}},
+			}},
+			{ID: "db", Kind: "rds:instance", Attributes: map[string]string{}},
+		},
+		Assocs: []Assoc{
+			{ID: "p1", Label: "replicates", FromEntities: []string{"db"}, ToEntities: []string{"web"}},
+			{ID: "s1", Label: "connects", FromEntities: []string{"web"}, ToEntities: []string{"db"}, OtherEntities: []string{"vpc"},
+				Attributes: map[string]interface{}{"region": "us-east-1"}},
+		},
+	}
+	if !reflect.DeepEqual(imp, want) {
+		t.Fatalf("expecting %+v, got %+v", want, imp)
+	}
+	if errs := imp.Validate(); len(errs) != 0 {
+		t.Fatalf("expecting valid import, got %v", errs)
+	}
+}
+
+func TestImportValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		errs int
+	}{
+		{"valid", `{"app":{"id":"a1"},"entities":[{"id":"e1","entities":[{"id":"e2"}]}],"assocs":[{"id":"s1","fromentities":["e1"],"toentities":["e2"]}]}`, 0},
+		{"no app id", `{"app":{}}`, 1},
+		{"attack graph", `{"app":{"id":"a1","type":"attackGraph"}}`, 1},
+		{"entity ids", `{"app":{"id":"a1"},"entities":[{"id":""},{"id":"e/1"},{"id":"e1","entities":[{"id":"e1"}]}]}`, 3},
+		{"assoc ids", `{"app":{"id":"a1"},"entities":[{"id":"e1"}],"assocs":[{"id":"","toentities":["e1"]},{"id":"s/1","toentities":["e1"]},{"id":"s1","toentities":["e1"]},{"id":"s1","toentities":["e1"]}]}`, 3},
+		{"assoc members", `{"app":{"id":"a1"},"entities":[{"id":"e1"}],"assocs":[{"id":"s1"},{"id":"s2","fromentities":["e1"],"toentities":["e2"]}]}`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var imp AppImport
+			if err := json.Unmarshal([]byte(tt.doc), &imp); err != nil {
+				t.Fatal(err)
+			}
+			if errs := imp.Validate(); len(errs) != tt.errs {
+				t.Fatalf("expecting %v errors, got %v", tt.errs, errs)
+			}
+		})
+	}
+}
+
+// importReport imports a document, returning the status and the report, if
+// any
+func importReport(t *testing.T, r http.Handler, query, doc string) (int, ImportReport) {
+	t.Helper()
+	w := serve(r, "POST", "/v1/import"+query, doc)
+	var report ImportReport
+	if w.Header().Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("expecting import report, got %v: %v", w.Body.String(), err)
+		}
+	}
+	return w.Code, report
+}
+
+func TestImport(t *testing.T) {
+	d := newTestDb(t)
+	r := newTestRouter(d)
+
+	doc := `{"app":{"id":"a1","name":"App"},
+		"entities":[{"id":"e1","kind":"vm"},{"id":"e2","kind":"db"},{"id":"e3","kind":"s3"}],
+		"assocs":[{"id":"s1","fromentities":["e1"],"toentities":["e2"]},{"id":"s2","fromentities":["e1"],"toentities":["e3"]}]}`
+	code, report := importReport(t, r, "", doc)
+	if code != http.StatusCreated || !report.AppCreated || report.Version != 1 || len(report.Entities.Created) != 3 || len(report.Assocs.Created) != 2 {
+		t.Fatalf("expecting app created at version 1, got %v %+v", code, report)
+	}
+
+	// evaluation history of the app is kept across imports
+	app, _ := Apps(d).Get("a1")
+	app.Stats.NumRuns = 2
+	Apps(d).Put("a1", app)
+
+	// e1 changes, e2 is unchanged, e3 and s2 are removed and e4 is created
+	next := `{"app":{"id":"a1","name":"App"},
+		"entities":[{"id":"e1","kind":"vm","attributes":{"os":"linux"}},{"id":"e2","kind":"db"},{"id":"e4","kind":"lambda"}],
+		"assocs":[{"id":"s1","fromentities":["e1"],"toentities":["e2"]}]}`
+	code, report = importReport(t, r, "?dryRun=true", next)
+	wantEntities := ImportChanges{Created: []string{"e4"}, Updated: []string{"e1"}, Removed: []string{"e3"}}
+	wantAssocs := ImportChanges{Created: []string{}, Updated: []string{}, Removed: []string{"s2"}}
+	if code != http.StatusOK || !report.DryRun || report.AppCreated || report.Version != 0 ||
+		!reflect.DeepEqual(report.Entities, wantEntities) || !reflect.DeepEqual(report.Assocs, wantAssocs) {
+		t.Fatalf("expecting dry run changes %+v %+v, got %v %+v", wantEntities, wantAssocs, code, report)
+	}
+	if keys := Entities(d).Keys(GetAppPrefix("a1")); len(keys) != 3 || keys[2] != GetEntityKey("a1", "e3") {
+		t.Fatalf("expecting dry run to write nothing, got %v", keys)
+	}
+	if got := versions(d, "a1"); len(got) != 1 {
+		t.Fatalf("expecting no new version of dry run, got %v", got)
+	}
+
+	// the app is then replaced as reported
+	code, report = importReport(t, r, "", next)
+	if code != http.StatusCreated || report.Version != 2 ||
+		!reflect.DeepEqual(report.Entities, wantEntities) || !reflect.DeepEqual(report.Assocs, wantAssocs) {
+		t.Fatalf("expecting changes %+v %+v at version 2, got %v %+v", wantEntities, wantAssocs, code, report)
+	}
+	want := []string{GetEntityKey("a1", "e1"), GetEntityKey("a1", "e2"), GetEntityKey("a1", "e4")}
+	if keys := Entities(d).Keys(GetAppPrefix("a1")); !reflect.DeepEqual(keys, want) {
+		t.Fatalf("expecting entities %v, got %v", want, keys)
+	}
+	if keys := Assocs(d).Keys(GetAppPrefix("a1")); len(keys) != 1 || keys[0] != GetEntityKey("a1", "s1") {
+		t.Fatalf("expecting assoc s1 only, got %v", keys)
+	}
+	if app, err := Apps(d).Get("a1"); err != nil || app.Stats.NumRuns != 2 {
+		t.Fatalf("expecting stats kept, got %+v %v", app, err)
+	}
+	snap, err := GetSnapshot(d, "a1", 2)
+	if err != nil || len(snap.Entities) != 3 || len(snap.Assocs) != 1 {
+		t.Fatalf("expecting snapshot of 3 entities and 1 assoc, got %+v %v", snap, err)
+	}
+
+	// invalid imports are reported without writing
+	code, report = importReport(t, r, "", `{"app":{"id":"a1"},"assocs":[{"id":"s1","toentities":["e9"]}]}`)
+	if code != http.StatusBadRequest || report.Valid || len(report.Errors) != 1 {
+		t.Fatalf("expecting invalid import report, got %v %+v", code, report)
+	}
+	if code, _ := importReport(t, r, "", `{"app":`); code != http.StatusBadRequest {
+		t.Fatalf("expecting malformed import to fail, got %v", code)
+	}
+	if got := versions(d, "a1"); len(got) != 2 {
+		t.Fatalf("expecting no new version of invalid imports, got %v", got)
+	}
+}
+
+// failingDb begins transactions failing writes of a table, or conflicting
+// on every commit
+type failingDb struct {
+	*db.MemoryDb
+	table    string
+	conflict bool
+}
+
+type failingTx struct {
+	db.Tx
+	d *failingDb
+}
+
+func (d *failingDb) Begin() (db.Tx, error) {
+	tx, err := d.MemoryDb.Begin()
+	return &failingTx{tx, d}, err
+}
+
+func (tx *failingTx) Add(table, key string, value interface{}) error {
+	if table == tx.d.table {
+		return errors.New("write failed")
+	}
+	return tx.Tx.Add(table, key, value)
+}
+
+func (tx *failingTx) Del(table, key string) error {
+	if table == tx.d.table {
+		return errors.New("delete failed")
+	}
+	return tx.Tx.Del(table, key)
+}
+
+func (tx *failingTx) Commit() error {
+	if tx.d.conflict {
+		tx.Rollback()
+		return db.ErrConflict
+	}
+	return tx.Tx.Commit()
+}
+
+func TestImportFailures(t *testing.T) {
+	doc := `{"app":{"id":"a1"},"entities":[{"id":"e1"},{"id":"e2"}],"assocs":[{"id":"s1","fromentities":["e1"],"toentities":["e2"]}]}`
+	tests := []struct {
+		name     string
+		table    string
+		conflict bool
+		code     int
+	}{
+		{"app write", DB_TABLE_GRAPH, false, http.StatusInternalServerError},
+		{"entity write", DB_TABLE_ENTITIES, false, http.StatusInternalServerError},
+		{"assoc delete", DB_TABLE_ASSOCS, false, http.StatusInternalServerError},
+		{"conflicts", "", true, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &failingDb{newTestDb(t), tt.table, tt.conflict}
+
+			// an assoc of an earlier import is removed by this one
+			Assocs(d.MemoryDb).Put(GetEntityKey("a1", "s0"), Assoc{ID: "s0"})
+			if code, _ := importReport(t, newTestRouter(d), "", doc); code != tt.code {
+				t.Fatalf("expecting %v, got %v", tt.code, code)
+			}
+			if _, err := Apps(d).Get("a1"); err == nil {
+				t.Fatalf("expecting nothing written")
+			}
+			if keys := Entities(d).Keys(GetAppPrefix("a1")); len(keys) != 0 {
+				t.Fatalf("expecting no entities written, got %v", keys)
+			}
+			if got := versions(d, "a1"); len(got) != 0 {
+				t.Fatalf("expecting no version, got %v", got)
+			}
+		})
+	}
+
+	// apps failing to decode are not replaced
+	d := newTestDb(t)
+	d.Add(DB_TABLE_GRAPH, "a1", "not a record")
+	for _, query := range []string{"", "?dryRun=true"} {
+		if code, _ := importReport(t, newTestRouter(d), query, doc); code != http.StatusInternalServerError {
+			t.Fatalf("expecting %v to fail decoding the app, got %v", query, code)
+		}
+	}
+}
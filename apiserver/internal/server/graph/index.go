@@ -0,0 +1,165 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+const (
+	// secondary indexes of entities and assocs, values scoped by app
+	INDEX_APP       = "app"       // app id
+	INDEX_KIND      = "kind"      // app id/entity kind or assoc label
+	INDEX_ATTRIBUTE = "attribute" // app id/attribute key=value
+	INDEX_MEMBER    = "member"    // app id/member entity id, of assocs
+)
+
+// appOf returns app id of an entity or assoc key, app ids having no /
+func appOf(key string) string {
+	aid, _, _ := strings.Cut(key, "/")
+	return aid
+}
+
+// KindIndexValue returns the kind index value of an entity kind or assoc label
+func KindIndexValue(aid, kind string) string {
+	return GetAppPrefix(aid) + kind
+}
+
+// AttributeIndexValue returns the attribute index value of an attribute
+func AttributeIndexValue(aid, key, value string) string {
+	return GetAppPrefix(aid) + key + "=" + value
+}
+
+// MemberIndexValue returns the member index value of a hyperedge member
+func MemberIndexValue(aid, eid string) string {
+	return GetEntityKey(aid, TrimAppPrefix(aid, eid))
+}
+
+func indexApp(key string, value interface{}) []string {
+	return []string{appOf(key)}
+}
+
+func indexKind(key string, value interface{}) []string {
+	if e, err := entityCodec.Decode(value); err == nil {
+		return []string{KindIndexValue(appOf(key), e.Kind)}
+	}
+	if a, err := assocCodec.Decode(value); err == nil {
+		return []string{KindIndexValue(appOf(key), a.Label)}
+	}
+	return nil
+}
+
+func indexAttribute(key string, value interface{}) []string {
+	aid := appOf(key)
+	ret := []string{}
+	if e, err := entityCodec.Decode(value); err == nil {
+		for k, attr := range e.Attributes {
+			ret = append(ret, AttributeIndexValue(aid, k, attr))
+		}
+	} else if a, err := assocCodec.Decode(value); err == nil {
+		for k, attr := range a.Attributes {
+			ret = append(ret, AttributeIndexValue(aid, k, fmt.Sprint(attr)))
+		}
+	}
+	return ret
+}
+
+func indexMember(key string, value interface{}) []string {
+	a, err := assocCodec.Decode(value)
+	if err != nil {
+		return nil
+	}
+	aid := appOf(key)
+	ret := []string{}
+	for _, m := range a.Members() {
+		ret = append(ret, MemberIndexValue(aid, m))
+	}
+	return ret
+}
+
+// CreateIndexes creates secondary indexes of entities and assocs by app, kind
+// and attribute, and of assocs by member entity
+func CreateIndexes(d db.Db) error {
+	for _, table := range []string{DB_TABLE_ENTITIES, DB_TABLE_ASSOCS} {
+		if err := d.CreateIndex(table, INDEX_APP, indexApp); err != nil {
+			return err
+		}
+		if err := d.CreateIndex(table, INDEX_KIND, indexKind); err != nil {
+			return err
+		}
+		if err := d.CreateIndex(table, INDEX_ATTRIBUTE, indexAttribute); err != nil {
+			return err
+		}
+	}
+	return d.CreateIndex(DB_TABLE_ASSOCS, INDEX_MEMBER, indexMember)
+}
+
+// lookup lists entries of an index value, falling back to a scan of app
+// entries filtered by the index function when the DB lacks the index
+func lookup(d db.Db, table, index, aid, value string) []db.KeyValue {
+	if rows, err := d.Lookup(table, index, value); err == nil {
+		return rows
+	}
+	rows := d.Scan(table, GetAppPrefix(aid))
+	if index == INDEX_APP {
+		return rows
+	}
+	fn := map[string]db.IndexFunc{
+		INDEX_KIND:      indexKind,
+		INDEX_ATTRIBUTE: indexAttribute,
+		INDEX_MEMBER:    indexMember,
+	}[index]
+	ret := []db.KeyValue{}
+	for _, row := range rows {
+		for _, v := range fn(row.Key, row.Value) {
+			if v == value {
+				ret = append(ret, row)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func toEntities(rows []db.KeyValue) []Entity {
+	ret, err := Entities(nil).DecodeRows(rows)
+	logErrors(err)
+	return ret
+}
+
+func toAssocs(rows []db.KeyValue) []Assoc {
+	ret, err := Assocs(nil).DecodeRows(rows)
+	logErrors(err)
+	return ret
+}
+
+// AppEntities returns entities of an app sorted by key, with app prefixed ids
+func AppEntities(d db.Db, aid string) []Entity {
+	return toEntities(lookup(d, DB_TABLE_ENTITIES, INDEX_APP, aid, aid))
+}
+
+// AppAssocs returns assocs of an app sorted by key, with app prefixed ids
+func AppAssocs(d db.Db, aid string) []Assoc {
+	return toAssocs(lookup(d, DB_TABLE_ASSOCS, INDEX_APP, aid, aid))
+}
+
+// EntitiesOfKind returns entities of an app of a given kind
+func EntitiesOfKind(d db.Db, aid, kind string) []Entity {
+	return toEntities(lookup(d, DB_TABLE_ENTITIES, INDEX_KIND, aid, KindIndexValue(aid, kind)))
+}
+
+// EntitiesWithAttribute returns entities of an app with a given attribute value
+func EntitiesWithAttribute(d db.Db, aid, key, value string) []Entity {
+	return toEntities(lookup(d, DB_TABLE_ENTITIES, INDEX_ATTRIBUTE, aid, AttributeIndexValue(aid, key, value)))
+}
+
+// AssocsOfLabel returns assocs of an app with a given label
+func AssocsOfLabel(d db.Db, aid, label string) []Assoc {
+	return toAssocs(lookup(d, DB_TABLE_ASSOCS, INDEX_KIND, aid, KindIndexValue(aid, label)))
+}
+
+// AssocsOfMember returns assocs of an app with a given member entity
+func AssocsOfMember(d db.Db, aid, eid string) []Assoc {
+	return toAssocs(lookup(d, DB_TABLE_ASSOCS, INDEX_MEMBER, aid, MemberIndexValue(aid, eid)))
+}
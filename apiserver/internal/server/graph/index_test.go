@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+func TestIndexLookups(t *testing.T) {
+	// apps whose id is a prefix of another one, with and without indexes
+	indexed := db.NewMemoryDb(DB_TABLE_ENTITIES, DB_TABLE_ASSOCS)
+	if err := CreateIndexes(indexed); err != nil {
+		t.Fatal(err)
+	}
+	scanned := db.NewMemoryDb(DB_TABLE_ENTITIES, DB_TABLE_ASSOCS)
+	for _, d := range []db.Db{indexed, scanned} {
+		for _, aid := range []string{"prod", "prod2"} {
+			key := GetEntityKey(aid, "e1")
+			Entities(d).Put(key, Entity{ID: key, Kind: "vm", Attributes: map[string]string{"Env": aid}})
+			key = GetEntityKey(aid, "s1")
+			Assocs(d).Put(key, Assoc{ID: key, Label: "ssh", FromEntities: []string{"e1"}})
+		}
+	}
+
+	ids := func(entities []Entity, assocs []Assoc) []string {
+		ret := []string{}
+		for _, e := range entities {
+			ret = append(ret, e.ID)
+		}
+		for _, a := range assocs {
+			ret = append(ret, a.ID)
+		}
+		return ret
+	}
+	tests := []struct {
+		name   string
+		lookup func(d db.Db) []string
+		want   []string
+	}{
+		{"app entities", func(d db.Db) []string { return ids(AppEntities(d, "prod"), nil) }, []string{"prod/e1"}},
+		{"app assocs", func(d db.Db) []string { return ids(nil, AppAssocs(d, "prod")) }, []string{"prod/s1"}},
+		{"kind", func(d db.Db) []string { return ids(EntitiesOfKind(d, "prod", "vm"), nil) }, []string{"prod/e1"}},
+		{"attribute", func(d db.Db) []string { return ids(EntitiesWithAttribute(d, "prod2", "Env", "prod2"), nil) }, []string{"prod2/e1"}},
+		{"label", func(d db.Db) []string { return ids(nil, AssocsOfLabel(d, "prod2", "ssh")) }, []string{"prod2/s1"}},
+		{"member", func(d db.Db) []string { return ids(nil, AssocsOfMember(d, "prod", "e1")) }, []string{"prod/s1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, d := range []db.Db{indexed, scanned} {
+				if got := tt.lookup(d); !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("expecting %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	// updates and deletes move entries out of index values
+	key := GetEntityKey("prod", "e1")
+	Entities(indexed).Put(key, Entity{ID: key, Kind: "db"})
+	if got := ids(EntitiesOfKind(indexed, "prod", "vm"), nil); len(got) != 0 {
+		t.Fatalf("expecting updated kind unindexed, got %v", got)
+	}
+	indexed.Del(DB_TABLE_ASSOCS, GetEntityKey("prod", "s1"))
+	if got := ids(nil, AssocsOfMember(indexed, "prod", "e1")); len(got) != 0 {
+		t.Fatalf("expecting deleted assoc unindexed, got %v", got)
+	}
+}
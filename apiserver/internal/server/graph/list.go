@@ -0,0 +1,292 @@
+package graph
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// page size of list endpoints following a cursor without a limit, lists
+	// being returned whole when given neither
+	DEFAULT_PAGE_SIZE = 500
+	MAX_PAGE_SIZE     = 5000
+
+	// sort orders of list endpoints, by id being the key order of the store
+	SORT_ID      = "id"
+	SORT_NAME    = "name"
+	SORT_CREATED = "created"
+	SORT_SCORE   = "score"
+)
+
+// RiskLevels returns the highest risk level of findings of each entity of an
+// app, keyed by entity id
+type RiskLevels func(aid string) map[string]string
+
+// SetRiskLevels sets risk levels, lowest first, and the source of entity risk
+// levels used to filter and sort lists
+func (g *Graph) SetRiskLevels(levels []string, fn RiskLevels) {
+	g.riskLevels = levels
+	g.entityRisks = fn
+}
+
+// riskRank returns position of a risk level, -1 if unknown
+func (g *Graph) riskRank(level string) int {
+	for i, l := range g.riskLevels {
+		if strings.EqualFold(l, level) {
+			return i
+		}
+	}
+	return -1
+}
+
+// risks returns risk levels of entities of an app
+func (g *Graph) risks(aid string) map[string]string {
+	if g.entityRisks == nil {
+		return map[string]string{}
+	}
+	return g.entityRisks(aid)
+}
+
+// appRisk returns the highest risk level of entities of an app, "" if none
+func (g *Graph) appRisk(aid string) string {
+	risk := ""
+	for _, level := range g.risks(aid) {
+		if risk == "" || g.riskRank(level) > g.riskRank(risk) {
+			risk = level
+		}
+	}
+	return risk
+}
+
+// attackGraphRisk returns the highest risk level of findings of an attack
+// graph, "" if none
+func (g *Graph) attackGraphRisk(agid string) string {
+	risk := ""
+	for _, e := range AppEntities(g.db, agid) {
+		if g.riskRank(e.Attributes["Risk"]) < 0 {
+			continue
+		}
+		if risk == "" || g.riskRank(e.Attributes["Risk"]) > g.riskRank(risk) {
+			risk = strings.ToLower(e.Attributes["Risk"])
+		}
+	}
+	return risk
+}
+
+// listParams are pagination, sort and filter parameters of a list request
+type listParams struct {
+	limit  int // 0 for all items
+	cursor *cursor
+	sort   string
+	desc   bool
+
+	kind  string
+	label string
+	risk  string
+	attrs map[string]string
+}
+
+// cursor is the position of the last item of a page
+type cursor struct {
+	Sort  string  `json:"s"`
+	Desc  bool    `json:"d,omitempty"`
+	Key   string  `json:"k,omitempty"`
+	Score float64 `json:"n,omitempty"`
+	Time  int64   `json:"t,omitempty"` // unix nanoseconds
+	ID    string  `json:"i"`
+}
+
+// listItem is the sort key of a listed item
+type listItem struct {
+	id      string
+	name    string
+	created string
+	score   float64
+}
+
+// parseListParams parses list parameters with given supported sort orders
+// besides key order, e.g.
+// ?limit=100&cursor=...&sort=created&order=desc&kind=iam:*&attr=Public:true
+func (g *Graph) parseListParams(r *http.Request, sorts ...string) (listParams, error) {
+	query := r.URL.Query()
+	p := listParams{
+		sort:  SORT_ID,
+		kind:  query.Get("kind"),
+		label: query.Get("label"),
+		risk:  query.Get("risk"),
+		attrs: map[string]string{},
+	}
+
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > MAX_PAGE_SIZE {
+			return p, fmt.Errorf("invalid limit %v, expected 1 to %d", s, MAX_PAGE_SIZE)
+		}
+		p.limit = n
+	}
+	if s := query.Get("sort"); s != "" {
+		p.sort = s
+	}
+	sorts = append([]string{SORT_ID}, sorts...)
+	valid := false
+	for _, s := range sorts {
+		valid = valid || s == p.sort
+	}
+	if !valid {
+		return p, fmt.Errorf("invalid sort %v, expected one of %v", p.sort, strings.Join(sorts, ", "))
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		p.desc = true
+	default:
+		return p, fmt.Errorf("invalid order %v, expected asc or desc", query.Get("order"))
+	}
+
+	if p.risk != "" && g.riskRank(p.risk) < 0 {
+		return p, fmt.Errorf("invalid risk %v, expected one of %v", p.risk, strings.Join(g.riskLevels, ", "))
+	}
+	for _, attr := range query["attr"] {
+		k, v, _ := strings.Cut(attr, ":")
+		if k == "" {
+			return p, fmt.Errorf("invalid attr %v, expected key or key:value", attr)
+		}
+		p.attrs[k] = v
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil || c.Sort != p.sort || c.Desc != p.desc {
+			return p, fmt.Errorf("invalid cursor %v", s)
+		}
+		p.cursor = c
+		if p.limit == 0 {
+			p.limit = DEFAULT_PAGE_SIZE
+		}
+	}
+	return p, nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// key returns the sort key of an item
+func (p listParams) key(item listItem) *cursor {
+	c := &cursor{Sort: p.sort, Desc: p.desc, ID: item.id}
+	switch p.sort {
+	case SORT_NAME:
+		c.Key = item.name
+	case SORT_CREATED:
+		if t := ParseTime(item.created); !t.IsZero() {
+			c.Time = t.UnixNano()
+		}
+	case SORT_SCORE:
+		c.Score = item.score
+	}
+	return c
+}
+
+// less orders two sort keys, ties broken by id
+func (p listParams) less(a, b *cursor) bool {
+	switch {
+	case a.Score != b.Score:
+		return (a.Score < b.Score) != p.desc
+	case a.Time != b.Time:
+		return (a.Time < b.Time) != p.desc
+	case a.Key != b.Key:
+		return (a.Key < b.Key) != p.desc
+	case a.ID != b.ID:
+		return (a.ID < b.ID) != p.desc
+	}
+	return false
+}
+
+// page sorts items and returns positions of those on the requested page, and
+// the cursor of the next page, "" on the last page. Items listed in key order
+// are already sorted by id, and are paged without sorting them.
+func (p listParams) page(items []listItem) ([]int, string) {
+	keys := make([]*cursor, len(items))
+	order := make([]int, len(items))
+	for i, item := range items {
+		keys[i] = p.key(item)
+		order[i] = i
+	}
+	switch {
+	case p.sort != SORT_ID:
+		sort.Slice(order, func(i, j int) bool { return p.less(keys[order[i]], keys[order[j]]) })
+	case p.desc:
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+	}
+
+	start := 0
+	if p.cursor != nil {
+		start = sort.Search(len(order), func(i int) bool { return p.less(p.cursor, keys[order[i]]) })
+	}
+	end := start + p.limit
+	if p.limit == 0 || end >= len(order) {
+		return order[start:], ""
+	}
+	return order[start:end], keys[order[end-1]].encode()
+}
+
+// matchAttrs checks attribute filters, matching values as glob patterns
+func (p listParams) matchAttrs(attrs map[string]string) bool {
+	for k, pattern := range p.attrs {
+		v, ok := attrs[k]
+		if !ok || (pattern != "" && !match(pattern, v)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRisk checks the risk filter, matching risk levels at or above it
+func (g *Graph) matchRisk(p listParams, level string) bool {
+	return p.risk == "" || (level != "" && g.riskRank(level) >= g.riskRank(p.risk))
+}
+
+// match checks a value against a glob pattern, e.g. iam:*, empty patterns
+// matching any value
+func match(pattern, value string) bool {
+	if pattern == "" || pattern == value {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
+
+// isPattern checks if a value has glob pattern characters
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[\\")
+}
+
+// anyAttrs converts attributes of any value type to strings
+func anyAttrs(attrs map[string]interface{}) map[string]string {
+	strs := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		strs[k] = fmt.Sprint(v)
+	}
+	return strs
+}
@@ -0,0 +1,103 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// listApps returns ids of a page of apps and the next cursor
+func listApps(t *testing.T, r http.Handler, query url.Values) ([]string, string) {
+	t.Helper()
+	w := serve(r, "GET", "/v1/apps?"+query.Encode(), "")
+	var list AppsList
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("%v: %v", w.Body, err)
+	}
+	ids := []string{}
+	for _, a := range list.Apps {
+		ids = append(ids, a.ID)
+	}
+	return ids, list.NextCursor
+}
+
+func TestListOrder(t *testing.T) {
+	d := newTestDb(t)
+	r := newTestRouter(d)
+	apps := []AppData{
+		{ID: "a1", Name: "c", Created: "2024-11-01T10:00:00Z"},
+		{ID: "a2", Name: "a", Created: "2024-11-01T10:00:00.5Z"},
+		{ID: "a3", Name: "b", Created: "2024-11-01T09:59:59.999999999Z"},
+		{ID: "a4", Name: "b", Created: "2024-11-01T11:59:00+02:00"},
+	}
+	for _, a := range apps {
+		Apps(d).Put(a.ID, a)
+	}
+
+	tests := []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{"a1", "a2", "a3", "a4"}},
+		{"order=desc", []string{"a4", "a3", "a2", "a1"}},
+		{"sort=name", []string{"a2", "a3", "a4", "a1"}},
+		{"sort=created", []string{"a4", "a3", "a1", "a2"}},
+		{"sort=created&order=desc", []string{"a2", "a1", "a3", "a4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			if ids, _ := listApps(t, r, query); !reflect.DeepEqual(ids, tt.ids) {
+				t.Fatalf("expecting %v, got %v", tt.ids, ids)
+			}
+
+			// pages of one item follow the same order
+			ids := []string{}
+			query.Set("limit", "1")
+			for {
+				page, next := listApps(t, r, query)
+				ids = append(ids, page...)
+				if next == "" {
+					break
+				}
+				query.Set("cursor", next)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Fatalf("expecting pages of %v, got %v", tt.ids, ids)
+			}
+		})
+	}
+}
+
+func TestListUnbounded(t *testing.T) {
+	d := newTestDb(t)
+	r := newTestRouter(d)
+	n := DEFAULT_PAGE_SIZE + 10
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("a%04d", i)
+		Apps(d).Put(id, AppData{ID: id})
+	}
+
+	// lists are whole without limit or cursor
+	ids, next := listApps(t, r, url.Values{})
+	if len(ids) != n || next != "" {
+		t.Fatalf("expecting all %v apps, got %v and cursor %q", n, len(ids), next)
+	}
+
+	// a cursor without limit pages by the default page size
+	ids, next = listApps(t, r, url.Values{"limit": {"5"}})
+	if len(ids) != 5 || next == "" {
+		t.Fatalf("expecting a page of 5 apps, got %v", ids)
+	}
+	ids, _ = listApps(t, r, url.Values{"cursor": {next}})
+	if len(ids) != DEFAULT_PAGE_SIZE || ids[0] != "a0005" {
+		t.Fatalf("expecting %v apps from a0005, got %v from %v", DEFAULT_PAGE_SIZE, len(ids), ids[0])
+	}
+	ids, _ = listApps(t, r, url.Values{"cursor": {next}, "limit": {"3"}})
+	if !reflect.DeepEqual(ids, []string{"a0005", "a0006", "a0007"}) {
+		t.Fatalf("expecting a0005 to a0007, got %v", ids)
+	}
+}
@@ -0,0 +1,456 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+var (
+	// errors of record writes replied as 404 and 400
+	errNotFound = errors.New("Data not found")
+	errInvalid  = errors.New("invalid record")
+)
+
+// txError replies with the error of a failed transaction, a conflict with
+// concurrent writes being 409 and an unmet If-Match precondition 412
+func txError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		http.Error(w, "Data not found", http.StatusNotFound)
+	case errors.Is(err, errInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, db.ErrPrecondition):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, db.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// RecordError replies with the error of a record read, records failing to
+// decode being 500 rather than not found
+func RecordError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrRecord) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, "Data not found", http.StatusNotFound)
+}
+
+// readRecord returns a record along with its version, read from one snapshot
+// so that the version is the one of the record served
+func readRecord[T any](g *Graph, repo *db.Repository[T], key string) (T, uint64, error) {
+	var (
+		record  T
+		version uint64
+	)
+	err := db.RunTx(g.db, func(tx db.Tx) error {
+		var err error
+		if record, err = repo.On(tx).Get(key); err != nil {
+			return err
+		}
+		version, err = tx.Version(repo.Table(), key)
+		return err
+	})
+	return record, version, err
+}
+
+// setETag sets the ETag header of a record read to its version, replying 304
+// Not Modified and returning true when it matches the If-None-Match header
+func setETag(w http.ResponseWriter, r *http.Request, version uint64) bool {
+	etag := db.ETag(version)
+	w.Header().Set("ETag", etag)
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// showRecord returns a record as served, entities and assocs without app
+// prefixed ids
+func showRecord(aid string, record interface{}) interface{} {
+	switch v := record.(type) {
+	case Entity:
+		v.ID = TrimAppPrefix(aid, v.ID)
+		return v
+	case Assoc:
+		v.ID = TrimAppPrefix(aid, v.ID)
+		return v
+	}
+	return record
+}
+
+// mergeObject applies a JSON merge patch object onto a JSON object, null
+// values deleting members
+func mergeObject(doc, patch map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	for k, v := range patch {
+		switch pv := v.(type) {
+		case nil:
+			delete(doc, k)
+		case map[string]interface{}:
+			dv, _ := doc[k].(map[string]interface{})
+			doc[k] = mergeObject(dv, pv)
+		default:
+			doc[k] = v
+		}
+	}
+	return doc
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) onto a record, decoding
+// the patched record into out
+func mergePatch(record interface{}, patch map[string]interface{}, out interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(mergeObject(doc, patch)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	return nil
+}
+
+// parsePreconditions parses If-Match and If-None-Match headers of a write or
+// delete, replying 400 and returning false when invalid
+func parsePreconditions(w http.ResponseWriter, r *http.Request) ([]*db.Precondition, bool) {
+	match, err := db.ParseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	noneMatch, err := db.ParseIfNoneMatch(r.Header.Get("If-None-Match"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return []*db.Precondition{match, noneMatch}, true
+}
+
+// checkPreconditions returns ErrPrecondition unless a record meets all
+// preconditions
+func checkPreconditions(tx db.Tx, preconditions []*db.Precondition, table, key string) error {
+	for _, p := range preconditions {
+		if err := p.Check(tx, table, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRecord writes a record computed from the current one, nil when absent,
+// in a transaction checking the If-Match and If-None-Match headers, along
+// with a new snapshot version for entities and assocs, and replies with the
+// record and the ETag of the version written. Current records failing to
+// decode are not replaced.
+func writeRecord[T any](g *Graph, w http.ResponseWriter, r *http.Request, aid string, repo *db.Repository[T], key string,
+	update func(cur *T) (T, error)) {
+	preconditions, ok := parsePreconditions(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		record  T
+		created bool
+		version uint64
+		table   = repo.Table()
+	)
+	committed, err := db.RunTxSeq(g.db, func(tx db.Tx) error {
+		created = false
+		if err := checkPreconditions(tx, preconditions, table, key); err != nil {
+			return err
+		}
+		var cur *T
+		v, err := repo.On(tx).Get(key)
+		switch {
+		case errors.Is(err, db.ErrRecord):
+			return err
+		case err != nil:
+			created = true
+		default:
+			cur = &v
+		}
+		if record, err = update(cur); err != nil {
+			return err
+		}
+		if err := repo.On(tx).Put(key, record); err != nil {
+			return err
+		}
+		version, err = tx.Version(table, key)
+		return err
+	})
+	if err != nil {
+		txError(w, err)
+		return
+	}
+
+	// the version written is the one committed, or the one staged on DBs
+	// without commit sequence numbers
+	if committed > 0 {
+		version = committed
+	}
+	log.Printf("updated %v %v\n", table, key)
+
+	// return JSON response
+	w.Header().Set("ETag", db.ETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(showRecord(aid, record))
+}
+
+// deleteRecord deletes a record with del in a transaction checking the
+// If-Match and If-None-Match headers
+func (g *Graph) deleteRecord(w http.ResponseWriter, r *http.Request, aid, table, key string, del func(tx db.Tx) error) {
+	preconditions, ok := parsePreconditions(w, r)
+	if !ok {
+		return
+	}
+
+	err := db.RunTx(g.db, func(tx db.Tx) error {
+		if _, err := tx.Get(table, key); err != nil {
+			return errNotFound
+		}
+		if err := checkPreconditions(tx, preconditions, table, key); err != nil {
+			return err
+		}
+		return del(tx)
+	})
+	if err != nil {
+		txError(w, err)
+		return
+	}
+	log.Printf("deleted %v %v\n", table, key)
+
+	fmt.Fprintf(w, "Data with ID %s deleted", TrimAppPrefix(aid, key))
+}
+
+// decodePatch decodes a JSON merge patch from a request body
+func decodePatch(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	patch := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return patch, true
+}
+
+// UpdateAppData is PUT handler to replace an app, keeping its evaluation
+// history
+func (g *Graph) UpdateAppData(w http.ResponseWriter, r *http.Request) {
+	var (
+		app  AppData
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validAppId(aid); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRecord(g, w, r, aid, Apps(g.db), aid, func(cur *AppData) (AppData, error) {
+		a := app
+		a.ID = aid
+		a.Created = time.Now().UTC().Format(time.RFC3339Nano)
+		if cur != nil {
+			a.Stats = cur.Stats
+			a.Created = cur.Created
+		}
+		a.LastModified = time.Now().UTC().Format(time.RFC3339Nano)
+		return a, nil
+	})
+}
+
+// PatchAppData is PATCH handler to update an app with a JSON merge patch
+func (g *Graph) PatchAppData(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	aid := vars["id"]
+
+	patch, ok := decodePatch(w, r)
+	if !ok {
+		return
+	}
+	writeRecord(g, w, r, aid, Apps(g.db), aid, func(cur *AppData) (AppData, error) {
+		var a AppData
+		if cur == nil {
+			return a, errNotFound
+		}
+		if err := mergePatch(*cur, patch, &a); err != nil {
+			return a, err
+		}
+		a.ID = aid
+		a.LastModified = time.Now().UTC().Format(time.RFC3339Nano)
+		return a, nil
+	})
+}
+
+// DeleteAppData is DELETE handler to delete an app along with its entities,
+// assocs, snapshots and attack graphs, all at once
+func (g *Graph) DeleteAppData(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	aid := vars["id"]
+
+	g.deleteRecord(w, r, aid, DB_TABLE_GRAPH, aid, func(tx db.Tx) error {
+		return deleteApp(tx, aid)
+	})
+}
+
+// UpdateEntity is PUT handler to replace an entity of an app
+func (g *Graph) UpdateEntity(w http.ResponseWriter, r *http.Request) {
+	var (
+		entity Entity
+		vars   = mux.Vars(r)
+		aid    = vars["aid"]
+		ekey   = GetEntityKey(aid, vars["eid"])
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&entity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRecord(g, w, r, aid, Entities(g.db), ekey, func(cur *Entity) (Entity, error) {
+		e := entity
+		e.ID = ekey
+		e.Created = time.Now().UTC().Format(time.RFC3339Nano)
+		if cur != nil {
+			e.Created = cur.Created
+		}
+		e.LastModified = time.Now().UTC().Format(time.RFC3339Nano)
+		return e, nil
+	})
+}
+
+// PatchEntity is PATCH handler to update an entity of an app with a JSON
+// merge patch
+func (g *Graph) PatchEntity(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["aid"]
+		ekey = GetEntityKey(aid, vars["eid"])
+	)
+
+	patch, ok := decodePatch(w, r)
+	if !ok {
+		return
+	}
+	writeRecord(g, w, r, aid, Entities(g.db), ekey, func(cur *Entity) (Entity, error) {
+		var e Entity
+		if cur == nil {
+			return e, errNotFound
+		}
+		if err := mergePatch(*cur, patch, &e); err != nil {
+			return e, err
+		}
+		e.ID = ekey
+		e.LastModified = time.Now().UTC().Format(time.RFC3339Nano)
+		return e, nil
+	})
+}
+
+// DeleteEntity is DELETE handler to delete an entity of an app along with its
+// assocs
+func (g *Graph) DeleteEntity(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["aid"]
+		ekey = GetEntityKey(aid, vars["eid"])
+	)
+
+	g.deleteRecord(w, r, aid, DB_TABLE_ENTITIES, ekey, func(tx db.Tx) error {
+		return deleteEntity(tx, aid, ekey)
+	})
+}
+
+// UpdateAssoc is PUT handler to replace an assoc of an app
+func (g *Graph) UpdateAssoc(w http.ResponseWriter, r *http.Request) {
+	var (
+		assoc Assoc
+		vars  = mux.Vars(r)
+		aid   = vars["aid"]
+		skey  = GetEntityKey(aid, vars["sid"])
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&assoc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRecord(g, w, r, aid, Assocs(g.db), skey, func(cur *Assoc) (Assoc, error) {
+		a := assoc
+		a.ID = skey
+		a.Created = time.Now().UTC().Format(time.RFC3339Nano)
+		if cur != nil {
+			a.Created = cur.Created
+		}
+		a.LastModified = time.Now().UTC().Format(time.RFC3339Nano)
+		return a, nil
+	})
+}
+
+// PatchAssoc is PATCH handler to update an assoc of an app with a JSON merge
+// patch
+func (g *Graph) PatchAssoc(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["aid"]
+		skey = GetEntityKey(aid, vars["sid"])
+	)
+
+	patch, ok := decodePatch(w, r)
+	if !ok {
+		return
+	}
+	writeRecord(g, w, r, aid, Assocs(g.db), skey, func(cur *Assoc) (Assoc, error) {
+		var a Assoc
+		if cur == nil {
+			return a, errNotFound
+		}
+		if err := mergePatch(*cur, patch, &a); err != nil {
+			return a, err
+		}
+		a.ID = skey
+		a.LastModified = time.Now().UTC().Format(time.RFC3339Nano)
+		return a, nil
+	})
+}
+
+// DeleteAssoc is DELETE handler to delete an assoc of an app
+func (g *Graph) DeleteAssoc(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["aid"]
+		skey = GetEntityKey(aid, vars["sid"])
+	)
+
+	g.deleteRecord(w, r, aid, DB_TABLE_ASSOCS, skey, func(tx db.Tx) error {
+		return tx.Del(DB_TABLE_ASSOCS, skey)
+	})
+}
@@ -0,0 +1,147 @@
+package graph
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+func TestETags(t *testing.T) {
+	d := newTestDb(t)
+	r := newTestRouter(d)
+	serve(r, "POST", "/v1/app", `{"id":"a1","name":"app"}`)
+	serve(r, "POST", "/v1/app/a1/entity", `{"entities":[{"id":"e1","kind":"vm"}]}`)
+
+	// etag returns the ETag of a GET of a record
+	etag := func(path string) string {
+		t.Helper()
+		w := serve(r, "GET", path, ``)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+			t.Fatalf("expecting a record with an ETag, got %v %v", w.Code, w.Header())
+		}
+		return w.Header().Get("ETag")
+	}
+
+	for _, path := range []string{"/v1/app/a1", "/v1/app/a1/entity/e1"} {
+		t.Run(path, func(t *testing.T) {
+			stale := etag(path)
+			if w := serve(r, "GET", path, ``, "If-None-Match", `"0", `+stale); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Fatalf("expecting 304 without a body, got %v %v", w.Code, w.Body)
+			}
+
+			// a write based on the current version succeeds and returns the
+			// new ETag, one based on the stale version then fails
+			w := serve(r, "PATCH", path, `{"attributes":{"Owner":"secops"}}`, "If-Match", stale)
+			if w.Code != http.StatusOK || w.Header().Get("ETag") == stale || w.Header().Get("ETag") != etag(path) {
+				t.Fatalf("expecting a write with a new ETag, got %v %v %v", w.Code, w.Header().Get("ETag"), w.Body)
+			}
+			current := w.Header().Get("ETag")
+			if w := serve(r, "GET", path, ``, "If-None-Match", stale); w.Code != http.StatusOK {
+				t.Fatalf("expecting the modified record, got %v", w.Code)
+			}
+
+			tests := []struct {
+				name    string
+				method  string
+				ifMatch string
+				code    int
+			}{
+				{"stale", "PATCH", stale, http.StatusPreconditionFailed},
+				{"stale delete", "DELETE", stale, http.StatusPreconditionFailed},
+				{"weak", "PATCH", "W/" + current, http.StatusPreconditionFailed},
+				{"invalid", "PATCH", "42", http.StatusBadRequest},
+				{"any of", "PATCH", stale + ", " + current, http.StatusOK},
+				{"any version", "PATCH", "*", http.StatusOK},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					before := etag(path)
+					w := serve(r, tt.method, path, `{"attributes":{"Owner":"`+tt.name+`"}}`, "If-Match", tt.ifMatch)
+					if w.Code != tt.code {
+						t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+					}
+					if after := etag(path); (after != before) != (tt.code == http.StatusOK) {
+						t.Fatalf("expecting a write only when the precondition is met, ETag %v became %v", before, after)
+					}
+					if tt.code == http.StatusOK && !strings.Contains(w.Body.String(), tt.name) {
+						t.Fatalf("expecting the written record, got %v", w.Body)
+					}
+				})
+			}
+		})
+	}
+
+	// deletes of the current version succeed, absent records fail If-Match *
+	if w := serve(r, "DELETE", "/v1/app/a1/entity/e1", ``, "If-Match", etag("/v1/app/a1/entity/e1")); w.Code != http.StatusOK {
+		t.Fatalf("expecting delete, got %v %v", w.Code, w.Body)
+	}
+	if w := serve(r, "PUT", "/v1/app/a1/entity/e1", `{"kind":"vm"}`, "If-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expecting 412 for an absent record, got %v %v", w.Code, w.Body)
+	}
+	if w := serve(r, "PUT", "/v1/app/a1/entity/e1", `{"kind":"vm"}`); w.Code != http.StatusCreated || w.Header().Get("ETag") == "" {
+		t.Fatalf("expecting the record created with an ETag, got %v %v", w.Code, w.Header())
+	}
+}
+
+func TestIfNoneMatchWrites(t *testing.T) {
+	d := newTestDb(t)
+	r := newTestRouter(d)
+	serve(r, "POST", "/v1/app", `{"id":"a1","name":"app"}`)
+	path := "/v1/app/a1/entity/e1"
+
+	// * creates records only when absent, and writes are never 304
+	tests := []struct {
+		name        string
+		method      string
+		ifNoneMatch string
+		code        int
+	}{
+		{"created", "PUT", "*", http.StatusCreated},
+		{"existing", "PUT", "*", http.StatusPreconditionFailed},
+		{"existing patch", "PATCH", "*", http.StatusPreconditionFailed},
+		{"existing delete", "DELETE", "*", http.StatusPreconditionFailed},
+		{"other version", "PATCH", `"1"`, http.StatusOK},
+		{"invalid", "PATCH", "1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := d.Rows[DB_TABLE_ENTITIES][GetEntityKey("a1", "e1")]
+			w := serve(r, tt.method, path, `{"kind":"`+tt.name+`"}`, "If-None-Match", tt.ifNoneMatch)
+			if w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+			}
+			after := d.Rows[DB_TABLE_ENTITIES][GetEntityKey("a1", "e1")]
+			if written := !reflect.DeepEqual(before, after); written != (tt.code < 300) {
+				t.Fatalf("expecting a write only when the precondition is met, written %v", written)
+			}
+		})
+	}
+
+	// the current version fails, weakly compared
+	w := serve(r, "GET", path, ``)
+	etag := w.Header().Get("ETag")
+	if w := serve(r, "PATCH", path, `{"kind":"vm"}`, "If-None-Match", "W/"+etag); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expecting 412 for the current version, got %v %v", w.Code, w.Body)
+	}
+}
+
+func TestWriteETag(t *testing.T) {
+	d := newTestDb(t)
+	r := newTestRouter(d)
+	serve(r, "POST", "/v1/app", `{"id":"a1","name":"app"}`)
+
+	// the ETag of a write is the version committed, past writes of other
+	// records since
+	for i := 0; i < 3; i++ {
+		serve(r, "POST", "/v1/app", `{"id":"a`+strconv.Itoa(i+2)+`","name":"app"}`)
+		w := serve(r, "PATCH", "/v1/app/a1", `{"name":"renamed"}`)
+		version, _ := d.Version(DB_TABLE_GRAPH, "a1")
+		if w.Code != http.StatusOK || w.Header().Get("ETag") != db.ETag(version) {
+			t.Fatalf("expecting ETag %v, got %v %v", db.ETag(version), w.Code, w.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package graph
+
+import (
+	"log"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+const (
+	// record types of graph tables
+	RECORD_APP      = "app"
+	RECORD_ENTITY   = "entity"
+	RECORD_ASSOC    = "assoc"
+	RECORD_SNAPSHOT = "snapshot"
+
+	// current schema versions of record types, bumped along with a migration
+	// from the previous version whenever fields of their type change. Snapshots
+	// hold entities and assocs, so their version is bumped along with those.
+	APP_SCHEMA_VERSION      = 1
+	ENTITY_SCHEMA_VERSION   = 1
+	ASSOC_SCHEMA_VERSION    = 1
+	SNAPSHOT_SCHEMA_VERSION = 2
+)
+
+var (
+	// schemas of graph record types, with migrations by the schema version
+	// they upgrade from
+	AppSchema = db.Schema{
+		Type:       RECORD_APP,
+		Version:    APP_SCHEMA_VERSION,
+		Migrations: map[int]db.Migration{},
+	}
+	EntitySchema = db.Schema{
+		Type:       RECORD_ENTITY,
+		Version:    ENTITY_SCHEMA_VERSION,
+		Migrations: map[int]db.Migration{},
+	}
+	AssocSchema = db.Schema{
+		Type:       RECORD_ASSOC,
+		Version:    ASSOC_SCHEMA_VERSION,
+		Migrations: map[int]db.Migration{},
+	}
+	SnapshotSchema = db.Schema{
+		Type:    RECORD_SNAPSHOT,
+		Version: SNAPSHOT_SCHEMA_VERSION,
+		Migrations: map[int]db.Migration{
+			// snapshots of version 1 were amended by time rather than by
+			// discovery run, and have none
+			1: func(doc map[string]interface{}) error { return nil },
+		},
+	}
+
+	// codecs of entities and assocs, decoding records of index functions
+	entityCodec = db.Codec[Entity]{Schema: EntitySchema}
+	assocCodec  = db.Codec[Assoc]{Schema: AssocSchema}
+)
+
+// Apps returns the repository of apps and attack graphs of a DB
+func Apps(d db.Db) *db.Repository[AppData] {
+	return db.NewRepository[AppData](d, DB_TABLE_GRAPH, AppSchema)
+}
+
+// Entities returns the repository of entities of a DB, keyed by app
+// prefixed ids
+func Entities(d db.Db) *db.Repository[Entity] {
+	return db.NewRepository[Entity](d, DB_TABLE_ENTITIES, EntitySchema)
+}
+
+// Assocs returns the repository of assocs of a DB, keyed by app prefixed ids
+func Assocs(d db.Db) *db.Repository[Assoc] {
+	return db.NewRepository[Assoc](d, DB_TABLE_ASSOCS, AssocSchema)
+}
+
+// Snapshots returns the repository of app snapshots of a DB
+func Snapshots(d db.Db) *db.Repository[Snapshot] {
+	return db.NewRepository[Snapshot](d, DB_TABLE_SNAPSHOTS, SnapshotSchema)
+}
+
+// logErrors logs records failing to decode, which are left out of listings
+func logErrors(err error) {
+	if err != nil {
+		log.Printf("skipped records: %v\n", err)
+	}
+}
+
+// Migrate upgrades records of graph tables of older schema versions to their
+// current ones
+func Migrate(d db.Db) error {
+	migrations := map[string]func() (int, error){
+		DB_TABLE_GRAPH:     Apps(d).Migrate,
+		DB_TABLE_ENTITIES:  Entities(d).Migrate,
+		DB_TABLE_ASSOCS:    Assocs(d).Migrate,
+		DB_TABLE_SNAPSHOTS: Snapshots(d).Migrate,
+	}
+	for table, migrate := range migrations {
+		n, err := migrate()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("migrated %v records of %v\n", n, table)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package graph
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+func TestMigrateTenant(t *testing.T) {
+	// records read back as JSON, as from a persistent backend
+	d := newTestDb(t)
+	d.Add(DB_TABLE_GRAPH, "a1", []byte(`{"type":"app","schema":1,"data":{"id":"a1","name":"app"}}`))
+	d.Add(DB_TABLE_ENTITIES, "a1/e1", []byte(`{"type":"entity","schema":1,"data":{"id":"a1/e1","kind":"vm"}}`))
+	if err := Migrate(d); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := d.Get(DB_TABLE_ENTITIES, "a1/e1"); v.(db.Record).Data.(Entity).Kind != "vm" {
+		t.Fatalf("expecting a typed entity, got %+v", v)
+	}
+	if v, _ := d.Get(DB_TABLE_GRAPH, "a1"); v.(db.Record).Data.(AppData).Name != "app" {
+		t.Fatalf("expecting a typed app, got %+v", v)
+	}
+
+	// records failing to decode fail migration, and are reported on reads
+	// and writes instead of being skipped as missing
+	d.Add(DB_TABLE_ENTITIES, "a1/e2", []byte(`{"type":"entity","schema":1,"data":{"id":"a1/e2","owner":"x"}}`))
+	if err := Migrate(d); err == nil || !strings.Contains(err.Error(), "entities a1/e2") {
+		t.Fatalf("expecting migration error of a1/e2, got %v", err)
+	}
+	r := newTestRouter(d)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		code   int
+	}{
+		{"read", "GET", "/v1/app/a1/entity/e2", http.StatusInternalServerError},
+		{"written", "PUT", "/v1/app/a1/entity/e2", http.StatusInternalServerError},
+		{"listed", "GET", "/v1/app/a1/entities", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, tt.method, tt.path, `{"kind":"vm"}`)
+			if w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+			}
+			if tt.code == http.StatusOK && (!strings.Contains(w.Body.String(), `"e1"`) || strings.Contains(w.Body.String(), `"e2"`)) {
+				t.Fatalf("expecting e1 listed without e2, got %v", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+const (
+	// DB table to store versioned app snapshots
+	DB_TABLE_SNAPSHOTS = "snapshots"
+
+	// snapshot versions kept per app, older ones being deleted
+	SNAPSHOT_RETENTION = 20
+)
+
+// GetSnapshotKey returns the key of an app snapshot version
+func GetSnapshotKey(aid string, version int) string {
+	return fmt.Sprintf("%s/%d", aid, version)
+}
+
+// copyAttributes returns a copy of entity attributes
+func copyAttributes(attrs map[string]string) map[string]string {
+	c := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		c[k] = v
+	}
+	return c
+}
+
+// GetSnapshots returns snapshots of an app sorted by version
+func GetSnapshots(d db.Db, aid string) []Snapshot {
+	snapshots := []Snapshot{}
+	snaps, err := Snapshots(d).Scan(GetAppPrefix(aid))
+	logErrors(err)
+	for _, s := range snaps {
+		if s.App == aid {
+			snapshots = append(snapshots, s)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Version < snapshots[j].Version })
+	return snapshots
+}
+
+// GetSnapshot returns a given snapshot version of an app
+func GetSnapshot(d db.Db, aid string, version int) (Snapshot, error) {
+	return Snapshots(d).Get(GetSnapshotKey(aid, version))
+}
+
+// recordSnapshot stores current entities and assocs of an app as a new
+// version, or as the latest version when it was opened by the same discovery
+// run, so that entities and assocs posted by one run make one version, and
+// deletes versions beyond SNAPSHOT_RETENTION. Ingestions without a run, and
+// imports, always open a new version. Concurrent ingestions of an app
+// conflict on the version key.
+func recordSnapshot(d db.Db, aid, run string) (int, error) {
+	snapshots := GetSnapshots(d, aid)
+	snap := Snapshot{
+		App:      aid,
+		Version:  1,
+		Run:      run,
+		Created:  time.Now().UTC().Format(time.RFC3339Nano),
+		Entities: make(map[string]Entity),
+		Assocs:   make(map[string]Assoc),
+	}
+	if len(snapshots) > 0 {
+		latest := snapshots[len(snapshots)-1]
+		snap.Version = latest.Version + 1
+		if run != "" && latest.Run == run {
+			snap.Version = latest.Version
+			snap.Created = latest.Created
+		}
+	}
+	snap.ID = GetSnapshotKey(aid, snap.Version)
+
+	for _, e := range AppEntities(d, aid) {
+		e.ID = TrimAppPrefix(aid, e.ID)
+		e.Attributes = copyAttributes(e.Attributes)
+		snap.Entities[e.ID] = e
+	}
+	for _, a := range AppAssocs(d, aid) {
+		a.ID = TrimAppPrefix(aid, a.ID)
+		snap.Assocs[a.ID] = a
+	}
+
+	if err := Snapshots(d).Put(snap.ID, snap); err != nil {
+		return 0, err
+	}
+	for _, s := range snapshots {
+		if s.Version > snap.Version-SNAPSHOT_RETENTION {
+			break
+		}
+		if err := Snapshots(d).Del(s.ID); err != nil {
+			return 0, err
+		}
+	}
+	log.Printf("app snapshot %v\n", snap.ID)
+	return snap.Version, nil
+}
+
+// SnapshotDb returns an in-memory DB holding the app and contents of a
+// snapshot, along with additional empty tables
+func SnapshotDb(app AppData, snap Snapshot, tables ...string) *db.MemoryDb {
+	d := db.NewMemoryDb(append([]string{DB_TABLE_GRAPH, DB_TABLE_ENTITIES, DB_TABLE_ASSOCS}, tables...)...)
+	CreateIndexes(d)
+	Apps(d).Put(app.ID, app)
+	for _, e := range snap.Entities {
+		e.ID = GetEntityKey(app.ID, e.ID)
+		Entities(d).Put(e.ID, e)
+	}
+	for _, a := range snap.Assocs {
+		a.ID = GetEntityKey(app.ID, a.ID)
+		Assocs(d).Put(a.ID, a)
+	}
+	return d
+}
+
+// GetAppSnapshots is GET handler to list snapshot versions of an app
+func (g *Graph) GetAppSnapshots(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	aid := vars["id"]
+
+	snapList := SnapshotList{
+		App:       aid,
+		Snapshots: []SnapshotInfo{},
+	}
+	for _, s := range GetSnapshots(g.db, aid) {
+		snapList.Snapshots = append(snapList.Snapshots, SnapshotInfo{
+			Version:     s.Version,
+			Run:         s.Run,
+			Created:     s.Created,
+			NumEntities: len(s.Entities),
+			NumAssocs:   len(s.Assocs),
+		})
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapList)
+}
+
+// GetAppSnapshot is GET handler to fetch a snapshot version of an app
+func (g *Graph) GetAppSnapshot(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	version, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+	snap, err := GetSnapshot(g.db, aid, version)
+	if err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
@@ -0,0 +1,150 @@
+package graph
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// newTestDb returns a DB of graph tables with their indexes
+func newTestDb(t *testing.T) *db.MemoryDb {
+	t.Helper()
+	d := db.NewMemoryDb(DB_TABLE_GRAPH, DB_TABLE_ENTITIES, DB_TABLE_ASSOCS, DB_TABLE_SNAPSHOTS)
+	if err := CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// newTestRouter returns app, entity and assoc handlers on a DB
+func newTestRouter(d db.Db) *mux.Router {
+	g := NewGraph(d)
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/app", g.CreateAppData).Methods("POST")
+	r.HandleFunc("/v1/app/{id}", g.GetAppData).Methods("GET")
+	r.HandleFunc("/v1/app/{id}", g.UpdateAppData).Methods("PUT")
+	r.HandleFunc("/v1/app/{id}", g.PatchAppData).Methods("PATCH")
+	r.HandleFunc("/v1/app/{id}", g.DeleteAppData).Methods("DELETE")
+	r.HandleFunc("/v1/apps", g.GetAllApps).Methods("GET")
+	r.HandleFunc("/v1/import", g.ImportApp).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/entity", g.CreateEntities).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/entities", g.GetAllEntities).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/assoc", g.CreateAssocData).Methods("POST")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.GetEntityData).Methods("GET")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.UpdateEntity).Methods("PUT")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.PatchEntity).Methods("PATCH")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.DeleteEntity).Methods("DELETE")
+	return r
+}
+
+// serve serves a request with a JSON body, returning its response
+func serve(r http.Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// versions returns snapshot versions of an app
+func versions(d db.Db, aid string) []int {
+	ret := []int{}
+	for _, s := range GetSnapshots(d, aid) {
+		ret = append(ret, s.Version)
+	}
+	return ret
+}
+
+func TestSnapshotPerRun(t *testing.T) {
+	d := newTestDb(t)
+	r := newTestRouter(d)
+
+	// entities and assocs of one discovery run make one version
+	serve(r, "POST", "/v1/app/a1/entity?run=r1", `{"entities":[{"id":"e1","kind":"vm"},{"id":"e2","kind":"db"}]}`)
+	serve(r, "POST", "/v1/app/a1/assoc?run=r1", `{"assocs":[{"id":"s1","entities":["e1","e2"]}]}`)
+	if got := versions(d, "a1"); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("expecting version 1, got %v", got)
+	}
+	snap, err := GetSnapshot(d, "a1", 1)
+	if err != nil || len(snap.Entities) != 2 || len(snap.Assocs) != 1 || snap.Run != "r1" {
+		t.Fatalf("expecting 2 entities and 1 assoc of run r1, got %+v %v", snap, err)
+	}
+
+	// single record writes do not record versions
+	if w := serve(r, "PUT", "/v1/app/a1/entity/e1", `{"kind":"container"}`); w.Code != http.StatusOK {
+		t.Fatalf("expecting entity update, got %v %v", w.Code, w.Body)
+	}
+	if w := serve(r, "DELETE", "/v1/app/a1/entity/e2", ``); w.Code != http.StatusOK {
+		t.Fatalf("expecting entity delete, got %v %v", w.Code, w.Body)
+	}
+	if got := versions(d, "a1"); len(got) != 1 {
+		t.Fatalf("expecting no new version, got %v", got)
+	}
+
+	// the next run records them in a new version, however soon it comes,
+	// and leaves the version of the previous run as it was
+	serve(r, "POST", "/v1/app/a1/entity?run=r2", `{"entities":[{"id":"e3","kind":"vm"}]}`)
+	if got := versions(d, "a1"); len(got) != 2 || got[1] != 2 {
+		t.Fatalf("expecting versions 1 and 2, got %v", got)
+	}
+	snap, _ = GetSnapshot(d, "a1", 2)
+	if len(snap.Entities) != 2 || snap.Entities["e1"].Kind != "container" {
+		t.Fatalf("expecting updated e1 and new e3, got %+v", snap.Entities)
+	}
+	if snap, _ := GetSnapshot(d, "a1", 1); len(snap.Entities) != 2 || snap.Entities["e1"].Kind != "vm" {
+		t.Fatalf("expecting version 1 unchanged, got %+v", snap.Entities)
+	}
+
+	// ingestions without a run, imports, and runs of versions no longer the
+	// latest open new versions
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"no run", "/v1/app/a1/entity", `{"entities":[{"id":"e4","kind":"vm"}]}`},
+		{"no run again", "/v1/app/a1/assoc", `{"assocs":[{"id":"s2","entities":["e3","e4"]}]}`},
+		{"earlier run", "/v1/app/a1/entity?run=r1", `{"entities":[{"id":"e5","kind":"vm"}]}`},
+		{"import", "/v1/import?app=a1", `{"app":{"id":"a1","name":"app"},"entities":[{"id":"e1","kind":"vm"}]}`},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(r, "POST", tt.path, tt.body); w.Code >= 300 {
+				t.Fatalf("expecting ingestion, got %v %v", w.Code, w.Body)
+			}
+			if got := versions(d, "a1"); len(got) != 3+i {
+				t.Fatalf("expecting a new version, got %v", got)
+			}
+		})
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	d := newTestDb(t)
+	r := newTestRouter(d)
+	for i := 0; i < SNAPSHOT_RETENTION+5; i++ {
+		serve(r, "POST", "/v1/app/a1/entity", `{"entities":[{"id":"e1","kind":"vm"}]}`)
+	}
+	got := versions(d, "a1")
+	if len(got) != SNAPSHOT_RETENTION || got[0] != 6 || got[len(got)-1] != SNAPSHOT_RETENTION+5 {
+		t.Fatalf("expecting versions 6 to %v, got %v", SNAPSHOT_RETENTION+5, got)
+	}
+}
+
+func TestSnapshotMigration(t *testing.T) {
+	// snapshots of version 1 have no run
+	d := newTestDb(t)
+	d.Add(DB_TABLE_SNAPSHOTS, "a1/1", db.Record{Type: RECORD_SNAPSHOT, Schema: 1,
+		Data: map[string]interface{}{"id": "a1/1", "app": "a1", "version": 1, "created": "2024-11-01T00:00:00Z"}})
+	snap, err := GetSnapshot(d, "a1", 1)
+	if err != nil || snap.Version != 1 || snap.Run != "" {
+		t.Fatalf("expecting migrated snapshot, got %+v %v", snap, err)
+	}
+}
@@ -7,6 +7,10 @@ import (
 // Graph defines database of vertices and edges, and corresponding operations
 type Graph struct {
 	db db.Db
+
+	// risk levels, lowest first, and entity risk levels of an app
+	riskLevels  []string
+	entityRisks RiskLevels
 }
 
 // AppData represent a graph application
@@ -24,17 +28,21 @@ type AppData struct {
 	LastModifiedUser string                 `json:"lastModifiedUser"`
 }
 
-// TBD
+// Stats represent app evaluation run metadata
 type Stats struct {
-	NumRuns    int   `json:"numruns"`
-	NumQueries int   `json:"numqueries"`
-	NumResp    int   `json:"numresp"`
-	RunTS      []int `json:"runts"`
+	NumRuns         int     `json:"numruns"`
+	NumQueries      int     `json:"numqueries"`
+	NumResp         int     `json:"numresp"`
+	RunTS           []int   `json:"runts"`
+	LastAttackGraph string  `json:"lastAttackGraph"`
+	LastScore       float64 `json:"lastScore"`
 }
 
 // list of graph applications
 type AppsList struct {
-	Apps []AppData `json:"apps"`
+	Apps       []AppData `json:"apps"`
+	Total      int       `json:"total"`
+	NextCursor string    `json:"nextCursor,omitempty"`
 }
 
 // Entity represents graph vertices
@@ -63,7 +71,9 @@ type Action struct {
 
 // list of graph entities
 type EntityList struct {
-	Entities []Entity `json:"entities"`
+	Entities   []Entity `json:"entities"`
+	Total      int      `json:"total"`
+	NextCursor string   `json:"nextCursor,omitempty"`
 }
 
 // Assoc represents graph edges or associations
@@ -85,7 +95,9 @@ type Assoc struct {
 
 // list of graph edges
 type AssocList struct {
-	Assocs []Assoc `json:"assocs"`
+	Assocs     []Assoc `json:"assocs"`
+	Total      int     `json:"total"`
+	NextCursor string  `json:"nextCursor,omitempty"`
 }
 
 // generic response
@@ -97,3 +109,57 @@ type Response struct {
 type RiskResponse struct {
 	Response string `json:"response"`
 }
+
+// Snapshot is a versioned copy of an app hypergraph taken on each ingestion
+type Snapshot struct {
+	ID       string            `json:"id"`
+	App      string            `json:"app"`
+	Version  int               `json:"version"`
+	Run      string            `json:"run,omitempty"`
+	Created  string            `json:"created"`
+	Entities map[string]Entity `json:"entities"`
+	Assocs   map[string]Assoc  `json:"assocs"`
+}
+
+// SnapshotInfo describes a snapshot without its contents
+type SnapshotInfo struct {
+	Version     int    `json:"version"`
+	Run         string `json:"run,omitempty"`
+	Created     string `json:"created"`
+	NumEntities int    `json:"numEntities"`
+	NumAssocs   int    `json:"numAssocs"`
+}
+
+// list of app snapshots
+type SnapshotList struct {
+	App       string         `json:"app"`
+	Snapshots []SnapshotInfo `json:"snapshots"`
+}
+
+// AppImport is a whole app, i.e. app metadata, entities and assocs, imported
+// at once
+type AppImport struct {
+	App      AppData  `json:"app"`
+	Entities []Entity `json:"entities"`
+	Assocs   []Assoc  `json:"assocs"`
+}
+
+// ImportChanges lists ids created, updated and removed by an import
+type ImportChanges struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Removed []string `json:"removed"`
+}
+
+// ImportReport is the outcome, or the would-be outcome on dry runs, of an import
+type ImportReport struct {
+	App        string        `json:"app"`
+	Format     string        `json:"format"`
+	DryRun     bool          `json:"dryRun"`
+	Valid      bool          `json:"valid"`
+	Errors     []string      `json:"errors"`
+	AppCreated bool          `json:"appCreated"`
+	Entities   ImportChanges `json:"entities"`
+	Assocs     ImportChanges `json:"assocs"`
+	Version    int           `json:"version,omitempty"`
+}
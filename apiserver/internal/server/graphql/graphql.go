@@ -0,0 +1,535 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	// maximum nesting of selection sets
+	MAX_DEPTH = 15
+
+	// maximum nesting of selection sets, inline fragments and input values
+	// parsed, whatever their depth once fragments are expanded
+	MAX_NESTING = 64
+
+	// maximum number of values of a result, objects, lists and scalars
+	MAX_RESULT_SIZE = 100000
+
+	// maximum size of posted requests
+	MAX_REQUEST_SIZE = 1 << 20
+)
+
+// NewGraphQL returns a new GraphQL element on a given DB
+func NewGraphQL(db db.Db) *GraphQL {
+	return &GraphQL{
+		db: db,
+	}
+}
+
+// object is a result object keeping its fields in query order
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *object) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		val, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func newError(loc Location, format string, args ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+// namedType strips list and non-null wrappers of a type reference
+func namedType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+// fieldOf returns a field of a schema type, nil if unknown
+func fieldOf(t *typeDef, name string) *fieldDef {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Execute executes a GraphQL request, returning errors without data when the
+// request is invalid
+func (q *GraphQL) Execute(req Request) Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		pe := err.(*parseError)
+		return Response{Errors: []*Error{{Message: pe.Error(), Locations: []Location{pe.loc}}}}
+	}
+
+	// select the operation
+	var op *operation
+	for _, o := range doc.operations {
+		if req.OperationName == "" || o.name == req.OperationName {
+			if op != nil {
+				return Response{Errors: []*Error{{Message: "operationName is required with multiple operations"}}}
+			}
+			op = o
+		}
+	}
+	if op == nil {
+		return Response{Errors: []*Error{{Message: fmt.Sprintf("unknown operation %v", req.OperationName)}}}
+	}
+	if op.kind != "query" {
+		return Response{Errors: []*Error{newError(op.loc, "%v operations are not supported, only queries", op.kind)}}
+	}
+
+	c := &execContext{
+		db:          q.db,
+		variables:   map[string]interface{}{},
+		fragments:   doc.fragments,
+		hypergraphs: map[string]*graph.Hypergraph{},
+		findings:    map[string][]scenarios.Finding{},
+		latest:      map[string]string{},
+	}
+	for _, v := range op.variables {
+		val, ok := req.Variables[v.name]
+		if !ok && v.defValue != nil {
+			val, _ = c.literal(v.defValue)
+			ok = true
+		}
+		if !ok || val == nil {
+			if strings.HasSuffix(v.typ, "!") {
+				return Response{Errors: []*Error{newError(op.loc, "variable $%v of type %v is required", v.name, v.typ)}}
+			}
+			continue
+		}
+		coerced, err := coerce(v.typ, val)
+		if err != nil {
+			return Response{Errors: []*Error{newError(op.loc, "variable $%v: %v", v.name, err)}}
+		}
+		c.variables[v.name] = coerced
+	}
+
+	if errs := c.validate(schema["Query"], op.selections, 1, map[string]bool{}); len(errs) > 0 {
+		return Response{Errors: errs}
+	}
+	data := c.executeSelections(schema["Query"], nil, op.selections, []interface{}{})
+	if c.size > MAX_RESULT_SIZE {
+		return Response{Errors: []*Error{newError(op.loc, "query result larger than %d values, narrow the query", MAX_RESULT_SIZE)}}
+	}
+	return Response{Data: data, Errors: c.errors}
+}
+
+// literal returns the value of an input value, substituting variables
+func (c *execContext) literal(v *value) (interface{}, error) {
+	switch v.kind {
+	case VALUE_VARIABLE:
+		return c.variables[v.variable], nil
+	case VALUE_NULL:
+		return nil, nil
+	case VALUE_LIST:
+		ret := []interface{}{}
+		for _, item := range v.list {
+			val, err := c.literal(item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, val)
+		}
+		return ret, nil
+	case VALUE_OBJECT:
+		ret := map[string]interface{}{}
+		for k, field := range v.fields {
+			val, err := c.literal(field)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = val
+		}
+		return ret, nil
+	}
+	return v.literal, nil
+}
+
+// coerce checks an input value against a scalar or enum type, converting
+// JSON numbers of Int arguments
+func coerce(typ string, v interface{}) (interface{}, error) {
+	if v == nil {
+		if strings.HasSuffix(typ, "!") {
+			return nil, fmt.Errorf("expected non-null %v", typ)
+		}
+		return nil, nil
+	}
+	typ = strings.TrimSuffix(typ, "!")
+	if strings.HasPrefix(typ, "[") {
+		items, ok := v.([]interface{})
+		if !ok {
+			items = []interface{}{v}
+		}
+		ret := []interface{}{}
+		for _, item := range items {
+			val, err := coerce(typ[1:len(typ)-1], item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, val)
+		}
+		return ret, nil
+	}
+
+	switch typ {
+	case "String", "ID":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		if typ == "ID" {
+			switch n := v.(type) {
+			case int64:
+				return fmt.Sprint(n), nil
+			case float64:
+				if n == float64(int64(n)) {
+					return fmt.Sprint(int64(n)), nil
+				}
+			}
+		}
+	case "Int":
+		switch n := v.(type) {
+		case int64:
+			return n, nil
+		case float64:
+			if n == float64(int64(n)) {
+				return int64(n), nil
+			}
+		}
+	case "Float":
+		switch n := v.(type) {
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	default:
+		if t, ok := schema[typ]; ok && t.enum != nil {
+			if s, ok := v.(string); ok {
+				for _, e := range t.enum {
+					if e == s {
+						return s, nil
+					}
+				}
+			}
+			return nil, fmt.Errorf("expected %v, one of %v", typ, strings.Join(t.enum, ", "))
+		}
+	}
+	return nil, fmt.Errorf("expected %v, got %v", typ, v)
+}
+
+// arguments returns coerced arguments of a field, with defaults
+func (c *execContext) arguments(f *fieldDef, sel *selection) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for _, a := range f.args {
+		v, ok := sel.args[a.name]
+		if !ok || (v.kind == VALUE_VARIABLE && c.variables[v.variable] == nil) {
+			if a.defValue != nil {
+				args[a.name] = a.defValue
+			} else if strings.HasSuffix(a.typ, "!") {
+				return nil, fmt.Errorf("argument %v of type %v is required", a.name, a.typ)
+			}
+			continue
+		}
+		val, err := c.literal(v)
+		if err != nil {
+			return nil, err
+		}
+		if args[a.name], err = coerce(a.typ, val); err != nil {
+			return nil, fmt.Errorf("argument %v: %v", a.name, err)
+		}
+	}
+	return args, nil
+}
+
+// included evaluates @skip and @include directives
+func (c *execContext) included(dirs []*directive) bool {
+	for _, d := range dirs {
+		v, ok := d.args["if"]
+		if !ok {
+			continue
+		}
+		val, _ := c.literal(v)
+		cond, _ := val.(bool)
+		if (d.name == "skip" && cond) || (d.name == "include" && !cond) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks selections against the schema: known fields, arguments and
+// fragments, selection sets of objects only, and nesting depth
+func (c *execContext) validate(t *typeDef, selections []*selection, depth int, spread map[string]bool) []*Error {
+	errs := []*Error{}
+	if depth > MAX_DEPTH {
+		return append(errs, newError(selections[0].loc, "query nested deeper than %d levels", MAX_DEPTH))
+	}
+	for _, sel := range selections {
+		for _, d := range sel.directives {
+			if d.name != "skip" && d.name != "include" {
+				errs = append(errs, newError(sel.loc, "unknown directive @%v", d.name))
+			} else if _, ok := d.args["if"]; !ok {
+				errs = append(errs, newError(sel.loc, "directive @%v requires argument if", d.name))
+			}
+		}
+		switch {
+		case sel.fragment != "":
+			f, ok := c.fragments[sel.fragment]
+			if !ok {
+				errs = append(errs, newError(sel.loc, "unknown fragment %q", sel.fragment))
+				continue
+			}
+			if spread[f.name] {
+				errs = append(errs, newError(sel.loc, "fragment %q spreads itself", f.name))
+				continue
+			}
+			if f.typeCond != t.name {
+				errs = append(errs, newError(sel.loc, "fragment %q on %v cannot be spread within %v", f.name, f.typeCond, t.name))
+				continue
+			}
+			spread[f.name] = true
+			errs = append(errs, c.validate(t, f.selections, depth, spread)...)
+			delete(spread, f.name)
+		case sel.name == "":
+			if sel.typeCond != "" && sel.typeCond != t.name {
+				errs = append(errs, newError(sel.loc, "inline fragment on %v cannot be spread within %v", sel.typeCond, t.name))
+				continue
+			}
+			errs = append(errs, c.validate(t, sel.selections, depth, spread)...)
+		case sel.name == "__typename":
+			if sel.selections != nil {
+				errs = append(errs, newError(sel.loc, "field __typename of type String! has no subfields"))
+			}
+		default:
+			f := fieldOf(t, sel.name)
+			if f == nil {
+				errs = append(errs, newError(sel.loc, "unknown field %q on type %v", sel.name, t.name))
+				continue
+			}
+			for name := range sel.args {
+				known := false
+				for _, a := range f.args {
+					known = known || a.name == name
+				}
+				if !known {
+					errs = append(errs, newError(sel.loc, "unknown argument %q on field %v.%v", name, t.name, f.name))
+				}
+			}
+			if _, err := c.arguments(f, sel); err != nil {
+				errs = append(errs, newError(sel.loc, "field %v.%v: %v", t.name, f.name, err))
+			}
+			named := namedType(f.typ)
+			sub, isObject := schema[named]
+			isObject = isObject && sub.enum == nil
+			switch {
+			case isObject && sel.selections == nil:
+				errs = append(errs, newError(sel.loc, "field %q of type %v must have a selection of subfields", sel.name, f.typ))
+			case !isObject && sel.selections != nil:
+				errs = append(errs, newError(sel.loc, "field %q of type %v has no subfields", sel.name, f.typ))
+			case isObject:
+				errs = append(errs, c.validate(sub, sel.selections, depth+1, spread)...)
+			}
+		}
+	}
+	return errs
+}
+
+// collectFields groups selected fields by response key, in query order,
+// expanding fragments
+func (c *execContext) collectFields(t *typeDef, selections []*selection, keys *[]string, fields map[string][]*selection) {
+	for _, sel := range selections {
+		if !c.included(sel.directives) {
+			continue
+		}
+		switch {
+		case sel.fragment != "":
+			c.collectFields(t, c.fragments[sel.fragment].selections, keys, fields)
+		case sel.name == "":
+			c.collectFields(t, sel.selections, keys, fields)
+		default:
+			key := sel.alias
+			if key == "" {
+				key = sel.name
+			}
+			if _, ok := fields[key]; !ok {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		}
+	}
+}
+
+// executeSelections resolves selected fields of an object
+func (c *execContext) executeSelections(t *typeDef, parent interface{}, selections []*selection, path []interface{}) *object {
+	keys := []string{}
+	fields := map[string][]*selection{}
+	c.collectFields(t, selections, &keys, fields)
+
+	obj := &object{values: map[string]interface{}{}}
+	for _, key := range keys {
+		if c.size > MAX_RESULT_SIZE {
+			break
+		}
+		sel := fields[key][0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if sel.name == "__typename" {
+			obj.set(key, t.name)
+			continue
+		}
+		f := fieldOf(t, sel.name)
+		v, err := c.resolve(f, parent, sel)
+		if err != nil {
+			c.errors = append(c.errors, &Error{Message: err.Error(), Locations: []Location{sel.loc}, Path: fieldPath})
+			obj.set(key, nil)
+			continue
+		}
+		sub := []*selection{}
+		for _, s := range fields[key] {
+			sub = append(sub, s.selections...)
+		}
+		obj.set(key, c.complete(f.typ, v, sub, sel.loc, fieldPath))
+	}
+	return obj
+}
+
+// resolve runs a field resolver, recovering from panics
+func (c *execContext) resolve(f *fieldDef, parent interface{}, sel *selection) (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("GraphQL resolver %v failed: %v\n", f.name, r)
+			v, err = nil, fmt.Errorf("internal error resolving %v", f.name)
+		}
+	}()
+	args, err := c.arguments(f, sel)
+	if err != nil {
+		return nil, err
+	}
+	return f.resolve(c, parent, args)
+}
+
+// complete renders a resolved value of a given type
+func (c *execContext) complete(typ string, v interface{}, selections []*selection, loc Location, path []interface{}) interface{} {
+	if strings.HasSuffix(typ, "!") {
+		ret := c.complete(strings.TrimSuffix(typ, "!"), v, selections, loc, path)
+		if ret == nil {
+			c.errors = append(c.errors, &Error{Message: "unexpected null of non-null type " + typ, Locations: []Location{loc}, Path: path})
+		}
+		return ret
+	}
+	if v == nil {
+		return nil
+	}
+
+	// results stop growing once too large, and are discarded
+	if c.size++; c.size > MAX_RESULT_SIZE {
+		return nil
+	}
+	if strings.HasPrefix(typ, "[") {
+		items := reflect.ValueOf(v)
+		if items.Kind() != reflect.Slice {
+			return nil
+		}
+		ret := make([]interface{}, 0, items.Len())
+		for i := 0; i < items.Len() && c.size <= MAX_RESULT_SIZE; i++ {
+			itemPath := append(append([]interface{}{}, path...), i)
+			ret = append(ret, c.complete(typ[1:len(typ)-1], items.Index(i).Interface(), selections, loc, itemPath))
+		}
+		return ret
+	}
+	if t, ok := schema[typ]; ok && t.enum == nil {
+		return c.executeSelections(t, v, selections, path)
+	}
+	return v
+}
+
+// Query is GET and POST handler of GraphQL queries
+func (q *GraphQL) Query(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if vars := query.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				http.Error(w, "invalid variables: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	} else {
+		body, err := io.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_SIZE))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/graphql" {
+			req.Query = string(body)
+		} else if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid GraphQL request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "missing GraphQL query", http.StatusBadRequest)
+		return
+	}
+
+	resp := q.Execute(req)
+	status := http.StatusOK
+	if resp.Data == nil {
+		status = http.StatusBadRequest
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetSchema is GET handler to retrieve the GraphQL schema
+func (q *GraphQL) GetSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, SDL())
+}
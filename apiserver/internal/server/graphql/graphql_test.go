@@ -0,0 +1,193 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"shorthand query", `{ apps { id } }`, ""},
+		{"variables and defaults", `query Q($id: ID! = "a1", $k: [String] = ["x", {a: 1.5}]) { app(id: $id) { id } }`, ""},
+		{"fragments and directives", `fragment F on App { id } { apps { ...F ... on App @skip(if: true) { name } } }`, ""},
+		{"block string", `{ app(id: """  a1 """) { id } }`, ""},
+		{"aliases and comments", "{ # apps\n  x: apps { id } }", ""},
+		{"unterminated", `{ apps { id }`, "unexpected end of document"},
+		{"extra brace", `{ apps { id } } }`, `unexpected Punctuator "}" (1:17)`},
+		{"empty selection set", `{ apps { } }`, "empty selection set"},
+		{"no operation", `fragment F on App { id }`, "document has no operation"},
+		{"duplicate fragment", `fragment F on App { id } fragment F on App { id } { apps { ...F } }`, `duplicate fragment "F"`},
+		{"duplicate argument", `{ app(id: "a", id: "b") { id } }`, `duplicate argument "id"`},
+		{"variable in default", `query ($a: ID = $b) { apps { id } }`, `unexpected Punctuator "$"`},
+		{"deep selection sets", strings.Repeat("{ a ", MAX_NESTING+1), "nested deeper than 64 levels"},
+		{"deep values", `{ app(id: ` + strings.Repeat("[", MAX_NESTING+1), "nested deeper than 64 levels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parse(tt.src)
+			if tt.err == "" {
+				if err != nil || len(doc.operations) != 1 {
+					t.Fatalf("expecting one operation, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expecting error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// newTestDb returns a DB of an app of n entities all reaching one another,
+// and of attack graphs of the app
+func newTestDb(t *testing.T, n int) db.Db {
+	t.Helper()
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS, graph.DB_TABLE_SNAPSHOTS)
+	if err := graph.CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	graph.Apps(d).Put("a1", graph.AppData{ID: "a1", Name: "app"})
+	ids := []string{}
+	for i := 0; i < n; i++ {
+		ids = append(ids, fmt.Sprintf("e%d", i))
+		key := graph.GetEntityKey("a1", ids[i])
+		graph.Entities(d).Put(key, graph.Entity{ID: key, Kind: "vm", Attributes: map[string]string{"env": "prod"}})
+	}
+	key := graph.GetEntityKey("a1", "s1")
+	graph.Assocs(d).Put(key, graph.Assoc{ID: key, Label: "net", FromEntities: ids, ToEntities: ids})
+
+	// attack graphs created at 08:00 and 09:00 UTC
+	for id, created := range map[string]string{"ag1": "2024-05-01T10:00:00+02:00", "ag2": "2024-05-01T09:00:00Z"} {
+		graph.Apps(d).Put(id, graph.AppData{ID: id, Type: scenarios.APP_TYPE_ATTACK_GRAPH, Created: created,
+			Attributes: map[string]interface{}{scenarios.ATTR_SOURCE_APP: "a1"}})
+	}
+	return d
+}
+
+func TestExecute(t *testing.T) {
+	q := NewGraphQL(newTestDb(t, 3))
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		data      string
+		err       string
+	}{
+		{
+			"fields and aliases",
+			`{ apps { id n: name stats { numRuns } } }`, nil,
+			`{"apps":[{"id":"a1","n":"app","stats":{"numRuns":0}}]}`, "",
+		},
+		{
+			"variables, arguments and fragments",
+			`query ($id: ID!) { app(id: $id) { ...E } } fragment E on App { entity(id: "e1") { kind attribute(key: "env") } }`,
+			map[string]interface{}{"id": "a1"},
+			`{"app":{"entity":{"kind":"vm","attribute":"prod"}}}`, "",
+		},
+		{
+			"directives",
+			`query ($x: Boolean!) { apps { id @include(if: $x) name @skip(if: $x) } }`,
+			map[string]interface{}{"x": false},
+			`{"apps":[{"name":"app"}]}`, "",
+		},
+		{
+			"traversal",
+			`{ app(id: "a1") { entity(id: "e0") { neighbors { id } } } }`, nil,
+			`{"app":{"entity":{"neighbors":[{"id":"e1"},{"id":"e2"}]}}}`, "",
+		},
+		{
+			"attack graphs by creation time",
+			`{ attackGraphs(app: "a1") { id } }`, nil,
+			`{"attackGraphs":[{"id":"ag1"},{"id":"ag2"}]}`, "",
+		},
+		{
+			"unknown app",
+			`{ app(id: "a2") { id } }`, nil,
+			`{"app":null}`, "",
+		},
+		{"unknown field", `{ apps { owner } }`, nil, "", `unknown field "owner" on type App`},
+		{"missing subfields", `{ apps }`, nil, "", `must have a selection of subfields`},
+		{"missing variable", `query ($id: ID!) { app(id: $id) { id } }`, nil, "", `variable $id of type ID! is required`},
+		{"mutation", `mutation { apps { id } }`, nil, "", `mutation operations are not supported`},
+		{
+			"too deep",
+			`{ app(id: "a1") { entities ` + strings.Repeat(`{ neighbors `, MAX_DEPTH) + `{ id }` + strings.Repeat(` }`, MAX_DEPTH+1) + ` }`, nil,
+			"", fmt.Sprintf("nested deeper than %d levels", MAX_DEPTH),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := q.Execute(Request{Query: tt.query, Variables: tt.variables})
+			if tt.err != "" {
+				if resp.Data != nil || len(resp.Errors) == 0 || !strings.Contains(resp.Errors[0].Message, tt.err) {
+					t.Fatalf("expecting error %q without data, got %+v", tt.err, resp)
+				}
+				return
+			}
+			if len(resp.Errors) > 0 {
+				t.Fatalf("expecting no errors, got %v", resp.Errors[0].Message)
+			}
+			data, _ := json.Marshal(resp.Data)
+			if string(data) != tt.data {
+				t.Fatalf("expecting %v, got %v", tt.data, string(data))
+			}
+		})
+	}
+}
+
+func TestResultSize(t *testing.T) {
+	// entities reach 9 others each, so that 5 hops reach more than
+	// MAX_RESULT_SIZE entities
+	q := NewGraphQL(newTestDb(t, 10))
+	query := `{ app(id: "a1") { entities ` + strings.Repeat(`{ neighbors `, 5) + `{ id }` + strings.Repeat(` }`, 6) + ` }`
+	resp := q.Execute(Request{Query: query})
+	if resp.Data != nil || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, "larger than") {
+		t.Fatalf("expecting a result size error without data, got %+v", resp.Errors)
+	}
+
+	// narrower queries of the same depth are served
+	query = strings.Replace(query, "entities", `entity(id: "e0")`, 1)
+	query = strings.Replace(query, "{ neighbors ", "", 1)
+	query = strings.Replace(query, " }", "", 1)
+	if resp := q.Execute(Request{Query: query}); resp.Data == nil || len(resp.Errors) > 0 {
+		t.Fatalf("expecting data, got %+v", resp.Errors)
+	}
+}
+
+// scanCountingDb counts range scans of a table
+type scanCountingDb struct {
+	db.Db
+	table string
+	scans int
+}
+
+func (d *scanCountingDb) Range(table, start, end string) []db.KeyValue {
+	if table == d.table {
+		d.scans++
+	}
+	return d.Db.Range(table, start, end)
+}
+
+func TestLatestAttackGraphCached(t *testing.T) {
+	// findings of every entity are of the same latest attack graph, looked
+	// up once per query
+	d := &scanCountingDb{Db: newTestDb(t, 5), table: graph.DB_TABLE_GRAPH}
+	q := NewGraphQL(d)
+	resp := q.Execute(Request{Query: `{ app(id: "a1") { findings { id } entities { findings { id } } } }`})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("expecting no errors, got %v", resp.Errors[0].Message)
+	}
+	if d.scans != 1 {
+		t.Fatalf("expecting apps scanned once, got %v scans", d.scans)
+	}
+}
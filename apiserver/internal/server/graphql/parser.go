@@ -0,0 +1,523 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// token kinds
+const (
+	TOKEN_EOF    = "EOF"
+	TOKEN_PUNCT  = "Punctuator"
+	TOKEN_NAME   = "Name"
+	TOKEN_INT    = "Int"
+	TOKEN_FLOAT  = "Float"
+	TOKEN_STRING = "String"
+)
+
+// value kinds
+const (
+	VALUE_VARIABLE = "Variable"
+	VALUE_INT      = "Int"
+	VALUE_FLOAT    = "Float"
+	VALUE_STRING   = "String"
+	VALUE_BOOLEAN  = "Boolean"
+	VALUE_NULL     = "Null"
+	VALUE_ENUM     = "Enum"
+	VALUE_LIST     = "List"
+	VALUE_OBJECT   = "Object"
+)
+
+type token struct {
+	kind  string
+	value string
+	loc   Location
+}
+
+// parser is a recursive descent parser of GraphQL executable documents
+type parser struct {
+	src  string
+	pos  int
+	line int
+	col  int
+	tok  token
+
+	// nesting of selection sets and input values being parsed
+	depth int
+}
+
+// parseError is a syntax error at a query location
+type parseError struct {
+	msg string
+	loc Location
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("Syntax Error: %s (%d:%d)", e.msg, e.loc.Line, e.loc.Column)
+}
+
+// parse parses a GraphQL query document
+func parse(src string) (doc *document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pe, ok := r.(*parseError)
+			if !ok {
+				panic(r)
+			}
+			err = pe
+		}
+	}()
+
+	p := &parser{src: src, line: 1, col: 1}
+	p.next()
+	doc = &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != TOKEN_EOF {
+		switch {
+		case p.peek(TOKEN_PUNCT, "{"):
+			doc.operations = append(doc.operations, &operation{kind: "query", loc: p.tok.loc, selections: p.selectionSet()})
+		case p.peek(TOKEN_NAME, "fragment"):
+			f := p.fragmentDef()
+			if _, ok := doc.fragments[f.name]; ok {
+				p.fail(f.loc, fmt.Sprintf("duplicate fragment %q", f.name))
+			}
+			doc.fragments[f.name] = f
+		case p.peek(TOKEN_NAME, "query"), p.peek(TOKEN_NAME, "mutation"), p.peek(TOKEN_NAME, "subscription"):
+			doc.operations = append(doc.operations, p.operationDef())
+		default:
+			p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		p.fail(p.tok.loc, "document has no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) fail(loc Location, msg string) {
+	panic(&parseError{msg: msg, loc: loc})
+}
+
+func (p *parser) unexpected() {
+	if p.tok.kind == TOKEN_EOF {
+		p.fail(p.tok.loc, "unexpected end of document")
+	}
+	p.fail(p.tok.loc, fmt.Sprintf("unexpected %s %q", p.tok.kind, p.tok.value))
+}
+
+// advance moves past a rune, tracking lines and columns
+func (p *parser) advance() rune {
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	if r == '\n' {
+		p.line++
+		p.col = 1
+	} else {
+		p.col++
+	}
+	return r
+}
+
+// next reads the next token, skipping whitespace, commas and comments
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.advance()
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' && !strings.HasPrefix(p.src[p.pos:], "\ufeff") {
+			break
+		}
+		p.advance()
+	}
+	loc := Location{Line: p.line, Column: p.col}
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: TOKEN_EOF, loc: loc}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.advance()
+		p.advance()
+		p.advance()
+		p.tok = token{kind: TOKEN_PUNCT, value: "...", loc: loc}
+	case strings.ContainsRune("!$():=@[]{}|&", rune(c)):
+		p.advance()
+		p.tok = token{kind: TOKEN_PUNCT, value: string(c), loc: loc}
+	case c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z'):
+		start := p.pos
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.advance()
+		}
+		p.tok = token{kind: TOKEN_NAME, value: p.src[start:p.pos], loc: loc}
+	case c == '-' || (c >= '0' && c <= '9'):
+		p.tok = p.number(loc)
+	case c == '"':
+		p.tok = token{kind: TOKEN_STRING, value: p.str(loc), loc: loc}
+	default:
+		p.fail(loc, fmt.Sprintf("unexpected character %q", c))
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// number reads an Int or Float token
+func (p *parser) number(loc Location) token {
+	start := p.pos
+	kind := TOKEN_INT
+	digits := func() {
+		if p.pos >= len(p.src) || !isDigit(p.src[p.pos]) {
+			p.fail(Location{Line: p.line, Column: p.col}, "invalid number")
+		}
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.advance()
+		}
+	}
+	if p.src[p.pos] == '-' {
+		p.advance()
+	}
+	digits()
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		kind = TOKEN_FLOAT
+		p.advance()
+		digits()
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		kind = TOKEN_FLOAT
+		p.advance()
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.advance()
+		}
+		digits()
+	}
+	return token{kind: kind, value: p.src[start:p.pos], loc: loc}
+}
+
+// str reads a string or block string token, returning its value
+func (p *parser) str(loc Location) string {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		p.advance()
+		p.advance()
+		p.advance()
+		end := strings.Index(p.src[p.pos:], `"""`)
+		if end < 0 {
+			p.fail(loc, "unterminated string")
+		}
+		raw := p.src[p.pos : p.pos+end]
+		for p.pos < len(p.src) && !strings.HasPrefix(p.src[p.pos:], `"""`) {
+			p.advance()
+		}
+		p.advance()
+		p.advance()
+		p.advance()
+		return blockString(raw)
+	}
+
+	p.advance()
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			p.fail(loc, "unterminated string")
+		}
+		r := p.advance()
+		switch r {
+		case '"':
+			return b.String()
+		case '\\':
+			if p.pos >= len(p.src) {
+				p.fail(loc, "unterminated string")
+			}
+			esc := p.advance()
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteRune(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					p.fail(loc, "invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					p.fail(loc, "invalid unicode escape")
+				}
+				for i := 0; i < 4; i++ {
+					p.advance()
+				}
+				b.WriteRune(rune(code))
+			default:
+				p.fail(loc, fmt.Sprintf("invalid escape \\%c", esc))
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+// blockString strips common indentation and blank first and last lines
+func blockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, l := range lines[1:] {
+		trimmed := strings.TrimLeft(l, " \t")
+		if trimmed != "" && (indent < 0 || len(l)-len(trimmed) < indent) {
+			indent = len(l) - len(trimmed)
+		}
+	}
+	for i := 1; i < len(lines) && indent > 0; i++ {
+		if len(lines[i]) >= indent {
+			lines[i] = lines[i][indent:]
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *parser) peek(kind, val string) bool {
+	return p.tok.kind == kind && p.tok.value == val
+}
+
+// expect consumes a given punctuator or keyword
+func (p *parser) expect(kind, val string) {
+	if !p.peek(kind, val) {
+		p.unexpected()
+	}
+	p.next()
+}
+
+// skip consumes a given punctuator if present
+func (p *parser) skip(val string) bool {
+	if p.peek(TOKEN_PUNCT, val) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) name() string {
+	if p.tok.kind != TOKEN_NAME {
+		p.unexpected()
+	}
+	name := p.tok.value
+	p.next()
+	return name
+}
+
+func (p *parser) operationDef() *operation {
+	op := &operation{kind: p.tok.value, loc: p.tok.loc}
+	p.next()
+	if p.tok.kind == TOKEN_NAME {
+		op.name = p.name()
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			p.expect(TOKEN_PUNCT, "$")
+			v := &variableDef{name: p.name()}
+			p.expect(TOKEN_PUNCT, ":")
+			v.typ = p.typeRef()
+			if p.skip("=") {
+				v.defValue = p.value(true)
+			}
+			op.variables = append(op.variables, v)
+		}
+	}
+	p.directives()
+	op.selections = p.selectionSet()
+	return op
+}
+
+func (p *parser) fragmentDef() *fragment {
+	f := &fragment{loc: p.tok.loc}
+	p.next()
+	if p.peek(TOKEN_NAME, "on") {
+		p.unexpected()
+	}
+	f.name = p.name()
+	p.expect(TOKEN_NAME, "on")
+	f.typeCond = p.name()
+	p.directives()
+	f.selections = p.selectionSet()
+	return f
+}
+
+// typeRef reads a type reference, e.g. [String!]!
+func (p *parser) typeRef() string {
+	var typ string
+	if p.skip("[") {
+		typ = "[" + p.typeRef() + "]"
+		p.expect(TOKEN_PUNCT, "]")
+	} else {
+		typ = p.name()
+	}
+	if p.skip("!") {
+		typ += "!"
+	}
+	return typ
+}
+
+// nest enters a selection set or input value, failing when nested too deep
+func (p *parser) nest() {
+	if p.depth++; p.depth > MAX_NESTING {
+		p.fail(p.tok.loc, fmt.Sprintf("document nested deeper than %d levels", MAX_NESTING))
+	}
+}
+
+func (p *parser) selectionSet() []*selection {
+	p.nest()
+	defer func() { p.depth-- }()
+	p.expect(TOKEN_PUNCT, "{")
+	selections := []*selection{}
+	for !p.skip("}") {
+		selections = append(selections, p.selection())
+	}
+	if len(selections) == 0 {
+		p.fail(p.tok.loc, "empty selection set")
+	}
+	return selections
+}
+
+func (p *parser) selection() *selection {
+	s := &selection{loc: p.tok.loc}
+	if p.skip("...") {
+		if p.tok.kind == TOKEN_NAME && p.tok.value != "on" {
+			s.fragment = p.name()
+			s.directives = p.directives()
+			return s
+		}
+		if p.peek(TOKEN_NAME, "on") {
+			p.next()
+			s.typeCond = p.name()
+		}
+		s.directives = p.directives()
+		s.selections = p.selectionSet()
+		return s
+	}
+
+	s.name = p.name()
+	if p.skip(":") {
+		s.alias = s.name
+		s.name = p.name()
+	}
+	s.args = p.arguments(false)
+	s.directives = p.directives()
+	if p.peek(TOKEN_PUNCT, "{") {
+		s.selections = p.selectionSet()
+	}
+	return s
+}
+
+func (p *parser) arguments(constant bool) map[string]*value {
+	args := map[string]*value{}
+	if !p.skip("(") {
+		return args
+	}
+	for !p.skip(")") {
+		loc := p.tok.loc
+		name := p.name()
+		if _, ok := args[name]; ok {
+			p.fail(loc, fmt.Sprintf("duplicate argument %q", name))
+		}
+		p.expect(TOKEN_PUNCT, ":")
+		args[name] = p.value(constant)
+	}
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	dirs := []*directive{}
+	for p.skip("@") {
+		d := &directive{name: p.name()}
+		d.args = p.arguments(false)
+		dirs = append(dirs, d)
+	}
+	return dirs
+}
+
+// value reads an input value, variables are not allowed in constants
+func (p *parser) value(constant bool) *value {
+	v := &value{loc: p.tok.loc}
+	switch p.tok.kind {
+	case TOKEN_PUNCT:
+		switch p.tok.value {
+		case "$":
+			if constant {
+				p.unexpected()
+			}
+			p.next()
+			v.kind, v.variable = VALUE_VARIABLE, p.name()
+			return v
+		case "[":
+			p.nest()
+			defer func() { p.depth-- }()
+			p.next()
+			v.kind, v.list = VALUE_LIST, []*value{}
+			for !p.skip("]") {
+				v.list = append(v.list, p.value(constant))
+			}
+			return v
+		case "{":
+			p.nest()
+			defer func() { p.depth-- }()
+			p.next()
+			v.kind, v.fields = VALUE_OBJECT, map[string]*value{}
+			for !p.skip("}") {
+				name := p.name()
+				p.expect(TOKEN_PUNCT, ":")
+				v.fields[name] = p.value(constant)
+			}
+			return v
+		}
+	case TOKEN_INT:
+		n, err := strconv.ParseInt(p.tok.value, 10, 64)
+		if err != nil {
+			p.fail(v.loc, "invalid Int "+p.tok.value)
+		}
+		v.kind, v.literal = VALUE_INT, n
+	case TOKEN_FLOAT:
+		f, err := strconv.ParseFloat(p.tok.value, 64)
+		if err != nil {
+			p.fail(v.loc, "invalid Float "+p.tok.value)
+		}
+		v.kind, v.literal = VALUE_FLOAT, f
+	case TOKEN_STRING:
+		v.kind, v.literal = VALUE_STRING, p.tok.value
+	case TOKEN_NAME:
+		switch p.tok.value {
+		case "true", "false":
+			v.kind, v.literal = VALUE_BOOLEAN, p.tok.value == "true"
+		case "null":
+			v.kind = VALUE_NULL
+		default:
+			v.kind, v.literal = VALUE_ENUM, p.tok.value
+		}
+	default:
+		p.unexpected()
+	}
+	p.next()
+	return v
+}
@@ -0,0 +1,646 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	// neighbor directions of entities
+	DIRECTION_OUT  = "OUT"
+	DIRECTION_IN   = "IN"
+	DIRECTION_BOTH = "BOTH"
+)
+
+// schema types by name, scalars are not listed
+var schema = map[string]*typeDef{}
+
+// scalar types
+var scalars = map[string]bool{"String": true, "ID": true, "Int": true, "Float": true, "Boolean": true}
+
+// schemaOrder lists schema types in SDL order
+var schemaOrder = []string{"Query", "App", "Stats", "Entity", "Assoc", "AttackGraph", "Finding", "Attribute", "Direction"}
+
+func init() {
+	for _, t := range []*typeDef{queryType, appType, statsType, entityType, assocType, attackGraphType, findingType, attributeType, directionType} {
+		schema[t.name] = t
+	}
+}
+
+// hypergraph returns the cached hypergraph of an app
+func (c *execContext) hypergraph(aid string) *graph.Hypergraph {
+	h, ok := c.hypergraphs[aid]
+	if !ok {
+		h = graph.LoadHypergraph(c.db, aid)
+		c.hypergraphs[aid] = h
+	}
+	return h
+}
+
+// attackGraphFindings returns the cached findings of an attack graph
+func (c *execContext) attackGraphFindings(agid string) []scenarios.Finding {
+	f, ok := c.findings[agid]
+	if !ok {
+		f = scenarios.NewScenario(c.db).Findings(agid)
+		c.findings[agid] = f
+	}
+	return f
+}
+
+// latestAttackGraph returns the cached id of the latest attack graph of an
+// app, empty if none
+func (c *execContext) latestAttackGraph(aid string) string {
+	agid, ok := c.latest[aid]
+	if !ok {
+		ag, _ := scenarios.NewScenario(c.db).LatestAttackGraph(aid)
+		agid = ag.ID
+		c.latest[aid] = agid
+	}
+	return agid
+}
+
+// app returns an app or attack graph by id, nil if absent, failing when its
+// record does not decode
+func (c *execContext) app(aid string) (interface{}, error) {
+	a, err := graph.Apps(c.db).Get(aid)
+	if errors.Is(err, db.ErrRecord) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// entity returns an entity of an app by id, nil if absent
+func (c *execContext) entity(aid, eid string) interface{} {
+	e, ok := c.hypergraph(aid).Entities[graph.TrimAppPrefix(aid, eid)]
+	if !ok {
+		return nil
+	}
+	return entityRef{app: aid, entity: e}
+}
+
+// entities returns entities of an app by id, skipping absent ones
+func (c *execContext) entities(aid string, eids []string) []interface{} {
+	ret := []interface{}{}
+	for _, eid := range eids {
+		if e := c.entity(aid, eid); e != nil {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+// findingsOf returns findings of an attack graph at or above a minimum risk,
+// optionally of an entity
+func (c *execContext) findingsOf(aid, agid, minRisk, eid string) []interface{} {
+	ret := []interface{}{}
+	for _, f := range c.attackGraphFindings(agid) {
+		if eid != "" && f.Entity != eid {
+			continue
+		}
+		if minRisk != "" && scenarios.RiskRank(f.Risk) < scenarios.RiskRank(minRisk) {
+			continue
+		}
+		ret = append(ret, findingRef{app: aid, attackGraph: agid, finding: f})
+	}
+	return ret
+}
+
+// latestFindings returns findings of the latest attack graph of an app
+func (c *execContext) latestFindings(aid, minRisk, eid string) []interface{} {
+	agid := c.latestAttackGraph(aid)
+	if agid == "" {
+		return []interface{}{}
+	}
+	return c.findingsOf(aid, agid, minRisk, eid)
+}
+
+// attributes returns attributes sorted by key
+func attributes(attrs map[string]string) []interface{} {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ret := []interface{}{}
+	for _, k := range keys {
+		ret = append(ret, attribute{key: k, value: attrs[k]})
+	}
+	return ret
+}
+
+// anyAttributes returns attributes of any value type sorted by key
+func anyAttributes(attrs map[string]interface{}) []interface{} {
+	strs := map[string]string{}
+	for k, v := range attrs {
+		strs[k] = fmt.Sprint(v)
+	}
+	return attributes(strs)
+}
+
+func strArg(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+// validRisk checks a minimum risk argument
+func validRisk(risk string) error {
+	if risk != "" && scenarios.RiskRank(risk) < 0 {
+		return fmt.Errorf("invalid risk %v, expected one of %v", risk, strings.Join(scenarios.RiskLevels, ", "))
+	}
+	return nil
+}
+
+// field returns a field resolving a property of its parent
+func field(name, typ, description string, get func(parent interface{}) interface{}) *fieldDef {
+	return &fieldDef{
+		name:        name,
+		typ:         typ,
+		description: description,
+		resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+			return get(parent), nil
+		},
+	}
+}
+
+var queryType = &typeDef{
+	name:        "Query",
+	description: "apps of the tenant, with their hypergraphs, attack graphs and findings",
+	fields: []*fieldDef{
+		{
+			name:        "apps",
+			typ:         "[App!]!",
+			description: "apps sorted by id, attack graphs excluded",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				all, err := graph.Apps(c.db).List()
+				if err != nil {
+					return nil, err
+				}
+				apps := []graph.AppData{}
+				for _, a := range all {
+					if a.Type != scenarios.APP_TYPE_ATTACK_GRAPH {
+						apps = append(apps, a)
+					}
+				}
+				sort.Slice(apps, func(i, j int) bool { return apps[i].ID < apps[j].ID })
+				return apps, nil
+			},
+		},
+		{
+			name:        "app",
+			typ:         "App",
+			description: "app by id",
+			args:        []*argDef{{name: "id", typ: "ID!"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				app, err := c.app(strArg(args, "id"))
+				if a, ok := app.(graph.AppData); ok && a.Type != scenarios.APP_TYPE_ATTACK_GRAPH {
+					return a, nil
+				}
+				return nil, err
+			},
+		},
+		{
+			name:        "attackGraphs",
+			typ:         "[AttackGraph!]!",
+			description: "attack graphs sorted by creation, optionally of an app",
+			args:        []*argDef{{name: "app", typ: "ID"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				aid := strArg(args, "app")
+				all, err := graph.Apps(c.db).List()
+				if err != nil {
+					return nil, err
+				}
+				ags := []graph.AppData{}
+				for _, a := range all {
+					if a.Type != scenarios.APP_TYPE_ATTACK_GRAPH {
+						continue
+					}
+					if aid == "" || a.Attributes[scenarios.ATTR_SOURCE_APP] == aid {
+						ags = append(ags, a)
+					}
+				}
+				sort.Slice(ags, func(i, j int) bool {
+					ti, tj := graph.ParseTime(ags[i].Created), graph.ParseTime(ags[j].Created)
+					if !ti.Equal(tj) {
+						return ti.Before(tj)
+					}
+					return ags[i].ID < ags[j].ID
+				})
+				return ags, nil
+			},
+		},
+		{
+			name:        "attackGraph",
+			typ:         "AttackGraph",
+			description: "attack graph by id",
+			args:        []*argDef{{name: "id", typ: "ID!"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				app, err := c.app(strArg(args, "id"))
+				if a, ok := app.(graph.AppData); ok && a.Type == scenarios.APP_TYPE_ATTACK_GRAPH {
+					return a, nil
+				}
+				return nil, err
+			},
+		},
+	},
+}
+
+var appType = &typeDef{
+	name:        "App",
+	description: "an app hypergraph",
+	fields: []*fieldDef{
+		field("id", "ID!", "", func(p interface{}) interface{} { return p.(graph.AppData).ID }),
+		field("name", "String", "", func(p interface{}) interface{} { return p.(graph.AppData).Name }),
+		field("type", "String", "", func(p interface{}) interface{} { return p.(graph.AppData).Type }),
+		field("description", "String", "", func(p interface{}) interface{} { return p.(graph.AppData).Description }),
+		field("created", "String", "", func(p interface{}) interface{} { return p.(graph.AppData).Created }),
+		field("lastModified", "String", "", func(p interface{}) interface{} { return p.(graph.AppData).LastModified }),
+		field("attributes", "[Attribute!]!", "", func(p interface{}) interface{} { return anyAttributes(p.(graph.AppData).Attributes) }),
+		field("stats", "Stats!", "evaluation run metadata", func(p interface{}) interface{} { return p.(graph.AppData).Stats }),
+		{
+			name:        "entities",
+			typ:         "[Entity!]!",
+			description: "top level entities sorted by id, optionally of a kind",
+			args:        []*argDef{{name: "kind", typ: "String"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				aid := parent.(graph.AppData).ID
+				kind := strArg(args, "kind")
+				ret := []interface{}{}
+				entities := graph.AppEntities(c.db, aid)
+				if kind != "" {
+					entities = graph.EntitiesOfKind(c.db, aid, kind)
+				}
+				for _, e := range entities {
+					e.ID = graph.TrimAppPrefix(aid, e.ID)
+					ret = append(ret, entityRef{app: aid, entity: e})
+				}
+				sort.Slice(ret, func(i, j int) bool { return ret[i].(entityRef).entity.ID < ret[j].(entityRef).entity.ID })
+				return ret, nil
+			},
+		},
+		{
+			name:        "entity",
+			typ:         "Entity",
+			description: "entity by id, nested entities included",
+			args:        []*argDef{{name: "id", typ: "ID!"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				return c.entity(parent.(graph.AppData).ID, strArg(args, "id")), nil
+			},
+		},
+		{
+			name:        "assocs",
+			typ:         "[Assoc!]!",
+			description: "hyperedges sorted by id, optionally of a label",
+			args:        []*argDef{{name: "label", typ: "String"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				aid := parent.(graph.AppData).ID
+				label := strArg(args, "label")
+				h := c.hypergraph(aid)
+				ret := []interface{}{}
+				for _, a := range h.Assocs {
+					if label == "" || a.Label == label {
+						ret = append(ret, assocRef{app: aid, assoc: a})
+					}
+				}
+				sort.Slice(ret, func(i, j int) bool { return ret[i].(assocRef).assoc.ID < ret[j].(assocRef).assoc.ID })
+				return ret, nil
+			},
+		},
+		{
+			name:        "assoc",
+			typ:         "Assoc",
+			description: "hyperedge by id",
+			args:        []*argDef{{name: "id", typ: "ID!"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				aid := parent.(graph.AppData).ID
+				if a, ok := c.hypergraph(aid).Assocs[strArg(args, "id")]; ok {
+					return assocRef{app: aid, assoc: a}, nil
+				}
+				return nil, nil
+			},
+		},
+		{
+			name:        "attackGraph",
+			typ:         "AttackGraph",
+			description: "latest attack graph",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				if ag, ok := scenarios.NewScenario(c.db).LatestAttackGraph(parent.(graph.AppData).ID); ok {
+					return ag, nil
+				}
+				return nil, nil
+			},
+		},
+		{
+			name:        "findings",
+			typ:         "[Finding!]!",
+			description: "findings of the latest attack graph, at or above a minimum risk",
+			args:        []*argDef{{name: "risk", typ: "String"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				risk := strArg(args, "risk")
+				if err := validRisk(risk); err != nil {
+					return nil, err
+				}
+				return c.latestFindings(parent.(graph.AppData).ID, risk, ""), nil
+			},
+		},
+	},
+}
+
+var statsType = &typeDef{
+	name:        "Stats",
+	description: "app evaluation run metadata",
+	fields: []*fieldDef{
+		field("numRuns", "Int!", "", func(p interface{}) interface{} { return p.(graph.Stats).NumRuns }),
+		field("runTimestamps", "[Int!]!", "unix seconds of runs", func(p interface{}) interface{} { return p.(graph.Stats).RunTS }),
+		field("lastAttackGraph", "String", "", func(p interface{}) interface{} { return p.(graph.Stats).LastAttackGraph }),
+		field("lastScore", "Float", "", func(p interface{}) interface{} { return p.(graph.Stats).LastScore }),
+	},
+}
+
+var entityType = &typeDef{
+	name:        "Entity",
+	description: "a hypergraph vertex",
+	fields: []*fieldDef{
+		field("id", "ID!", "", func(p interface{}) interface{} { return p.(entityRef).entity.ID }),
+		field("name", "String", "", func(p interface{}) interface{} { return p.(entityRef).entity.Name }),
+		field("description", "String", "", func(p interface{}) interface{} { return p.(entityRef).entity.Description }),
+		field("kind", "String", "", func(p interface{}) interface{} { return p.(entityRef).entity.Kind }),
+		field("created", "String", "", func(p interface{}) interface{} { return p.(entityRef).entity.Created }),
+		field("lastModified", "String", "", func(p interface{}) interface{} { return p.(entityRef).entity.LastModified }),
+		field("attributes", "[Attribute!]!", "", func(p interface{}) interface{} { return attributes(p.(entityRef).entity.Attributes) }),
+		{
+			name:        "attribute",
+			typ:         "String",
+			description: "attribute value by key",
+			args:        []*argDef{{name: "key", typ: "String!"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				if v, ok := parent.(entityRef).entity.Attributes[strArg(args, "key")]; ok {
+					return v, nil
+				}
+				return nil, nil
+			},
+		},
+		{
+			name:        "entities",
+			typ:         "[Entity!]!",
+			description: "nested entities",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				e := parent.(entityRef)
+				ret := []interface{}{}
+				for _, child := range e.entity.Entities {
+					ret = append(ret, entityRef{app: e.app, entity: child})
+				}
+				return ret, nil
+			},
+		},
+		{
+			name:        "app",
+			typ:         "App",
+			description: "",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				return c.app(parent.(entityRef).app)
+			},
+		},
+		{
+			name:        "assocs",
+			typ:         "[Assoc!]!",
+			description: "hyperedges the entity is a member of, sorted by id",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				e := parent.(entityRef)
+				ret := []interface{}{}
+				for _, a := range c.hypergraph(e.app).Assocs {
+					for _, m := range a.Members() {
+						if graph.TrimAppPrefix(e.app, m) == e.entity.ID {
+							ret = append(ret, assocRef{app: e.app, assoc: a})
+							break
+						}
+					}
+				}
+				sort.Slice(ret, func(i, j int) bool { return ret[i].(assocRef).assoc.ID < ret[j].(assocRef).assoc.ID })
+				return ret, nil
+			},
+		},
+		{
+			name:        "neighbors",
+			typ:         "[Entity!]!",
+			description: "entities one hop away through hyperedges or containment, sorted by id",
+			args:        []*argDef{{name: "direction", typ: "Direction", defValue: DIRECTION_OUT}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				e := parent.(entityRef)
+				h := c.hypergraph(e.app)
+				dir := strArg(args, "direction")
+				ids := map[string]bool{}
+				if dir == DIRECTION_OUT || dir == DIRECTION_BOTH {
+					for _, hop := range h.Next(e.entity.ID) {
+						ids[hop.To] = true
+					}
+				}
+				if dir == DIRECTION_IN || dir == DIRECTION_BOTH {
+					for eid := range h.Entities {
+						for _, hop := range h.Next(eid) {
+							if hop.To == e.entity.ID {
+								ids[eid] = true
+							}
+						}
+					}
+				}
+				delete(ids, e.entity.ID)
+				sorted := make([]string, 0, len(ids))
+				for eid := range ids {
+					sorted = append(sorted, eid)
+				}
+				sort.Strings(sorted)
+				return c.entities(e.app, sorted), nil
+			},
+		},
+		{
+			name:        "findings",
+			typ:         "[Finding!]!",
+			description: "findings of the latest attack graph of the app on the entity, at or above a minimum risk",
+			args:        []*argDef{{name: "risk", typ: "String"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				risk := strArg(args, "risk")
+				if err := validRisk(risk); err != nil {
+					return nil, err
+				}
+				e := parent.(entityRef)
+				return c.latestFindings(e.app, risk, e.entity.ID), nil
+			},
+		},
+	},
+}
+
+var assocType = &typeDef{
+	name:        "Assoc",
+	description: "a hyperedge between from, to and other members",
+	fields: []*fieldDef{
+		field("id", "ID!", "", func(p interface{}) interface{} { return p.(assocRef).assoc.ID }),
+		field("name", "String", "", func(p interface{}) interface{} { return p.(assocRef).assoc.Name }),
+		field("description", "String", "", func(p interface{}) interface{} { return p.(assocRef).assoc.Description }),
+		field("label", "String", "", func(p interface{}) interface{} { return p.(assocRef).assoc.Label }),
+		field("created", "String", "", func(p interface{}) interface{} { return p.(assocRef).assoc.Created }),
+		field("lastModified", "String", "", func(p interface{}) interface{} { return p.(assocRef).assoc.LastModified }),
+		field("attributes", "[Attribute!]!", "", func(p interface{}) interface{} { return anyAttributes(p.(assocRef).assoc.Attributes) }),
+		field("permissions", "[String!]!", "permissions granted by the hyperedge", func(p interface{}) interface{} { return p.(assocRef).assoc.Permissions() }),
+		{
+			name: "from",
+			typ:  "[Entity!]!",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				a := parent.(assocRef)
+				return c.entities(a.app, a.assoc.FromEntities), nil
+			},
+		},
+		{
+			name: "to",
+			typ:  "[Entity!]!",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				a := parent.(assocRef)
+				return c.entities(a.app, a.assoc.ToEntities), nil
+			},
+		},
+		{
+			name: "other",
+			typ:  "[Entity!]!",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				a := parent.(assocRef)
+				return c.entities(a.app, a.assoc.OtherEntities), nil
+			},
+		},
+		{
+			name:        "members",
+			typ:         "[Entity!]!",
+			description: "from, to and other members",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				a := parent.(assocRef)
+				return c.entities(a.app, a.assoc.Members()), nil
+			},
+		},
+	},
+}
+
+var attackGraphType = &typeDef{
+	name:        "AttackGraph",
+	description: "attack scenarios generated from an app evaluation",
+	fields: []*fieldDef{
+		field("id", "ID!", "", func(p interface{}) interface{} { return p.(graph.AppData).ID }),
+		field("name", "String", "", func(p interface{}) interface{} { return p.(graph.AppData).Name }),
+		field("created", "String", "", func(p interface{}) interface{} { return p.(graph.AppData).Created }),
+		{
+			name:        "app",
+			typ:         "App",
+			description: "evaluated app",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				aid, _ := parent.(graph.AppData).Attributes[scenarios.ATTR_SOURCE_APP].(string)
+				return c.app(aid)
+			},
+		},
+		{
+			name:        "findings",
+			typ:         "[Finding!]!",
+			description: "findings at or above a minimum risk, optionally of an entity",
+			args:        []*argDef{{name: "risk", typ: "String"}, {name: "entity", typ: "ID"}},
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				risk := strArg(args, "risk")
+				if err := validRisk(risk); err != nil {
+					return nil, err
+				}
+				ag := parent.(graph.AppData)
+				aid, _ := ag.Attributes[scenarios.ATTR_SOURCE_APP].(string)
+				return c.findingsOf(aid, ag.ID, risk, strArg(args, "entity")), nil
+			},
+		},
+	},
+}
+
+var findingType = &typeDef{
+	name:        "Finding",
+	description: "an attack scenario detected on an entity",
+	fields: []*fieldDef{
+		field("id", "ID!", "", func(p interface{}) interface{} { return p.(findingRef).finding.ID }),
+		field("title", "String", "", func(p interface{}) interface{} { return p.(findingRef).finding.Title }),
+		field("risk", "String", "", func(p interface{}) interface{} { return p.(findingRef).finding.Risk }),
+		field("tacticId", "String", "ATT&CK tactic", func(p interface{}) interface{} { return p.(findingRef).finding.TacticID }),
+		field("tactic", "String", "", func(p interface{}) interface{} { return p.(findingRef).finding.Tactic }),
+		field("techniqueId", "String", "ATT&CK technique or sub-technique", func(p interface{}) interface{} { return p.(findingRef).finding.TechniqueID }),
+		field("technique", "String", "", func(p interface{}) interface{} { return p.(findingRef).finding.Technique.Technique }),
+		field("remediation", "String", "", func(p interface{}) interface{} { return p.(findingRef).finding.Remediation }),
+		field("attributes", "[Attribute!]!", "", func(p interface{}) interface{} { return attributes(p.(findingRef).finding.Attributes) }),
+		field("entityId", "ID", "", func(p interface{}) interface{} { return p.(findingRef).finding.Entity }),
+		{
+			name:        "entity",
+			typ:         "Entity",
+			description: "entity of the evaluated app",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				f := parent.(findingRef)
+				return c.entity(f.app, f.finding.Entity), nil
+			},
+		},
+		{
+			name: "attackGraph",
+			typ:  "AttackGraph",
+			resolve: func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error) {
+				return c.app(parent.(findingRef).attackGraph)
+			},
+		},
+	},
+}
+
+var attributeType = &typeDef{
+	name:        "Attribute",
+	description: "a key value attribute",
+	fields: []*fieldDef{
+		field("key", "String!", "", func(p interface{}) interface{} { return p.(attribute).key }),
+		field("value", "String", "", func(p interface{}) interface{} { return p.(attribute).value }),
+	},
+}
+
+var directionType = &typeDef{
+	name:        "Direction",
+	description: "traversal direction of neighbors",
+	enum:        []string{DIRECTION_OUT, DIRECTION_IN, DIRECTION_BOTH},
+}
+
+// SDL returns the schema in GraphQL schema definition language
+func SDL() string {
+	var b strings.Builder
+	for i, name := range schemaOrder {
+		t := schema[name]
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "# %s\n", t.description)
+		if t.enum != nil {
+			fmt.Fprintf(&b, "enum %s {\n  %s\n}\n", t.name, strings.Join(t.enum, "\n  "))
+			continue
+		}
+		fmt.Fprintf(&b, "type %s {\n", t.name)
+		for _, f := range t.fields {
+			if f.description != "" {
+				fmt.Fprintf(&b, "  # %s\n", f.description)
+			}
+			b.WriteString("  " + f.name)
+			if len(f.args) > 0 {
+				args := []string{}
+				for _, a := range f.args {
+					arg := a.name + ": " + a.typ
+					if a.defValue != nil {
+						arg += fmt.Sprintf(" = %v", a.defValue)
+					}
+					args = append(args, arg)
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.typ + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+package graphql
+
+import (
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+// GraphQL serves GraphQL queries over the hypergraph of apps, their attack
+// graphs and findings
+type GraphQL struct {
+	db db.Db
+}
+
+// Request is a GraphQL request, posted as JSON or given as GET query parameters
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Response is a GraphQL response, data is absent when the request is invalid
+type Response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error with its query location and result path
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+// Location is a line and column of a query, from 1
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// parsed query document
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string
+	name       string
+	variables  []*variableDef
+	selections []*selection
+	loc        Location
+}
+
+type variableDef struct {
+	name     string
+	typ      string
+	defValue *value
+}
+
+type fragment struct {
+	name       string
+	typeCond   string
+	selections []*selection
+	loc        Location
+}
+
+// selection is a field, a fragment spread (fragment set) or an inline fragment
+// (typeCond or selections set without name)
+type selection struct {
+	alias      string
+	name       string
+	args       map[string]*value
+	directives []*directive
+	selections []*selection
+	fragment   string
+	typeCond   string
+	loc        Location
+}
+
+type directive struct {
+	name string
+	args map[string]*value
+}
+
+// value is a literal, or a variable reference when variable is set
+type value struct {
+	kind     string
+	variable string
+	literal  interface{}
+	list     []*value
+	fields   map[string]*value
+	loc      Location
+}
+
+// schema types
+
+type typeDef struct {
+	name        string
+	description string
+	fields      []*fieldDef
+	enum        []string
+}
+
+type fieldDef struct {
+	name        string
+	typ         string
+	description string
+	args        []*argDef
+	resolve     func(c *execContext, parent interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+type argDef struct {
+	name     string
+	typ      string
+	defValue interface{}
+}
+
+// execContext caches hypergraphs and findings loaded while executing a query
+type execContext struct {
+	db          db.Db
+	variables   map[string]interface{}
+	fragments   map[string]*fragment
+	errors      []*Error
+	hypergraphs map[string]*graph.Hypergraph
+	findings    map[string][]scenarios.Finding
+
+	// latest attack graph ids of apps, empty when an app has none
+	latest map[string]string
+
+	// number of values of the result
+	size int
+}
+
+// resolved object values of schema types
+
+type entityRef struct {
+	app    string
+	entity graph.Entity
+}
+
+type assocRef struct {
+	app   string
+	assoc graph.Assoc
+}
+
+type findingRef struct {
+	app         string
+	attackGraph string
+	finding     scenarios.Finding
+}
+
+type attribute struct {
+	key   string
+	value string
+}
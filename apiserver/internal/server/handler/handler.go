@@ -1,42 +1,194 @@
 package handler
 
 import (
+	"log"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/mux"
 
+	"github.com/zetafence/zentaris/apiserver/internal/server/activity"
+	"github.com/zetafence/zentaris/apiserver/internal/server/audit"
+	"github.com/zetafence/zentaris/apiserver/internal/server/auth"
 	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/events"
+	"github.com/zetafence/zentaris/apiserver/internal/server/export"
 	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graphql"
+	"github.com/zetafence/zentaris/apiserver/internal/server/mitre"
+	"github.com/zetafence/zentaris/apiserver/internal/server/notify"
+	"github.com/zetafence/zentaris/apiserver/internal/server/query"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
 	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+	"github.com/zetafence/zentaris/apiserver/internal/server/tenant"
+	"github.com/zetafence/zentaris/apiserver/internal/server/vulns"
 )
 
-// CORS middleware to handle CORS preflight requests and set headers
-func corsMiddleware(next http.Handler) http.Handler {
+// CORS middleware to handle CORS preflight requests and set headers, allowing
+// a given API key header
+func corsMiddleware(authHeader string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Set CORS headers
+			w.Header().Set("Access-Control-Allow-Origin", "*")                                                   // Allow all origins (change this to your specific origin if needed)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")             // Allowed methods
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-Match, If-None-Match, "+authHeader) // Allowed headers
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")                                              // Exposed headers
+
+			// Handle preflight request
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent) // Respond with 204 No Content
+				return
+			}
+
+			// Continue to the next handler
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Config holds handler settings from the command-line
+type Config struct {
+	AttackBundle string // local MITRE ATT&CK STIX bundle
+	CVSSFile     string // local CVSS vectors CSV
+	EPSSFile     string // local FIRST EPSS CSV
+	KEVFile      string // local CISA KEV catalog
+	CloudTrail   string // local directory of CloudTrail logs
+	Notify       notify.Config
+	AuthHeader   string // request header carrying API keys
+	AdminKey     string // bootstrap admin API key
+}
+
+// shared holds data loaded once and shared by all tenants
+type shared struct {
+	bundle   *mitre.Bundle
+	vulns    *vulns.Data
+	notifier *notify.Notifier
+}
+
+// tenantTables lists DB tables of each tenant
+var tenantTables = []string{graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS, graph.DB_TABLE_SNAPSHOTS,
+	risk.DB_TABLE_CROWN_JEWEL_RULES, risk.DB_TABLE_TRENDS, notify.DB_TABLE_NOTIFIED}
+
+// scopedHandler is a handler on a DB notifying the observer of the request it
+// serves
+type scopedHandler struct {
+	db      *db.ObservedDb
+	handler http.Handler
+}
+
+// audited serves read-only requests with handlers on an observed DB, and each
+// mutating request with handlers on a DB auditing its changes on the entry of
+// that request, so that concurrent requests neither wait for one another nor
+// get attributed changes of one another. Handlers of mutating requests are
+// pooled rather than registered on each request, so they must not write once
+// their request completed.
+func audited(au *audit.Audit, d *db.ObservedDb, register func(d db.Db) http.Handler) http.Handler {
+	shared := register(d)
+	pool := &sync.Pool{}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")                   // Allow all origins (change this to your specific origin if needed)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS") // Allowed methods
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")       // Allowed headers
-
-		// Handle preflight request
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent) // Respond with 204 No Content
+		if auth.ReadOnly(r) {
+			shared.ServeHTTP(w, r)
 			return
 		}
-
-		// Continue to the next handler
-		next.ServeHTTP(w, r)
+		s, ok := pool.Get().(*scopedHandler)
+		if !ok {
+			s = &scopedHandler{db: d.With(nil)}
+			s.handler = register(s.db)
+		}
+		s.db.Observer = au.ObserveRequest(r)
+		s.handler.ServeHTTP(w, r)
+		s.db.Observer = d.Observer
+		pool.Put(s)
 	})
 }
 
 // RegisterHandlers registers all REST handlers
-func RegisterHandlers() *mux.Router {
-	// global in-memory DB instance
-	db := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS)
+func RegisterHandlers(cfg Config) *mux.Router {
+	// system in-memory DB instance, tenants have their own
+	sys := db.NewMemoryDb(auth.DB_TABLE_API_KEYS, auth.DB_TABLE_API_KEY_USAGE, audit.DB_TABLE_AUDIT)
+
+	// audit log of all mutations, API key usage is not audited
+	au := audit.NewAudit(sys, auth.DB_TABLE_API_KEY_USAGE)
 
 	// init router
 	r := mux.NewRouter()
 
+	// API keys
+	if cfg.AuthHeader == "" {
+		cfg.AuthHeader = auth.DEFAULT_HEADER
+	}
+	keys := db.NewObservedDb(sys, au.Observe())
+	a := auth.NewAuth(keys, cfg.AuthHeader)
+	if cfg.AdminKey != "" {
+		if err := a.Bootstrap(cfg.AdminKey); err != nil {
+			log.Printf("unable to add admin API key: %v\n", err)
+		}
+	}
+	r.Handle("/v1/apiKeys", audited(au, keys, func(d db.Db) http.Handler {
+		return http.HandlerFunc(a.On(d).CreateAPIKey)
+	})).Methods("POST")
+	r.HandleFunc("/v1/apiKeys", a.GetAPIKeys).Methods("GET")
+	r.HandleFunc("/v1/apiKeys", a.GetAPIKeys).Methods("OPTIONS")
+	r.Handle("/v1/apiKey/{id}", audited(au, keys, func(d db.Db) http.Handler {
+		return http.HandlerFunc(a.On(d).RevokeAPIKey)
+	})).Methods("DELETE")
+	r.HandleFunc("/v1/apiKey/{id}", a.RevokeAPIKey).Methods("OPTIONS")
+
+	// SIEM forwarding of evaluated findings of all tenants
+	sh := shared{
+		bundle:   mitre.LoadAttackBundle(cfg.AttackBundle),
+		vulns:    vulns.LoadData(cfg.CVSSFile, cfg.EPSSFile, cfg.KEVFile),
+		notifier: notify.NewNotifier(cfg.Notify),
+	}
+	r.HandleFunc("/v1/notifications", auth.SystemOnly(sh.notifier.GetNotifications)).Methods("GET")
+	r.HandleFunc("/v1/notifications", auth.SystemOnly(sh.notifier.GetNotifications)).Methods("OPTIONS")
+	r.HandleFunc("/v1/notifications/test", auth.SystemOnly(sh.notifier.TestNotifications)).Methods("POST")
+	r.HandleFunc("/v1/notifications/test", auth.SystemOnly(sh.notifier.TestNotifications)).Methods("OPTIONS")
+
+	// audit log
+	r.HandleFunc("/v1/audit", au.GetAudit).Methods("GET")
+	r.HandleFunc("/v1/audit", au.GetAudit).Methods("OPTIONS")
+	r.HandleFunc("/v1/audit/export", au.ExportAudit).Methods("GET")
+	r.HandleFunc("/v1/audit/export", au.ExportAudit).Methods("OPTIONS")
+	r.HandleFunc("/v1/audit/verify", au.VerifyAudit).Methods("GET")
+	r.HandleFunc("/v1/audit/verify", au.VerifyAudit).Methods("OPTIONS")
+
+	// tenants, every other endpoint is served on the DB of the request tenant
+	ts := tenant.NewTenants(func(org, group string) db.Db {
+		d := db.NewMemoryDb(tenantTables...)
+		if err := graph.CreateIndexes(d); err != nil {
+			log.Fatalf("unable to create indexes: %v", err)
+		}
+		if err := graph.Migrate(d); err != nil {
+			log.Fatalf("unable to migrate records: %v", err)
+		}
+		return db.NewObservedDb(d, au.Observe())
+	}, func(t *tenant.Tenant) http.Handler {
+		// tenant DBs are observed DBs created above
+		return audited(au, t.Db.(*db.ObservedDb), func(d db.Db) http.Handler {
+			return registerTenantHandlers(cfg, sh, t, d)
+		})
+	})
+	r.HandleFunc("/v1/tenants", ts.CreateTenant).Methods("POST")
+	r.HandleFunc("/v1/tenants", ts.GetTenants).Methods("GET")
+	r.HandleFunc("/v1/tenants", ts.GetTenants).Methods("OPTIONS")
+	r.PathPrefix(tenant.TENANT_PATH).Handler(ts)
+	r.PathPrefix("/v1/").Handler(ts)
+
+	// Apply CORS middleware, then API key authentication, then auditing
+	r.Use(corsMiddleware(cfg.AuthHeader))
+	r.Use(a.Middleware)
+	r.Use(au.Middleware)
+
+	return r
+}
+
+// registerTenantHandlers registers REST handlers of a tenant on its DB, or on
+// a DB of a request scoped to it
+func registerTenantHandlers(cfg Config, sh shared, t *tenant.Tenant, db db.Db) *mux.Router {
+	r := mux.NewRouter()
+
 	// app REST endpoints
 	g := graph.NewGraph(db)
 	r.HandleFunc("/v1/app", g.CreateAppData).Methods("POST")
@@ -44,15 +196,47 @@ func RegisterHandlers() *mux.Router {
 	r.HandleFunc("/v1/apps", g.GetAllApps).Methods("OPTIONS")
 	r.HandleFunc("/v1/app/{id}", g.GetAppData).Methods("GET")
 	r.HandleFunc("/v1/app/{id}", g.GetAppData).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}", g.UpdateAppData).Methods("PUT")
+	r.HandleFunc("/v1/app/{id}", g.PatchAppData).Methods("PATCH")
+	r.HandleFunc("/v1/app/{id}", g.DeleteAppData).Methods("DELETE")
 
-	// eval
-	r.HandleFunc("/v1/app/{id}/eval", g.EvalAppData).Methods("POST")
-	r.HandleFunc("/v1/app/{id}/eval", g.EvalAppData).Methods("OPTIONS")
+	// bulk import of whole apps
+	r.HandleFunc("/v1/import", g.ImportApp).Methods("POST")
+	r.HandleFunc("/v1/import", g.ImportApp).Methods("OPTIONS")
+
+	// eval and risk trend history
+	rk := risk.NewRisk(db)
+	r.HandleFunc("/v1/app/{id}/eval", rk.EvalAppData).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/eval", rk.EvalAppData).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/trend", rk.GetTrend).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/trend", rk.GetTrend).Methods("OPTIONS")
+
+	// SIEM forwarding of evaluated findings, on the tenant DB as deliveries
+	// may outlive requests
+	rk.OnEvaluate(sh.notifier.Hook(t.Db, t.Org, t.Group))
 
 	// build attack scenarios
 	sc := scenarios.NewScenario(db)
 	r.HandleFunc("/v1/scenarios", sc.BuildAttackScenarios).Methods("POST")
 	r.HandleFunc("/v1/scenarios", sc.BuildAttackScenarios).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/findings", sc.GetFindings).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/findings", sc.GetFindings).Methods("OPTIONS")
+
+	// finding risk levels to filter and sort lists
+	g.SetRiskLevels(scenarios.RiskLevels, sc.EntityRiskLevels)
+
+	// GraphQL queries over app hypergraphs, attack graphs and findings
+	gql := graphql.NewGraphQL(db)
+	r.HandleFunc("/v1/graphql", gql.Query).Methods("POST")
+	r.HandleFunc("/v1/graphql", gql.Query).Methods("GET")
+	r.HandleFunc("/v1/graphql", gql.Query).Methods("OPTIONS")
+	r.HandleFunc("/v1/graphql/schema", gql.GetSchema).Methods("GET")
+	r.HandleFunc("/v1/graphql/schema", gql.GetSchema).Methods("OPTIONS")
+
+	// pattern matching queries over hyperedges of an app
+	qy := query.NewQuery(db)
+	r.HandleFunc("/v1/app/{id}/query", qy.RunQuery).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/query", qy.RunQuery).Methods("OPTIONS")
 
 	// risk data
 	r.HandleFunc("/v1/risk", g.GetRiskData).Methods("GET")
@@ -63,19 +247,93 @@ func RegisterHandlers() *mux.Router {
 	r.HandleFunc("/v1/app/{id}/entities", g.GetAllEntities).Methods("GET")
 	r.HandleFunc("/v1/app/{id}/entities", g.GetAllEntities).Methods("OPTIONS")
 	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.GetEntityData).Methods("GET")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.UpdateEntity).Methods("PUT")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.PatchEntity).Methods("PATCH")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.DeleteEntity).Methods("DELETE")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}", g.GetEntityData).Methods("OPTIONS")
 
 	// assoc endpoints
 	r.HandleFunc("/v1/app/{id}/assoc", g.CreateAssocData).Methods("POST")
 	r.HandleFunc("/v1/app/{id}/assocs", g.GetAllAssocs).Methods("GET")
 	r.HandleFunc("/v1/app/{id}/assocs", g.GetAllAssocs).Methods("OPTIONS")
 	r.HandleFunc("/v1/app/{aid}/assoc/{sid}", g.GetAssocData).Methods("GET")
+	r.HandleFunc("/v1/app/{aid}/assoc/{sid}", g.UpdateAssoc).Methods("PUT")
+	r.HandleFunc("/v1/app/{aid}/assoc/{sid}", g.PatchAssoc).Methods("PATCH")
+	r.HandleFunc("/v1/app/{aid}/assoc/{sid}", g.DeleteAssoc).Methods("DELETE")
+	r.HandleFunc("/v1/app/{aid}/assoc/{sid}", g.GetAssocData).Methods("OPTIONS")
+
+	// live change events
+	ev := events.NewEvents(db)
+	r.HandleFunc("/v1/events", ev.StreamEvents).Methods("GET")
+	r.HandleFunc("/v1/events", ev.StreamEvents).Methods("OPTIONS")
+
+	// snapshot endpoints
+	r.HandleFunc("/v1/app/{id}/snapshots", g.GetAppSnapshots).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/snapshots", g.GetAppSnapshots).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/snapshot/{version}", g.GetAppSnapshot).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/snapshot/{version}", g.GetAppSnapshot).Methods("OPTIONS")
+
+	// crown jewels and blast radius
+	r.HandleFunc("/v1/app/{id}/crownJewels", rk.TagCrownJewels).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/crownJewels", rk.GetCrownJewels).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/crownJewels", rk.GetCrownJewels).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/crownJewel/{eid}", rk.UntagCrownJewel).Methods("DELETE")
+	r.HandleFunc("/v1/app/{id}/crownJewel/{eid}", rk.UntagCrownJewel).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/crownJewelRules", rk.CreateCrownJewelRules).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/crownJewelRules", rk.GetCrownJewelRules).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/crownJewelRules", rk.GetCrownJewelRules).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/entity/{eid}/blastRadius", rk.GetBlastRadius).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/entity/{eid}/blastRadius", rk.GetBlastRadius).Methods("OPTIONS")
+
+	// attack paths and choke points
+	r.HandleFunc("/v1/app/{id}/attackPaths", rk.GetAttackPaths).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/attackPaths", rk.GetAttackPaths).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/chokePoints", rk.GetChokePoints).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/chokePoints", rk.GetChokePoints).Methods("OPTIONS")
+
+	// what-if remediation simulation
+	r.HandleFunc("/v1/app/{id}/whatIf", rk.SimulateWhatIf).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/whatIf", rk.SimulateWhatIf).Methods("OPTIONS")
+
+	// drift between snapshot versions
+	r.HandleFunc("/v1/app/{id}/drift", rk.GetDrift).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/drift", rk.GetDrift).Methods("OPTIONS")
+
+	// MITRE ATT&CK mapping
+	m := mitre.NewMitre(db, sh.bundle)
+	r.HandleFunc("/v1/app/{id}/attackMatrix", m.GetAttackMatrix).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/attackMatrix", m.GetAttackMatrix).Methods("OPTIONS")
+	r.HandleFunc("/v1/attackTechniques", m.GetScenarioTechniques).Methods("GET")
+	r.HandleFunc("/v1/attackTechniques", m.GetScenarioTechniques).Methods("OPTIONS")
+
+	// vulnerability ingestion
+	v := vulns.NewVulns(db, sh.vulns)
+	r.HandleFunc("/v1/app/{id}/vulns", v.ImportVulns).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/vulns", v.ImportVulns).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}/vulns", v.GetEntityVulns).Methods("GET")
+	r.HandleFunc("/v1/app/{aid}/entity/{eid}/vulns", v.GetEntityVulns).Methods("OPTIONS")
+
+	// CloudTrail activity ingestion
+	act := activity.NewActivity(db, cfg.CloudTrail)
+	r.HandleFunc("/v1/app/{id}/cloudTrail", act.IngestCloudTrail).Methods("POST")
+	r.HandleFunc("/v1/app/{id}/cloudTrail", act.IngestCloudTrail).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/activity", act.GetActivity).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/activity", act.GetActivity).Methods("OPTIONS")
+
+	// exports
+	x := export.NewExport(db)
+	r.HandleFunc("/v1/app/{id}/export/sarif", x.GetSarif).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/export/sarif", x.GetSarif).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/export/stix", x.GetStix).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/export/stix", x.GetStix).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/export/ocsf", x.GetOcsf).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/export/ocsf", x.GetOcsf).Methods("OPTIONS")
+	r.HandleFunc("/v1/app/{id}/export/{format}", x.GetGraph).Methods("GET")
+	r.HandleFunc("/v1/app/{id}/export/{format}", x.GetGraph).Methods("OPTIONS")
 
 	// app endpoints
 	r.HandleFunc("/v1/attackGraphs", g.GetAllAttackGraphs).Methods("GET")
 	r.HandleFunc("/v1/attackGraphs", g.GetAllAttackGraphs).Methods("OPTIONS")
 
-	// Apply CORS middleware
-	r.Use(corsMiddleware)
-
 	return r
 }
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/audit"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+const testAdminKey = "test-admin-key"
+
+// newRouter returns all REST handlers with a bootstrap admin key
+func newRouter(t *testing.T) *mux.Router {
+	t.Helper()
+	return RegisterHandlers(Config{AdminKey: testAdminKey})
+}
+
+// do serves a request authenticated by a key, returning its response
+func do(r http.Handler, key, method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	var rd io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		rd = strings.NewReader(b)
+	default:
+		data, _ := json.Marshal(b)
+		rd = strings.NewReader(string(data))
+	}
+	req := httptest.NewRequest(method, path, rd)
+	req.Header.Set("Authorization", "Bearer "+key)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuditedRequests(t *testing.T) {
+	r := newRouter(t)
+
+	// concurrent requests of different tenants
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/v1/org/org%d/group/default/app", i%2)
+			app := graph.AppData{ID: fmt.Sprintf("app%d", i), Name: "app"}
+			if w := do(r, testAdminKey, http.MethodPost, path, app); w.Code != http.StatusCreated {
+				t.Errorf("create app%d: got %v %v", i, w.Code, w.Body)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	w := do(r, testAdminKey, http.MethodGet, "/v1/audit?method=POST", nil)
+	var list audit.EntryList
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 10 {
+		t.Fatalf("expecting 10 entries, got %+v", list)
+	}
+	for _, e := range list.Entries {
+		var i int
+		fmt.Sscanf(e.App, "app%d", &i)
+		if len(e.Changes) != 1 || e.Changes[0].Key != e.App || e.Org != fmt.Sprintf("org%d", i%2) {
+			t.Errorf("expecting the change of its own app and org, got %+v", e)
+		}
+	}
+
+	w = do(r, testAdminKey, http.MethodGet, "/v1/audit/verify", nil)
+	var v audit.Verification
+	json.NewDecoder(w.Body).Decode(&v)
+	if !v.Valid {
+		t.Fatalf("expecting a valid chain, got %+v", v)
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/auth"
+)
+
+// newKey creates an API key with the admin key, returning it
+func newKey(t *testing.T, r http.Handler, req auth.APIKeyRequest) string {
+	t.Helper()
+	w := do(r, testAdminKey, http.MethodPost, "/v1/apiKeys", req)
+	var created auth.CreatedAPIKey
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.Key == "" {
+		t.Fatalf("create key %+v: got %v %v", req, w.Code, err)
+	}
+	return created.Key
+}
+
+func TestTenantCreation(t *testing.T) {
+	r := newRouter(t)
+	admin := newKey(t, r, auth.APIKeyRequest{Name: "admin", Scope: auth.SCOPE_ADMIN, Org: "acme"})
+	ingest := newKey(t, r, auth.APIKeyRequest{Name: "ci", Scope: auth.SCOPE_INGEST, Org: "acme"})
+	prod := newKey(t, r, auth.APIKeyRequest{Name: "prod", Scope: auth.SCOPE_INGEST, Org: "acme", Groups: []string{"prod"}})
+	reader := newKey(t, r, auth.APIKeyRequest{Name: "ro", Scope: auth.SCOPE_READ_ONLY, Org: "acme"})
+	app := `{"id":"a1","name":"app"}`
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		code   int
+	}{
+		{"default group of own org", reader, http.MethodGet, "/v1/org/acme/group/default/apps", http.StatusOK},
+		{"other org", ingest, http.MethodPost, "/v1/org/other/group/default/app", http.StatusForbidden},
+		{"ingest into a new group", ingest, http.MethodPost, "/v1/org/acme/group/staging/app", http.StatusForbidden},
+		{"read a new group", reader, http.MethodGet, "/v1/org/acme/group/staging/apps", http.StatusNotFound},
+		{"ungranted group", prod, http.MethodPost, "/v1/org/acme/group/staging/app", http.StatusForbidden},
+		{"unprovisioned granted group", prod, http.MethodPost, "/v1/org/acme/group/prod/app", http.StatusForbidden},
+		{"admin ingests into a new group", admin, http.MethodPost, "/v1/org/acme/group/prod/app", http.StatusCreated},
+		{"ingest into the created group", prod, http.MethodPost, "/v1/org/acme/group/prod/app", http.StatusCreated},
+		{"system ingest into another org", testAdminKey, http.MethodPost, "/v1/org/other/group/default/app", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := ""
+			if tt.method == http.MethodPost {
+				body = app
+			}
+			if w := do(r, tt.key, tt.method, tt.path, body); w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+			}
+		})
+	}
+
+	// admins create tenants ahead of ingestion
+	if w := do(r, ingest, http.MethodPost, "/v1/tenants", `{"group":"dev"}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expecting ingest key not to create tenants, got %v", w.Code)
+	}
+	if w := do(r, admin, http.MethodPost, "/v1/tenants", `{"group":"dev"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expecting admin to create a tenant, got %v %v", w.Code, w.Body)
+	}
+	if w := do(r, ingest, http.MethodPost, "/v1/org/acme/group/dev/app", app); w.Code != http.StatusCreated {
+		t.Fatalf("expecting ingestion into the created tenant, got %v %v", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,213 @@
+package mitre
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+// default Enterprise matrix tactic order, used without a loaded bundle
+var defaultTacticOrder = []string{
+	"TA0043", "TA0042", "TA0001", "TA0002", "TA0003", "TA0004", "TA0005",
+	"TA0006", "TA0007", "TA0008", "TA0009", "TA0011", "TA0010", "TA0040",
+}
+
+// ScenarioTechnique is a scenario mapping along with its validation status
+type ScenarioTechnique struct {
+	Scenario string `json:"scenario"`
+	scenarios.Technique
+	Validated bool   `json:"validated"`
+	Error     string `json:"error,omitempty"`
+}
+
+// list of scenario mappings
+type ScenarioTechniqueList struct {
+	Bundle     string              `json:"bundle"`
+	Techniques []ScenarioTechnique `json:"techniques"`
+}
+
+// NewMitre returns a new MITRE ATT&CK element, validating scenario mappings
+// against a loaded STIX bundle when one is given
+func NewMitre(db db.Db, bundle *Bundle) *Mitre {
+	return &Mitre{
+		db:     db,
+		bundle: bundle,
+	}
+}
+
+// LoadAttackBundle loads a local STIX bundle shared by MITRE ATT&CK elements,
+// logging scenario mappings it invalidates
+func LoadAttackBundle(bundlePath string) *Bundle {
+	if bundlePath == "" {
+		return nil
+	}
+
+	bundle, err := LoadBundle(bundlePath)
+	if err != nil {
+		log.Printf("unable to load ATT&CK bundle %v: %v\n", bundlePath, err)
+		return nil
+	}
+	log.Printf("loaded %v %v with %v techniques\n", bundle.Name, bundle.Version, len(bundle.Techniques))
+
+	for _, st := range NewMitre(nil, bundle).scenarioTechniques() {
+		if !st.Validated {
+			log.Printf("invalid ATT&CK mapping of scenario %q: %v\n", st.Scenario, st.Error)
+		}
+	}
+	return bundle
+}
+
+// validate returns whether a technique is valid against the loaded bundle
+func (m *Mitre) validate(t scenarios.Technique) error {
+	if m.bundle == nil {
+		return nil
+	}
+	return m.bundle.Validate(t)
+}
+
+// bundleName returns name and version of the loaded bundle
+func (m *Mitre) bundleName() string {
+	if m.bundle == nil {
+		return ""
+	}
+	return m.bundle.Name + " " + m.bundle.Version
+}
+
+// scenarioTechniques returns all scenario mappings sorted by scenario
+func (m *Mitre) scenarioTechniques() []ScenarioTechnique {
+	sts := []ScenarioTechnique{}
+	for title, t := range scenarios.ScenarioTechniques {
+		st := ScenarioTechnique{Scenario: title, Technique: t, Validated: m.bundle != nil}
+		if err := m.validate(t); err != nil {
+			st.Validated = false
+			st.Error = err.Error()
+		}
+		sts = append(sts, st)
+	}
+	sort.Slice(sts, func(i, j int) bool { return sts[i].Scenario < sts[j].Scenario })
+	return sts
+}
+
+// tacticRank returns matrix position of a tactic
+func (m *Mitre) tacticRank(id string) int {
+	order := defaultTacticOrder
+	if m.bundle != nil && len(m.bundle.TacticOrder) > 0 {
+		order = m.bundle.TacticOrder
+	}
+	for i, t := range order {
+		if t == id {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// BuildMatrix returns the ATT&CK matrix of the latest attack graph of an app
+func (m *Mitre) BuildMatrix(aid string) Matrix {
+	matrix := Matrix{
+		App:     aid,
+		Bundle:  m.bundleName(),
+		Tactics: []MatrixTactic{},
+	}
+	sc := scenarios.NewScenario(m.db)
+	ag, ok := sc.LatestAttackGraph(aid)
+	if !ok {
+		return matrix
+	}
+	matrix.AttackGraph = ag.ID
+
+	tactics := map[string]*MatrixTactic{}
+	techniques := map[string]map[string]*MatrixTechnique{} // tactic -> technique
+	entities := map[string]map[string]bool{}               // tactic/technique -> entities
+	for _, f := range sc.Findings(ag.ID) {
+		if f.TacticID == "" || f.TechniqueID == "" {
+			continue
+		}
+		tactic, ok := tactics[f.TacticID]
+		if !ok {
+			tactic = &MatrixTactic{ID: f.TacticID, Name: f.Tactic}
+			if m.bundle != nil {
+				if t, ok := m.bundle.Tactics[f.TacticID]; ok {
+					tactic.Name = t.Name
+				}
+			}
+			tactics[f.TacticID] = tactic
+			techniques[f.TacticID] = map[string]*MatrixTechnique{}
+		}
+		technique, ok := techniques[f.TacticID][f.TechniqueID]
+		if !ok {
+			technique = &MatrixTechnique{
+				ID:        f.TechniqueID,
+				Name:      f.Technique.Technique,
+				Validated: m.bundle != nil && m.validate(f.Technique) == nil,
+			}
+			if m.bundle != nil {
+				if t, ok := m.bundle.Techniques[f.TechniqueID]; ok {
+					technique.Name = t.Name
+				}
+			}
+			techniques[f.TacticID][f.TechniqueID] = technique
+		}
+		key := f.TacticID + "/" + f.TechniqueID
+		if _, ok := entities[key]; !ok {
+			entities[key] = map[string]bool{}
+		}
+		entities[key][f.Entity] = true
+		technique.Findings++
+		tactic.Findings++
+	}
+
+	for tid, tactic := range tactics {
+		for _, technique := range techniques[tid] {
+			technique.Entities = []string{}
+			for e := range entities[tid+"/"+technique.ID] {
+				technique.Entities = append(technique.Entities, e)
+			}
+			sort.Strings(technique.Entities)
+			tactic.Techniques = append(tactic.Techniques, *technique)
+		}
+		sort.Slice(tactic.Techniques, func(i, j int) bool {
+			return tactic.Techniques[i].ID < tactic.Techniques[j].ID
+		})
+		matrix.Tactics = append(matrix.Tactics, *tactic)
+	}
+	sort.Slice(matrix.Tactics, func(i, j int) bool {
+		return m.tacticRank(matrix.Tactics[i].ID) < m.tacticRank(matrix.Tactics[j].ID)
+	})
+	return matrix
+}
+
+// GetAttackMatrix is GET handler to retrieve the ATT&CK matrix of an app
+func (m *Mitre) GetAttackMatrix(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	aid := vars["id"]
+
+	if _, err := m.db.Get(graph.DB_TABLE_GRAPH, aid); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.BuildMatrix(aid))
+}
+
+// GetScenarioTechniques is GET handler to retrieve ATT&CK mappings of all
+// attack scenarios and their validation against the loaded bundle
+func (m *Mitre) GetScenarioTechniques(w http.ResponseWriter, r *http.Request) {
+	stList := ScenarioTechniqueList{
+		Bundle:     m.bundleName(),
+		Techniques: m.scenarioTechniques(),
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stList)
+}
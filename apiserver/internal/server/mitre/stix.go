@@ -0,0 +1,124 @@
+package mitre
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	STIX_SOURCE_MITRE_ATTACK = "mitre-attack"
+)
+
+// stixObject is the subset of STIX 2.x object fields used by ATT&CK
+type stixObject struct {
+	Type               string   `json:"type"`
+	ID                 string   `json:"id"`
+	Name               string   `json:"name"`
+	Revoked            bool     `json:"revoked"`
+	Deprecated         bool     `json:"x_mitre_deprecated"`
+	ShortName          string   `json:"x_mitre_shortname"`
+	Version            string   `json:"x_mitre_version"`
+	Platforms          []string `json:"x_mitre_platforms"`
+	TacticRefs         []string `json:"tactic_refs"`
+	ExternalReferences []struct {
+		SourceName string `json:"source_name"`
+		ExternalID string `json:"external_id"`
+	} `json:"external_references"`
+	KillChainPhases []struct {
+		KillChainName string `json:"kill_chain_name"`
+		PhaseName     string `json:"phase_name"`
+	} `json:"kill_chain_phases"`
+}
+
+// attackId returns the ATT&CK id, e.g. T1110 or TA0006, of a STIX object
+func (o stixObject) attackId() string {
+	for _, ref := range o.ExternalReferences {
+		if ref.SourceName == STIX_SOURCE_MITRE_ATTACK {
+			return ref.ExternalID
+		}
+	}
+	return ""
+}
+
+// LoadBundle loads an ATT&CK Enterprise or Cloud STIX bundle from a local file
+func LoadBundle(path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var stix struct {
+		Type    string       `json:"type"`
+		Objects []stixObject `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &stix); err != nil {
+		return nil, fmt.Errorf("LoadBundle: %v", err)
+	}
+	if stix.Type != "bundle" {
+		return nil, fmt.Errorf("LoadBundle: %v is not a STIX bundle", path)
+	}
+
+	b := &Bundle{
+		Tactics:    make(map[string]Tactic),
+		Techniques: make(map[string]Technique),
+	}
+	stixTactics := map[string]string{} // STIX id -> tactic id
+	tacticRefs := []string{}
+	for _, o := range stix.Objects {
+		if o.Revoked || o.Deprecated {
+			continue
+		}
+		switch o.Type {
+		case "x-mitre-collection":
+			b.Name, b.Version = o.Name, o.Version
+		case "x-mitre-matrix":
+			tacticRefs = append(tacticRefs, o.TacticRefs...)
+		case "x-mitre-tactic":
+			id := o.attackId()
+			b.Tactics[id] = Tactic{ID: id, Name: o.Name, ShortName: o.ShortName}
+			stixTactics[o.ID] = id
+		case "attack-pattern":
+			t := Technique{ID: o.attackId(), Name: o.Name, Tactics: []string{}, Platforms: o.Platforms}
+			if t.ID == "" {
+				continue
+			}
+			for _, phase := range o.KillChainPhases {
+				if phase.KillChainName == STIX_SOURCE_MITRE_ATTACK {
+					t.Tactics = append(t.Tactics, phase.PhaseName)
+				}
+			}
+			b.Techniques[t.ID] = t
+		}
+	}
+	for _, ref := range tacticRefs {
+		if id, ok := stixTactics[ref]; ok {
+			b.TacticOrder = append(b.TacticOrder, id)
+		}
+	}
+	if b.Name == "" {
+		b.Name = "ATT&CK"
+	}
+	return b, nil
+}
+
+// Validate checks that a scenario technique exists in the bundle and belongs
+// to the scenario tactic
+func (b *Bundle) Validate(t scenarios.Technique) error {
+	technique, ok := b.Techniques[t.TechniqueID]
+	if !ok {
+		return fmt.Errorf("technique %v not found in %v", t.TechniqueID, b.Name)
+	}
+	tactic, ok := b.Tactics[t.TacticID]
+	if !ok {
+		return fmt.Errorf("tactic %v not found in %v", t.TacticID, b.Name)
+	}
+	for _, shortName := range technique.Tactics {
+		if strings.EqualFold(shortName, tactic.ShortName) {
+			return nil
+		}
+	}
+	return fmt.Errorf("technique %v does not belong to tactic %v", t.TechniqueID, t.TacticID)
+}
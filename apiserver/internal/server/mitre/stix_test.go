@@ -0,0 +1,132 @@
+package mitre
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+// testBundle is a minimal ATT&CK bundle with two tactics, in matrix order
+// opposite of their object order, and techniques of several states
+const testBundle = `{
+	"type": "bundle",
+	"objects": [
+		{"type": "x-mitre-collection", "name": "Enterprise ATT&CK", "x_mitre_version": "15.1"},
+		{"type": "x-mitre-matrix", "tactic_refs": ["x-mitre-tactic--2", "x-mitre-tactic--1"]},
+		{"type": "x-mitre-tactic", "id": "x-mitre-tactic--1", "name": "Initial Access", "x_mitre_shortname": "initial-access",
+			"external_references": [{"source_name": "mitre-attack", "external_id": "TA0001"}]},
+		{"type": "x-mitre-tactic", "id": "x-mitre-tactic--2", "name": "Credential Access", "x_mitre_shortname": "credential-access",
+			"external_references": [{"source_name": "mitre-attack", "external_id": "TA0006"}]},
+		{"type": "attack-pattern", "name": "Brute Force", "x_mitre_platforms": ["IaaS"],
+			"external_references": [{"source_name": "capec", "external_id": "CAPEC-49"}, {"source_name": "mitre-attack", "external_id": "T1110"}],
+			"kill_chain_phases": [{"kill_chain_name": "mitre-attack", "phase_name": "credential-access"}, {"kill_chain_name": "other", "phase_name": "initial-access"}]},
+		{"type": "attack-pattern", "name": "Revoked", "revoked": true,
+			"external_references": [{"source_name": "mitre-attack", "external_id": "T0001"}]},
+		{"type": "attack-pattern", "name": "Deprecated", "x_mitre_deprecated": true,
+			"external_references": [{"source_name": "mitre-attack", "external_id": "T0002"}]},
+		{"type": "attack-pattern", "name": "No ATT&CK id",
+			"external_references": [{"source_name": "capec", "external_id": "CAPEC-1"}]}
+	]
+}`
+
+// writeBundle writes a bundle into a temporary file
+func writeBundle(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bundle.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadBundle(t *testing.T) {
+	b, err := LoadBundle(writeBundle(t, testBundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Name != "Enterprise ATT&CK" || b.Version != "15.1" {
+		t.Fatalf("expecting collection name and version, got %v %v", b.Name, b.Version)
+	}
+	if !reflect.DeepEqual(b.TacticOrder, []string{"TA0006", "TA0001"}) {
+		t.Fatalf("expecting tactics in matrix order, got %v", b.TacticOrder)
+	}
+
+	// revoked, deprecated and unidentified techniques are left out, and
+	// tactics are kill chain phases of ATT&CK only
+	want := map[string]Technique{
+		"T1110": {ID: "T1110", Name: "Brute Force", Tactics: []string{"credential-access"}, Platforms: []string{"IaaS"}},
+	}
+	if !reflect.DeepEqual(b.Techniques, want) {
+		t.Fatalf("expecting techniques %+v, got %+v", want, b.Techniques)
+	}
+}
+
+func TestLoadBundleInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"malformed", `{"type": "bundle", "objects": [`, "LoadBundle"},
+		{"wrong types", `{"type": "bundle", "objects": {}}`, "LoadBundle"},
+		{"not a bundle", `{"type": "attack-pattern"}`, "not a STIX bundle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadBundle(writeBundle(t, tt.data)); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expecting error %q, got %v", tt.err, err)
+			}
+		})
+	}
+	if _, err := LoadBundle(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatalf("expecting missing file to fail")
+	}
+
+	// bundles failing to load are not used
+	if b := LoadAttackBundle(writeBundle(t, `[]`)); b != nil {
+		t.Fatalf("expecting no bundle, got %+v", b)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	b, err := LoadBundle(writeBundle(t, testBundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		technique scenarios.Technique
+		err       string
+	}{
+		{"valid", scenarios.Technique{TacticID: "TA0006", TechniqueID: "T1110"}, ""},
+		{"unknown technique", scenarios.Technique{TacticID: "TA0006", TechniqueID: "T1078"}, "technique T1078 not found"},
+		{"revoked technique", scenarios.Technique{TacticID: "TA0006", TechniqueID: "T0001"}, "technique T0001 not found"},
+		{"unknown tactic", scenarios.Technique{TacticID: "TA0040", TechniqueID: "T1110"}, "tactic TA0040 not found"},
+		{"other tactic", scenarios.Technique{TacticID: "TA0001", TechniqueID: "T1110"}, "does not belong to tactic TA0001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := b.Validate(tt.technique)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expecting error %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// scenario mappings are reported invalid against the bundle, and valid
+	// without one
+	for _, st := range NewMitre(nil, b).scenarioTechniques() {
+		if st.Validated != (st.Technique.TechniqueID == "T1110" && st.Technique.TacticID == "TA0006") {
+			t.Errorf("%v: unexpected validation %v %v", st.Scenario, st.Validated, st.Error)
+		}
+	}
+	for _, st := range NewMitre(nil, nil).scenarioTechniques() {
+		if st.Validated || st.Error != "" {
+			t.Errorf("%v: expecting no validation without a bundle, got %+v", st.Scenario, st)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package mitre
+
+import (
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Mitre represents MITRE ATT&CK mapping of attack graph findings
+type Mitre struct {
+	db     db.Db
+	bundle *Bundle
+}
+
+// Tactic is an ATT&CK tactic, e.g. TA0006 Credential Access
+type Tactic struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	ShortName string `json:"shortName"`
+}
+
+// Technique is an ATT&CK technique or sub-technique, e.g. T1078.004
+type Technique struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Tactics   []string `json:"tactics"` // tactic short names
+	Platforms []string `json:"platforms"`
+}
+
+// Bundle is the subset of an ATT&CK STIX bundle used for validation
+type Bundle struct {
+	Name        string               `json:"name"`
+	Version     string               `json:"version"`
+	Tactics     map[string]Tactic    `json:"tactics"`     // tactic id -> tactic
+	TacticOrder []string             `json:"tacticOrder"` // tactic ids in matrix order
+	Techniques  map[string]Technique `json:"techniques"`  // technique id -> technique
+}
+
+// MatrixTechnique is a technique cell of the ATT&CK matrix of an app
+type MatrixTechnique struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Findings  int      `json:"findings"`
+	Entities  []string `json:"entities"`
+	Validated bool     `json:"validated"`
+}
+
+// MatrixTactic is a tactic column of the ATT&CK matrix of an app
+type MatrixTactic struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Findings   int               `json:"findings"`
+	Techniques []MatrixTechnique `json:"techniques"`
+}
+
+// Matrix is the ATT&CK matrix view of an app
+type Matrix struct {
+	App         string         `json:"app"`
+	AttackGraph string         `json:"attackGraph"`
+	Bundle      string         `json:"bundle"`
+	Tactics     []MatrixTactic `json:"tactics"`
+}
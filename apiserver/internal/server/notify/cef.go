@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	// CEF device identification
+	CEF_VENDOR  = "Zetafence"
+	CEF_PRODUCT = "Zentaris"
+	CEF_VERSION = "1.0.0"
+
+	// syslog transports
+	SYSLOG_UDP = "udp"
+	SYSLOG_TCP = "tcp"
+	SYSLOG_TLS = "tls"
+
+	// syslog facility local0
+	SYSLOG_FACILITY = 16
+
+	// timeout dialing and writing to syslog receivers
+	SYSLOG_TIMEOUT = 10 * time.Second
+)
+
+// cefSeverity maps a finding risk onto CEF severity 0-10
+func cefSeverity(risk string) int {
+	switch risk {
+	case scenarios.RISK_CRITICAL:
+		return 10
+	case scenarios.RISK_HIGH:
+		return 8
+	case scenarios.RISK_MEDIUM:
+		return 5
+	}
+	return 3
+}
+
+// syslogSeverity maps a finding risk onto syslog severity
+func syslogSeverity(risk string) int {
+	switch risk {
+	case scenarios.RISK_CRITICAL:
+		return 2
+	case scenarios.RISK_HIGH:
+		return 3
+	case scenarios.RISK_MEDIUM:
+		return 4
+	}
+	return 5
+}
+
+// cefHeader escapes CEF header fields
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ").Replace(s)
+}
+
+// cefValue escapes CEF extension values
+func cefValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`).Replace(s)
+}
+
+// CEF formats an event as an ArcSight Common Event Format message
+func CEF(ev Event) string {
+	rt := ev.Timestamp
+	if t, err := time.Parse(time.RFC3339, ev.Timestamp); err == nil {
+		rt = strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	pairs := []string{}
+	add := func(key, value string) {
+		if value != "" {
+			pairs = append(pairs, key+"="+cefValue(value))
+		}
+	}
+	add("rt", rt)
+	add("act", ev.Change)
+	add("externalId", ev.ID)
+	add("msg", ev.Remediation)
+	add("cat", ev.Tactic)
+	if ev.Org != "" {
+		add("flexString1Label", "tenant")
+		add("flexString1", ev.Org+"/"+ev.Group)
+	}
+
+	// custom strings, labeled only when set
+	custom := [][2]string{
+		{"app", ev.App},
+		{"entity", ev.Entity},
+		{"attackGraph", ev.AttackGraph},
+		{"mitreTechnique", ev.TechniqueID},
+		{"mitreTactic", ev.TacticID},
+		{"previousRisk", ev.PreviousRisk},
+	}
+	for i, kv := range custom {
+		if kv[1] != "" {
+			add(fmt.Sprintf("cs%dLabel", i+1), kv[0])
+			add(fmt.Sprintf("cs%d", i+1), kv[1])
+		}
+	}
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeader(CEF_VENDOR), cefHeader(CEF_PRODUCT), cefHeader(CEF_VERSION),
+		cefHeader(scenarios.RuleID(ev.Title)), cefHeader(ev.Title), cefSeverity(ev.Risk),
+		strings.Join(pairs, " "))
+}
+
+// SyslogSink sends events as CEF over syslog, one message per event
+type SyslogSink struct {
+	addr     string
+	proto    string
+	insecure bool
+	hostname string
+}
+
+// NewSyslogSink creates a syslog sink over UDP, TCP or TLS
+func NewSyslogSink(addr, proto string, insecure bool) (*SyslogSink, error) {
+	proto = strings.ToLower(proto)
+	if proto == "" {
+		proto = SYSLOG_UDP
+	}
+	if proto != SYSLOG_UDP && proto != SYSLOG_TCP && proto != SYSLOG_TLS {
+		return nil, fmt.Errorf("unsupported syslog protocol %v", proto)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &SyslogSink{addr: addr, proto: proto, insecure: insecure, hostname: hostname}, nil
+}
+
+// Name returns the sink name
+func (s *SyslogSink) Name() string {
+	return fmt.Sprintf("syslog+%v://%v", s.proto, s.addr)
+}
+
+// dial connects to the syslog receiver
+func (s *SyslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: SYSLOG_TIMEOUT}
+	if s.proto == SYSLOG_TLS {
+		return tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{InsecureSkipVerify: s.insecure})
+	}
+	return dialer.Dial(s.proto, s.addr)
+}
+
+// Send writes RFC 3164 syslog messages carrying CEF payloads, newline framed
+// over TCP and TLS
+func (s *SyslogSink) Send(events []Event) error {
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(SYSLOG_TIMEOUT))
+
+	for _, ev := range events {
+		msg := fmt.Sprintf("<%d>%s %s %s", SYSLOG_FACILITY*8+syslogSeverity(ev.Risk),
+			time.Now().Format(time.Stamp), s.hostname, CEF(ev))
+		if s.proto != SYSLOG_UDP {
+			msg += "\n"
+		}
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
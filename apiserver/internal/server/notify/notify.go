@@ -0,0 +1,292 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	// tenant DB table of finding risks per app and sink, as last delivered
+	DB_TABLE_NOTIFIED = "notified"
+
+	// changes of findings
+	CHANGE_NEW     = "new"
+	CHANGE_CHANGED = "changed"
+
+	// default minimum risk of forwarded findings
+	DEFAULT_SEVERITY = scenarios.RISK_HIGH
+
+	// delivery attempts, and initial backoff doubled after each attempt
+	NOTIFY_RETRIES = 5
+	NOTIFY_BACKOFF = time.Second
+
+	// number of recent deliveries kept
+	MAX_DELIVERIES = 100
+)
+
+func init() {
+	// notified findings of apps are deleted along with them
+	graph.RegisterAppTables(DB_TABLE_NOTIFIED)
+}
+
+// NewNotifier creates a notifier forwarding findings to configured sinks
+func NewNotifier(cfg Config) *Notifier {
+	if scenarios.RiskRank(cfg.Severity) < 0 {
+		cfg.Severity = DEFAULT_SEVERITY
+	}
+	cfg.Severity = strings.ToLower(cfg.Severity)
+	n := &Notifier{
+		cfg:        cfg,
+		sinks:      []Sink{},
+		retries:    NOTIFY_RETRIES,
+		backoff:    NOTIFY_BACKOFF,
+		deliveries: []Delivery{},
+		pending:    make(map[string]*pending),
+		running:    make(map[string]bool),
+	}
+	if cfg.SyslogAddr != "" {
+		if s, err := NewSyslogSink(cfg.SyslogAddr, cfg.SyslogProto, cfg.Insecure); err != nil {
+			log.Printf("syslog forwarding disabled: %v\n", err)
+		} else {
+			n.sinks = append(n.sinks, s)
+		}
+	}
+	if cfg.WebhookURL != "" {
+		n.sinks = append(n.sinks, NewWebhookSink(cfg.WebhookURL, cfg.WebhookSecret, cfg.Insecure))
+	}
+	return n
+}
+
+// findingKey identifies a finding across attack graphs of an app
+func findingKey(f scenarios.Finding) string {
+	return f.Title + "/" + f.Entity
+}
+
+// getNotifiedKey returns the key of finding risks of an app delivered to a sink
+func getNotifiedKey(aid, sink string) string {
+	return graph.GetEntityKey(aid, sink)
+}
+
+// Changes returns findings new or of changed risk since the evaluation of an
+// app last delivered to a sink, at or above the configured severity, along
+// with finding risks of this evaluation to record once delivered
+func (n *Notifier) Changes(d db.Db, aid, sink, agid, timestamp string, findings []scenarios.Finding) ([]Event, map[string]string) {
+	previous := map[string]string{}
+	if v, err := d.Get(DB_TABLE_NOTIFIED, getNotifiedKey(aid, sink)); err == nil {
+		if p, ok := v.(map[string]string); ok {
+			previous = p
+		}
+	}
+
+	// the same scenario may be found several times on an entity, keep its
+	// highest risk
+	latest := map[string]scenarios.Finding{}
+	keys := []string{}
+	for _, f := range findings {
+		key := findingKey(f)
+		old, ok := latest[key]
+		if !ok {
+			keys = append(keys, key)
+		}
+		if !ok || scenarios.RiskRank(f.Risk) > scenarios.RiskRank(old.Risk) {
+			latest[key] = f
+		}
+	}
+
+	current := map[string]string{}
+	events := []Event{}
+	for _, key := range keys {
+		f := latest[key]
+		current[key] = f.Risk
+		if scenarios.RiskRank(f.Risk) < scenarios.RiskRank(n.cfg.Severity) {
+			continue
+		}
+		ev := Event{App: aid, AttackGraph: agid, Timestamp: timestamp, Finding: f}
+		old, ok := previous[key]
+		switch {
+		case !ok:
+			ev.Change = CHANGE_NEW
+		case old != f.Risk:
+			ev.Change = CHANGE_CHANGED
+			ev.PreviousRisk = old
+		default:
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, current
+}
+
+// Hook returns the evaluation hook of a tenant, forwarding new or changed
+// findings of its app evaluations and delivering to each sink in the background
+func (n *Notifier) Hook(d db.Db, org, group string) func(risk.TrendPoint) {
+	return func(point risk.TrendPoint) {
+		findings := scenarios.NewScenario(d).Findings(point.AttackGraph)
+		for _, sink := range n.sinks {
+			n.enqueue(sink, &pending{d: d, org: org, group: group, point: point, findings: findings})
+		}
+	}
+}
+
+// enqueue queues an evaluation of an app for delivery to a sink, replacing
+// the one pending, and starts delivering unless a delivery to the sink of the
+// app is running
+func (n *Notifier) enqueue(sink Sink, p *pending) {
+	key := strings.Join([]string{p.org, p.group, p.point.App, sink.Name()}, "/")
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.pending[key] = p
+	if n.running[key] {
+		return
+	}
+	n.running[key] = true
+	go n.drain(sink, key)
+}
+
+// drain delivers evaluations queued for a sink of an app in turn, each one
+// diffed against findings of the previous delivery once it is done, so that
+// changes are neither sent twice nor overwritten by an older delivery
+func (n *Notifier) drain(sink Sink, key string) {
+	for {
+		n.mutex.Lock()
+		p, ok := n.pending[key]
+		delete(n.pending, key)
+		if !ok {
+			delete(n.running, key)
+			n.mutex.Unlock()
+			return
+		}
+		n.mutex.Unlock()
+
+		aid := p.point.App
+		events, current := n.Changes(p.d, aid, sink.Name(), p.point.AttackGraph, p.point.Timestamp, p.findings)
+		for i := range events {
+			events[i].Org = p.org
+			events[i].Group = p.group
+		}
+		if len(events) > 0 {
+			log.Printf("new findings notification %v/%v/%v to %v, %v events\n", p.org, p.group, aid, sink.Name(), len(events))
+		}
+		n.deliver(p.d, sink, aid, events, current)
+	}
+}
+
+// deliver sends events of an app evaluation to a sink, and records finding
+// risks of the evaluation as delivered to the sink once sent, so that events
+// failing to be delivered are sent again on the next evaluation
+func (n *Notifier) deliver(d db.Db, sink Sink, aid string, events []Event, current map[string]string) {
+	if len(events) > 0 {
+		if delivery := n.send(sink, events, n.retries); delivery.Error != "" {
+			return
+		}
+	}
+	if err := d.Add(DB_TABLE_NOTIFIED, getNotifiedKey(aid, sink.Name()), current); err != nil {
+		log.Printf("unable to record notification to %v: %v\n", sink.Name(), err)
+	}
+}
+
+// send delivers events to a sink, retrying with exponential backoff
+func (n *Notifier) send(sink Sink, events []Event, attempts int) Delivery {
+	d := Delivery{Sink: sink.Name(), Events: len(events)}
+	if len(events) > 0 {
+		d.Org = events[0].Org
+		d.Group = events[0].Group
+		d.App = events[0].App
+	}
+	backoff := n.backoff
+	var err error
+	for d.Attempts < attempts {
+		d.Attempts++
+		if err = sink.Send(events); err == nil {
+			break
+		}
+		var perm permanentError
+		if errors.As(err, &perm) || d.Attempts == attempts {
+			break
+		}
+		log.Printf("notification to %v failed, retrying in %v: %v\n", d.Sink, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err != nil {
+		d.Error = err.Error()
+		log.Printf("notification to %v failed: %v\n", d.Sink, err)
+	}
+	d.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	n.record(d)
+	return d
+}
+
+// record keeps a delivery among recent deliveries
+func (n *Notifier) record(d Delivery) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.deliveries = append(n.deliveries, d)
+	if len(n.deliveries) > MAX_DELIVERIES {
+		n.deliveries = n.deliveries[len(n.deliveries)-MAX_DELIVERIES:]
+	}
+}
+
+// sinkNames returns names of configured sinks
+func (n *Notifier) sinkNames() []string {
+	names := []string{}
+	for _, sink := range n.sinks {
+		names = append(names, sink.Name())
+	}
+	return names
+}
+
+// GetNotifications is GET handler listing sinks and recent deliveries
+func (n *Notifier) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	n.mutex.Lock()
+	status := Status{
+		Severity:   n.cfg.Severity,
+		Sinks:      n.sinkNames(),
+		Deliveries: append([]Delivery{}, n.deliveries...),
+	}
+	n.mutex.Unlock()
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// TestNotifications is POST handler sending a test finding to all sinks once
+func (n *Notifier) TestNotifications(w http.ResponseWriter, r *http.Request) {
+	if len(n.sinks) == 0 {
+		http.Error(w, "No notification sinks configured", http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	ev := Event{
+		App:       "test",
+		Timestamp: now,
+		Change:    CHANGE_NEW,
+		Finding: scenarios.Finding{
+			ID:          fmt.Sprintf("test-%v", time.Now().Unix()),
+			Title:       "Zentaris Test Notification",
+			Risk:        n.cfg.Severity,
+			Remediation: "No action required",
+		},
+	}
+	deliveries := []Delivery{}
+	for _, sink := range n.sinks {
+		deliveries = append(deliveries, n.send(sink, []Event{ev}, 1))
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Status{Severity: n.cfg.Severity, Sinks: n.sinkNames(), Deliveries: deliveries})
+}
@@ -0,0 +1,341 @@
+package notify
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+// testEvent returns an event of a finding
+func testEvent(title, risk string) Event {
+	return Event{
+		App:       "a1",
+		Timestamp: "2024-05-01T10:00:00Z",
+		Change:    CHANGE_NEW,
+		Finding:   scenarios.Finding{ID: "f1", Title: title, Risk: risk, Entity: "e1"},
+	}
+}
+
+func TestCEF(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   Event
+		want string
+	}{
+		{
+			"escaped header and values",
+			Event{Timestamp: "2024-05-01T10:00:00Z", Change: CHANGE_NEW, Finding: scenarios.Finding{
+				ID: "f1", Title: `S3 | public`, Risk: scenarios.RISK_CRITICAL, Remediation: "a=b\nc\\d"}},
+			`CEF:0|Zetafence|Zentaris|1.0.0|s3-public|S3 \| public|10|rt=1714557600000 act=new externalId=f1 msg=a\=b\nc\\d`,
+		},
+		{
+			"tenant and custom strings",
+			Event{Org: "acme", Group: "prod", App: "a1", Timestamp: "now", Change: CHANGE_CHANGED, PreviousRisk: "low",
+				Finding: scenarios.Finding{Title: "Escalation", Risk: scenarios.RISK_HIGH, Entity: "e1"}},
+			`CEF:0|Zetafence|Zentaris|1.0.0|escalation|Escalation|8|rt=now act=changed flexString1Label=tenant flexString1=acme/prod ` +
+				`cs1Label=app cs1=a1 cs2Label=entity cs2=e1 cs6Label=previousRisk cs6=low`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CEF(tt.ev); got != tt.want {
+				t.Fatalf("expecting\n%v\ngot\n%v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSyslogFraming(t *testing.T) {
+	events := []Event{testEvent("First", scenarios.RISK_HIGH), testEvent("Second", scenarios.RISK_CRITICAL)}
+	want := []string{"<131>", "<130>"}
+
+	t.Run("tcp", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		lines := make(chan []string)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				close(lines)
+				return
+			}
+			defer conn.Close()
+			ret := []string{}
+			sc := bufio.NewScanner(conn)
+			for sc.Scan() {
+				ret = append(ret, sc.Text())
+			}
+			lines <- ret
+		}()
+
+		s, _ := NewSyslogSink(l.Addr().String(), SYSLOG_TCP, false)
+		if err := s.Send(events); err != nil {
+			t.Fatal(err)
+		}
+		got := <-lines
+		if len(got) != len(events) {
+			t.Fatalf("expecting %v newline framed messages, got %q", len(events), got)
+		}
+		for i, line := range got {
+			if !strings.HasPrefix(line, want[i]) || !strings.HasSuffix(line, CEF(events[i])) {
+				t.Errorf("expecting %v priority and CEF payload, got %q", want[i], line)
+			}
+		}
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+		s, _ := NewSyslogSink(pc.LocalAddr().String(), SYSLOG_UDP, false)
+		if err := s.Send(events); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4096)
+		for i := range events {
+			pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := string(buf[:n])
+			if !strings.HasPrefix(msg, want[i]) || !strings.HasSuffix(msg, CEF(events[i])) {
+				t.Errorf("expecting one unframed message per datagram, got %q", msg)
+			}
+		}
+	})
+}
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", "1700000000", []byte(`{"events":[]}`))
+	if want := "sha256=3947de27ec923573170fccda604ddfb25583ff98dc51e96cca2e11c59545026a"; got != want {
+		t.Fatalf("expecting %v, got %v", want, got)
+	}
+
+	// receivers verify the signature of the body and timestamp received
+	verified := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sig := Sign("secret", r.Header.Get(HEADER_TIMESTAMP), body)
+		verified <- r.Header.Get(HEADER_SIGNATURE) == sig && r.Header.Get(HEADER_EVENT) == EVENT_FINDINGS
+	}))
+	defer srv.Close()
+	if err := NewWebhookSink(srv.URL, "secret", false).Send([]Event{testEvent("First", scenarios.RISK_HIGH)}); err != nil {
+		t.Fatal(err)
+	}
+	if !<-verified {
+		t.Fatalf("expecting a valid signature")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		failed   bool
+	}{
+		{"delivered", []int{http.StatusOK}, 1, false},
+		{"retried server errors", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, 3, false},
+		{"client error not retried", []int{http.StatusBadRequest}, 1, true},
+		{"retries run out", []int{http.StatusBadGateway}, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				w.WriteHeader(tt.statuses[min(requests, len(tt.statuses)-1)])
+				requests++
+			}))
+			defer srv.Close()
+
+			n := NewNotifier(Config{})
+			n.backoff = time.Millisecond
+			d := n.send(NewWebhookSink(srv.URL, "", false), []Event{testEvent("First", scenarios.RISK_HIGH)}, 3)
+			if d.Attempts != tt.attempts || requests != tt.attempts || (d.Error != "") != tt.failed {
+				t.Fatalf("expecting %v attempts, failed %v, got %+v after %v requests", tt.attempts, tt.failed, d, requests)
+			}
+		})
+	}
+}
+
+// min returns the smaller of two ints
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// testSink records events sent, failing while asked to
+type testSink struct {
+	name   string
+	fail   bool
+	events []Event
+}
+
+func (s *testSink) Name() string {
+	return s.name
+}
+
+func (s *testSink) Send(events []Event) error {
+	if s.fail {
+		return permanentError{errors.New("unreachable")}
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestDeliveredPerSink(t *testing.T) {
+	d := db.NewMemoryDb(DB_TABLE_NOTIFIED)
+	n := NewNotifier(Config{Severity: scenarios.RISK_HIGH})
+	up := &testSink{name: "up"}
+	down := &testSink{name: "down", fail: true}
+	findings := []scenarios.Finding{
+		{Title: "Escalation", Entity: "e1", Risk: scenarios.RISK_HIGH},
+		{Title: "Escalation", Entity: "e1", Risk: scenarios.RISK_CRITICAL},
+		{Title: "Dormant", Entity: "e2", Risk: scenarios.RISK_LOW},
+	}
+
+	// evaluate, delivering changes to each sink
+	evaluate := func(findings []scenarios.Finding) map[string][]Event {
+		ret := map[string][]Event{}
+		for _, sink := range []*testSink{up, down} {
+			events, current := n.Changes(d, "a1", sink.name, "ag1", "now", findings)
+			ret[sink.name] = events
+			n.deliver(d, sink, "a1", events, current)
+		}
+		return ret
+	}
+
+	// the highest risk of a finding is sent once to the sink it reached,
+	// and again to the sink it failed to reach
+	for i := 0; i < 2; i++ {
+		got := evaluate(findings)
+		if len(got["down"]) != 1 || got["down"][0].Risk != scenarios.RISK_CRITICAL || got["down"][0].Change != CHANGE_NEW {
+			t.Fatalf("evaluation %v: expecting the critical finding to be sent again, got %+v", i, got["down"])
+		}
+		if want := 1 - i; len(got["up"]) != want {
+			t.Fatalf("evaluation %v: expecting %v events sent, got %+v", i, want, got["up"])
+		}
+	}
+
+	// risk changes are sent once the sink is reachable
+	down.fail = false
+	findings[1].Risk = scenarios.RISK_MEDIUM
+	findings[2].Risk = scenarios.RISK_HIGH
+	got := evaluate(findings)
+	if len(got["up"]) != 2 || got["up"][0].Change != CHANGE_CHANGED || got["up"][0].PreviousRisk != scenarios.RISK_CRITICAL {
+		t.Fatalf("expecting changed and new findings, got %+v", got["up"])
+	}
+	if len(got["down"]) != 2 || got["down"][0].Change != CHANGE_NEW || len(down.events) != 2 {
+		t.Fatalf("expecting new findings delivered, got %+v", got["down"])
+	}
+	if got := evaluate(findings); len(got["up"])+len(got["down"]) != 0 {
+		t.Fatalf("expecting no changes, got %+v", got)
+	}
+}
+
+// blockingSink records events sent, each send waiting to be released
+type blockingSink struct {
+	mutex   sync.Mutex
+	started chan bool
+	release chan bool
+	events  []Event
+}
+
+func (s *blockingSink) Name() string {
+	return "blocking"
+}
+
+func (s *blockingSink) Send(events []Event) error {
+	s.started <- true
+	<-s.release
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// putFinding stores a finding of an attack graph on an entity
+func putFinding(d db.Db, agid, title, eid, risk string) {
+	key := graph.GetEntityKey(agid, title+" ("+eid+")")
+	graph.Entities(d).Put(key, graph.Entity{ID: key, Name: title,
+		Attributes: map[string]string{scenarios.ATTR_ENTITY: eid, "Risk": risk}})
+}
+
+// idle waits until no delivery is running
+func idle(t *testing.T, n *Notifier) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		n.mutex.Lock()
+		running := len(n.running)
+		n.mutex.Unlock()
+		if running == 0 {
+			return
+		}
+	}
+	t.Fatalf("expecting deliveries to be done")
+}
+
+func TestHookSerialized(t *testing.T) {
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS, DB_TABLE_NOTIFIED)
+	if err := graph.CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	putFinding(d, "ag1", "Escalation", "e1", scenarios.RISK_HIGH)
+	putFinding(d, "ag2", "Escalation", "e1", scenarios.RISK_CRITICAL)
+
+	sink := &blockingSink{started: make(chan bool), release: make(chan bool)}
+	n := NewNotifier(Config{})
+	n.sinks = []Sink{sink}
+	hook := n.Hook(d, "acme", "prod")
+
+	// evaluations during a delivery are diffed once it is done, so the new
+	// finding is sent once
+	hook(risk.TrendPoint{App: "a1", AttackGraph: "ag1", Timestamp: "t1"})
+	<-sink.started
+	hook(risk.TrendPoint{App: "a1", AttackGraph: "ag1", Timestamp: "t2"})
+	hook(risk.TrendPoint{App: "a1", AttackGraph: "ag1", Timestamp: "t3"})
+	sink.release <- true
+	idle(t, n)
+	if len(sink.events) != 1 || sink.events[0].Change != CHANGE_NEW || sink.events[0].Org != "acme" {
+		t.Fatalf("expecting one new finding sent, got %+v", sink.events)
+	}
+
+	// an older evaluation pending while a newer one is delivered is replaced
+	// by it, and never overwrites the findings it delivered
+	hook(risk.TrendPoint{App: "a1", AttackGraph: "ag2", Timestamp: "t4"})
+	<-sink.started
+	hook(risk.TrendPoint{App: "a1", AttackGraph: "ag1", Timestamp: "t5"})
+	hook(risk.TrendPoint{App: "a1", AttackGraph: "ag2", Timestamp: "t6"})
+	sink.release <- true
+	idle(t, n)
+	if len(sink.events) != 2 || sink.events[1].Change != CHANGE_CHANGED || sink.events[1].Risk != scenarios.RISK_CRITICAL {
+		t.Fatalf("expecting the raised risk sent once, got %+v", sink.events)
+	}
+	v, _ := d.Get(DB_TABLE_NOTIFIED, getNotifiedKey("a1", sink.Name()))
+	if got := v.(map[string]string)["Escalation/e1"]; got != scenarios.RISK_CRITICAL {
+		t.Fatalf("expecting critical risk delivered, got %v", got)
+	}
+}
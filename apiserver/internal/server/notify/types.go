@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+// Notifier forwards new or changed findings of app evaluations of all
+// tenants to SIEM tools
+type Notifier struct {
+	cfg   Config
+	sinks []Sink
+
+	// delivery retries with exponential backoff
+	retries int
+	backoff time.Duration
+
+	// most recent deliveries, oldest first
+	mutex      sync.Mutex
+	deliveries []Delivery
+
+	// latest evaluation pending delivery per tenant, app and sink, and those
+	// being delivered, deliveries to a sink of an app being serialized
+	pending map[string]*pending
+	running map[string]bool
+}
+
+// evaluation of an app of a tenant pending delivery
+type pending struct {
+	d        db.Db
+	org      string
+	group    string
+	point    risk.TrendPoint
+	findings []scenarios.Finding
+}
+
+// Config configures SIEM forwarding of findings
+type Config struct {
+	Severity      string // minimum risk of forwarded findings
+	SyslogAddr    string // syslog receiver host:port
+	SyslogProto   string // udp, tcp or tls
+	WebhookURL    string // JSON webhook receiver
+	WebhookSecret string // HMAC key signing webhook payloads
+	Insecure      bool   // skip TLS certificate verification of receivers
+}
+
+// Sink delivers findings events to a receiver
+type Sink interface {
+	Name() string
+	Send(events []Event) error
+}
+
+// Event is a new or changed finding of an app evaluation
+type Event struct {
+	Org          string `json:"org"`
+	Group        string `json:"group"`
+	App          string `json:"app"`
+	AttackGraph  string `json:"attackGraph"`
+	Timestamp    string `json:"timestamp"`
+	Change       string `json:"change"` // new or changed
+	PreviousRisk string `json:"previousRisk,omitempty"`
+	scenarios.Finding
+}
+
+// WebhookPayload is the JSON body posted to webhooks
+type WebhookPayload struct {
+	Org         string  `json:"org"`
+	Group       string  `json:"group"`
+	App         string  `json:"app"`
+	AttackGraph string  `json:"attackGraph"`
+	Timestamp   string  `json:"timestamp"`
+	Events      []Event `json:"events"`
+}
+
+// Delivery is the outcome of delivering events to a sink
+type Delivery struct {
+	Sink      string `json:"sink"`
+	Org       string `json:"org"`
+	Group     string `json:"group"`
+	App       string `json:"app"`
+	Events    int    `json:"events"`
+	Attempts  int    `json:"attempts"`
+	Timestamp string `json:"timestamp"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Status lists configured sinks and recent deliveries
+type Status struct {
+	Severity   string     `json:"severity"`
+	Sinks      []string   `json:"sinks"`
+	Deliveries []Delivery `json:"deliveries"`
+}
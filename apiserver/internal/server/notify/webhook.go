@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// webhook headers
+	HEADER_EVENT     = "X-Zentaris-Event"
+	HEADER_TIMESTAMP = "X-Zentaris-Timestamp"
+	HEADER_SIGNATURE = "X-Zentaris-Signature"
+
+	// webhook event type
+	EVENT_FINDINGS = "findings"
+
+	// timeout of webhook requests
+	WEBHOOK_TIMEOUT = 10 * time.Second
+)
+
+// permanentError is a delivery error not worth retrying
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Sign returns the HMAC-SHA256 signature of a webhook body sent at a given
+// unix timestamp, i.e. "sha256=" followed by hex HMAC of "<timestamp>.<body>"
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookSink posts events as signed JSON, one request per evaluation
+type WebhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookSink creates a webhook sink
+func NewWebhookSink(url, secret string, insecure bool) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		secret: secret,
+		client: &http.Client{
+			Timeout: WEBHOOK_TIMEOUT,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+	}
+}
+
+// Name returns the sink name
+func (s *WebhookSink) Name() string {
+	return "webhook+" + s.url
+}
+
+// Send posts events, failing permanently on client errors other than 429
+func (s *WebhookSink) Send(events []Event) error {
+	payload := WebhookPayload{Events: events}
+	if len(events) > 0 {
+		payload.Org = events[0].Org
+		payload.Group = events[0].Group
+		payload.App = events[0].App
+		payload.AttackGraph = events[0].AttackGraph
+		payload.Timestamp = events[0].Timestamp
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return permanentError{err}
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_EVENT, EVENT_FINDINGS)
+	req.Header.Set(HEADER_TIMESTAMP, ts)
+	if s.secret != "" {
+		req.Header.Set(HEADER_SIGNATURE, Sign(s.secret, ts, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return permanentError{fmt.Errorf("webhook returned %v", resp.Status)}
+}
@@ -0,0 +1,561 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// token kinds
+const (
+	TOKEN_EOF    = "end of query"
+	TOKEN_IDENT  = "identifier"
+	TOKEN_STRING = "string"
+	TOKEN_NUMBER = "number"
+	TOKEN_PARAM  = "parameter"
+	TOKEN_PUNCT  = "symbol"
+)
+
+// edge pattern directions
+const (
+	DIR_ANY   = 0
+	DIR_RIGHT = 1
+	DIR_LEFT  = 2
+)
+
+// operator of inline pattern properties
+const OP_PROPERTY = "PROPERTY"
+
+// two character symbols
+var symbols = []string{"<>", "!=", "<=", ">=", "=~"}
+
+// comparison operators
+var comparisons = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "=~": true}
+
+type token struct {
+	kind   string
+	value  string
+	quoted bool
+	pos    int
+}
+
+// parser is a recursive descent parser of queries
+type parser struct {
+	src string
+	pos int
+	tok token
+
+	// number of anonymous variables
+	anonymous int
+
+	// hyperedges of patterns, and nesting of expressions being parsed
+	hops  int
+	depth int
+}
+
+// SyntaxError is a query syntax error at an offset of the query
+type SyntaxError struct {
+	Msg    string
+	Offset int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// parse parses a query statement
+func parse(src string) (st *statement, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se
+		}
+	}()
+
+	p := &parser{src: src}
+	p.next()
+	st = &statement{limit: -1}
+	if p.keyword("EXPLAIN") {
+		st.explain = true
+	}
+	p.expectKeyword("MATCH")
+	st.patterns = append(st.patterns, p.pattern())
+	for p.symbol(",") {
+		st.patterns = append(st.patterns, p.pattern())
+	}
+	if p.keyword("WHERE") {
+		st.where = p.expr()
+	}
+
+	p.expectKeyword("RETURN")
+	st.distinct = p.keyword("DISTINCT")
+	for {
+		start := p.tok.pos
+		item := &returnItem{expr: p.expr()}
+		item.alias = strings.TrimSpace(p.src[start:p.tok.pos])
+		if p.keyword("AS") {
+			item.alias = p.ident()
+		}
+		st.returns = append(st.returns, item)
+		if !p.symbol(",") {
+			break
+		}
+	}
+
+	if p.keyword("ORDER") {
+		p.expectKeyword("BY")
+		for {
+			item := &orderItem{expr: p.expr()}
+			if p.keyword("DESC") {
+				item.desc = true
+			} else {
+				p.keyword("ASC")
+			}
+			st.orderBy = append(st.orderBy, item)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if p.keyword("SKIP") {
+		st.skip = p.count()
+	}
+	if p.keyword("LIMIT") {
+		pos := p.tok.pos
+		if st.limit = p.count(); st.limit > MAX_LIMIT {
+			p.fail(pos, "LIMIT larger than %d", MAX_LIMIT)
+		}
+	}
+	p.symbol(";")
+	if p.tok.kind != TOKEN_EOF {
+		p.unexpected()
+	}
+	return st, nil
+}
+
+func (p *parser) fail(pos int, format string, args ...interface{}) {
+	panic(&SyntaxError{Msg: fmt.Sprintf(format, args...), Offset: pos})
+}
+
+func (p *parser) unexpected() {
+	if p.tok.kind == TOKEN_EOF {
+		p.fail(p.tok.pos, "unexpected end of query")
+	}
+	p.fail(p.tok.pos, "unexpected %s %q", p.tok.kind, p.tok.value)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// next reads the next token, skipping whitespace and // comments
+func (p *parser) next() {
+	for p.pos < len(p.src) {
+		if strings.HasPrefix(p.src[p.pos:], "//") {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c := p.src[p.pos]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			break
+		}
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: TOKEN_EOF, pos: start}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case isIdentStart(c):
+		for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok = token{kind: TOKEN_IDENT, value: p.src[start:p.pos], pos: start}
+	case c == '`':
+		end := strings.IndexByte(p.src[p.pos+1:], '`')
+		if end < 0 {
+			p.fail(start, "unterminated quoted identifier")
+		}
+		p.tok = token{kind: TOKEN_IDENT, value: p.src[p.pos+1 : p.pos+1+end], quoted: true, pos: start}
+		p.pos += end + 2
+	case c == '$':
+		p.pos++
+		for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+			p.pos++
+		}
+		if p.pos == start+1 {
+			p.fail(start, "missing parameter name")
+		}
+		p.tok = token{kind: TOKEN_PARAM, value: p.src[start+1 : p.pos], pos: start}
+	case c == '"' || c == '\'':
+		p.tok = token{kind: TOKEN_STRING, value: p.str(c), pos: start}
+	case c >= '0' && c <= '9':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: TOKEN_NUMBER, value: p.src[start:p.pos], pos: start}
+	default:
+		for _, s := range symbols {
+			if strings.HasPrefix(p.src[p.pos:], s) {
+				p.pos += len(s)
+				p.tok = token{kind: TOKEN_PUNCT, value: s, pos: start}
+				return
+			}
+		}
+		if !strings.ContainsRune("()[]{},.:;-<>=*+", rune(c)) {
+			p.fail(start, "unexpected character %q", c)
+		}
+		p.pos++
+		p.tok = token{kind: TOKEN_PUNCT, value: string(c), pos: start}
+	}
+}
+
+// str reads a quoted string with backslash escapes
+func (p *parser) str(quote byte) string {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) {
+			p.fail(start, "unterminated string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return b.String()
+		case '\\':
+			if p.pos >= len(p.src) {
+				p.fail(start, "unterminated string")
+			}
+			esc := p.src[p.pos]
+			p.pos++
+			switch esc {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(esc)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// keyword consumes a case-insensitive keyword if present
+func (p *parser) keyword(kw string) bool {
+	if p.tok.kind == TOKEN_IDENT && !p.tok.quoted && strings.EqualFold(p.tok.value, kw) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kw string) {
+	if !p.keyword(kw) {
+		if p.tok.kind == TOKEN_EOF {
+			p.fail(p.tok.pos, "expected %s", kw)
+		}
+		p.fail(p.tok.pos, "expected %s, found %q", kw, p.tok.value)
+	}
+}
+
+// isKeyword returns true if the current token is one of given keywords
+func (p *parser) isKeyword(kws ...string) bool {
+	for _, kw := range kws {
+		if p.tok.kind == TOKEN_IDENT && !p.tok.quoted && strings.EqualFold(p.tok.value, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// symbol consumes a symbol if present
+func (p *parser) symbol(s string) bool {
+	if p.tok.kind == TOKEN_PUNCT && p.tok.value == s {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) {
+	if !p.symbol(s) {
+		if p.tok.kind == TOKEN_EOF {
+			p.fail(p.tok.pos, "expected %q", s)
+		}
+		p.fail(p.tok.pos, "expected %q, found %q", s, p.tok.value)
+	}
+}
+
+func (p *parser) ident() string {
+	if p.tok.kind != TOKEN_IDENT {
+		p.unexpected()
+	}
+	name := p.tok.value
+	p.next()
+	return name
+}
+
+func (p *parser) count() int {
+	if p.tok.kind != TOKEN_NUMBER {
+		p.unexpected()
+	}
+	n, err := strconv.Atoi(p.tok.value)
+	if err != nil || n < 0 {
+		p.fail(p.tok.pos, "invalid count %q", p.tok.value)
+	}
+	p.next()
+	return n
+}
+
+// label reads a kind or hyperedge label, quoted or raw up to whitespace or a
+// closing bracket, e.g. iam:user or s3:*
+func (p *parser) label() string {
+	if p.tok.kind == TOKEN_STRING {
+		s := p.tok.value
+		p.next()
+		return s
+	}
+	start := p.tok.pos
+	end := start
+	for end < len(p.src) && !strings.ContainsRune(" \t\r\n(){}[]", rune(p.src[end])) {
+		end++
+	}
+	if end == start {
+		p.unexpected()
+	}
+	p.pos = end
+	p.next()
+	return p.src[start:end]
+}
+
+// variable returns an optional variable name, else a new anonymous one
+func (p *parser) variable() string {
+	if p.tok.kind == TOKEN_IDENT {
+		return p.ident()
+	}
+	p.anonymous++
+	return fmt.Sprintf("_%d", p.anonymous)
+}
+
+func (p *parser) props() map[string]expr {
+	props := map[string]expr{}
+	if !p.symbol("{") {
+		return props
+	}
+	for !p.symbol("}") {
+		if len(props) > 0 {
+			p.expect(",")
+		}
+		name := p.ident()
+		p.expect(":")
+		props[name] = p.primary()
+	}
+	return props
+}
+
+func (p *parser) node() *nodePattern {
+	p.expect("(")
+	n := &nodePattern{variable: p.variable()}
+	if p.symbol(":") {
+		n.kind = p.label()
+	}
+	n.props = p.props()
+	p.expect(")")
+	return n
+}
+
+// edge reads -[h:label]->, <-[h:label]- or -[h:label]-
+func (p *parser) edge() *edgePattern {
+	e := &edgePattern{dir: DIR_ANY}
+	if p.symbol("<") {
+		e.dir = DIR_LEFT
+	}
+	p.expect("-")
+	p.expect("[")
+	e.variable = p.variable()
+	if p.symbol(":") {
+		e.label = p.label()
+	}
+	e.props = p.props()
+	p.expect("]")
+	p.expect("-")
+	if p.symbol(">") {
+		if e.dir == DIR_LEFT {
+			p.fail(p.tok.pos, "hyperedge pattern with both directions")
+		}
+		e.dir = DIR_RIGHT
+	}
+	return e
+}
+
+func (p *parser) pattern() *pattern {
+	pt := &pattern{nodes: []*nodePattern{p.node()}}
+	for (p.tok.kind == TOKEN_PUNCT) && (p.tok.value == "-" || p.tok.value == "<") {
+		if p.hops++; p.hops > MAX_HOPS {
+			p.fail(p.tok.pos, "query matches more than %d hyperedges", MAX_HOPS)
+		}
+		pt.edges = append(pt.edges, p.edge())
+		pt.nodes = append(pt.nodes, p.node())
+	}
+	return pt
+}
+
+// expressions, lowest precedence first
+
+// nest enters an expression, failing when nested too deep
+func (p *parser) nest() {
+	if p.depth++; p.depth > MAX_NESTING {
+		p.fail(p.tok.pos, "expression nested deeper than %d levels", MAX_NESTING)
+	}
+}
+
+func (p *parser) expr() expr {
+	p.nest()
+	defer func() { p.depth-- }()
+	left := p.and()
+	for p.keyword("OR") {
+		left = &binaryExpr{op: "OR", left: left, right: p.and()}
+	}
+	return left
+}
+
+func (p *parser) and() expr {
+	left := p.not()
+	for p.keyword("AND") {
+		left = &binaryExpr{op: "AND", left: left, right: p.not()}
+	}
+	return left
+}
+
+func (p *parser) not() expr {
+	if p.keyword("NOT") {
+		p.nest()
+		defer func() { p.depth-- }()
+		return &unaryExpr{op: "NOT", operand: p.not()}
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() expr {
+	left := p.primary()
+	switch {
+	case p.tok.kind == TOKEN_PUNCT && comparisons[p.tok.value]:
+		op := p.tok.value
+		if op == "!=" {
+			op = "<>"
+		}
+		p.next()
+		return &binaryExpr{op: op, left: left, right: p.primary()}
+	case p.keyword("IN"):
+		return &binaryExpr{op: "IN", left: left, right: p.primary()}
+	case p.keyword("CONTAINS"):
+		return &binaryExpr{op: "CONTAINS", left: left, right: p.primary()}
+	case p.keyword("STARTS"):
+		p.expectKeyword("WITH")
+		return &binaryExpr{op: "STARTS WITH", left: left, right: p.primary()}
+	case p.keyword("ENDS"):
+		p.expectKeyword("WITH")
+		return &binaryExpr{op: "ENDS WITH", left: left, right: p.primary()}
+	case p.keyword("IS"):
+		op := "IS NULL"
+		if p.keyword("NOT") {
+			op = "IS NOT NULL"
+		}
+		p.expectKeyword("NULL")
+		return &unaryExpr{op: op, operand: left}
+	}
+	return left
+}
+
+func (p *parser) primary() expr {
+	tok := p.tok
+	switch tok.kind {
+	case TOKEN_STRING:
+		p.next()
+		return &literalExpr{value: tok.value}
+	case TOKEN_NUMBER:
+		p.next()
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			p.fail(tok.pos, "invalid number %q", tok.value)
+		}
+		return &literalExpr{value: f}
+	case TOKEN_PARAM:
+		p.next()
+		return &paramExpr{name: tok.value}
+	case TOKEN_PUNCT:
+		switch tok.value {
+		case "(":
+			p.next()
+			e := p.expr()
+			p.expect(")")
+			return e
+		case "[":
+			p.next()
+			l := &listExpr{}
+			for !p.symbol("]") {
+				if len(l.items) > 0 {
+					p.expect(",")
+				}
+				l.items = append(l.items, p.expr())
+			}
+			return l
+		case "-":
+			p.next()
+			if p.tok.kind != TOKEN_NUMBER {
+				p.unexpected()
+			}
+			lit := p.primary().(*literalExpr)
+			lit.value = -lit.value.(float64)
+			return lit
+		}
+	case TOKEN_IDENT:
+		if !tok.quoted {
+			switch strings.ToUpper(tok.value) {
+			case "TRUE", "FALSE":
+				p.next()
+				return &literalExpr{value: strings.EqualFold(tok.value, "true")}
+			case "NULL":
+				p.next()
+				return &literalExpr{}
+			}
+		}
+		p.next()
+		if p.symbol("(") {
+			f := &funcExpr{name: strings.ToLower(tok.value)}
+			if p.symbol("*") {
+				f.star = true
+				p.expect(")")
+				return f
+			}
+			for !p.symbol(")") {
+				if len(f.args) > 0 {
+					p.expect(",")
+				}
+				f.args = append(f.args, p.expr())
+			}
+			return f
+		}
+		if p.symbol(".") {
+			return &propExpr{variable: tok.value, prop: p.ident()}
+		}
+		return &varExpr{name: tok.value}
+	}
+	p.unexpected()
+	return nil
+}
@@ -0,0 +1,447 @@
+package query
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+// index holds hyperedge membership of entities of a hypergraph
+type index struct {
+	h *graph.Hypergraph
+
+	// sorted entity ids
+	ids []string
+
+	// entity id -> sorted ids of hyperedges with the entity as a member
+	assocs map[string][]string
+
+	// hyperedge id -> trimmed from, to and other member ids
+	from, rest map[string][]string
+}
+
+func newIndex(h *graph.Hypergraph) *index {
+	idx := &index{
+		h:      h,
+		assocs: make(map[string][]string),
+		from:   make(map[string][]string),
+		rest:   make(map[string][]string),
+	}
+	for id := range h.Entities {
+		idx.ids = append(idx.ids, id)
+	}
+	sort.Strings(idx.ids)
+
+	aids := make([]string, 0, len(h.Assocs))
+	for id := range h.Assocs {
+		aids = append(aids, id)
+	}
+	sort.Strings(aids)
+	for _, id := range aids {
+		a := h.Assocs[id]
+		seen := map[string]bool{}
+		add := func(list []string, members []string) []string {
+			for _, m := range members {
+				m = graph.TrimAppPrefix(h.AppID, m)
+				if _, ok := h.Entities[m]; !ok {
+					continue
+				}
+				list = append(list, m)
+				if !seen[m] {
+					seen[m] = true
+					idx.assocs[m] = append(idx.assocs[m], id)
+				}
+			}
+			return list
+		}
+		idx.from[id] = add(nil, a.FromEntities)
+		idx.rest[id] = add(add(nil, a.ToEntities), a.OtherEntities)
+	}
+	return idx
+}
+
+// ofKind returns number of entities matching a kind pattern
+func (idx *index) ofKind(kind string) int {
+	n := 0
+	for _, e := range idx.h.Entities {
+		if matches(kind, e.Kind) {
+			n++
+		}
+	}
+	return n
+}
+
+// members returns members of a hyperedge on the left and right sides of an
+// edge pattern
+func (idx *index) members(aid string, dir int) (left, right []string) {
+	switch dir {
+	case DIR_RIGHT:
+		return idx.from[aid], idx.rest[aid]
+	case DIR_LEFT:
+		return idx.rest[aid], idx.from[aid]
+	}
+	all := append(append([]string{}, idx.from[aid]...), idx.rest[aid]...)
+	return all, all
+}
+
+// matches checks a kind or label against a glob pattern, e.g. iam:*
+func matches(pattern, value string) bool {
+	if pattern == "" || pattern == value {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
+
+// variables returns variables referenced by an expression
+func variables(e expr, vars map[string]bool) {
+	switch e := e.(type) {
+	case *varExpr:
+		vars[e.name] = true
+	case *propExpr:
+		vars[e.variable] = true
+	case *listExpr:
+		for _, item := range e.items {
+			variables(item, vars)
+		}
+	case *unaryExpr:
+		variables(e.operand, vars)
+	case *binaryExpr:
+		variables(e.left, vars)
+		variables(e.right, vars)
+	case *funcExpr:
+		for _, arg := range e.args {
+			variables(arg, vars)
+		}
+	}
+}
+
+// conjuncts splits an expression into its top level AND operands
+func conjuncts(e expr) []expr {
+	if b, ok := e.(*binaryExpr); ok && b.op == "AND" {
+		return append(conjuncts(b.left), conjuncts(b.right)...)
+	}
+	if e == nil {
+		return nil
+	}
+	return []expr{e}
+}
+
+// propFilters returns filters of inline pattern properties, sorted by property
+// name, matching a value or any value of a list
+func propFilters(variable string, props map[string]expr) []expr {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	filters := []expr{}
+	for _, name := range names {
+		filters = append(filters, &binaryExpr{
+			op:    OP_PROPERTY,
+			left:  &propExpr{variable: variable, prop: name},
+			right: props[name],
+		})
+	}
+	return filters
+}
+
+// planner builds a plan of a statement over a hypergraph
+type planner struct {
+	idx    *index
+	params map[string]interface{}
+
+	// variable -> true for nodes, false for hyperedges
+	kinds map[string]bool
+	bound map[string]bool
+	plan  *plan
+}
+
+// check verifies variables are used consistently and only reference bound
+// variables
+func (pl *planner) check(st *statement) error {
+	declare := func(name string, node bool) error {
+		if node2, ok := pl.kinds[name]; ok && node2 != node {
+			return fmt.Errorf("variable %v used as both entity and hyperedge", name)
+		}
+		pl.kinds[name] = node
+		return nil
+	}
+	for _, pt := range st.patterns {
+		for _, n := range pt.nodes {
+			if err := declare(n.variable, true); err != nil {
+				return err
+			}
+		}
+		for _, e := range pt.edges {
+			if err := declare(e.variable, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	vars := map[string]bool{}
+	variables(st.where, vars)
+	for _, item := range st.returns {
+		variables(item.expr, vars)
+	}
+	for _, item := range st.orderBy {
+		item.expr = pl.resolveAlias(st, item.expr)
+		variables(item.expr, vars)
+	}
+	for name := range vars {
+		if _, ok := pl.kinds[name]; !ok {
+			return fmt.Errorf("undefined variable %v", name)
+		}
+	}
+	return nil
+}
+
+// resolveAlias replaces an order by reference to a returned column alias with
+// a column reference
+func (pl *planner) resolveAlias(st *statement, e expr) expr {
+	v, ok := e.(*varExpr)
+	if !ok {
+		return e
+	}
+	if _, ok := pl.kinds[v.name]; ok {
+		return e
+	}
+	for i, item := range st.returns {
+		if item.alias == v.name {
+			return &columnExpr{index: i}
+		}
+	}
+	return e
+}
+
+// candidates estimates number of entities a node pattern scan yields
+func (pl *planner) candidates(n *nodePattern) (int, []string) {
+	if id, ok := n.props["id"]; ok {
+		switch v := constant(id, pl.params).(type) {
+		case string:
+			return 1, []string{v}
+		case []interface{}:
+			ids := []string{}
+			for _, s := range v {
+				ids = append(ids, fmt.Sprint(s))
+			}
+			return len(ids), ids
+		}
+	}
+	if n.kind != "" {
+		return pl.idx.ofKind(n.kind), nil
+	}
+	return len(pl.idx.ids), nil
+}
+
+// start returns position of the node a pattern is matched from: an already
+// bound node, else the most selective one
+func (pl *planner) start(pt *pattern) int {
+	for i, n := range pt.nodes {
+		if pl.bound[n.variable] {
+			return i
+		}
+	}
+	best, cost := 0, -1
+	for i, n := range pt.nodes {
+		c, _ := pl.candidates(n)
+		if cost < 0 || c < cost {
+			best, cost = i, c
+		}
+	}
+	return best
+}
+
+func (pl *planner) scan(n *nodePattern) {
+	s := &step{node: n, kind: n.kind}
+	switch {
+	case pl.bound[n.variable]:
+		s.desc = fmt.Sprintf("check bound %v", nodeString(n))
+	default:
+		c, ids := pl.candidates(n)
+		s.ids = ids
+		switch {
+		case ids != nil:
+			s.desc = fmt.Sprintf("scan %v by id, %d candidates", nodeString(n), c)
+		case n.kind != "":
+			s.desc = fmt.Sprintf("scan %v by kind, %d candidates", nodeString(n), c)
+		default:
+			s.desc = fmt.Sprintf("scan %v, all %d entities", nodeString(n), c)
+		}
+	}
+	pl.bound[n.variable] = true
+	pl.plan.steps = append(pl.plan.steps, s)
+}
+
+func (pl *planner) expand(from *nodePattern, e *edgePattern, to *nodePattern, reversed bool) {
+	s := &step{node: to, edge: e, from: from.variable, reversed: reversed, kind: to.kind}
+	left, right := nodeString(from), nodeString(to)
+	if reversed {
+		left, right = right, left
+	}
+	how := "membership"
+	if pl.bound[e.variable] {
+		how = "bound hyperedge"
+	}
+	s.desc = fmt.Sprintf("expand %v%v%v from %v via %v", left, edgeString(e), right, from.variable, how)
+	pl.bound[e.variable] = true
+	pl.bound[to.variable] = true
+	pl.plan.steps = append(pl.plan.steps, s)
+}
+
+// newPlan plans a statement: patterns are matched in order, each from its
+// most selective node and expanded along its hyperedges, with filters pushed
+// down to the earliest step binding all their variables
+func newPlan(st *statement, idx *index, params map[string]interface{}) (*plan, error) {
+	pl := &planner{
+		idx:    idx,
+		params: params,
+		kinds:  map[string]bool{},
+		bound:  map[string]bool{},
+		plan:   &plan{},
+	}
+	if err := pl.check(st); err != nil {
+		return nil, err
+	}
+
+	filters := conjuncts(st.where)
+	for _, pt := range st.patterns {
+		for _, n := range pt.nodes {
+			filters = append(filters, propFilters(n.variable, n.props)...)
+		}
+		for _, e := range pt.edges {
+			filters = append(filters, propFilters(e.variable, e.props)...)
+		}
+
+		start := pl.start(pt)
+		pl.scan(pt.nodes[start])
+		for i := start; i < len(pt.edges); i++ {
+			pl.expand(pt.nodes[i], pt.edges[i], pt.nodes[i+1], false)
+		}
+		for i := start - 1; i >= 0; i-- {
+			pl.expand(pt.nodes[i+1], pt.edges[i], pt.nodes[i], true)
+		}
+	}
+
+	// push filters to the first step with all their variables bound
+	bound := map[string]bool{}
+	for _, s := range pl.plan.steps {
+		bound[s.node.variable] = true
+		if s.edge != nil {
+			bound[s.edge.variable] = true
+		}
+		rest := []expr{}
+		for _, f := range filters {
+			vars := map[string]bool{}
+			variables(f, vars)
+			ready := true
+			for v := range vars {
+				ready = ready && bound[v]
+			}
+			if !ready {
+				rest = append(rest, f)
+				continue
+			}
+			s.filters = append(s.filters, f)
+			s.desc += fmt.Sprintf(", filter %v", exprString(f))
+		}
+		filters = rest
+	}
+	return pl.plan, nil
+}
+
+// constant evaluates a literal or parameter expression, nil otherwise
+func constant(e expr, params map[string]interface{}) interface{} {
+	switch e := e.(type) {
+	case *literalExpr:
+		return e.value
+	case *paramExpr:
+		return params[e.name]
+	case *listExpr:
+		items := []interface{}{}
+		for _, item := range e.items {
+			items = append(items, constant(item, params))
+		}
+		return items
+	}
+	return nil
+}
+
+func nodeString(n *nodePattern) string {
+	if n.kind == "" {
+		return "(" + n.variable + ")"
+	}
+	return "(" + n.variable + ":" + n.kind + ")"
+}
+
+func edgeString(e *edgePattern) string {
+	s := "-[" + e.variable
+	if e.label != "" {
+		s += ":" + e.label
+	}
+	s += "]-"
+	switch e.dir {
+	case DIR_RIGHT:
+		return s + ">"
+	case DIR_LEFT:
+		return "<" + s
+	}
+	return s
+}
+
+// exprString renders an expression in query syntax
+func exprString(e expr) string {
+	switch e := e.(type) {
+	case *literalExpr:
+		switch v := e.value.(type) {
+		case nil:
+			return "null"
+		case string:
+			return fmt.Sprintf("%q", v)
+		}
+		return fmt.Sprint(e.value)
+	case *paramExpr:
+		return "$" + e.name
+	case *varExpr:
+		return e.name
+	case *propExpr:
+		return e.variable + "." + e.prop
+	case *columnExpr:
+		return fmt.Sprintf("column %d", e.index+1)
+	case *listExpr:
+		items := []string{}
+		for _, item := range e.items {
+			items = append(items, exprString(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *unaryExpr:
+		if e.op == "NOT" {
+			return "NOT " + exprString(e.operand)
+		}
+		return exprString(e.operand) + " " + e.op
+	case *binaryExpr:
+		op := e.op
+		if op == OP_PROPERTY {
+			op = "="
+		}
+		s := exprString(e.left) + " " + op + " " + exprString(e.right)
+		if e.op == "OR" {
+			return "(" + s + ")"
+		}
+		return s
+	case *funcExpr:
+		if e.star {
+			return e.name + "(*)"
+		}
+		args := []string{}
+		for _, arg := range e.args {
+			args = append(args, exprString(arg))
+		}
+		return e.name + "(" + strings.Join(args, ", ") + ")"
+	}
+	return "?"
+}
@@ -0,0 +1,878 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	// bindings returned without an explicit LIMIT
+	DEFAULT_LIMIT = 1000
+
+	// maximum candidate bindings tried by a single query
+	MAX_STEPS = 1000000
+
+	// maximum bindings kept by a single query to order, group or skip them,
+	// and maximum LIMIT
+	MAX_ROWS  = 100000
+	MAX_LIMIT = 10000
+
+	// maximum hyperedges matched by a query, and nesting of its expressions
+	MAX_HOPS    = 10
+	MAX_NESTING = 32
+
+	// maximum size of posted requests
+	MAX_REQUEST_SIZE = 1 << 20
+)
+
+// NewQuery returns a new Query element on a given DB
+func NewQuery(db db.Db) *Query {
+	return &Query{
+		db: db,
+	}
+}
+
+// evalError is a query error raised while planning or matching
+type evalError struct {
+	msg string
+}
+
+func (e *evalError) Error() string {
+	return e.msg
+}
+
+func fail(format string, args ...interface{}) {
+	panic(&evalError{msg: fmt.Sprintf(format, args...)})
+}
+
+// entityVal and assocVal are entities and hyperedges bound to variables
+type entityVal struct {
+	id string
+}
+
+type assocVal struct {
+	id string
+}
+
+// row is a projected binding, keeping its binding for ORDER BY
+type row struct {
+	values  []interface{}
+	binding map[string]interface{}
+	group   []map[string]interface{}
+}
+
+// executor matches a plan by backtracking over its steps
+type executor struct {
+	db     db.Db
+	st     *statement
+	plan   *plan
+	idx    *index
+	params map[string]interface{}
+
+	steps    int
+	agg      bool
+	bindings []map[string]interface{}
+	rows     []*row
+	seen     map[string]bool
+	regexps  map[string]*regexp.Regexp
+
+	// lazily computed entity risk, number of findings and crown jewels
+	risks    map[string]string
+	findings map[string]int
+	jewels   map[string]risk.CrownJewel
+}
+
+// Run runs a query over the hypergraph of an app
+func (q *Query) Run(aid string, req Request) (res Result, err error) {
+	st, err := parse(req.Query)
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*evalError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	idx := newIndex(graph.LoadHypergraph(q.db, aid))
+	p, err := newPlan(st, idx, req.Params)
+	if err != nil {
+		return res, err
+	}
+	res = Result{
+		App:      aid,
+		Columns:  []string{},
+		Bindings: []map[string]interface{}{},
+	}
+	for _, item := range st.returns {
+		res.Columns = append(res.Columns, item.alias)
+	}
+	if st.explain {
+		for _, s := range p.steps {
+			res.Plan = append(res.Plan, s.desc)
+		}
+		return res, nil
+	}
+
+	ex := &executor{
+		db:      q.db,
+		st:      st,
+		plan:    p,
+		idx:     idx,
+		params:  req.Params,
+		seen:    map[string]bool{},
+		regexps: map[string]*regexp.Regexp{},
+	}
+	for _, item := range st.returns {
+		if isCount(item.expr) {
+			ex.agg = true
+		} else if hasCount(item.expr) {
+			fail("count() is only supported as a returned column")
+		}
+	}
+	ex.match(0, map[string]interface{}{})
+	if ex.agg {
+		ex.aggregate()
+	}
+	ex.order()
+
+	limit := st.limit
+	if limit < 0 {
+		limit = DEFAULT_LIMIT
+	}
+	for i := st.skip; i < len(ex.rows) && len(res.Bindings) < limit; i++ {
+		binding := map[string]interface{}{}
+		for j, col := range res.Columns {
+			binding[col] = ex.output(ex.rows[i].values[j])
+		}
+		res.Bindings = append(res.Bindings, binding)
+	}
+	res.Count = len(res.Bindings)
+	return res, nil
+}
+
+// match binds variables of a step and its following steps, returning false
+// once enough bindings are found
+func (ex *executor) match(i int, b map[string]interface{}) bool {
+	if i == len(ex.plan.steps) {
+		return ex.emit(b)
+	}
+	s := ex.plan.steps[i]
+	h := ex.idx.h
+
+	// scan of node candidates
+	if s.edge == nil {
+		for _, id := range ex.scan(s, b) {
+			if !ex.try(s, b, map[string]interface{}{s.node.variable: entityVal{id}}, func() bool {
+				return ex.match(i+1, b)
+			}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// expansion through hyperedges of a bound node
+	from := b[s.from].(entityVal).id
+	assocs := ex.idx.assocs[from]
+	if a, ok := b[s.edge.variable].(assocVal); ok {
+		assocs = []string{a.id}
+	}
+	for _, aid := range assocs {
+		if !matches(s.edge.label, h.Assocs[aid].Label) {
+			continue
+		}
+		left, right := ex.idx.members(aid, s.edge.dir)
+		if s.reversed {
+			left, right = right, left
+		}
+		if !contains(left, from) {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, to := range right {
+			if to == from || seen[to] || !matches(s.kind, h.Entities[to].Kind) {
+				continue
+			}
+			seen[to] = true
+			vals := map[string]interface{}{s.edge.variable: assocVal{aid}, s.node.variable: entityVal{to}}
+			if !ex.try(s, b, vals, func() bool { return ex.match(i+1, b) }) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// scan returns candidate entity ids of a scan step
+func (ex *executor) scan(s *step, b map[string]interface{}) []string {
+	h := ex.idx.h
+	ids := ex.idx.ids
+	if v, ok := b[s.node.variable].(entityVal); ok {
+		ids = []string{v.id}
+	} else if s.ids != nil {
+		ids = []string{}
+		for _, id := range s.ids {
+			ids = append(ids, graph.TrimAppPrefix(h.AppID, id))
+		}
+	}
+
+	ret := []string{}
+	for _, id := range ids {
+		if e, ok := h.Entities[id]; ok && matches(s.kind, e.Kind) {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// try binds given values, checks filters of a step and continues matching
+func (ex *executor) try(s *step, b map[string]interface{}, vals map[string]interface{}, next func() bool) bool {
+	ex.steps++
+	if ex.steps > MAX_STEPS {
+		fail("query exceeded %d steps, narrow the pattern or add filters", MAX_STEPS)
+	}
+
+	bound := []string{}
+	defer func() {
+		for _, name := range bound {
+			delete(b, name)
+		}
+	}()
+	for name, v := range vals {
+		if cur, ok := b[name]; ok {
+			if cur != v {
+				return true
+			}
+			continue
+		}
+		b[name] = v
+		bound = append(bound, name)
+	}
+	for _, f := range s.filters {
+		if !truthy(ex.eval(f, b, nil)) {
+			return true
+		}
+	}
+	return next()
+}
+
+// emit projects a complete binding
+func (ex *executor) emit(b map[string]interface{}) bool {
+	copied := make(map[string]interface{}, len(b))
+	for k, v := range b {
+		copied[k] = v
+	}
+	if len(ex.rows)+len(ex.bindings) >= MAX_ROWS {
+		fail("query kept more than %d bindings, add filters or a LIMIT without ORDER BY", MAX_ROWS)
+	}
+	if ex.agg {
+		ex.bindings = append(ex.bindings, copied)
+		return true
+	}
+
+	r := &row{binding: copied}
+	for _, item := range ex.st.returns {
+		r.values = append(r.values, ex.eval(item.expr, copied, nil))
+	}
+	if ex.st.distinct {
+		key := fmt.Sprintf("%#v", r.values)
+		if ex.seen[key] {
+			return true
+		}
+		ex.seen[key] = true
+	}
+	ex.rows = append(ex.rows, r)
+
+	// without ordering, stop as soon as the requested page is complete
+	limit := ex.st.limit
+	if limit < 0 {
+		limit = DEFAULT_LIMIT
+	}
+	return len(ex.st.orderBy) > 0 || len(ex.rows) < ex.st.skip+limit
+}
+
+// aggregate groups bindings by returned columns other than count()
+func (ex *executor) aggregate() {
+	groups := map[string]*row{}
+	keys := []string{}
+	for _, b := range ex.bindings {
+		values := make([]interface{}, len(ex.st.returns))
+		for i, item := range ex.st.returns {
+			if !isCount(item.expr) {
+				values[i] = ex.eval(item.expr, b, nil)
+			}
+		}
+		key := fmt.Sprintf("%#v", values)
+		g, ok := groups[key]
+		if !ok {
+			g = &row{values: values, binding: b}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.group = append(g.group, b)
+	}
+
+	// counting all bindings yields a single row even without matches
+	if len(ex.bindings) == 0 {
+		all := true
+		for _, item := range ex.st.returns {
+			all = all && isCount(item.expr)
+		}
+		if all {
+			key := ""
+			groups[key] = &row{values: make([]interface{}, len(ex.st.returns)), binding: map[string]interface{}{}}
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		g := groups[key]
+		for i, item := range ex.st.returns {
+			if !isCount(item.expr) {
+				continue
+			}
+			f := item.expr.(*funcExpr)
+			n := 0
+			for _, b := range g.group {
+				if f.star || ex.eval(f.args[0], b, nil) != nil {
+					n++
+				}
+			}
+			g.values[i] = n
+		}
+		if ex.st.distinct {
+			k := fmt.Sprintf("%#v", g.values)
+			if ex.seen[k] {
+				continue
+			}
+			ex.seen[k] = true
+		}
+		ex.rows = append(ex.rows, g)
+	}
+}
+
+// order sorts rows by ORDER BY items, nulls last
+func (ex *executor) order() {
+	if len(ex.st.orderBy) == 0 {
+		return
+	}
+	keys := make([][]interface{}, len(ex.rows))
+	for i, r := range ex.rows {
+		for _, item := range ex.st.orderBy {
+			keys[i] = append(keys[i], ex.eval(item.expr, r.binding, r))
+		}
+	}
+	perm := make([]int, len(ex.rows))
+	for i := range perm {
+		perm[i] = i
+	}
+	sort.SliceStable(perm, func(i, j int) bool {
+		a, b := keys[perm[i]], keys[perm[j]]
+		for k, item := range ex.st.orderBy {
+			c := orderCompare(a[k], b[k])
+			if c == 0 {
+				continue
+			}
+			if item.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	rows := make([]*row, len(ex.rows))
+	for i, p := range perm {
+		rows[i] = ex.rows[p]
+	}
+	ex.rows = rows
+}
+
+// isCount checks if an expression is a count() aggregate
+func isCount(e expr) bool {
+	f, ok := e.(*funcExpr)
+	return ok && f.name == "count"
+}
+
+// hasCount checks if an expression contains a count() aggregate
+func hasCount(e expr) bool {
+	switch e := e.(type) {
+	case *funcExpr:
+		if e.name == "count" {
+			return true
+		}
+		for _, arg := range e.args {
+			if hasCount(arg) {
+				return true
+			}
+		}
+	case *listExpr:
+		for _, item := range e.items {
+			if hasCount(item) {
+				return true
+			}
+		}
+	case *unaryExpr:
+		return hasCount(e.operand)
+	case *binaryExpr:
+		return hasCount(e.left) || hasCount(e.right)
+	}
+	return false
+}
+
+// eval evaluates an expression on a binding, or a projected row
+func (ex *executor) eval(e expr, b map[string]interface{}, r *row) interface{} {
+	switch e := e.(type) {
+	case *literalExpr:
+		return e.value
+	case *paramExpr:
+		v, ok := ex.params[e.name]
+		if !ok {
+			fail("missing parameter $%v", e.name)
+		}
+		return v
+	case *varExpr:
+		return b[e.name]
+	case *propExpr:
+		return ex.property(b[e.variable], e.prop)
+	case *columnExpr:
+		if r == nil {
+			return nil
+		}
+		return r.values[e.index]
+	case *listExpr:
+		items := []interface{}{}
+		for _, item := range e.items {
+			items = append(items, ex.eval(item, b, r))
+		}
+		return items
+	case *unaryExpr:
+		v := ex.eval(e.operand, b, r)
+		switch e.op {
+		case "NOT":
+			return !truthy(v)
+		case "IS NULL":
+			return v == nil
+		}
+		return v != nil
+	case *binaryExpr:
+		return ex.binary(e, b, r)
+	case *funcExpr:
+		return ex.call(e, b, r)
+	}
+	fail("unsupported expression")
+	return nil
+}
+
+func (ex *executor) binary(e *binaryExpr, b map[string]interface{}, r *row) interface{} {
+	switch e.op {
+	case "AND":
+		return truthy(ex.eval(e.left, b, r)) && truthy(ex.eval(e.right, b, r))
+	case "OR":
+		return truthy(ex.eval(e.left, b, r)) || truthy(ex.eval(e.right, b, r))
+	}
+
+	left, right := ex.eval(e.left, b, r), ex.eval(e.right, b, r)
+	if left == nil || right == nil {
+		return false
+	}
+	switch e.op {
+	case "=":
+		return equals(left, right)
+	case OP_PROPERTY:
+		if list, ok := right.([]interface{}); ok {
+			if _, ok := left.([]interface{}); !ok {
+				for _, item := range list {
+					if equals(left, item) {
+						return true
+					}
+				}
+				return false
+			}
+		}
+		return equals(left, right)
+	case "<>":
+		return !equals(left, right)
+	case "<", "<=", ">", ">=":
+		c, ok := compare(left, right)
+		if !ok {
+			return false
+		}
+		switch e.op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		}
+		return c >= 0
+	case "=~":
+		s, ok := left.(string)
+		pattern, ok2 := right.(string)
+		if !ok || !ok2 {
+			return false
+		}
+		re, ok := ex.regexps[pattern]
+		if !ok {
+			var err error
+			if re, err = regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+				fail("invalid regular expression %q: %v", pattern, err)
+			}
+			ex.regexps[pattern] = re
+		}
+		return re.MatchString(s)
+	case "IN":
+		list, ok := right.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range list {
+			if equals(left, item) {
+				return true
+			}
+		}
+		return false
+	case "CONTAINS":
+		if list, ok := left.([]interface{}); ok {
+			for _, item := range list {
+				if equals(item, right) {
+					return true
+				}
+			}
+			return false
+		}
+		s, ok := left.(string)
+		sub, ok2 := right.(string)
+		return ok && ok2 && strings.Contains(s, sub)
+	case "STARTS WITH", "ENDS WITH":
+		s, ok := left.(string)
+		affix, ok2 := right.(string)
+		if !ok || !ok2 {
+			return false
+		}
+		if e.op == "STARTS WITH" {
+			return strings.HasPrefix(s, affix)
+		}
+		return strings.HasSuffix(s, affix)
+	}
+	fail("unsupported operator %v", e.op)
+	return nil
+}
+
+func (ex *executor) call(f *funcExpr, b map[string]interface{}, r *row) interface{} {
+	if f.name == "count" {
+		fail("count() is only supported as a returned column")
+	}
+	if len(f.args) != 1 {
+		fail("%v() expects a single argument", f.name)
+	}
+	v := ex.eval(f.args[0], b, r)
+	switch f.name {
+	case "size":
+		switch v := v.(type) {
+		case string:
+			return len(v)
+		case []interface{}:
+			return len(v)
+		}
+		return nil
+	case "lower", "upper":
+		s, ok := v.(string)
+		if !ok {
+			return nil
+		}
+		if f.name == "lower" {
+			return strings.ToLower(s)
+		}
+		return strings.ToUpper(s)
+	}
+	fail("unknown function %v()", f.name)
+	return nil
+}
+
+// property returns a property of a bound entity or hyperedge, nil if absent
+func (ex *executor) property(v interface{}, prop string) interface{} {
+	h := ex.idx.h
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case entityVal:
+		e := h.Entities[v.id]
+		switch prop {
+		case "id":
+			return e.ID
+		case "name":
+			return e.Name
+		case "kind":
+			return e.Kind
+		case "description":
+			return e.Description
+		case "app":
+			return h.AppID
+		case "risk":
+			ex.loadFindings()
+			if r, ok := ex.risks[e.ID]; ok {
+				return r
+			}
+			return nil
+		case "findings":
+			ex.loadFindings()
+			return ex.findings[e.ID]
+		case "crownJewel":
+			if ex.jewels == nil {
+				ex.jewels = risk.NewRisk(ex.db).CrownJewels(h)
+			}
+			_, ok := ex.jewels[e.ID]
+			return ok
+		}
+		if a, ok := e.Attributes[prop]; ok {
+			return a
+		}
+		return nil
+	case assocVal:
+		a := h.Assocs[v.id]
+		switch prop {
+		case "id":
+			return a.ID
+		case "name":
+			return a.Name
+		case "label":
+			return a.Label
+		case "description":
+			return a.Description
+		case "permissions":
+			return strings2list(a.Permissions())
+		case "members":
+			return strings2list(trim(h.AppID, a.Members()))
+		case "from":
+			return strings2list(trim(h.AppID, a.FromEntities))
+		case "to":
+			return strings2list(trim(h.AppID, a.ToEntities))
+		case "other":
+			return strings2list(trim(h.AppID, a.OtherEntities))
+		}
+		if attr, ok := a.Attributes[prop]; ok {
+			return attr
+		}
+		return nil
+	}
+	fail("property %v of a value that is not an entity or hyperedge", prop)
+	return nil
+}
+
+// loadFindings loads risk and number of findings of entities from the latest
+// attack graph of the app
+func (ex *executor) loadFindings() {
+	if ex.risks != nil {
+		return
+	}
+	ex.risks = map[string]string{}
+	ex.findings = map[string]int{}
+	aid := ex.idx.h.AppID
+	for _, f := range scenarios.NewScenario(ex.db).LatestFindings(aid) {
+		eid := graph.TrimAppPrefix(aid, f.Entity)
+		ex.findings[eid]++
+		if cur, ok := ex.risks[eid]; !ok || scenarios.RiskRank(f.Risk) > scenarios.RiskRank(cur) {
+			ex.risks[eid] = f.Risk
+		}
+	}
+}
+
+// output converts a value to its JSON representation
+func (ex *executor) output(v interface{}) interface{} {
+	h := ex.idx.h
+	switch v := v.(type) {
+	case entityVal:
+		return h.Entities[v.id]
+	case assocVal:
+		a := h.Assocs[v.id]
+		a.FromEntities = trim(h.AppID, a.FromEntities)
+		a.ToEntities = trim(h.AppID, a.ToEntities)
+		a.OtherEntities = trim(h.AppID, a.OtherEntities)
+		return a
+	case []interface{}:
+		items := []interface{}{}
+		for _, item := range v {
+			items = append(items, ex.output(item))
+		}
+		return items
+	}
+	return v
+}
+
+func trim(aid string, ids []string) []string {
+	ret := []string{}
+	for _, id := range ids {
+		ret = append(ret, graph.TrimAppPrefix(aid, id))
+	}
+	return ret
+}
+
+func strings2list(s []string) []interface{} {
+	ret := []interface{}{}
+	for _, v := range s {
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// number converts numbers and numeric strings
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compare orders two values: numerically if both are numbers, by rank if both
+// are risk levels, else as strings or booleans
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	switch a := a.(type) {
+	case string:
+		s, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		if x, y := scenarios.RiskRank(a), scenarios.RiskRank(s); x >= 0 && y >= 0 {
+			return x - y, true
+		}
+		return strings.Compare(a, s), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a == y:
+			return 0, true
+		case !a:
+			return -1, true
+		}
+		return 1, true
+	case entityVal:
+		y, ok := b.(entityVal)
+		return strings.Compare(a.id, y.id), ok
+	case assocVal:
+		y, ok := b.(assocVal)
+		return strings.Compare(a.id, y.id), ok
+	}
+	return 0, false
+}
+
+func equals(a, b interface{}) bool {
+	if x, ok := a.([]interface{}); ok {
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equals(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	c, ok := compare(a, b)
+	return ok && c == 0
+}
+
+// orderCompare orders any two values, nulls last
+func orderCompare(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	if c, ok := compare(a, b); ok {
+		return c
+	}
+	return strings.Compare(fmt.Sprintf("%T%v", a, a), fmt.Sprintf("%T%v", b, b))
+}
+
+// RunQuery is POST handler to match a pattern query over the hypergraph of an
+// app, returning its bindings
+func (q *Query) RunQuery(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	aid := vars["id"]
+	if _, err := q.db.Get(graph.DB_TABLE_GRAPH, aid); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+
+	var req Request
+	body, err := io.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_SIZE))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "invalid query request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		http.Error(w, "missing query", http.StatusBadRequest)
+		return
+	}
+
+	res, err := q.Run(aid, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
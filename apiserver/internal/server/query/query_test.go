@@ -0,0 +1,150 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+// newTestDb returns a DB of an app of users assuming a role reading a bucket
+func newTestDb(t *testing.T) db.Db {
+	t.Helper()
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS, graph.DB_TABLE_SNAPSHOTS)
+	if err := graph.CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	entities := []graph.Entity{
+		{ID: "u1", Name: "alice", Kind: "iam:user", Attributes: map[string]string{"admin": "true", "age": "30"}},
+		{ID: "u2", Name: "bob", Kind: "iam:user", Attributes: map[string]string{"age": "5"}},
+		{ID: "r1", Name: "deployer", Kind: "iam:role"},
+		{ID: "b1", Name: "logs", Kind: "s3:bucket"},
+	}
+	for _, e := range entities {
+		e.ID = graph.GetEntityKey("a1", e.ID)
+		graph.Entities(d).Put(e.ID, e)
+	}
+	assocs := []graph.Assoc{
+		{ID: "s1", Label: "sts:assume", FromEntities: []string{"a1/u1", "a1/u2"}, ToEntities: []string{"a1/r1"}},
+		{ID: "s2", Label: "s3:read", FromEntities: []string{"a1/r1"}, ToEntities: []string{"a1/b1"}},
+	}
+	for _, a := range assocs {
+		a.ID = graph.GetEntityKey("a1", a.ID)
+		graph.Assocs(d).Put(a.ID, a)
+	}
+	return d
+}
+
+// rows renders bindings of a result as column values joined by spaces, one
+// binding per row
+func rows(res Result) string {
+	ret := []string{}
+	for _, b := range res.Bindings {
+		vals := []string{}
+		for _, col := range res.Columns {
+			vals = append(vals, fmt.Sprint(b[col]))
+		}
+		ret = append(ret, strings.Join(vals, " "))
+	}
+	return strings.Join(ret, "; ")
+}
+
+func TestRun(t *testing.T) {
+	q := NewQuery(newTestDb(t))
+	tests := []struct {
+		name   string
+		query  string
+		params map[string]interface{}
+		rows   string
+		err    string
+	}{
+		{"hyperedge by label", `MATCH (u:iam:user)-[h:sts:*]->(r) RETURN u.name, h.label, r.name ORDER BY u.name`, nil,
+			"alice sts:assume deployer; bob sts:assume deployer", ""},
+		{"two hops", `MATCH (u:iam:user)-[h1]->(r)-[h2:s3:*]->(b) RETURN DISTINCT b.name`, nil, "logs", ""},
+		{"backwards", `MATCH (b:s3:bucket)<-[h]-(r) RETURN r.name`, nil, "deployer", ""},
+		{"numeric comparison", `MATCH (u:iam:user) WHERE u.age > 10 RETURN u.name`, nil, "alice", ""},
+		{"string operators", `MATCH (u) WHERE u.name STARTS WITH 'a' OR u.name =~ 'b.b' RETURN u.name ORDER BY u.name DESC`, nil,
+			"bob; alice", ""},
+		{"null and lists", `MATCH (u:iam:user) WHERE u.admin IS NULL AND u.name IN ['bob', 'carol'] RETURN upper(u.name)`, nil, "BOB", ""},
+		{"parameters", `MATCH (u {name: $name}) RETURN u.kind`, map[string]interface{}{"name": "alice"}, "iam:user", ""},
+		{"count", `MATCH (u:iam:user)-[h]->(r) RETURN r.name, count(*) AS n`, nil, "deployer 2", ""},
+		{"skip and limit", `MATCH (u) RETURN u.name ORDER BY u.name SKIP 1 LIMIT 2`, nil, "bob; deployer", ""},
+		{"nested expressions", `MATCH (u:iam:user) WHERE NOT (NOT (u.admin = 'true')) RETURN u.name`, nil, "alice", ""},
+		{"syntax error", `MATCH (u RETURN u`, nil, "", "syntax error at offset 9"},
+		{"undefined variable", `MATCH (u) RETURN v.name`, nil, "", "undefined variable v"},
+		{"unknown function", `MATCH (u) RETURN foo(u.name)`, nil, "", "unknown function foo()"},
+		{"limit too large", `MATCH (u) RETURN u LIMIT 10001`, nil, "", "LIMIT larger than 10000"},
+		{"too many hops", `MATCH (a)` + strings.Repeat(`-[]->()`, MAX_HOPS+1) + ` RETURN a`, nil, "", "more than 10 hyperedges"},
+		{"too deep", `MATCH (u) WHERE ` + strings.Repeat("NOT ", MAX_NESTING) + `true RETURN u`, nil, "", "nested deeper than 32 levels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := q.Run("a1", Request{Query: tt.query, Params: tt.params})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expecting error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rows(res); got != tt.rows {
+				t.Fatalf("expecting %q, got %q", tt.rows, got)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	res, err := NewQuery(newTestDb(t)).Run("a1", Request{Query: `EXPLAIN MATCH (u:iam:user)-[h]->(r:iam:role) RETURN u`})
+	if err != nil || len(res.Plan) == 0 || len(res.Bindings) != 0 {
+		t.Fatalf("expecting a plan without bindings, got %+v %v", res, err)
+	}
+	// the planner starts from the most selective node
+	if !strings.Contains(res.Plan[0], "r") || !strings.Contains(res.Plan[0], "iam:role") {
+		t.Fatalf("expecting the plan to start from the role, got %v", res.Plan)
+	}
+}
+
+func TestBounded(t *testing.T) {
+	// 400 entities reach one another through one hyperedge
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS, graph.DB_TABLE_SNAPSHOTS)
+	graph.CreateIndexes(d)
+	ids := []string{}
+	for i := 0; i < 400; i++ {
+		ids = append(ids, graph.GetEntityKey("a1", fmt.Sprintf("e%03d", i)))
+		graph.Entities(d).Put(ids[i], graph.Entity{ID: ids[i], Kind: "vm"})
+	}
+	graph.Assocs(d).Put("a1/s1", graph.Assoc{ID: "a1/s1", FromEntities: ids, ToEntities: ids})
+	q := NewQuery(d)
+
+	tests := []struct {
+		name  string
+		query string
+		count int
+		err   string
+	}{
+		{"paged without ordering", `MATCH (a)-[h]->(b) RETURN a.id, b.id`, DEFAULT_LIMIT, ""},
+		{"ordered", `MATCH (a)-[h]->(b) RETURN a.id ORDER BY a.id`, 0, "more than 100000 bindings"},
+		{"skipped", `MATCH (a)-[h]->(b) RETURN a.id SKIP 150000`, 0, "more than 100000 bindings"},
+		{"grouped", `MATCH (a)-[h]->(b) RETURN a.kind, count(*)`, 0, "more than 100000 bindings"},
+		{"walked", `MATCH (a)-[h1]->(b)-[h2]->(c) WHERE c.name = 'none' RETURN a.id`, 0, "steps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := q.Run("a1", Request{Query: tt.query})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expecting error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil || res.Count != tt.count {
+				t.Fatalf("expecting %v bindings, got %v %v", tt.count, res.Count, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package query
+
+import (
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Query runs pattern matching queries over app hypergraphs
+type Query struct {
+	db db.Db
+}
+
+// Request is a query with its $parameters
+type Request struct {
+	Query  string                 `json:"query"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// Result lists matching bindings, each binding maps returned columns to
+// entities, hyperedges or values
+type Result struct {
+	App      string                   `json:"app"`
+	Columns  []string                 `json:"columns"`
+	Bindings []map[string]interface{} `json:"bindings"`
+	Count    int                      `json:"count"`
+	Plan     []string                 `json:"plan,omitempty"`
+}
+
+// parsed query
+
+type statement struct {
+	explain  bool
+	patterns []*pattern
+	where    expr
+	distinct bool
+	returns  []*returnItem
+	orderBy  []*orderItem
+	skip     int
+	limit    int
+}
+
+// pattern is a path of node patterns joined by hyperedge patterns
+type pattern struct {
+	nodes []*nodePattern
+	edges []*edgePattern
+}
+
+type nodePattern struct {
+	variable string
+	kind     string
+	props    map[string]expr
+}
+
+// edgePattern matches a hyperedge between its left and right nodes: from the
+// From members to the To or Other members (DIR_RIGHT), backwards (DIR_LEFT),
+// or between any two members (DIR_ANY)
+type edgePattern struct {
+	variable string
+	label    string
+	props    map[string]expr
+	dir      int
+}
+
+type returnItem struct {
+	expr  expr
+	alias string
+}
+
+type orderItem struct {
+	expr expr
+	desc bool
+}
+
+// expressions
+
+type expr interface{}
+
+type literalExpr struct {
+	value interface{}
+}
+
+type paramExpr struct {
+	name string
+}
+
+type varExpr struct {
+	name string
+}
+
+type propExpr struct {
+	variable string
+	prop     string
+}
+
+type listExpr struct {
+	items []expr
+}
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+// columnExpr references a returned column, e.g. an alias in ORDER BY
+type columnExpr struct {
+	index int
+}
+
+type funcExpr struct {
+	name string
+	args []expr
+	star bool
+}
+
+// plan is a sequence of steps binding one variable each, followed by filters
+// evaluated as soon as their variables are bound
+type plan struct {
+	steps []*step
+}
+
+type step struct {
+	// scan of node candidates, or expansion from a bound node through an edge
+	node     *nodePattern
+	edge     *edgePattern
+	from     string
+	reversed bool
+
+	// scan candidates restricted by id or kind
+	ids  []string
+	kind string
+
+	filters []expr
+	desc    string
+}
@@ -0,0 +1,339 @@
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+const (
+	CHOKE_POINT_ENTITY = "entity"
+	CHOKE_POINT_ASSOC  = "assoc"
+
+	// minimum risk category of paths considered for choke points
+	DEFAULT_CHOKE_POINT_CATEGORY = CATEGORY_HIGH
+)
+
+// ChokePoint is a single remediation, and the attack paths it eliminates
+type ChokePoint struct {
+	Type         string  `json:"type"`
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Remediation  string  `json:"remediation"`
+	Paths        int     `json:"paths"`
+	RiskScore    float64 `json:"riskScore"`
+	InMinimumCut bool    `json:"inMinimumCut"`
+	EntryPoint   bool    `json:"entryPoint,omitempty"`
+}
+
+// ChokePointReport ranks remediations of an app by eliminated attack paths
+type ChokePointReport struct {
+	App           string       `json:"app"`
+	Category      string       `json:"category"`
+	TotalPaths    int          `json:"totalPaths"`
+	HighRiskPaths int          `json:"highRiskPaths"`
+	TotalRisk     float64      `json:"totalRisk"`
+	Truncated     bool         `json:"truncated"`
+	MinimumCut    []ChokePoint `json:"minimumCut"`
+	Remediations  []ChokePoint `json:"remediations"`
+}
+
+// getChokePointKey returns a key unique across entities and hyperedges
+func getChokePointKey(typ, id string) string {
+	return typ + ":" + id
+}
+
+// remediation returns a remediation recommendation of a choke point
+func remediation(h *graph.Hypergraph, cp ChokePoint) string {
+	if cp.Type == CHOKE_POINT_ASSOC {
+		a := h.Assocs[cp.ID]
+		return fmt.Sprintf("Remove or scope down hyperedge %v granting %v.",
+			cp.ID, strings.Join(a.Permissions(), ", "))
+	}
+	e := h.Entities[cp.ID]
+	switch {
+	case cp.EntryPoint:
+		return fmt.Sprintf("Remove internet exposure of %v %v by closing open ports and public addresses.", e.Kind, cp.ID)
+	case strings.Contains(e.Kind, "user"):
+		return fmt.Sprintf("Enforce MFA and remove unused credentials of user %v.", cp.ID)
+	case strings.Contains(e.Kind, "role"):
+		return fmt.Sprintf("Restrict trust policy and set permission boundary of role %v.", cp.ID)
+	case strings.Contains(e.Kind, "policy"):
+		return fmt.Sprintf("Apply least privilege to policy %v.", cp.ID)
+	}
+	return fmt.Sprintf("Isolate %v %v from attack paths.", e.Kind, cp.ID)
+}
+
+// ChokePoints ranks entities and hyperedges by the number and risk of
+// attack paths at or above a category that pass through them
+func (rk *Risk) ChokePoints(h *graph.Hypergraph, maxDepth int, category string) ChokePointReport {
+	paths, truncated := rk.AttackPaths(h, maxDepth)
+	report := ChokePointReport{
+		App:          h.AppID,
+		Category:     category,
+		TotalPaths:   len(paths),
+		Truncated:    truncated,
+		MinimumCut:   []ChokePoint{},
+		Remediations: []ChokePoint{},
+	}
+
+	points := map[string]*ChokePoint{}
+	add := func(typ, id string, score float64) {
+		key := getChokePointKey(typ, id)
+		cp, ok := points[key]
+		if !ok {
+			cp = &ChokePoint{Type: typ, ID: id}
+			points[key] = cp
+		}
+		cp.Paths++
+		cp.RiskScore += score
+	}
+
+	for _, p := range paths {
+		if categoryRank(p.Category) < categoryRank(category) {
+			continue
+		}
+		report.HighRiskPaths++
+		report.TotalRisk += p.Score
+
+		// crown jewels themselves are not remediations
+		for _, eid := range p.Entities {
+			if eid != p.Target {
+				add(CHOKE_POINT_ENTITY, eid, p.Score)
+			}
+		}
+		for _, aid := range p.Assocs {
+			add(CHOKE_POINT_ASSOC, aid, p.Score)
+		}
+	}
+	report.TotalRisk = roundScore(report.TotalRisk)
+
+	entries := map[string]bool{}
+	for _, eid := range EntryPoints(h) {
+		entries[eid] = true
+	}
+	minCut := rk.minimumCut(h)
+	for _, key := range minCut {
+		if _, ok := points[key]; !ok {
+			typ, id, _ := strings.Cut(key, ":")
+			points[key] = &ChokePoint{Type: typ, ID: id}
+		}
+		points[key].InMinimumCut = true
+	}
+
+	for _, cp := range points {
+		cp.EntryPoint = cp.Type == CHOKE_POINT_ENTITY && entries[cp.ID]
+		if cp.Type == CHOKE_POINT_ENTITY {
+			cp.Name = h.Entities[cp.ID].Name
+		} else {
+			cp.Name = h.Assocs[cp.ID].Name
+		}
+		cp.Remediation = remediation(h, *cp)
+		cp.RiskScore = roundScore(cp.RiskScore)
+		if cp.Paths > 0 {
+			report.Remediations = append(report.Remediations, *cp)
+		}
+	}
+	sort.Slice(report.Remediations, func(i, j int) bool {
+		a, b := report.Remediations[i], report.Remediations[j]
+		if a.Paths != b.Paths {
+			return a.Paths > b.Paths
+		}
+		if a.RiskScore != b.RiskScore {
+			return a.RiskScore > b.RiskScore
+		}
+		return getChokePointKey(a.Type, a.ID) < getChokePointKey(b.Type, b.ID)
+	})
+	for _, key := range minCut {
+		report.MinimumCut = append(report.MinimumCut, *points[key])
+	}
+	return report
+}
+
+// flowEdge is a residual edge of the flow network
+type flowEdge struct {
+	to, rev int
+	cap     int
+}
+
+// flowNetwork is a flow network solved with Edmonds-Karp
+type flowNetwork struct {
+	adj [][]flowEdge
+}
+
+func (fn *flowNetwork) addNode() int {
+	fn.adj = append(fn.adj, nil)
+	return len(fn.adj) - 1
+}
+
+func (fn *flowNetwork) addEdge(from, to, cap int) {
+	fn.adj[from] = append(fn.adj[from], flowEdge{to: to, rev: len(fn.adj[to]), cap: cap})
+	fn.adj[to] = append(fn.adj[to], flowEdge{to: from, rev: len(fn.adj[from]) - 1, cap: 0})
+}
+
+// maxFlow saturates the network and returns nodes reachable from source in
+// the residual network, i.e. the source side of the minimum cut
+func (fn *flowNetwork) maxFlow(s, t int) []bool {
+	for {
+		prev := make([]int, len(fn.adj))
+		prevEdge := make([]int, len(fn.adj))
+		for i := range prev {
+			prev[i] = -1
+		}
+		prev[s] = s
+		queue := []int{s}
+		for len(queue) > 0 && prev[t] < 0 {
+			u := queue[0]
+			queue = queue[1:]
+			for i, e := range fn.adj[u] {
+				if e.cap > 0 && prev[e.to] < 0 {
+					prev[e.to], prevEdge[e.to] = u, i
+					queue = append(queue, e.to)
+				}
+			}
+		}
+		if prev[t] < 0 {
+			reached := make([]bool, len(fn.adj))
+			for i := range prev {
+				reached[i] = prev[i] >= 0
+			}
+			return reached
+		}
+
+		// augment along the shortest path
+		flow := math.MaxInt32
+		for v := t; v != s; v = prev[v] {
+			if c := fn.adj[prev[v]][prevEdge[v]].cap; c < flow {
+				flow = c
+			}
+		}
+		for v := t; v != s; v = prev[v] {
+			e := &fn.adj[prev[v]][prevEdge[v]]
+			e.cap -= flow
+			fn.adj[v][e.rev].cap += flow
+		}
+	}
+}
+
+// minimumCut returns keys of the smallest set of entities and hyperedges
+// separating internet exposed entry points from crown jewels
+func (rk *Risk) minimumCut(h *graph.Hypergraph) []string {
+	const inf = math.MaxInt32
+
+	type split struct{ in, out int }
+	var (
+		jewels = rk.CrownJewels(h)
+		fn     = &flowNetwork{}
+		source = fn.addNode()
+		sink   = fn.addNode()
+		nodes  = map[string]split{}
+	)
+
+	// every entity and hyperedge is split into in/out nodes, whose unit
+	// capacity edge is cut when the entity or hyperedge is remediated
+	node := func(key string, cap int) split {
+		n, ok := nodes[key]
+		if !ok {
+			n = split{in: fn.addNode(), out: fn.addNode()}
+			fn.addEdge(n.in, n.out, cap)
+			nodes[key] = n
+		}
+		return n
+	}
+	entries := map[string]bool{}
+	for _, eid := range EntryPoints(h) {
+		entries[eid] = true
+	}
+	ids := make([]string, 0, len(h.Entities))
+	for eid := range h.Entities {
+		ids = append(ids, eid)
+	}
+	sort.Strings(ids)
+	for _, eid := range ids {
+		cap := 1
+		if _, ok := jewels[eid]; ok && !entries[eid] {
+			cap = inf
+		}
+		n := node(getChokePointKey(CHOKE_POINT_ENTITY, eid), cap)
+		if entries[eid] {
+			fn.addEdge(source, n.in, inf)
+		}
+		if _, ok := jewels[eid]; ok {
+			fn.addEdge(n.out, sink, inf)
+		}
+	}
+	for _, eid := range ids {
+		from := nodes[getChokePointKey(CHOKE_POINT_ENTITY, eid)]
+		for _, hop := range h.Next(eid) {
+			to, ok := nodes[getChokePointKey(CHOKE_POINT_ENTITY, hop.To)]
+			if !ok {
+				continue
+			}
+			if hop.Assoc == "" {
+				fn.addEdge(from.out, to.in, inf)
+				continue
+			}
+			a := node(getChokePointKey(CHOKE_POINT_ASSOC, hop.Assoc), 1)
+			fn.addEdge(from.out, a.in, inf)
+			fn.addEdge(a.out, to.in, inf)
+		}
+	}
+
+	reached := fn.maxFlow(source, sink)
+	cut := []string{}
+	for key, n := range nodes {
+		if reached[n.in] && !reached[n.out] {
+			cut = append(cut, key)
+		}
+	}
+	sort.Strings(cut)
+	return cut
+}
+
+// GetChokePoints is GET handler to retrieve ranked remediations of an app
+func (rk *Risk) GetChokePoints(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars     = mux.Vars(r)
+		aid      = vars["id"]
+		category = r.URL.Query().Get("category")
+		limit    = 0
+	)
+
+	maxDepth, err := parseMaxDepth(r)
+	if err != nil {
+		http.Error(w, "invalid maxDepth", http.StatusBadRequest)
+		return
+	}
+	if category == "" {
+		category = DEFAULT_CHOKE_POINT_CATEGORY
+	}
+	if categoryRank(category) < 0 {
+		http.Error(w, "invalid category", http.StatusBadRequest)
+		return
+	}
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	log.Printf("computing choke points of app %v\n", aid)
+
+	report := rk.ChokePoints(graph.LoadHypergraph(rk.db, aid), maxDepth, category)
+	if limit > 0 && len(report.Remediations) > limit {
+		report.Remediations = report.Remediations[:limit]
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
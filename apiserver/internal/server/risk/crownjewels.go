@@ -0,0 +1,402 @@
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+const (
+	// DB table to store crown jewel rules
+	DB_TABLE_CROWN_JEWEL_RULES = "crownjewelrules"
+
+	// entity attributes set when tagged as crown jewel
+	ATTR_CROWN_JEWEL      = "CrownJewel"
+	ATTR_DATA_SENSITIVITY = "DataSensitivity"
+
+	DEFAULT_SENSITIVITY = "high"
+	TAGGED_BY_API       = "api"
+)
+
+func init() {
+	// rules and trends of apps are deleted along with them
+	graph.RegisterAppTables(DB_TABLE_CROWN_JEWEL_RULES, DB_TABLE_TRENDS)
+}
+
+// NewRisk returns a new risk analysis element
+func NewRisk(db db.Db) *Risk {
+	return &Risk{
+		db: db,
+	}
+}
+
+// matches returns true if glob pattern is empty or matches the value
+func matches(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
+
+// match returns true if the rule matches a given entity of a hypergraph
+func (rule CrownJewelRule) match(h *graph.Hypergraph, e graph.Entity) bool {
+	if rule.Kind == "" && rule.Label == "" && rule.Attribute == "" {
+		return false
+	}
+	if !matches(rule.Kind, e.Kind) {
+		return false
+	}
+	if rule.Attribute != "" {
+		v, ok := e.Attributes[rule.Attribute]
+		if !ok || !matches(rule.Value, v) {
+			return false
+		}
+	}
+	if rule.Label != "" {
+		for _, a := range h.Assocs {
+			if !matches(rule.Label, a.Label) {
+				continue
+			}
+			for _, m := range a.Members() {
+				if graph.TrimAppPrefix(h.AppID, m) == e.ID {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// getCrownJewelRules returns crown jewel rules of a given app
+func (rk *Risk) getCrownJewelRules(aid string) []CrownJewelRule {
+	rules := []CrownJewelRule{}
+	for _, rule := range db.Values(rk.db.Scan(DB_TABLE_CROWN_JEWEL_RULES, graph.GetAppPrefix(aid))) {
+		if r, ok := rule.(CrownJewelRule); ok {
+			r.ID = graph.TrimAppPrefix(aid, r.ID)
+			rules = append(rules, r)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// CrownJewels returns all crown jewels of a hypergraph keyed by entity id
+func (rk *Risk) CrownJewels(h *graph.Hypergraph) map[string]CrownJewel {
+	jewels := make(map[string]CrownJewel)
+	rules := rk.getCrownJewelRules(h.AppID)
+
+	for _, e := range h.Entities {
+		jewel := CrownJewel{
+			ID:   e.ID,
+			Name: e.Name,
+			Kind: e.Kind,
+		}
+		if e.Attributes[ATTR_CROWN_JEWEL] == "true" {
+			jewel.TaggedBy = TAGGED_BY_API
+			jewel.Sensitivity = e.Attributes[ATTR_DATA_SENSITIVITY]
+		} else {
+			for _, rule := range rules {
+				if rule.match(h, e) {
+					jewel.TaggedBy = rule.ID
+					jewel.Sensitivity = rule.Sensitivity
+					break
+				}
+			}
+		}
+		if jewel.TaggedBy == "" {
+			continue
+		}
+		if jewel.Sensitivity == "" {
+			jewel.Sensitivity = DEFAULT_SENSITIVITY
+		}
+		jewels[e.ID] = jewel
+	}
+	return jewels
+}
+
+// CreateCrownJewelRules is POST handler to store crown jewel rules of an app
+func (rk *Risk) CreateCrownJewelRules(w http.ResponseWriter, r *http.Request) {
+	var (
+		ruleList CrownJewelRuleList
+		vars     = mux.Vars(r)
+		aid      = vars["id"]
+	)
+
+	err := json.NewDecoder(r.Body).Decode(&ruleList)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, rule := range ruleList.Rules {
+		if rule.ID == "" {
+			http.Error(w, "crown jewel rule id is required", http.StatusBadRequest)
+			return
+		}
+	}
+
+	for _, rule := range ruleList.Rules {
+		rkey := graph.GetEntityKey(aid, rule.ID)
+		rule.ID = rkey
+		rk.db.Add(DB_TABLE_CROWN_JEWEL_RULES, rkey, rule)
+		log.Printf("new crown jewel rule %v\n", rkey)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "crown jewel rules for app %s created", aid)
+}
+
+// GetCrownJewelRules is GET handler to retrieve crown jewel rules of an app
+func (rk *Risk) GetCrownJewelRules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	aid := vars["id"]
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CrownJewelRuleList{Rules: rk.getCrownJewelRules(aid)})
+}
+
+// TagCrownJewels is POST handler to tag app entities as crown jewels
+func (rk *Risk) TagCrownJewels(w http.ResponseWriter, r *http.Request) {
+	var (
+		tag  CrownJewelTag
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	err := json.NewDecoder(r.Body).Decode(&tag)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if tag.Sensitivity == "" {
+		tag.Sensitivity = DEFAULT_SENSITIVITY
+	}
+
+	// entities are tagged all at once, or none when any is not found
+	var missing string
+	err = db.RunTx(rk.db, func(tx db.Tx) error {
+		for _, eid := range tag.Entities {
+			ekey := graph.GetEntityKey(aid, eid)
+			e, err := graph.Entities(tx).Get(ekey)
+			if err != nil {
+				missing = eid
+				return err
+			}
+
+			// copy attributes to avoid mutating a shared map
+			attrs := make(map[string]string, len(e.Attributes)+2)
+			for k, v := range e.Attributes {
+				attrs[k] = v
+			}
+			attrs[ATTR_CROWN_JEWEL] = "true"
+			attrs[ATTR_DATA_SENSITIVITY] = tag.Sensitivity
+			e.Attributes = attrs
+
+			if err := graph.Entities(tx).Put(ekey, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, db.ErrRecord):
+		graph.RecordError(w, err)
+		return
+	case missing != "":
+		http.Error(w, fmt.Sprintf("entity %v not found", missing), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, eid := range tag.Entities {
+		log.Printf("tagged crown jewel %v\n", graph.GetEntityKey(aid, eid))
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "crown jewels for app %s tagged", aid)
+}
+
+// UntagCrownJewel is DELETE handler to remove crown jewel tag of an entity
+func (rk *Risk) UntagCrownJewel(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+		eid  = vars["eid"]
+		ekey = graph.GetEntityKey(aid, eid)
+	)
+
+	// the tag is removed in a transaction, not to lose concurrent writes of
+	// other attributes
+	var unread bool
+	err := db.RunTx(rk.db, func(tx db.Tx) error {
+		e, err := graph.Entities(tx).Get(ekey)
+		if err != nil {
+			unread = true
+			return err
+		}
+		attrs := make(map[string]string, len(e.Attributes))
+		for k, v := range e.Attributes {
+			if k != ATTR_CROWN_JEWEL && k != ATTR_DATA_SENSITIVITY {
+				attrs[k] = v
+			}
+		}
+		e.Attributes = attrs
+		return graph.Entities(tx).Put(ekey, e)
+	})
+	switch {
+	case unread:
+		graph.RecordError(w, err)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("untagged crown jewel %v\n", ekey)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(graph.Response{Status: "success"})
+}
+
+// GetCrownJewels is GET handler to retrieve crown jewels of an app
+func (rk *Risk) GetCrownJewels(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	aid := vars["id"]
+
+	jewelList := CrownJewelList{
+		CrownJewels: []CrownJewel{},
+	}
+	for _, jewel := range rk.CrownJewels(graph.LoadHypergraph(rk.db, aid)) {
+		jewelList.CrownJewels = append(jewelList.CrownJewels, jewel)
+	}
+	sort.Slice(jewelList.CrownJewels, func(i, j int) bool {
+		return jewelList.CrownJewels[i].ID < jewelList.CrownJewels[j].ID
+	})
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jewelList)
+}
+
+// ComputeBlastRadius walks the hypergraph breadth-first from a compromised
+// entity and returns all crown jewels reachable within maxSteps (0 is unbounded)
+func (rk *Risk) ComputeBlastRadius(h *graph.Hypergraph, eid string, maxSteps int) BlastRadius {
+	type visit struct {
+		prev string
+		hop  graph.Hop
+		dist int
+	}
+	var (
+		jewels  = rk.CrownJewels(h)
+		visited = map[string]visit{eid: {}}
+		queue   = []string{eid}
+	)
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if maxSteps > 0 && visited[cur].dist >= maxSteps {
+			continue
+		}
+		for _, hop := range h.Next(cur) {
+			if _, ok := visited[hop.To]; ok {
+				continue
+			}
+			visited[hop.To] = visit{prev: cur, hop: hop, dist: visited[cur].dist + 1}
+			queue = append(queue, hop.To)
+		}
+	}
+
+	br := BlastRadius{
+		App:         h.AppID,
+		Entity:      eid,
+		Reachable:   len(visited) - 1,
+		CrownJewels: []ReachedJewel{},
+		Permissions: []string{},
+	}
+	allPerms := map[string]bool{}
+	for id, v := range visited {
+		jewel, ok := jewels[id]
+		if !ok || id == eid {
+			continue
+		}
+
+		// walk back to the compromised entity
+		reached := ReachedJewel{CrownJewel: jewel, Steps: v.dist}
+		perms := map[string]bool{}
+		for cur := id; cur != eid; cur = visited[cur].prev {
+			reached.Path = append([]string{cur}, reached.Path...)
+			if hop := visited[cur].hop; hop.Assoc != "" {
+				reached.Assocs = append([]string{hop.Assoc}, reached.Assocs...)
+				for _, p := range h.Assocs[hop.Assoc].Permissions() {
+					perms[p] = true
+					allPerms[p] = true
+				}
+			}
+		}
+		reached.Path = append([]string{eid}, reached.Path...)
+		reached.Permissions = sortedKeys(perms)
+		br.CrownJewels = append(br.CrownJewels, reached)
+	}
+	sort.Slice(br.CrownJewels, func(i, j int) bool {
+		if br.CrownJewels[i].Steps != br.CrownJewels[j].Steps {
+			return br.CrownJewels[i].Steps < br.CrownJewels[j].Steps
+		}
+		return br.CrownJewels[i].ID < br.CrownJewels[j].ID
+	})
+	br.Permissions = sortedKeys(allPerms)
+	return br
+}
+
+// GetBlastRadius is GET handler to compute blast radius of a compromised entity
+func (rk *Risk) GetBlastRadius(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars     = mux.Vars(r)
+		aid      = vars["id"]
+		eid      = vars["eid"]
+		maxSteps = 0
+	)
+
+	if s := r.URL.Query().Get("maxSteps"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid maxSteps", http.StatusBadRequest)
+			return
+		}
+		maxSteps = n
+	}
+
+	h := graph.LoadHypergraph(rk.db, aid)
+	if _, ok := h.Entities[eid]; !ok {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("computing blast radius of %v\n", graph.GetEntityKey(aid, eid))
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rk.ComputeBlastRadius(h, eid, maxSteps))
+}
+
+// sortedKeys returns sorted keys of a set
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
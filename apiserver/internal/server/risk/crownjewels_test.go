@@ -0,0 +1,145 @@
+package risk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+func TestTagCrownJewels(t *testing.T) {
+	d := newTestDb(t)
+	putEntity(t, d, "a1", "e1", nil)
+	putEntity(t, d, "a1", "e2", nil)
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/app/{id}/crownjewels", NewRisk(d).TagCrownJewels).Methods("POST")
+
+	tests := []struct {
+		name   string
+		body   string
+		code   int
+		tagged map[string]bool
+	}{
+		{"unknown entity", `{"entities":["e1","e3"]}`, http.StatusNotFound, map[string]bool{}},
+		{"all entities", `{"entities":["e1","e2"]}`, http.StatusCreated, map[string]bool{"e1": true, "e2": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("POST", "/v1/app/a1/crownjewels", strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+			}
+			// entities are tagged all at once or not at all
+			for _, eid := range []string{"e1", "e2"} {
+				e, _ := graph.Entities(d).Get(graph.GetEntityKey("a1", eid))
+				if got := e.Attributes[ATTR_CROWN_JEWEL] == "true"; got != tt.tagged[eid] {
+					t.Errorf("%v: expecting tagged %v, got %v", eid, tt.tagged[eid], got)
+				}
+			}
+		})
+	}
+}
+
+func TestUntagCrownJewel(t *testing.T) {
+	d := newTestDb(t)
+	putEntity(t, d, "a1", "e1", map[string]string{ATTR_CROWN_JEWEL: "true", ATTR_DATA_SENSITIVITY: "high", "Owner": "ops"})
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/app/{id}/crownjewels/{eid}", NewRisk(d).UntagCrownJewel).Methods("DELETE")
+
+	tests := []struct {
+		name string
+		eid  string
+		code int
+	}{
+		{"unknown entity", "e2", http.StatusNotFound},
+		{"tagged entity", "e1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/app/a1/crownjewels/"+tt.eid, nil))
+			if w.Code != tt.code {
+				t.Fatalf("expecting %v, got %v %v", tt.code, w.Code, w.Body)
+			}
+		})
+	}
+
+	// the tag is removed, other attributes are kept
+	e, _ := graph.Entities(d).Get(graph.GetEntityKey("a1", "e1"))
+	if len(e.Attributes) != 1 || e.Attributes["Owner"] != "ops" {
+		t.Fatalf("expecting only the tag removed, got %v", e.Attributes)
+	}
+}
+
+func TestBlastRadius(t *testing.T) {
+	// web reaches db1 in two steps through a gateway and in three through
+	// a queue, and db2 behind db1
+	d := newTestDb(t)
+	for _, eid := range []string{"web", "gw", "q1", "q2"} {
+		putEntity(t, d, "a1", eid, nil)
+	}
+	putEntity(t, d, "a1", "db1", jewel)
+	putEntity(t, d, "a1", "db2", jewel)
+	for _, s := range []struct {
+		sid, from, to, label string
+	}{
+		{"s1", "web", "gw", "ec2:Connect"},
+		{"s2", "gw", "db1", "rds:Connect"},
+		{"s3", "web", "q1", "sqs:Send"},
+		{"s4", "q1", "q2", "sqs:Send"},
+		{"s5", "q2", "db1", "rds:Admin"},
+		{"s6", "db1", "db2", "rds:Replicate"},
+	} {
+		key := graph.GetEntityKey("a1", s.sid)
+		if err := graph.Assocs(d).Put(key, graph.Assoc{ID: key, Name: s.sid, Label: s.label,
+			FromEntities: []string{s.from}, ToEntities: []string{s.to}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := graph.LoadHypergraph(d, "a1")
+
+	tests := []struct {
+		name     string
+		maxSteps int
+		steps    map[string]int
+		perms    []string
+	}{
+		{"unbounded", 0, map[string]int{"db1": 2, "db2": 3},
+			[]string{"ec2:Connect", "rds:Connect", "rds:Replicate"}},
+		{"bounded", 2, map[string]int{"db1": 2},
+			[]string{"ec2:Connect", "rds:Connect"}},
+		{"out of reach", 1, map[string]int{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := NewRisk(d).ComputeBlastRadius(h, "web", tt.maxSteps)
+			steps := map[string]int{}
+			for _, j := range br.CrownJewels {
+				steps[j.ID] = j.Steps
+				if len(j.Path) != j.Steps+1 || j.Path[0] != "web" || j.Path[j.Steps] != j.ID || len(j.Assocs) != j.Steps {
+					t.Errorf("expecting a shortest path to %v, got %+v", j.ID, j)
+				}
+			}
+			if !reflect.DeepEqual(steps, tt.steps) {
+				t.Fatalf("expecting minimum steps %v, got %v", tt.steps, steps)
+			}
+
+			// only permissions of shortest paths are used
+			if !reflect.DeepEqual(br.Permissions, tt.perms) {
+				t.Fatalf("expecting permissions %v, got %v", tt.perms, br.Permissions)
+			}
+		})
+	}
+
+	// permissions are reported per crown jewel too
+	br := NewRisk(d).ComputeBlastRadius(h, "web", 0)
+	if got := br.CrownJewels[0].Permissions; !reflect.DeepEqual(got, []string{"ec2:Connect", "rds:Connect"}) {
+		t.Fatalf("expecting permissions of the path to db1, got %v", got)
+	}
+}
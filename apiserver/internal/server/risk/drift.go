@@ -0,0 +1,284 @@
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+// AttributeChange is a single attribute modified between two versions
+type AttributeChange struct {
+	Attribute string `json:"attribute"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+}
+
+// ModifiedItem is an entity or hyperedge modified between two versions
+type ModifiedItem struct {
+	ID      string            `json:"id"`
+	Changes []AttributeChange `json:"changes"`
+}
+
+// RiskRaise is an entity whose risk category was raised between two versions
+type RiskRaise struct {
+	Entity         string `json:"entity"`
+	BeforeCategory string `json:"beforeCategory"`
+	AfterCategory  string `json:"afterCategory"`
+}
+
+// DriftReport is the difference of an app between two snapshot versions
+type DriftReport struct {
+	App              string         `json:"app"`
+	From             int            `json:"from"`
+	To               int            `json:"to"`
+	EntitiesAdded    []string       `json:"entitiesAdded"`
+	EntitiesRemoved  []string       `json:"entitiesRemoved"`
+	EntitiesModified []ModifiedItem `json:"entitiesModified"`
+	AssocsAdded      []string       `json:"assocsAdded"`
+	AssocsRemoved    []string       `json:"assocsRemoved"`
+	AssocsModified   []ModifiedItem `json:"assocsModified"`
+	NewAttackPaths   []AttackPath   `json:"newAttackPaths"`
+	RiskRaised       []RiskRaise    `json:"riskRaised"`
+}
+
+// diffFields returns changes between two sets of named fields
+func diffFields(before, after map[string]string) []AttributeChange {
+	changes := []AttributeChange{}
+	for k, v := range before {
+		if w, ok := after[k]; !ok || w != v {
+			changes = append(changes, AttributeChange{Attribute: k, Before: v, After: w})
+		}
+	}
+	for k, w := range after {
+		if _, ok := before[k]; !ok {
+			changes = append(changes, AttributeChange{Attribute: k, After: w})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Attribute < changes[j].Attribute })
+	return changes
+}
+
+// toString renders any field value for comparison
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// entityFields flattens comparable fields of an entity
+func entityFields(e graph.Entity) map[string]string {
+	fields := map[string]string{
+		"name":        e.Name,
+		"description": e.Description,
+		"kind":        e.Kind,
+		"fitness":     strconv.Itoa(e.Fitness),
+		"entities":    toString(e.Entities),
+	}
+	for k, v := range e.Attributes {
+		fields["attributes."+k] = v
+	}
+	return fields
+}
+
+// assocFields flattens comparable fields of an assoc
+func assocFields(a graph.Assoc) map[string]string {
+	fields := map[string]string{
+		"name":          a.Name,
+		"description":   a.Description,
+		"label":         a.Label,
+		"fromentities":  strings.Join(a.FromEntities, ","),
+		"toentities":    strings.Join(a.ToEntities, ","),
+		"otherentities": strings.Join(a.OtherEntities, ","),
+		"propensity":    strconv.Itoa(a.Propensity),
+	}
+	for k, v := range a.Attributes {
+		fields["attributes."+k] = toString(v)
+	}
+	return fields
+}
+
+// entityCategories returns the highest finding risk category of each entity
+func entityCategories(ev evaluation) map[string]string {
+	categories := map[string]string{}
+	for _, f := range ev.findings {
+		c := Category(levelScore(f.Risk))
+		if categoryRank(c) > categoryRank(categories[f.Entity]) {
+			categories[f.Entity] = c
+		}
+	}
+	return categories
+}
+
+// evaluateSnapshot runs scenarios and scoring of an app snapshot, version 0
+// being the empty app
+func (rk *Risk) evaluateSnapshot(app graph.AppData, version, maxDepth int) (graph.Snapshot, evaluation, error) {
+	snap := graph.Snapshot{App: app.ID}
+	if version > 0 {
+		var err error
+		if snap, err = graph.GetSnapshot(rk.db, app.ID, version); err != nil {
+			return snap, evaluation{}, err
+		}
+	}
+
+	// crown jewel rules are current ones, applied to past versions
+	d := graph.SnapshotDb(app, snap, DB_TABLE_CROWN_JEWEL_RULES)
+	for _, rule := range rk.getCrownJewelRules(app.ID) {
+		rule.ID = graph.GetEntityKey(app.ID, rule.ID)
+		d.Add(DB_TABLE_CROWN_JEWEL_RULES, rule.ID, rule)
+	}
+	ev, err := evaluate(d, app.ID, maxDepth)
+	return snap, ev, err
+}
+
+// Drift compares two snapshot versions of an app
+func (rk *Risk) Drift(app graph.AppData, from, to, maxDepth int) (DriftReport, error) {
+	before, evBefore, err := rk.evaluateSnapshot(app, from, maxDepth)
+	if err != nil {
+		return DriftReport{}, err
+	}
+	after, evAfter, err := rk.evaluateSnapshot(app, to, maxDepth)
+	if err != nil {
+		return DriftReport{}, err
+	}
+
+	report := DriftReport{
+		App:              app.ID,
+		From:             from,
+		To:               to,
+		EntitiesAdded:    []string{},
+		EntitiesRemoved:  []string{},
+		EntitiesModified: []ModifiedItem{},
+		AssocsAdded:      []string{},
+		AssocsRemoved:    []string{},
+		AssocsModified:   []ModifiedItem{},
+		NewAttackPaths:   []AttackPath{},
+		RiskRaised:       []RiskRaise{},
+	}
+
+	// entities
+	for id, e := range before.Entities {
+		f, ok := after.Entities[id]
+		if !ok {
+			report.EntitiesRemoved = append(report.EntitiesRemoved, id)
+		} else if changes := diffFields(entityFields(e), entityFields(f)); len(changes) > 0 {
+			report.EntitiesModified = append(report.EntitiesModified, ModifiedItem{ID: id, Changes: changes})
+		}
+	}
+	for id := range after.Entities {
+		if _, ok := before.Entities[id]; !ok {
+			report.EntitiesAdded = append(report.EntitiesAdded, id)
+		}
+	}
+
+	// hyperedges
+	for id, a := range before.Assocs {
+		b, ok := after.Assocs[id]
+		if !ok {
+			report.AssocsRemoved = append(report.AssocsRemoved, id)
+		} else if changes := diffFields(assocFields(a), assocFields(b)); len(changes) > 0 {
+			report.AssocsModified = append(report.AssocsModified, ModifiedItem{ID: id, Changes: changes})
+		}
+	}
+	for id := range after.Assocs {
+		if _, ok := before.Assocs[id]; !ok {
+			report.AssocsAdded = append(report.AssocsAdded, id)
+		}
+	}
+
+	// attack paths introduced by the changes
+	beforePaths := map[string]bool{}
+	for _, p := range evBefore.paths {
+		beforePaths[p.ID] = true
+	}
+	for _, p := range evAfter.paths {
+		if !beforePaths[p.ID] {
+			report.NewAttackPaths = append(report.NewAttackPaths, p)
+		}
+	}
+
+	// entities whose risk category went up
+	beforeCategories := entityCategories(evBefore)
+	for eid, c := range entityCategories(evAfter) {
+		if categoryRank(c) > categoryRank(beforeCategories[eid]) {
+			report.RiskRaised = append(report.RiskRaised, RiskRaise{
+				Entity:         eid,
+				BeforeCategory: beforeCategories[eid],
+				AfterCategory:  c,
+			})
+		}
+	}
+
+	sort.Strings(report.EntitiesAdded)
+	sort.Strings(report.EntitiesRemoved)
+	sort.Strings(report.AssocsAdded)
+	sort.Strings(report.AssocsRemoved)
+	sort.Slice(report.EntitiesModified, func(i, j int) bool { return report.EntitiesModified[i].ID < report.EntitiesModified[j].ID })
+	sort.Slice(report.AssocsModified, func(i, j int) bool { return report.AssocsModified[i].ID < report.AssocsModified[j].ID })
+	sort.Slice(report.RiskRaised, func(i, j int) bool { return report.RiskRaised[i].Entity < report.RiskRaised[j].Entity })
+	return report, nil
+}
+
+// GetDrift is GET handler to diff two snapshot versions of an app, by
+// default the latest version against the previous one
+func (rk *Risk) GetDrift(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	a, err := graph.Apps(rk.db).Get(aid)
+	if err != nil {
+		graph.RecordError(w, err)
+		return
+	}
+	maxDepth, err := parseMaxDepth(r)
+	if err != nil {
+		http.Error(w, "invalid maxDepth", http.StatusBadRequest)
+		return
+	}
+
+	to := 0
+	if snapshots := graph.GetSnapshots(rk.db, aid); len(snapshots) > 0 {
+		to = snapshots[len(snapshots)-1].Version
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = strconv.Atoi(s); err != nil || to < 0 {
+			http.Error(w, "invalid to version", http.StatusBadRequest)
+			return
+		}
+	}
+	from := to - 1
+	if s := r.URL.Query().Get("from"); s != "" {
+		if from, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "invalid from version", http.StatusBadRequest)
+			return
+		}
+	}
+	if from < 0 {
+		from = 0
+	}
+	log.Printf("computing drift of app %v from %v to %v\n", aid, from, to)
+
+	report, err := rk.Drift(a, from, to, maxDepth)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
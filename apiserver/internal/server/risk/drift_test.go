@@ -0,0 +1,79 @@
+package risk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+func TestDrift(t *testing.T) {
+	d := newTestDb(t)
+	app := graph.AppData{ID: "a1", Name: "a1"}
+	graph.Apps(d).Put(app.ID, app)
+
+	// version 2 exposes web to the internet, changes its environment and
+	// drops its team, replaces an entity and relabels a hyperedge
+	snaps := []graph.Snapshot{
+		{
+			Entities: map[string]graph.Entity{
+				"web": {ID: "web", Name: "web", Kind: "vm", Attributes: map[string]string{"Env": "dev", "Team": "a"}},
+				"db1": {ID: "db1", Name: "db1", Kind: "vm", Attributes: jewel},
+				"old": {ID: "old", Name: "old", Kind: "vm"},
+			},
+			Assocs: map[string]graph.Assoc{
+				"s1": {ID: "s1", Name: "s1", Label: "read", FromEntities: []string{"web"}, ToEntities: []string{"db1"}},
+			},
+		},
+		{
+			Entities: map[string]graph.Entity{
+				"web": {ID: "web", Name: "web", Kind: "vm", Attributes: map[string]string{"Env": "prod", ATTR_INTERNET_EXPOSED: "true"}},
+				"db1": {ID: "db1", Name: "db1", Kind: "vm", Attributes: jewel},
+				"new": {ID: "new", Name: "new", Kind: "vm"},
+			},
+			Assocs: map[string]graph.Assoc{
+				"s1": {ID: "s1", Name: "s1", Label: "write", FromEntities: []string{"web"}, ToEntities: []string{"db1"}},
+			},
+		},
+	}
+	for i, snap := range snaps {
+		snap.App, snap.Version = app.ID, i+1
+		snap.ID = graph.GetSnapshotKey(app.ID, snap.Version)
+		if err := graph.Snapshots(d).Put(snap.ID, snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := NewRisk(d).Drift(app, 1, 2, DEFAULT_MAX_DEPTH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.EntitiesAdded, []string{"new"}) || !reflect.DeepEqual(report.EntitiesRemoved, []string{"old"}) {
+		t.Fatalf("expecting new added and old removed, got %v %v", report.EntitiesAdded, report.EntitiesRemoved)
+	}
+
+	// attributes are diffed one by one, added, changed and removed
+	want := []ModifiedItem{{ID: "web", Changes: []AttributeChange{
+		{Attribute: "attributes.Env", Before: "dev", After: "prod"},
+		{Attribute: "attributes." + ATTR_INTERNET_EXPOSED, After: "true"},
+		{Attribute: "attributes.Team", Before: "a"},
+	}}}
+	if !reflect.DeepEqual(report.EntitiesModified, want) {
+		t.Fatalf("expecting entities modified %+v, got %+v", want, report.EntitiesModified)
+	}
+	want = []ModifiedItem{{ID: "s1", Changes: []AttributeChange{{Attribute: "label", Before: "read", After: "write"}}}}
+	if !reflect.DeepEqual(report.AssocsModified, want) || len(report.AssocsAdded) != 0 || len(report.AssocsRemoved) != 0 {
+		t.Fatalf("expecting assocs modified %+v, got %+v", want, report)
+	}
+
+	// the exposed web server opens a path to the crown jewel
+	if len(report.NewAttackPaths) != 1 {
+		t.Fatalf("expecting one new attack path, got %+v", report.NewAttackPaths)
+	}
+
+	// version 0 is the empty app
+	report, err = NewRisk(d).Drift(app, 0, 1, DEFAULT_MAX_DEPTH)
+	if err != nil || !reflect.DeepEqual(report.EntitiesAdded, []string{"db1", "old", "web"}) || len(report.EntitiesModified) != 0 {
+		t.Fatalf("expecting all entities added, got %+v %v", report, err)
+	}
+}
@@ -0,0 +1,192 @@
+package risk
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+const (
+	// bounds on attack path enumeration, by paths found and by hops walked
+	DEFAULT_MAX_DEPTH = 6
+	MAX_ATTACK_PATHS  = 10000
+	MAX_PATH_STEPS    = 1000000
+)
+
+// AttackPath is a path from an internet exposed entry point to a crown jewel
+type AttackPath struct {
+	ID          string   `json:"id"`
+	Entry       string   `json:"entry"`
+	Target      string   `json:"target"`
+	Entities    []string `json:"entities"`
+	Assocs      []string `json:"assocs"`
+	Permissions []string `json:"permissions"`
+	Factors     Factors  `json:"factors"`
+	Score       float64  `json:"score"`
+	Category    string   `json:"category"`
+}
+
+// list of attack paths
+type AttackPathList struct {
+	App         string       `json:"app"`
+	Truncated   bool         `json:"truncated"`
+	AttackPaths []AttackPath `json:"attackPaths"`
+}
+
+// getPathId returns a stable id of a path from its entities and hyperedges
+func getPathId(entities, assocs []string) string {
+	return strings.Join(entities, ",") + "|" + strings.Join(assocs, ",")
+}
+
+// EntryPoints returns sorted ids of internet exposed entities of a hypergraph
+func EntryPoints(h *graph.Hypergraph) []string {
+	entries := []string{}
+	for id, e := range h.Entities {
+		if IsInternetExposed(e) {
+			entries = append(entries, id)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// AttackPaths enumerates simple paths of at most maxDepth hops from entry
+// points to crown jewels, scored and sorted by descending score. Enumeration
+// stops once MAX_ATTACK_PATHS paths are found or MAX_PATH_STEPS hops are
+// walked, reporting paths as truncated.
+func (rk *Risk) AttackPaths(h *graph.Hypergraph, maxDepth int) ([]AttackPath, bool) {
+	var (
+		sc        = rk.newScorer(h)
+		paths     = []AttackPath{}
+		truncated = false
+		steps     = 0
+		entities  []string
+		assocs    []string
+		onPath    = map[string]bool{}
+	)
+
+	var walk func(cur string)
+	walk = func(cur string) {
+		steps++
+		if len(paths) >= MAX_ATTACK_PATHS || steps > MAX_PATH_STEPS {
+			truncated = true
+			return
+		}
+		if _, ok := sc.jewels[cur]; ok {
+			p := AttackPath{
+				ID:       getPathId(entities, assocs),
+				Entry:    entities[0],
+				Target:   cur,
+				Entities: append([]string{}, entities...),
+				Assocs:   append([]string{}, assocs...),
+			}
+			perms := map[string]bool{}
+			for _, aid := range assocs {
+				for _, perm := range h.Assocs[aid].Permissions() {
+					perms[perm] = true
+				}
+			}
+			p.Permissions = sortedKeys(perms)
+			sc.scorePath(&p)
+			paths = append(paths, p)
+		}
+		if len(assocs) >= maxDepth {
+			return
+		}
+		for _, hop := range h.Next(cur) {
+			if truncated {
+				return
+			}
+			if onPath[hop.To] {
+				continue
+			}
+			onPath[hop.To] = true
+			entities = append(entities, hop.To)
+			if hop.Assoc != "" {
+				assocs = append(assocs, hop.Assoc)
+			}
+
+			walk(hop.To)
+
+			if hop.Assoc != "" {
+				assocs = assocs[:len(assocs)-1]
+			}
+			entities = entities[:len(entities)-1]
+			delete(onPath, hop.To)
+		}
+	}
+
+	for _, entry := range EntryPoints(h) {
+		if truncated {
+			break
+		}
+		onPath[entry] = true
+		entities = []string{entry}
+		assocs = []string{}
+		walk(entry)
+		delete(onPath, entry)
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		if paths[i].Score != paths[j].Score {
+			return paths[i].Score > paths[j].Score
+		}
+		return paths[i].ID < paths[j].ID
+	})
+	return paths, truncated
+}
+
+// parseMaxDepth parses maxDepth query parameter
+func parseMaxDepth(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("maxDepth")
+	if s == "" {
+		return DEFAULT_MAX_DEPTH, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return n, nil
+}
+
+// GetAttackPaths is GET handler to retrieve scored attack paths of an app,
+// optionally filtered by a minimum risk category
+func (rk *Risk) GetAttackPaths(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars     = mux.Vars(r)
+		aid      = vars["id"]
+		category = r.URL.Query().Get("category")
+	)
+
+	maxDepth, err := parseMaxDepth(r)
+	if err != nil {
+		http.Error(w, "invalid maxDepth", http.StatusBadRequest)
+		return
+	}
+	if category != "" && categoryRank(category) < 0 {
+		http.Error(w, "invalid category", http.StatusBadRequest)
+		return
+	}
+
+	paths, truncated := rk.AttackPaths(graph.LoadHypergraph(rk.db, aid), maxDepth)
+	pathList := AttackPathList{
+		App:         aid,
+		Truncated:   truncated,
+		AttackPaths: []AttackPath{},
+	}
+	for _, p := range paths {
+		if categoryRank(p.Category) >= categoryRank(category) {
+			pathList.AttackPaths = append(pathList.AttackPaths, p)
+		}
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pathList)
+}
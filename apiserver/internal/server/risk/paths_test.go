@@ -0,0 +1,116 @@
+package risk
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+// newTestDb returns a DB of tenant tables with their indexes
+func newTestDb(t *testing.T) *db.MemoryDb {
+	t.Helper()
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS, graph.DB_TABLE_SNAPSHOTS,
+		DB_TABLE_CROWN_JEWEL_RULES, DB_TABLE_TRENDS)
+	if err := graph.CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// putEntity stores an entity of an app with attributes
+func putEntity(t *testing.T, d db.Db, aid, eid string, attrs map[string]string) {
+	t.Helper()
+	key := graph.GetEntityKey(aid, eid)
+	if err := graph.Entities(d).Put(key, graph.Entity{ID: key, Name: eid, Kind: "vm", Attributes: attrs}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putAssoc stores a hyperedge of an app from and to entities
+func putAssoc(t *testing.T, d db.Db, aid, sid string, from, to []string) {
+	t.Helper()
+	key := graph.GetEntityKey(aid, sid)
+	if err := graph.Assocs(d).Put(key, graph.Assoc{ID: key, Name: sid, FromEntities: from, ToEntities: to}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var (
+	exposed = map[string]string{ATTR_INTERNET_EXPOSED: "true"}
+	jewel   = map[string]string{ATTR_CROWN_JEWEL: "true"}
+)
+
+func TestChokePoints(t *testing.T) {
+	// two exposed web servers reach two databases through one gateway only
+	d := newTestDb(t)
+	putEntity(t, d, "a1", "web1", exposed)
+	putEntity(t, d, "a1", "web2", exposed)
+	putEntity(t, d, "a1", "gw", nil)
+	putEntity(t, d, "a1", "db1", jewel)
+	putEntity(t, d, "a1", "db2", jewel)
+	putAssoc(t, d, "a1", "s1", []string{"web1"}, []string{"gw"})
+	putAssoc(t, d, "a1", "s2", []string{"web2"}, []string{"gw"})
+	putAssoc(t, d, "a1", "s3", []string{"gw"}, []string{"db1", "db2"})
+
+	rk := NewRisk(d)
+	h := graph.LoadHypergraph(d, "a1")
+	paths, truncated := rk.AttackPaths(h, DEFAULT_MAX_DEPTH)
+	if len(paths) != 4 || truncated {
+		t.Fatalf("expecting 4 paths, got %v, truncated %v", len(paths), truncated)
+	}
+
+	tests := []struct {
+		name string
+		cut  []string
+	}{
+		{"gateway", []string{"entity:gw"}},
+		{"entry points", []string{"entity:web1", "entity:web2"}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if i == 1 {
+				// the gateway is a crown jewel too, so it cannot be cut, and
+				// the cut closest to entry points is reported
+				putEntity(t, d, "a1", "gw", jewel)
+				h = graph.LoadHypergraph(d, "a1")
+			}
+			report := rk.ChokePoints(h, DEFAULT_MAX_DEPTH, CATEGORY_LOW)
+			cut := []string{}
+			for _, cp := range report.MinimumCut {
+				cut = append(cut, getChokePointKey(cp.Type, cp.ID))
+			}
+			if !reflect.DeepEqual(cut, tt.cut) {
+				t.Fatalf("expecting minimum cut %v, got %v", tt.cut, cut)
+			}
+		})
+	}
+
+	report := rk.ChokePoints(h, DEFAULT_MAX_DEPTH, CATEGORY_LOW)
+	if top := report.Remediations[0]; top.ID != "s3" || top.Paths != 4 {
+		t.Fatalf("expecting hyperedge s3 on all paths to databases first, got %+v", top)
+	}
+}
+
+func TestAttackPathsBounded(t *testing.T) {
+	// a complete graph without crown jewels has no paths, but more simple
+	// paths to walk than MAX_PATH_STEPS
+	d := newTestDb(t)
+	ids := []string{}
+	for i := 0; i < 14; i++ {
+		ids = append(ids, fmt.Sprintf("e%02d", i))
+		putEntity(t, d, "a1", ids[i], nil)
+	}
+	putEntity(t, d, "a1", ids[0], exposed)
+	putAssoc(t, d, "a1", "s1", ids, ids)
+
+	paths, truncated := NewRisk(d).AttackPaths(graph.LoadHypergraph(d, "a1"), DEFAULT_MAX_DEPTH)
+	if len(paths) != 0 || !truncated {
+		t.Fatalf("expecting no paths and truncation, got %v, truncated %v", len(paths), truncated)
+	}
+	if _, truncated := NewRisk(d).AttackPaths(graph.LoadHypergraph(d, "a1"), 2); truncated {
+		t.Fatalf("expecting no truncation within 2 hops")
+	}
+}
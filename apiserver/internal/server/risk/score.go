@@ -0,0 +1,195 @@
+package risk
+
+import (
+	"math"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/activity"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+	"github.com/zetafence/zentaris/apiserver/internal/server/vulns"
+)
+
+const (
+	// weights of the entity risk score factors
+	WEIGHT_ACCESSIBILITY    = 0.30
+	WEIGHT_PRIVILEGE_LEVELS = 0.25
+	WEIGHT_DATA_SENSITIVITY = 0.25
+	WEIGHT_VULNERABILITY    = 0.20
+
+	// likelihood scales scores by 0.875 for quiet activity up to 1.25 for
+	// attack signals, i.e. BASE + SCALE * likelihood
+	LIKELIHOOD_BASE  = 0.75
+	LIKELIHOOD_SCALE = 0.05
+
+	// risk categories
+	CATEGORY_LOW      = "Low"
+	CATEGORY_MEDIUM   = "Medium"
+	CATEGORY_HIGH     = "High"
+	CATEGORY_CRITICAL = "Critical"
+
+	// entity attribute to explicitly mark internet exposure
+	ATTR_INTERNET_EXPOSED = "InternetExposed"
+)
+
+// Categories lists risk categories, lowest first
+var Categories = []string{CATEGORY_LOW, CATEGORY_MEDIUM, CATEGORY_HIGH, CATEGORY_CRITICAL}
+
+// levelScore maps a low/medium/high/critical level onto a 0-10 score
+func levelScore(level string) float64 {
+	switch strings.ToLower(level) {
+	case scenarios.RISK_CRITICAL:
+		return 10
+	case scenarios.RISK_HIGH:
+		return 7.5
+	case scenarios.RISK_MEDIUM:
+		return 5
+	case scenarios.RISK_LOW:
+		return 2.5
+	}
+	return 0
+}
+
+// Category returns risk category of a 0-10 score
+func Category(score float64) string {
+	switch {
+	case score >= 8.5:
+		return CATEGORY_CRITICAL
+	case score >= 6.5:
+		return CATEGORY_HIGH
+	case score >= 4:
+		return CATEGORY_MEDIUM
+	}
+	return CATEGORY_LOW
+}
+
+// categoryRank returns position of a risk category, -1 if unknown
+func categoryRank(category string) int {
+	for i, c := range Categories {
+		if strings.EqualFold(c, category) {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsInternetExposed returns true if an entity is reachable from the internet
+func IsInternetExposed(e graph.Entity) bool {
+	if e.Attributes[ATTR_INTERNET_EXPOSED] == "true" {
+		return true
+	}
+	if c, ok := e.Attributes["OpenPorts"]; ok && strings.Contains(c, "0.0.0.0") {
+		return true
+	}
+	_, ip := e.Attributes["PublicIpAddress"]
+	_, dns := e.Attributes["PublicDnsName"]
+	return ip || dns
+}
+
+// accessibilityScore scores exposure of an entity, external vs internal
+func accessibilityScore(e graph.Entity) float64 {
+	if e.Attributes[ATTR_INTERNET_EXPOSED] == "true" {
+		return 10
+	}
+	if c, ok := e.Attributes["OpenPorts"]; ok && strings.Contains(c, "0.0.0.0") {
+		return 10
+	}
+	if IsInternetExposed(e) {
+		return 7.5
+	}
+	return 2.5
+}
+
+// privilegeScore scores the level of access granted by a set of permissions
+func privilegeScore(perms []string) float64 {
+	score := 0.0
+	for _, p := range perms {
+		lp := strings.ToLower(p)
+		switch {
+		case lp == "*" || strings.HasSuffix(lp, ":*") || strings.Contains(lp, "admin"):
+			return 10
+		case strings.HasPrefix(lp, "iam:") || strings.HasPrefix(lp, "sts:"):
+			score = math.Max(score, 7.5)
+		case strings.Contains(lp, "put") || strings.Contains(lp, "create") ||
+			strings.Contains(lp, "delete") || strings.Contains(lp, "update") ||
+			strings.Contains(lp, "attach") || strings.Contains(lp, "write"):
+			score = math.Max(score, 5)
+		default:
+			score = math.Max(score, 2.5)
+		}
+	}
+	return score
+}
+
+// Factors is the breakdown of a risk score
+type Factors struct {
+	Accessibility   float64 `json:"accessibility"`
+	PrivilegeLevels float64 `json:"privilegeLevels"`
+	DataSensitivity float64 `json:"dataSensitivity"`
+	Vulnerability   float64 `json:"vulnerability"`
+	Likelihood      float64 `json:"likelihood"` // 0 when no activity is known
+}
+
+// Score returns the weighted composite score of the factors, scaled by
+// likelihood of observed activity when known
+func (f Factors) Score() float64 {
+	score := f.Accessibility*WEIGHT_ACCESSIBILITY +
+		f.PrivilegeLevels*WEIGHT_PRIVILEGE_LEVELS +
+		f.DataSensitivity*WEIGHT_DATA_SENSITIVITY +
+		f.Vulnerability*WEIGHT_VULNERABILITY
+	if f.Likelihood > 0 {
+		score = math.Min(10, score*(LIKELIHOOD_BASE+LIKELIHOOD_SCALE*f.Likelihood))
+	}
+	return roundScore(score)
+}
+
+// roundScore rounds a score to two decimals
+func roundScore(score float64) float64 {
+	return math.Round(score*100) / 100
+}
+
+// scorer scores entities and paths of a hypergraph
+type scorer struct {
+	h        *graph.Hypergraph
+	jewels   map[string]CrownJewel
+	findings map[string][]scenarios.Finding // entity id -> findings
+}
+
+// newScorer returns a scorer using crown jewels and latest attack graph findings
+func (rk *Risk) newScorer(h *graph.Hypergraph) *scorer {
+	sc := &scorer{
+		h:        h,
+		jewels:   rk.CrownJewels(h),
+		findings: make(map[string][]scenarios.Finding),
+	}
+	for _, f := range scenarios.NewScenario(rk.db).LatestFindings(h.AppID) {
+		sc.findings[f.Entity] = append(sc.findings[f.Entity], f)
+	}
+	return sc
+}
+
+// vulnerabilityScore scores weaknesses detected on an entity, i.e. attack
+// scenario findings and scanned CVEs
+func (sc *scorer) vulnerabilityScore(eid string) float64 {
+	score := vulns.MaxScore(sc.h.Entities[eid])
+	for _, f := range sc.findings[eid] {
+		score = math.Max(score, levelScore(f.Risk))
+	}
+	return score
+}
+
+// scorePath scores an attack path from its entry point to its target
+func (sc *scorer) scorePath(p *AttackPath) {
+	f := Factors{
+		Accessibility:   accessibilityScore(sc.h.Entities[p.Entry]),
+		PrivilegeLevels: privilegeScore(p.Permissions),
+		DataSensitivity: levelScore(sc.jewels[p.Target].Sensitivity),
+	}
+	for _, eid := range p.Entities {
+		f.Vulnerability = math.Max(f.Vulnerability, sc.vulnerabilityScore(eid))
+		f.Likelihood = math.Max(f.Likelihood, activity.Likelihood(sc.h.Entities[eid]))
+	}
+	p.Factors = f
+	p.Score = f.Score()
+	p.Category = Category(p.Score)
+}
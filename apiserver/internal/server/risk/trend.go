@@ -0,0 +1,217 @@
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	// DB table to store evaluation results as a time series per app
+	DB_TABLE_TRENDS = "trends"
+
+	// number of top attack paths kept per evaluation
+	TREND_TOP_PATHS = 5
+)
+
+// TrendPoint is the result of a single app evaluation
+type TrendPoint struct {
+	ID             string         `json:"id"`
+	App            string         `json:"app"`
+	Timestamp      string         `json:"timestamp"`
+	TS             int            `json:"ts"`
+	AttackGraph    string         `json:"attackGraph"`
+	AttackPaths    int            `json:"attackPaths"`
+	PathCategories map[string]int `json:"pathCategories"`
+	PathsTruncated bool           `json:"pathsTruncated"`
+	Findings       int            `json:"findings"`
+	FindingRisks   map[string]int `json:"findingRisks"`
+	TotalRisk      float64        `json:"totalRisk"`
+	MaxScore       float64        `json:"maxScore"`
+	AverageScore   float64        `json:"averageScore"`
+	TopPaths       []AttackPath   `json:"topPaths"`
+}
+
+// list of trend points, oldest first
+type TrendList struct {
+	App    string       `json:"app"`
+	Trends []TrendPoint `json:"trends"`
+}
+
+// getTrendKey returns a sortable key of an app evaluation
+func getTrendKey(aid string, t time.Time) string {
+	return graph.GetEntityKey(aid, fmt.Sprintf("%020d", t.UnixNano()))
+}
+
+// Evaluate runs attack scenarios and scoring of an app, stores the result in
+// the app trend history and updates app run stats, all at once, then calls
+// evaluation hooks
+func (rk *Risk) Evaluate(aid string) (TrendPoint, error) {
+	var point TrendPoint
+	err := db.RunTx(rk.db, func(tx db.Tx) error {
+		var err error
+		point, err = NewRisk(tx).evaluate(aid)
+		return err
+	})
+	if err != nil {
+		return TrendPoint{}, err
+	}
+
+	for _, fn := range rk.evaluated {
+		fn(point)
+	}
+	return point, nil
+}
+
+// evaluate runs attack scenarios and scoring of an app on the DB, returning
+// its trend point
+func (rk *Risk) evaluate(aid string) (TrendPoint, error) {
+	a, err := graph.Apps(rk.db).Get(aid)
+	if err != nil {
+		return TrendPoint{}, err
+	}
+
+	sc := scenarios.NewScenario(rk.db)
+	agid, err := sc.BuildAppScenarios(aid)
+	if err != nil {
+		return TrendPoint{}, err
+	}
+	paths, truncated := rk.AttackPaths(graph.LoadHypergraph(rk.db, aid), DEFAULT_MAX_DEPTH)
+	summary := evaluation{paths: paths, truncated: truncated, findings: sc.Findings(agid)}.summary()
+
+	now := time.Now().UTC()
+	point := TrendPoint{
+		ID:             getTrendKey(aid, now),
+		App:            aid,
+		Timestamp:      now.Format(time.RFC3339),
+		TS:             int(now.Unix()),
+		AttackGraph:    agid,
+		AttackPaths:    summary.AttackPaths,
+		PathCategories: summary.PathCategories,
+		PathsTruncated: summary.Truncated,
+		Findings:       summary.Findings,
+		FindingRisks:   summary.FindingRisks,
+		TotalRisk:      summary.TotalRisk,
+		TopPaths:       paths,
+	}
+	if len(paths) > 0 {
+		// paths are sorted by descending score
+		point.MaxScore = paths[0].Score
+		point.AverageScore = roundScore(summary.TotalRisk / float64(len(paths)))
+	}
+	if len(point.TopPaths) > TREND_TOP_PATHS {
+		point.TopPaths = point.TopPaths[:TREND_TOP_PATHS]
+	}
+	if err := rk.db.Add(DB_TABLE_TRENDS, point.ID, point); err != nil {
+		return TrendPoint{}, err
+	}
+
+	// run metadata of the app
+	a.Stats.NumRuns++
+	a.Stats.RunTS = append(a.Stats.RunTS, point.TS)
+	a.Stats.LastAttackGraph = agid
+	a.Stats.LastScore = point.MaxScore
+	if err := graph.Apps(rk.db).Put(aid, a); err != nil {
+		return TrendPoint{}, err
+	}
+	return point, nil
+}
+
+// OnEvaluate registers a hook called after each app evaluation
+func (rk *Risk) OnEvaluate(fn func(TrendPoint)) {
+	rk.evaluated = append(rk.evaluated, fn)
+}
+
+// EvalAppData is POST eval handler to evaluate attack risk of an app
+func (rk *Risk) EvalAppData(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	log.Printf("evaluating app %v\n", id)
+
+	if _, err := rk.db.Get(graph.DB_TABLE_GRAPH, id); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	if _, err := rk.Evaluate(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// return the slice as JSON
+	w.Header().Set("Content-Type", "application/json")
+	evalResp := graph.Response{
+		Status: fmt.Sprintf("attack risk evaluated success for %v, see attack graphs", id),
+	}
+	json.NewEncoder(w).Encode(evalResp)
+}
+
+// parseTime parses RFC3339 or unix seconds time query parameters
+func parseTime(s string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// GetTrend is GET handler to retrieve evaluation history of an app within an
+// optional from/to time range
+func (rk *Risk) GetTrend(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars     = mux.Vars(r)
+		aid      = vars["id"]
+		from, to time.Time
+		err      error
+	)
+
+	if s := r.URL.Query().Get("from"); s != "" {
+		if from, err = parseTime(s); err != nil {
+			http.Error(w, "invalid from time", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = parseTime(s); err != nil {
+			http.Error(w, "invalid to time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	trendList := TrendList{
+		App:    aid,
+		Trends: []TrendPoint{},
+	}
+	// trend keys sort by time, scan the keys of the time range
+	start, end := graph.GetAppPrefix(aid), db.PrefixEnd(graph.GetAppPrefix(aid))
+	if !from.IsZero() {
+		start = getTrendKey(aid, from)
+	}
+	if !to.IsZero() {
+		end = getTrendKey(aid, to.Add(time.Second))
+	}
+	for _, trend := range db.Values(rk.db.Range(DB_TABLE_TRENDS, start, end)) {
+		t, ok := trend.(TrendPoint)
+		if !ok {
+			continue
+		}
+		if !from.IsZero() && int64(t.TS) < from.Unix() {
+			continue
+		}
+		if !to.IsZero() && int64(t.TS) > to.Unix() {
+			continue
+		}
+		trendList.Trends = append(trendList.Trends, t)
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trendList)
+}
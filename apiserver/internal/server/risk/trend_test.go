@@ -0,0 +1,35 @@
+package risk
+
+import (
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+func TestEvaluate(t *testing.T) {
+	d := newTestDb(t)
+	graph.Apps(d).Put("a1", graph.AppData{ID: "a1", Name: "app"})
+	putEntity(t, d, "a1", "web", exposed)
+	putEntity(t, d, "a1", "db", jewel)
+	putAssoc(t, d, "a1", "s1", []string{"web"}, []string{"db"})
+
+	// hooks run once the evaluation is committed
+	rk := NewRisk(d)
+	committed := false
+	rk.OnEvaluate(func(point TrendPoint) {
+		_, err := d.Get(DB_TABLE_TRENDS, point.ID)
+		a, _ := graph.Apps(d).Get("a1")
+		committed = err == nil && a.Stats.NumRuns == 1
+	})
+	point, err := rk.Evaluate("a1")
+	if err != nil || point.AttackPaths != 1 || !committed {
+		t.Fatalf("expecting a committed evaluation of 1 path, got %+v %v, committed %v", point, err, committed)
+	}
+
+	if _, err := rk.Evaluate("a2"); err == nil {
+		t.Fatalf("expecting unknown app to fail")
+	}
+	if got := len(d.Scan(DB_TABLE_TRENDS, "")); got != 1 {
+		t.Fatalf("expecting 1 trend point, got %v", got)
+	}
+}
@@ -0,0 +1,67 @@
+package risk
+
+import (
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Risk represents risk analysis over app hypergraphs
+type Risk struct {
+	db db.Db
+
+	// hooks called after each app evaluation
+	evaluated []func(TrendPoint)
+}
+
+// CrownJewelRule tags entities as crown jewels when all non-empty criteria match
+type CrownJewelRule struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`        // glob on entity kind, e.g. "*s3*"
+	Label       string `json:"label"`       // glob on labels of hyperedges the entity is a member of
+	Attribute   string `json:"attribute"`   // entity attribute name, e.g. "Name"
+	Value       string `json:"value"`       // glob on the attribute value, e.g. "prod-*"
+	Sensitivity string `json:"sensitivity"` // low, medium, high, critical
+}
+
+// list of crown jewel rules
+type CrownJewelRuleList struct {
+	Rules []CrownJewelRule `json:"rules"`
+}
+
+// CrownJewelTag is the request to tag entities as crown jewels
+type CrownJewelTag struct {
+	Entities    []string `json:"entities"`
+	Sensitivity string   `json:"sensitivity"`
+}
+
+// CrownJewel is an entity tagged as crown jewel, either by API or by rule
+type CrownJewel struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Sensitivity string `json:"sensitivity"`
+	TaggedBy    string `json:"taggedBy"` // "api" or rule id
+}
+
+// list of crown jewels
+type CrownJewelList struct {
+	CrownJewels []CrownJewel `json:"crownJewels"`
+}
+
+// ReachedJewel is a crown jewel reachable from a compromised entity
+type ReachedJewel struct {
+	CrownJewel
+	Steps       int      `json:"steps"`
+	Path        []string `json:"path"`
+	Assocs      []string `json:"assocs"`
+	Permissions []string `json:"permissions"`
+}
+
+// BlastRadius is the set of crown jewels reachable from a compromised entity
+type BlastRadius struct {
+	App         string         `json:"app"`
+	Entity      string         `json:"entity"`
+	Reachable   int            `json:"reachable"`
+	CrownJewels []ReachedJewel `json:"crownJewels"`
+	Permissions []string       `json:"permissions"`
+}
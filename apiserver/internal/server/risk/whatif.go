@@ -0,0 +1,328 @@
+package risk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	// hypothetical change types
+	CHANGE_SET_ATTRIBUTE           = "setAttribute"
+	CHANGE_REMOVE_ASSOC            = "removeAssoc"
+	CHANGE_ADD_PERMISSION_BOUNDARY = "addPermissionBoundary"
+	CHANGE_CLOSE_PORT              = "closePort"
+
+	DEFAULT_PERMISSION_BOUNDARY = "what-if-permission-boundary"
+)
+
+// Change is a hypothetical change applied to an app hypergraph
+type Change struct {
+	Type      string `json:"type"`
+	Entity    string `json:"entity,omitempty"`
+	Assoc     string `json:"assoc,omitempty"`
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Port      string `json:"port,omitempty"`
+}
+
+// WhatIfRequest is a set of hypothetical changes to simulate
+type WhatIfRequest struct {
+	Changes  []Change `json:"changes"`
+	MaxDepth int      `json:"maxDepth"`
+}
+
+// WhatIfSummary summarizes attack paths and findings of one simulation side
+type WhatIfSummary struct {
+	AttackPaths    int            `json:"attackPaths"`
+	PathCategories map[string]int `json:"pathCategories"`
+	TotalRisk      float64        `json:"totalRisk"`
+	Findings       int            `json:"findings"`
+	FindingRisks   map[string]int `json:"findingRisks"`
+	Truncated      bool           `json:"truncated"`
+}
+
+// PathChange is an attack path whose risk changed by the simulation
+type PathChange struct {
+	ID             string  `json:"id"`
+	BeforeScore    float64 `json:"beforeScore"`
+	AfterScore     float64 `json:"afterScore"`
+	BeforeCategory string  `json:"beforeCategory"`
+	AfterCategory  string  `json:"afterCategory"`
+}
+
+// WhatIfResult is the before/after diff of a what-if simulation
+type WhatIfResult struct {
+	App             string              `json:"app"`
+	Changes         []Change            `json:"changes"`
+	Before          WhatIfSummary       `json:"before"`
+	After           WhatIfSummary       `json:"after"`
+	PathsRemoved    []AttackPath        `json:"pathsRemoved"`
+	PathsAdded      []AttackPath        `json:"pathsAdded"`
+	PathsChanged    []PathChange        `json:"pathsChanged"`
+	FindingsRemoved []scenarios.Finding `json:"findingsRemoved"`
+	FindingsAdded   []scenarios.Finding `json:"findingsAdded"`
+}
+
+// evaluation is the result of running scenarios and scoring on a DB
+type evaluation struct {
+	paths     []AttackPath
+	truncated bool
+	findings  []scenarios.Finding
+}
+
+// evaluate runs attack scenarios and scoring of an app on a given DB
+func evaluate(d db.Db, aid string, maxDepth int) (evaluation, error) {
+	sc := scenarios.NewScenario(d)
+	agid, err := sc.BuildAppScenarios(aid)
+	if err != nil {
+		return evaluation{}, err
+	}
+	paths, truncated := NewRisk(d).AttackPaths(graph.LoadHypergraph(d, aid), maxDepth)
+	return evaluation{paths: paths, truncated: truncated, findings: sc.Findings(agid)}, nil
+}
+
+// summary summarizes an evaluation
+func (ev evaluation) summary() WhatIfSummary {
+	s := WhatIfSummary{
+		AttackPaths:    len(ev.paths),
+		PathCategories: map[string]int{},
+		Findings:       len(ev.findings),
+		FindingRisks:   map[string]int{},
+		Truncated:      ev.truncated,
+	}
+	for _, c := range Categories {
+		s.PathCategories[c] = 0
+	}
+	for _, p := range ev.paths {
+		s.PathCategories[p.Category]++
+		s.TotalRisk += p.Score
+	}
+	for _, f := range ev.findings {
+		s.FindingRisks[f.Risk]++
+	}
+	s.TotalRisk = roundScore(s.TotalRisk)
+	return s
+}
+
+// getFindingKey identifies a finding across attack graphs
+func getFindingKey(f scenarios.Finding) string {
+	return f.Title + "|" + f.Entity
+}
+
+// copyEntity returns an entity with its own copy of attributes
+func copyEntity(e graph.Entity) graph.Entity {
+	attrs := make(map[string]string, len(e.Attributes))
+	for k, v := range e.Attributes {
+		attrs[k] = v
+	}
+	e.Attributes = attrs
+	return e
+}
+
+// closePort removes open port entries of a given port, or all publicly open
+// ports when port is empty, from a comma separated OpenPorts attribute
+func closePort(openPorts, port string) string {
+	kept := []string{}
+	for _, entry := range strings.Split(openPorts, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if port == "" && strings.Contains(entry, "0.0.0.0") {
+			continue
+		}
+		fields := strings.FieldsFunc(entry, func(r rune) bool { return r < '0' || r > '9' })
+		if port != "" && len(fields) > 0 && fields[0] == port {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return strings.Join(kept, ",")
+}
+
+// applyChange applies a hypothetical change of an app onto a given DB
+func applyChange(d db.Db, aid string, c Change) error {
+	if c.Type == CHANGE_REMOVE_ASSOC {
+		skey := graph.GetEntityKey(aid, c.Assoc)
+		if _, err := d.Get(graph.DB_TABLE_ASSOCS, skey); err != nil {
+			return fmt.Errorf("assoc %v not found", c.Assoc)
+		}
+		return d.Del(graph.DB_TABLE_ASSOCS, skey)
+	}
+
+	ekey := graph.GetEntityKey(aid, c.Entity)
+	e, err := graph.Entities(d).Get(ekey)
+	if errors.Is(err, db.ErrRecord) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("entity %v not found", c.Entity)
+	}
+	e = copyEntity(e)
+
+	switch c.Type {
+	case CHANGE_SET_ATTRIBUTE:
+		if c.Attribute == "" {
+			return fmt.Errorf("%v requires an attribute", c.Type)
+		}
+		if c.Value == "" {
+			delete(e.Attributes, c.Attribute)
+		} else {
+			e.Attributes[c.Attribute] = c.Value
+		}
+	case CHANGE_ADD_PERMISSION_BOUNDARY:
+		if c.Value == "" {
+			c.Value = DEFAULT_PERMISSION_BOUNDARY
+		}
+		e.Attributes["PermissionsBoundary"] = c.Value
+		if _, ok := e.Attributes["PermissionsBoundaryUsageCount"]; ok {
+			e.Attributes["PermissionsBoundaryUsageCount"] = "1"
+		}
+	case CHANGE_CLOSE_PORT:
+		ports := closePort(e.Attributes["OpenPorts"], c.Port)
+		if ports == "" {
+			delete(e.Attributes, "OpenPorts")
+		} else {
+			e.Attributes["OpenPorts"] = ports
+		}
+	default:
+		return fmt.Errorf("unknown change type %v", c.Type)
+	}
+	return graph.Entities(d).Put(ekey, e)
+}
+
+// WhatIf simulates hypothetical changes of an app on copy-on-write overlays,
+// leaving the real data untouched, and diffs attack paths and findings
+func (rk *Risk) WhatIf(aid string, req WhatIfRequest) (WhatIfResult, error) {
+	if req.MaxDepth <= 0 {
+		req.MaxDepth = DEFAULT_MAX_DEPTH
+	}
+
+	// evaluate before and after on one snapshot, so that concurrent writes
+	// don't show up as effects of the changes
+	snapshot, err := rk.db.Begin()
+	if err != nil {
+		return WhatIfResult{}, err
+	}
+	defer snapshot.Rollback()
+
+	before, err := evaluate(db.NewOverlayDb(snapshot), aid, req.MaxDepth)
+	if err != nil {
+		return WhatIfResult{}, err
+	}
+
+	overlay := db.NewOverlayDb(snapshot)
+	for _, c := range req.Changes {
+		if err := applyChange(overlay, aid, c); err != nil {
+			return WhatIfResult{}, err
+		}
+	}
+	after, err := evaluate(overlay, aid, req.MaxDepth)
+	if err != nil {
+		return WhatIfResult{}, err
+	}
+
+	result := WhatIfResult{
+		App:             aid,
+		Changes:         req.Changes,
+		Before:          before.summary(),
+		After:           after.summary(),
+		PathsRemoved:    []AttackPath{},
+		PathsAdded:      []AttackPath{},
+		PathsChanged:    []PathChange{},
+		FindingsRemoved: []scenarios.Finding{},
+		FindingsAdded:   []scenarios.Finding{},
+	}
+
+	// diff attack paths by id
+	afterPaths := map[string]AttackPath{}
+	for _, p := range after.paths {
+		afterPaths[p.ID] = p
+	}
+	beforePaths := map[string]bool{}
+	for _, p := range before.paths {
+		beforePaths[p.ID] = true
+		q, ok := afterPaths[p.ID]
+		if !ok {
+			result.PathsRemoved = append(result.PathsRemoved, p)
+			continue
+		}
+		if q.Score != p.Score {
+			result.PathsChanged = append(result.PathsChanged, PathChange{
+				ID:             p.ID,
+				BeforeScore:    p.Score,
+				AfterScore:     q.Score,
+				BeforeCategory: p.Category,
+				AfterCategory:  q.Category,
+			})
+		}
+	}
+	for _, p := range after.paths {
+		if !beforePaths[p.ID] {
+			result.PathsAdded = append(result.PathsAdded, p)
+		}
+	}
+
+	// diff findings by scenario and entity
+	afterFindings := map[string]bool{}
+	for _, f := range after.findings {
+		afterFindings[getFindingKey(f)] = true
+	}
+	beforeFindings := map[string]bool{}
+	for _, f := range before.findings {
+		beforeFindings[getFindingKey(f)] = true
+		if !afterFindings[getFindingKey(f)] {
+			result.FindingsRemoved = append(result.FindingsRemoved, f)
+		}
+	}
+	for _, f := range after.findings {
+		if !beforeFindings[getFindingKey(f)] {
+			result.FindingsAdded = append(result.FindingsAdded, f)
+		}
+	}
+	sort.Slice(result.PathsChanged, func(i, j int) bool {
+		return result.PathsChanged[i].ID < result.PathsChanged[j].ID
+	})
+	return result, nil
+}
+
+// SimulateWhatIf is POST handler to simulate hypothetical changes of an app
+func (rk *Risk) SimulateWhatIf(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  WhatIfRequest
+		vars = mux.Vars(r)
+		aid  = vars["id"]
+	)
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := rk.db.Get(graph.DB_TABLE_GRAPH, aid); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("simulating %v changes on app %v\n", len(req.Changes), aid)
+
+	result, err := rk.WhatIf(aid, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
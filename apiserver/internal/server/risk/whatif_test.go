@@ -0,0 +1,59 @@
+package risk
+
+import (
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+func TestWhatIf(t *testing.T) {
+	// an exposed web server reaches a database, and a user lacks MFA
+	d := newTestDb(t)
+	graph.Apps(d).Put("a1", graph.AppData{ID: "a1", Name: "a1"})
+	putEntity(t, d, "a1", "web1", exposed)
+	putEntity(t, d, "a1", "db1", jewel)
+	putAssoc(t, d, "a1", "s1", []string{"web1"}, []string{"db1"})
+	key := graph.GetEntityKey("a1", "u1")
+	if err := graph.Entities(d).Put(key, graph.Entity{ID: key, Name: "u1", Kind: "user", Attributes: map[string]string{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewRisk(d).WhatIf("a1", WhatIfRequest{Changes: []Change{
+		{Type: CHANGE_REMOVE_ASSOC, Assoc: "s1"},
+		{Type: CHANGE_SET_ATTRIBUTE, Entity: "u1", Attribute: "MFAEnabledTime", Value: "2024-01-01"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the path through the removed hyperedge is gone
+	if result.Before.AttackPaths != 1 || result.After.AttackPaths != 0 {
+		t.Fatalf("expecting 1 path before and none after, got %+v %+v", result.Before, result.After)
+	}
+	if len(result.PathsRemoved) != 1 || len(result.PathsAdded) != 0 || len(result.PathsChanged) != 0 {
+		t.Fatalf("expecting one path removed, got %+v", result)
+	}
+
+	// the brute force finding of the user is gone, and nothing is added
+	removed := map[string]bool{}
+	for _, f := range result.FindingsRemoved {
+		removed[f.Title+"|"+f.Entity] = true
+	}
+	if !removed["Credential Access Access via Brute Force|u1"] || len(result.FindingsAdded) != 0 {
+		t.Fatalf("expecting the brute force finding of u1 removed, got %+v added %+v", result.FindingsRemoved, result.FindingsAdded)
+	}
+	if result.Before.Findings-result.After.Findings != len(result.FindingsRemoved) {
+		t.Fatalf("expecting summaries to match removed findings, got %+v %+v", result.Before, result.After)
+	}
+
+	// real data is left untouched, attack graphs included
+	if _, err := graph.Assocs(d).Get(graph.GetEntityKey("a1", "s1")); err != nil {
+		t.Fatalf("expecting assoc s1 kept, got %v", err)
+	}
+	if e, _ := graph.Entities(d).Get(key); len(e.Attributes) != 0 {
+		t.Fatalf("expecting u1 unchanged, got %+v", e.Attributes)
+	}
+	if apps, _ := graph.Apps(d).List(); len(apps) != 1 {
+		t.Fatalf("expecting no attack graph stored, got %v apps", len(apps))
+	}
+}
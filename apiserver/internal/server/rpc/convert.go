@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+// stringMap renders attribute values as strings, JSON encoding non-scalars
+func stringMap(attrs map[string]interface{}) map[string]string {
+	ret := map[string]string{}
+	for k, v := range attrs {
+		switch val := v.(type) {
+		case string:
+			ret[k] = val
+		case bool, float64, int:
+			ret[k] = fmt.Sprint(val)
+		default:
+			data, _ := json.Marshal(val)
+			ret[k] = string(data)
+		}
+	}
+	return ret
+}
+
+// anyMap reverses stringMap, keeping values as strings
+func anyMap(attrs map[string]string) map[string]interface{} {
+	if attrs == nil {
+		return nil
+	}
+	ret := map[string]interface{}{}
+	for k, v := range attrs {
+		ret[k] = v
+	}
+	return ret
+}
+
+func int32Map(m map[string]int) map[string]int32 {
+	ret := map[string]int32{}
+	for k, v := range m {
+		ret[k] = int32(v)
+	}
+	return ret
+}
+
+func toApp(a graph.AppData) App {
+	ret := App{
+		ID:           a.ID,
+		Name:         a.Name,
+		Type:         a.Type,
+		Description:  a.Description,
+		Attributes:   stringMap(a.Attributes),
+		Created:      a.Created,
+		LastModified: a.LastModified,
+		Stats: Stats{
+			NumRuns:         int32(a.Stats.NumRuns),
+			LastAttackGraph: a.Stats.LastAttackGraph,
+			LastScore:       a.Stats.LastScore,
+		},
+	}
+	for _, ts := range a.Stats.RunTS {
+		ret.Stats.RunTS = append(ret.Stats.RunTS, int64(ts))
+	}
+	return ret
+}
+
+func fromApp(a App) graph.AppData {
+	return graph.AppData{
+		ID:          a.ID,
+		Name:        a.Name,
+		Type:        a.Type,
+		Description: a.Description,
+		Attributes:  anyMap(a.Attributes),
+	}
+}
+
+func toEntity(e graph.Entity) Entity {
+	ret := Entity{
+		ID:           e.ID,
+		Name:         e.Name,
+		Description:  e.Description,
+		Kind:         e.Kind,
+		Attributes:   e.Attributes,
+		Created:      e.Created,
+		LastModified: e.LastModified,
+	}
+	for _, child := range e.Entities {
+		ret.Entities = append(ret.Entities, toEntity(child))
+	}
+	return ret
+}
+
+func fromEntity(e Entity) graph.Entity {
+	ret := graph.Entity{
+		ID:          e.ID,
+		Name:        e.Name,
+		Description: e.Description,
+		Kind:        e.Kind,
+		Attributes:  e.Attributes,
+	}
+	for _, child := range e.Entities {
+		ret.Entities = append(ret.Entities, fromEntity(child))
+	}
+	return ret
+}
+
+func toAssoc(a graph.Assoc) Assoc {
+	return Assoc{
+		ID:            a.ID,
+		Name:          a.Name,
+		Description:   a.Description,
+		Label:         a.Label,
+		FromEntities:  a.FromEntities,
+		ToEntities:    a.ToEntities,
+		OtherEntities: a.OtherEntities,
+		Attributes:    stringMap(a.Attributes),
+		Created:       a.Created,
+		LastModified:  a.LastModified,
+	}
+}
+
+func fromAssoc(a Assoc) graph.Assoc {
+	return graph.Assoc{
+		ID:            a.ID,
+		Name:          a.Name,
+		Description:   a.Description,
+		Label:         a.Label,
+		FromEntities:  a.FromEntities,
+		ToEntities:    a.ToEntities,
+		OtherEntities: a.OtherEntities,
+		Attributes:    anyMap(a.Attributes),
+	}
+}
+
+func toFinding(agid string, f scenarios.Finding) Finding {
+	return Finding{
+		ID:          f.ID,
+		Title:       f.Title,
+		Entity:      f.Entity,
+		Risk:        f.Risk,
+		TacticID:    f.TacticID,
+		Tactic:      f.Tactic,
+		TechniqueID: f.TechniqueID,
+		Technique:   f.Technique.Technique,
+		Remediation: f.Remediation,
+		Attributes:  f.Attributes,
+		AttackGraph: agid,
+	}
+}
+
+func toRiskPoint(t risk.TrendPoint) RiskPoint {
+	return RiskPoint{
+		Timestamp:      t.Timestamp,
+		AttackGraph:    t.AttackGraph,
+		AttackPaths:    int32(t.AttackPaths),
+		PathCategories: int32Map(t.PathCategories),
+		Findings:       int32(t.Findings),
+		FindingRisks:   int32Map(t.FindingRisks),
+		TotalRisk:      t.TotalRisk,
+		MaxScore:       t.MaxScore,
+		AverageScore:   t.AverageScore,
+	}
+}
@@ -0,0 +1,539 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/risk"
+	"github.com/zetafence/zentaris/apiserver/internal/server/scenarios"
+)
+
+const (
+	// gRPC service name and path prefix of its methods
+	SERVICE      = "zentaris.v1.Zentaris"
+	SERVICE_PATH = "/" + SERVICE + "/"
+
+	// maximum size of received messages
+	MAX_MESSAGE_SIZE = 4 << 20
+
+	// gRPC status codes
+	CODE_OK                  = 0
+	CODE_UNKNOWN             = 2
+	CODE_INVALID_ARGUMENT    = 3
+	CODE_NOT_FOUND           = 5
+	CODE_ALREADY_EXISTS      = 6
+	CODE_PERMISSION_DENIED   = 7
+	CODE_RESOURCE_EXHAUSTED  = 8
+	CODE_FAILED_PRECONDITION = 9
+	CODE_UNIMPLEMENTED       = 12
+	CODE_INTERNAL            = 13
+	CODE_UNAVAILABLE         = 14
+	CODE_UNAUTHENTICATED     = 16
+)
+
+// request headers not forwarded onto REST calls
+var hopHeaders = map[string]bool{"Content-Type": true, "Content-Length": true, "Te": true, "User-Agent": true}
+
+// methods of the gRPC service
+var methods = map[string]func(s *Server, st *stream) error{
+	"ListApps":       (*Server).listApps,
+	"GetApp":         (*Server).getApp,
+	"CreateApp":      (*Server).createApp,
+	"ListEntities":   (*Server).listEntities,
+	"GetEntity":      (*Server).getEntity,
+	"UploadEntities": (*Server).uploadEntities,
+	"ListAssocs":     (*Server).listAssocs,
+	"GetAssoc":       (*Server).getAssoc,
+	"CreateAssocs":   (*Server).createAssocs,
+	"Scan":           (*Server).scan,
+	"StreamFindings": (*Server).streamFindings,
+	"GetRiskReport":  (*Server).getRiskReport,
+}
+
+// NewServer returns a gRPC server transcoding calls onto a REST handler
+func NewServer(rest http.Handler) *Server {
+	return &Server{
+		rest: rest,
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", e.Code, e.Message)
+}
+
+// statusCode maps REST response statuses onto gRPC status codes
+func statusCode(status int) int {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return CODE_INVALID_ARGUMENT
+	case http.StatusUnauthorized:
+		return CODE_UNAUTHENTICATED
+	case http.StatusForbidden:
+		return CODE_PERMISSION_DENIED
+	case http.StatusNotFound:
+		return CODE_NOT_FOUND
+	case http.StatusConflict:
+		return CODE_ALREADY_EXISTS
+	case http.StatusPreconditionFailed:
+		return CODE_FAILED_PRECONDITION
+	case http.StatusTooManyRequests:
+		return CODE_RESOURCE_EXHAUSTED
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return CODE_UNIMPLEMENTED
+	case http.StatusServiceUnavailable:
+		return CODE_UNAVAILABLE
+	}
+	if status >= 500 {
+		return CODE_INTERNAL
+	}
+	return CODE_UNKNOWN
+}
+
+// stream reads and writes length-prefixed messages of a call
+type stream struct {
+	r    *http.Request
+	w    http.ResponseWriter
+	body *bufio.Reader
+}
+
+// Recv reads the next request message, io.EOF when the client is done
+func (st *stream) Recv(msg interface{}) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(st.body, prefix[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return &Error{CODE_INVALID_ARGUMENT, "truncated message"}
+	}
+	if prefix[0] != 0 {
+		return &Error{CODE_UNIMPLEMENTED, "compressed messages not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > MAX_MESSAGE_SIZE {
+		return &Error{CODE_RESOURCE_EXHAUSTED, fmt.Sprintf("message larger than %d bytes", MAX_MESSAGE_SIZE)}
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(st.body, data); err != nil {
+		return &Error{CODE_INVALID_ARGUMENT, "truncated message"}
+	}
+	if err := Unmarshal(data, msg); err != nil {
+		return &Error{CODE_INVALID_ARGUMENT, err.Error()}
+	}
+	return nil
+}
+
+// RecvOne reads the single request message of unary and server streaming calls
+func (st *stream) RecvOne(msg interface{}) error {
+	if err := st.Recv(msg); err != nil {
+		if err == io.EOF {
+			return &Error{CODE_INVALID_ARGUMENT, "missing request message"}
+		}
+		return err
+	}
+	return nil
+}
+
+// Send writes a response message
+func (st *stream) Send(msg interface{}) error {
+	data, err := Marshal(msg)
+	if err != nil {
+		return &Error{CODE_INTERNAL, err.Error()}
+	}
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	if _, err := st.w.Write(append(frame, data...)); err != nil {
+		return err
+	}
+	if f, ok := st.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// ServeHTTP serves gRPC calls, answering with their status in trailers
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Method != http.MethodPost {
+		http.Error(w, "gRPC requires HTTP/2 POST requests", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	ct := r.Header.Get("Content-Type")
+	if ct != "application/grpc" && ct != "application/grpc+proto" {
+		http.Error(w, "unsupported content type "+ct, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	err := &Error{CODE_UNIMPLEMENTED, "unknown method " + r.URL.Path}
+	if name := strings.TrimPrefix(r.URL.Path, SERVICE_PATH); name != r.URL.Path {
+		if method, ok := methods[name]; ok {
+			err = nil
+			if e := method(s, &stream{r: r, w: w, body: bufio.NewReader(r.Body)}); e != nil {
+				if rpcErr, ok := e.(*Error); ok {
+					err = rpcErr
+				} else {
+					err = &Error{CODE_INTERNAL, e.Error()}
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("gRPC call %v failed: %v\n", r.URL.Path, err)
+		w.Header().Set("Grpc-Status", fmt.Sprint(err.Code))
+		w.Header().Set("Grpc-Message", encodeMessage(err.Message))
+		return
+	}
+	w.Header().Set("Grpc-Status", fmt.Sprint(CODE_OK))
+}
+
+// encodeMessage percent-encodes status messages, except printable ASCII
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// recorder records REST responses
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+}
+
+// call serves a REST request on behalf of a gRPC call, with its metadata as
+// headers, returning the response body
+func (s *Server) call(st *stream, method, path string, query url.Values, body interface{}) ([]byte, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, &Error{CODE_INTERNAL, err.Error()}
+		}
+		reader = bytes.NewReader(data)
+	}
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(st.r.Context(), method, target, reader)
+	if err != nil {
+		return nil, &Error{CODE_INVALID_ARGUMENT, err.Error()}
+	}
+	for k, v := range st.r.Header {
+		if !hopHeaders[k] && !strings.HasPrefix(k, "Grpc-") {
+			req.Header[k] = v
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.RemoteAddr = st.r.RemoteAddr
+
+	rec := &recorder{header: http.Header{}, status: http.StatusOK}
+	s.rest.ServeHTTP(rec, req)
+	if rec.status >= http.StatusMultipleChoices {
+		return nil, &Error{statusCode(rec.status), strings.TrimSpace(rec.body.String())}
+	}
+	return rec.body.Bytes(), nil
+}
+
+// callJSON serves a REST request decoding its JSON response
+func (s *Server) callJSON(st *stream, method, path string, query url.Values, body, resp interface{}) error {
+	data, err := s.call(st, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return &Error{CODE_INTERNAL, err.Error()}
+	}
+	return nil
+}
+
+// appPath returns the REST path of an app, followed by given elements
+func appPath(aid string, elems ...string) string {
+	path := "/v1/app/" + url.PathEscape(aid)
+	for _, e := range elems {
+		path += "/" + url.PathEscape(e)
+	}
+	return path
+}
+
+// pageQuery returns the query of a list page following a given cursor, with
+// the largest page size
+func pageQuery(cursor string) url.Values {
+	query := url.Values{"limit": {strconv.Itoa(graph.MAX_PAGE_SIZE)}}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	return query
+}
+
+// ingestQuery returns the query of ingestions, replacing and of a discovery
+// run when given
+func ingestQuery(replace bool, run string) url.Values {
+	query := url.Values{}
+	if replace {
+		query.Set("replace", "true")
+	}
+	if run != "" {
+		query.Set("run", run)
+	}
+	return query
+}
+
+func (s *Server) listApps(st *stream) error {
+	var in ListAppsRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	out := AppList{}
+	for cursor := ""; ; {
+		var list graph.AppsList
+		if err := s.callJSON(st, http.MethodGet, "/v1/apps", pageQuery(cursor), nil, &list); err != nil {
+			return err
+		}
+		for _, a := range list.Apps {
+			out.Apps = append(out.Apps, toApp(a))
+		}
+		if cursor = list.NextCursor; cursor == "" {
+			break
+		}
+	}
+	return st.Send(&out)
+}
+
+func (s *Server) getApp(st *stream) error {
+	var in AppRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	var a graph.AppData
+	if err := s.callJSON(st, http.MethodGet, appPath(in.App), nil, nil, &a); err != nil {
+		return err
+	}
+	out := toApp(a)
+	return st.Send(&out)
+}
+
+func (s *Server) createApp(st *stream) error {
+	var in App
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	resp, err := s.call(st, http.MethodPost, "/v1/app", nil, fromApp(in))
+	if err != nil {
+		return err
+	}
+	return st.Send(&Status{Status: string(resp)})
+}
+
+func (s *Server) listEntities(st *stream) error {
+	var in AppRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	out := EntityList{}
+	for cursor := ""; ; {
+		var list graph.EntityList
+		if err := s.callJSON(st, http.MethodGet, appPath(in.App, "entities"), pageQuery(cursor), nil, &list); err != nil {
+			return err
+		}
+		for _, e := range list.Entities {
+			out.Entities = append(out.Entities, toEntity(e))
+		}
+		if cursor = list.NextCursor; cursor == "" {
+			break
+		}
+	}
+	return st.Send(&out)
+}
+
+func (s *Server) getEntity(st *stream) error {
+	var in EntityRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	var e graph.Entity
+	if err := s.callJSON(st, http.MethodGet, appPath(in.App, "entity", in.Entity), nil, nil, &e); err != nil {
+		return err
+	}
+	out := toEntity(e)
+	return st.Send(&out)
+}
+
+// uploadEntities ingests a stream of entities of an app as one snapshot version
+func (s *Server) uploadEntities(st *stream) error {
+	var (
+		aid     string
+		replace bool
+		run     string
+		list    = graph.EntityList{Entities: []graph.Entity{}}
+	)
+	for {
+		var in UploadEntityRequest
+		err := st.Recv(&in)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if aid != "" && in.App != aid {
+			return &Error{CODE_INVALID_ARGUMENT, fmt.Sprintf("entities of apps %v and %v uploaded at once", aid, in.App)}
+		}
+		aid = in.App
+		replace = replace || in.Replace
+		if in.Run != "" {
+			run = in.Run
+		}
+		list.Entities = append(list.Entities, fromEntity(in.Entity))
+	}
+	if aid == "" {
+		return &Error{CODE_INVALID_ARGUMENT, "no entities uploaded"}
+	}
+	resp, err := s.call(st, http.MethodPost, appPath(aid, "entity"), ingestQuery(replace, run), list)
+	if err != nil {
+		return err
+	}
+	return st.Send(&Status{Status: string(resp)})
+}
+
+func (s *Server) listAssocs(st *stream) error {
+	var in AppRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	out := AssocList{}
+	for cursor := ""; ; {
+		var list graph.AssocList
+		if err := s.callJSON(st, http.MethodGet, appPath(in.App, "assocs"), pageQuery(cursor), nil, &list); err != nil {
+			return err
+		}
+		for _, a := range list.Assocs {
+			out.Assocs = append(out.Assocs, toAssoc(a))
+		}
+		if cursor = list.NextCursor; cursor == "" {
+			break
+		}
+	}
+	return st.Send(&out)
+}
+
+func (s *Server) getAssoc(st *stream) error {
+	var in AssocRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	var a graph.Assoc
+	if err := s.callJSON(st, http.MethodGet, appPath(in.App, "assoc", in.Assoc), nil, nil, &a); err != nil {
+		return err
+	}
+	out := toAssoc(a)
+	return st.Send(&out)
+}
+
+func (s *Server) createAssocs(st *stream) error {
+	var in CreateAssocsRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	list := graph.AssocList{Assocs: []graph.Assoc{}}
+	for _, a := range in.Assocs {
+		list.Assocs = append(list.Assocs, fromAssoc(a))
+	}
+	resp, err := s.call(st, http.MethodPost, appPath(in.App, "assoc"), ingestQuery(in.Replace, in.Run), list)
+	if err != nil {
+		return err
+	}
+	return st.Send(&Status{Status: string(resp)})
+}
+
+func (s *Server) scan(st *stream) error {
+	var in AppRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	var resp graph.Response
+	if err := s.callJSON(st, http.MethodPost, appPath(in.App, "eval"), nil, nil, &resp); err != nil {
+		return err
+	}
+	return st.Send(&Status{Status: resp.Status})
+}
+
+// streamFindings streams findings of the latest attack graph of an app
+func (s *Server) streamFindings(st *stream) error {
+	var in FindingsRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	var query url.Values
+	if in.Risk != "" {
+		query = url.Values{"risk": {in.Risk}}
+	}
+	var list scenarios.FindingList
+	if err := s.callJSON(st, http.MethodGet, appPath(in.App, "findings"), query, nil, &list); err != nil {
+		return err
+	}
+	for _, f := range list.Findings {
+		out := toFinding(list.AttackGraph, f)
+		if err := st.Send(&out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) getRiskReport(st *stream) error {
+	var in RiskReportRequest
+	if err := st.RecvOne(&in); err != nil {
+		return err
+	}
+	query := url.Values{}
+	if in.From != "" {
+		query.Set("from", in.From)
+	}
+	if in.To != "" {
+		query.Set("to", in.To)
+	}
+	var list risk.TrendList
+	if err := s.callJSON(st, http.MethodGet, appPath(in.App, "trend"), query, nil, &list); err != nil {
+		return err
+	}
+	out := RiskReport{App: list.App}
+	for _, t := range list.Trends {
+		out.Points = append(out.Points, toRiskPoint(t))
+	}
+	return st.Send(&out)
+}
@@ -0,0 +1,172 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/handler"
+)
+
+const testAdminKey = "test-admin-key"
+
+// newTestServer serves the gRPC API of all REST handlers over HTTP/2 and TLS
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(NewServer(handler.RegisterHandlers(handler.Config{AdminKey: testAdminKey})))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// invoke calls a method with request messages as a gRPC client does, returning
+// response messages and the status code and message of the call
+func invoke(t *testing.T, srv *httptest.Server, method, key string, reqs ...interface{}) ([][]byte, string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	for _, msg := range reqs {
+		data, err := Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prefix [5]byte
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+		body.Write(prefix[:])
+		body.Write(data)
+	}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+SERVICE_PATH+method, &body)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expecting HTTP/2, got %v", resp.Proto)
+	}
+
+	frames := [][]byte{}
+	for {
+		var prefix [5]byte
+		if _, err := io.ReadFull(resp.Body, prefix[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(resp.Body, data); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, data)
+	}
+	return frames, resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+}
+
+// decode decodes the single response message of a call
+func decode(t *testing.T, frames [][]byte, msg interface{}) {
+	t.Helper()
+	if len(frames) != 1 {
+		t.Fatalf("expecting one response message, got %v", len(frames))
+	}
+	if err := Unmarshal(frames[0], msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCalls(t *testing.T) {
+	srv := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		reqs   []interface{}
+		code   string
+	}{
+		{"unauthenticated", "ListApps", "", []interface{}{&ListAppsRequest{}}, "16"},
+		{"unknown method", "DeleteApp", testAdminKey, []interface{}{&AppRequest{App: "a1"}}, "12"},
+		{"missing request", "GetApp", testAdminKey, nil, "3"},
+		{"not found", "GetApp", testAdminKey, []interface{}{&AppRequest{App: "a1"}}, "5"},
+		{"created", "CreateApp", testAdminKey, []interface{}{&App{ID: "a1", Name: "app"}}, "0"},
+		{"uploaded as a stream", "UploadEntities", testAdminKey, []interface{}{
+			&UploadEntityRequest{App: "a1", Entity: Entity{ID: "u1", Kind: "iam:user", Attributes: map[string]string{"k": "v"}}},
+			&UploadEntityRequest{App: "a1", Entity: Entity{ID: "r1", Kind: "iam:role"}},
+		}, "0"},
+		{"streams of several apps", "UploadEntities", testAdminKey, []interface{}{
+			&UploadEntityRequest{App: "a1", Entity: Entity{ID: "u2"}},
+			&UploadEntityRequest{App: "a2", Entity: Entity{ID: "u3"}},
+		}, "3"},
+		{"scanned", "Scan", testAdminKey, []interface{}{&AppRequest{App: "a1"}}, "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, code, msg := invoke(t, srv, tt.method, tt.key, tt.reqs...); code != tt.code {
+				t.Fatalf("expecting status %v, got %v %v", tt.code, code, msg)
+			}
+		})
+	}
+
+	// responses decode into messages of zentaris.proto
+	var app App
+	frames, _, _ := invoke(t, srv, "GetApp", testAdminKey, &AppRequest{App: "a1"})
+	decode(t, frames, &app)
+	if app.ID != "a1" || app.Name != "app" || app.Stats.NumRuns != 1 || len(app.Stats.RunTS) != 1 {
+		t.Fatalf("expecting app a1 scanned once, got %+v", app)
+	}
+
+	var entities EntityList
+	frames, _, _ = invoke(t, srv, "ListEntities", testAdminKey, &AppRequest{App: "a1"})
+	decode(t, frames, &entities)
+	if len(entities.Entities) != 2 || entities.Entities[1].ID != "u1" || entities.Entities[1].Attributes["k"] != "v" {
+		t.Fatalf("expecting uploaded entities, got %+v", entities)
+	}
+
+	// uploads of one discovery run make one snapshot version
+	var status Status
+	for _, call := range []struct {
+		method string
+		req    interface{}
+		want   string
+	}{
+		{"UploadEntities", &UploadEntityRequest{App: "a1", Entity: Entity{ID: "u1"}, Run: "r1"}, "version 2"},
+		{"CreateAssocs", &CreateAssocsRequest{App: "a1", Assocs: []Assoc{{ID: "s1", FromEntities: []string{"u1"}}}, Run: "r1"}, "version 2"},
+		{"CreateAssocs", &CreateAssocsRequest{App: "a1", Assocs: []Assoc{{ID: "s1", FromEntities: []string{"u1"}}}}, "version 3"},
+	} {
+		frames, _, _ = invoke(t, srv, call.method, testAdminKey, call.req)
+		decode(t, frames, &status)
+		if !strings.HasSuffix(status.Status, call.want) {
+			t.Fatalf("%v: expecting %v, got %v", call.method, call.want, status.Status)
+		}
+	}
+
+	// findings stream one message each
+	frames, code, msg := invoke(t, srv, "StreamFindings", testAdminKey, &FindingsRequest{App: "a1"})
+	if code != "0" || len(frames) == 0 {
+		t.Fatalf("expecting streamed findings, got %v messages, status %v %v", len(frames), code, msg)
+	}
+	for _, data := range frames {
+		var f Finding
+		if err := Unmarshal(data, &f); err != nil || f.Title == "" || f.AttackGraph != app.Stats.LastAttackGraph {
+			t.Fatalf("expecting a finding of the last attack graph, got %+v %v", f, err)
+		}
+	}
+}
+
+func TestHTTP1Rejected(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, SERVICE_PATH+"ListApps", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	NewServer(http.NotFoundHandler()).ServeHTTP(rec, req)
+	if rec.Code != http.StatusHTTPVersionNotSupported {
+		t.Fatalf("expecting 505, got %v", rec.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"net/http"
+)
+
+// Server serves the gRPC API of zentaris.proto over HTTP/2, transcoding each
+// call onto the REST API so that calls share its authentication, tenancy and
+// auditing
+type Server struct {
+	// REST API handler
+	rest http.Handler
+}
+
+// Error is a gRPC status of a failed call
+type Error struct {
+	Code    int
+	Message string
+}
+
+// messages of zentaris.proto, fields are tagged with their field numbers
+
+type ListAppsRequest struct{}
+
+type AppRequest struct {
+	App string `pb:"1"`
+}
+
+type EntityRequest struct {
+	App    string `pb:"1"`
+	Entity string `pb:"2"`
+}
+
+type AssocRequest struct {
+	App   string `pb:"1"`
+	Assoc string `pb:"2"`
+}
+
+type Status struct {
+	Status string `pb:"1"`
+}
+
+type Stats struct {
+	NumRuns         int32   `pb:"1"`
+	RunTS           []int64 `pb:"2"`
+	LastAttackGraph string  `pb:"3"`
+	LastScore       float64 `pb:"4"`
+}
+
+type App struct {
+	ID           string            `pb:"1"`
+	Name         string            `pb:"2"`
+	Type         string            `pb:"3"`
+	Description  string            `pb:"4"`
+	Stats        Stats             `pb:"5"`
+	Attributes   map[string]string `pb:"6"`
+	Created      string            `pb:"7"`
+	LastModified string            `pb:"8"`
+}
+
+type AppList struct {
+	Apps []App `pb:"1"`
+}
+
+type Entity struct {
+	ID           string            `pb:"1"`
+	Name         string            `pb:"2"`
+	Description  string            `pb:"3"`
+	Kind         string            `pb:"4"`
+	Attributes   map[string]string `pb:"5"`
+	Entities     []Entity          `pb:"6"`
+	Created      string            `pb:"7"`
+	LastModified string            `pb:"8"`
+}
+
+type EntityList struct {
+	Entities []Entity `pb:"1"`
+}
+
+type UploadEntityRequest struct {
+	App     string `pb:"1"`
+	Entity  Entity `pb:"2"`
+	Replace bool   `pb:"3"`
+	Run     string `pb:"4"`
+}
+
+type Assoc struct {
+	ID            string            `pb:"1"`
+	Name          string            `pb:"2"`
+	Description   string            `pb:"3"`
+	Label         string            `pb:"4"`
+	FromEntities  []string          `pb:"5"`
+	ToEntities    []string          `pb:"6"`
+	OtherEntities []string          `pb:"7"`
+	Attributes    map[string]string `pb:"8"`
+	Created       string            `pb:"9"`
+	LastModified  string            `pb:"10"`
+}
+
+type AssocList struct {
+	Assocs []Assoc `pb:"1"`
+}
+
+type CreateAssocsRequest struct {
+	App     string  `pb:"1"`
+	Assocs  []Assoc `pb:"2"`
+	Replace bool    `pb:"3"`
+	Run     string  `pb:"4"`
+}
+
+type FindingsRequest struct {
+	App  string `pb:"1"`
+	Risk string `pb:"2"`
+}
+
+type Finding struct {
+	ID          string            `pb:"1"`
+	Title       string            `pb:"2"`
+	Entity      string            `pb:"3"`
+	Risk        string            `pb:"4"`
+	TacticID    string            `pb:"5"`
+	Tactic      string            `pb:"6"`
+	TechniqueID string            `pb:"7"`
+	Technique   string            `pb:"8"`
+	Remediation string            `pb:"9"`
+	Attributes  map[string]string `pb:"10"`
+	AttackGraph string            `pb:"11"`
+}
+
+type RiskReportRequest struct {
+	App  string `pb:"1"`
+	From string `pb:"2"`
+	To   string `pb:"3"`
+}
+
+type RiskPoint struct {
+	Timestamp      string           `pb:"1"`
+	AttackGraph    string           `pb:"2"`
+	AttackPaths    int32            `pb:"3"`
+	PathCategories map[string]int32 `pb:"4"`
+	Findings       int32            `pb:"5"`
+	FindingRisks   map[string]int32 `pb:"6"`
+	TotalRisk      float64          `pb:"7"`
+	MaxScore       float64          `pb:"8"`
+	AverageScore   float64          `pb:"9"`
+}
+
+type RiskReport struct {
+	App    string      `pb:"1"`
+	Points []RiskPoint `pb:"2"`
+}
@@ -0,0 +1,343 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// protobuf wire types
+const (
+	WIRE_VARINT  = 0
+	WIRE_FIXED64 = 1
+	WIRE_BYTES   = 2
+	WIRE_FIXED32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+// Marshal encodes a message, i.e. a pointer to a struct whose fields carry
+// their protobuf field numbers in pb tags, in protobuf wire format. Strings,
+// bools, int32, int64, doubles, nested messages, repeated fields, packed for
+// scalars as proto3 does, and string keyed maps are supported, as used by
+// zentaris.proto.
+func Marshal(msg interface{}) ([]byte, error) {
+	v := reflect.ValueOf(msg)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("unable to marshal %T", msg)
+	}
+	return appendMessage(nil, v)
+}
+
+// Unmarshal decodes a message in protobuf wire format, skipping unknown fields
+func Unmarshal(data []byte, msg interface{}) error {
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unable to unmarshal into %T", msg)
+	}
+	return decodeMessage(data, v.Elem())
+}
+
+// fieldNumbers returns struct field indexes by protobuf field number
+func fieldNumbers(t reflect.Type) map[uint64]int {
+	ret := map[uint64]int{}
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("pb"); tag != "" {
+			if n, err := strconv.ParseUint(tag, 10, 32); err == nil {
+				ret[n] = i
+			}
+		}
+	}
+	return ret
+}
+
+func appendTag(b []byte, num uint64, wire int) []byte {
+	return binary.AppendUvarint(b, num<<3|uint64(wire))
+}
+
+func appendBytes(b []byte, num uint64, data []byte) []byte {
+	b = appendTag(b, num, WIRE_BYTES)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// appendMessage encodes all non-default fields of a struct
+func appendMessage(b []byte, v reflect.Value) ([]byte, error) {
+	nums := fieldNumbers(v.Type())
+	order := make([]uint64, 0, len(nums))
+	for num := range nums {
+		order = append(order, num)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+
+	var err error
+	for _, num := range order {
+		f := v.Field(nums[num])
+		switch f.Kind() {
+		case reflect.Slice:
+			if isPackable(f.Type().Elem().Kind()) {
+				// repeated scalars are packed, as proto3 does by default
+				if f.Len() > 0 {
+					b = appendBytes(b, num, appendPacked(nil, f))
+				}
+				continue
+			}
+			for i := 0; i < f.Len(); i++ {
+				if b, err = appendValue(b, num, f.Index(i), true); err != nil {
+					return nil, err
+				}
+			}
+		case reflect.Map:
+			keys := f.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, k := range keys {
+				entry, err := appendValue(nil, 1, k, true)
+				if err != nil {
+					return nil, err
+				}
+				if entry, err = appendValue(entry, 2, f.MapIndex(k), true); err != nil {
+					return nil, err
+				}
+				b = appendBytes(b, num, entry)
+			}
+		default:
+			if b, err = appendValue(b, num, f, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// appendValue encodes a scalar or message field, skipping defaults unless
+// repeated or map entries
+func appendValue(b []byte, num uint64, f reflect.Value, always bool) ([]byte, error) {
+	if !always && f.IsZero() {
+		return b, nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		return appendBytes(b, num, []byte(f.String())), nil
+	case reflect.Bool:
+		b = appendTag(b, num, WIRE_VARINT)
+		if f.Bool() {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case reflect.Int32, reflect.Int64, reflect.Int:
+		b = appendTag(b, num, WIRE_VARINT)
+		return binary.AppendUvarint(b, uint64(f.Int())), nil
+	case reflect.Float64:
+		b = appendTag(b, num, WIRE_FIXED64)
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(f.Float())), nil
+	case reflect.Ptr:
+		if f.IsNil() {
+			return appendBytes(b, num, nil), nil
+		}
+		return appendValue(b, num, f.Elem(), true)
+	case reflect.Struct:
+		data, err := appendMessage(nil, f)
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(b, num, data), nil
+	}
+	return nil, fmt.Errorf("unsupported protobuf field type %v", f.Type())
+}
+
+// appendPacked encodes repeated scalars without their tags
+func appendPacked(b []byte, f reflect.Value) []byte {
+	for i := 0; i < f.Len(); i++ {
+		switch v := f.Index(i); v.Kind() {
+		case reflect.Bool:
+			if v.Bool() {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+		case reflect.Float64:
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v.Float()))
+		default:
+			b = binary.AppendUvarint(b, uint64(v.Int()))
+		}
+	}
+	return b
+}
+
+// readField reads the tag and value of the next field, values of varint and
+// fixed fields are returned in x, bytes fields in data
+func readField(b []byte) (num uint64, wire int, x uint64, data []byte, rest []byte, err error) {
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, 0, nil, nil, errTruncated
+	}
+	b = b[n:]
+	num, wire = tag>>3, int(tag&7)
+	switch wire {
+	case WIRE_VARINT:
+		if x, n = binary.Uvarint(b); n <= 0 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		b = b[n:]
+	case WIRE_FIXED64:
+		if len(b) < 8 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		x, b = binary.LittleEndian.Uint64(b), b[8:]
+	case WIRE_FIXED32:
+		if len(b) < 4 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		x, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+	case WIRE_BYTES:
+		size, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < size {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		data, b = b[n:n+int(size)], b[n+int(size):]
+	default:
+		return 0, 0, 0, nil, nil, fmt.Errorf("unsupported protobuf wire type %v", wire)
+	}
+	return num, wire, x, data, b, nil
+}
+
+// decodeMessage decodes fields of a message into a struct
+func decodeMessage(b []byte, v reflect.Value) error {
+	nums := fieldNumbers(v.Type())
+	for len(b) > 0 {
+		num, wire, x, data, rest, err := readField(b)
+		if err != nil {
+			return err
+		}
+		b = rest
+		i, ok := nums[num]
+		if !ok {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Slice:
+			elem := reflect.New(f.Type().Elem()).Elem()
+			if wire == WIRE_BYTES && isPackable(elem.Kind()) {
+				// packed repeated scalars
+				for len(data) > 0 {
+					elem := reflect.New(f.Type().Elem()).Elem()
+					if data, err = decodePacked(data, elem); err != nil {
+						return err
+					}
+					f.Set(reflect.Append(f, elem))
+				}
+				continue
+			}
+			if err := decodeValue(wire, x, data, elem); err != nil {
+				return err
+			}
+			f.Set(reflect.Append(f, elem))
+		case reflect.Map:
+			if f.IsNil() {
+				f.Set(reflect.MakeMap(f.Type()))
+			}
+			key := reflect.New(f.Type().Key()).Elem()
+			val := reflect.New(f.Type().Elem()).Elem()
+			for len(data) > 0 {
+				num, wire, x, field, rest, err := readField(data)
+				if err != nil {
+					return err
+				}
+				data = rest
+				switch num {
+				case 1:
+					err = decodeValue(wire, x, field, key)
+				case 2:
+					err = decodeValue(wire, x, field, val)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			f.SetMapIndex(key, val)
+		default:
+			if err := decodeValue(wire, x, data, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isPackable(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool, reflect.Int32, reflect.Int64, reflect.Int, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// decodePacked decodes the next scalar of packed repeated fields
+func decodePacked(b []byte, v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Float64 {
+		if len(b) < 8 {
+			return nil, errTruncated
+		}
+		return b[8:], decodeValue(WIRE_FIXED64, binary.LittleEndian.Uint64(b), nil, v)
+	}
+	x, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, errTruncated
+	}
+	return b[n:], decodeValue(WIRE_VARINT, x, nil, v)
+}
+
+// decodeValue decodes a scalar or message field
+func decodeValue(wire int, x uint64, data []byte, v reflect.Value) error {
+	mismatch := func() error {
+		return fmt.Errorf("unexpected protobuf wire type %v for %v", wire, v.Type())
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if wire != WIRE_BYTES {
+			return mismatch()
+		}
+		v.SetString(string(data))
+	case reflect.Bool:
+		if wire != WIRE_VARINT {
+			return mismatch()
+		}
+		v.SetBool(x != 0)
+	case reflect.Int32:
+		if wire != WIRE_VARINT {
+			return mismatch()
+		}
+		v.SetInt(int64(int32(x)))
+	case reflect.Int64, reflect.Int:
+		if wire != WIRE_VARINT {
+			return mismatch()
+		}
+		v.SetInt(int64(x))
+	case reflect.Float64:
+		if wire != WIRE_FIXED64 {
+			return mismatch()
+		}
+		v.SetFloat(math.Float64frombits(x))
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(wire, x, data, v.Elem())
+	case reflect.Struct:
+		if wire != WIRE_BYTES {
+			return mismatch()
+		}
+		return decodeMessage(data, v)
+	default:
+		return fmt.Errorf("unsupported protobuf field type %v", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package rpc
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestWireGolden(t *testing.T) {
+	// encodings worked out from the protobuf encoding spec, fields in field
+	// number order, repeated scalars packed
+	tests := []struct {
+		name   string
+		msg    interface{}
+		golden string
+	}{
+		{
+			"app with stats and attributes",
+			&App{ID: "a1", Name: "n", Stats: Stats{NumRuns: 2, RunTS: []int64{1, 2}, LastScore: 0.5}, Attributes: map[string]string{"k": "v"}},
+			"0a026131" + "12016e" + "2a0f" + "0802" + "12020102" + "21000000000000e03f" + "3206" + "0a016b120176",
+		},
+		{
+			"negative int32",
+			&Stats{NumRuns: -1},
+			"08ffffffffffffffffff01",
+		},
+		{
+			"nested messages and bool",
+			&UploadEntityRequest{App: "a", Entity: Entity{ID: "e", Entities: []Entity{{ID: "c"}}}, Replace: true},
+			"0a0161" + "1208" + "0a0165" + "32030a0163" + "1801",
+		},
+		{
+			"int32 map",
+			&RiskPoint{PathCategories: map[string]int32{"high": 3}},
+			"2208" + "0a0468696768" + "1003",
+		},
+		{
+			"defaults omitted",
+			&App{},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Marshal(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(data); got != tt.golden {
+				t.Fatalf("expecting %v, got %v", tt.golden, got)
+			}
+
+			// decoding golden bytes round trips
+			golden, _ := hex.DecodeString(tt.golden)
+			got := reflect.New(reflect.TypeOf(tt.msg).Elem())
+			if err := Unmarshal(golden, got.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Interface(), tt.msg) {
+				t.Fatalf("expecting %+v, got %+v", tt.msg, got.Interface())
+			}
+		})
+	}
+}
+
+func TestWireDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		want  Stats
+		error bool
+	}{
+		{"unpacked repeated scalars", "0802" + "1001" + "1002", Stats{NumRuns: 2, RunTS: []int64{1, 2}}, false},
+		{"unknown fields skipped", "0802" + "2a0178" + "4d01020304" + "490000000000000000", Stats{NumRuns: 2}, false},
+		{"truncated varint", "08ff", Stats{}, true},
+		{"truncated bytes", "1a05616263", Stats{}, true},
+		{"int32 as bytes", "0a0161", Stats{}, true},
+		{"string as varint", "1801", Stats{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			var got Stats
+			err := Unmarshal(data, &got)
+			if (err != nil) != tt.error {
+				t.Fatalf("expecting error %v, got %v", tt.error, err)
+			}
+			if !tt.error && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expecting %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package scenarios
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+const (
+	// risk levels of attack scenarios, lowest first
+	RISK_LOW      = "low"
+	RISK_MEDIUM   = "medium"
+	RISK_HIGH     = "high"
+	RISK_CRITICAL = "critical"
+)
+
+// RiskLevels lists risk levels of attack scenarios, lowest first
+var RiskLevels = []string{RISK_LOW, RISK_MEDIUM, RISK_HIGH, RISK_CRITICAL}
+
+// RiskRank returns position of a risk level, -1 if unknown
+func RiskRank(risk string) int {
+	for i, r := range RiskLevels {
+		if strings.EqualFold(r, risk) {
+			return i
+		}
+	}
+	return -1
+}
+
+// LatestAttackGraph returns the most recently built attack graph of an app
+func (s *Scenario) LatestAttackGraph(aid string) (graph.AppData, bool) {
+	var (
+		latest graph.AppData
+		found  bool
+	)
+	apps, err := graph.Apps(s.db).List()
+	if err != nil {
+		log.Printf("skipped records: %v\n", err)
+	}
+	for _, a := range apps {
+		if a.Type != APP_TYPE_ATTACK_GRAPH || a.Attributes[ATTR_SOURCE_APP] != aid {
+			continue
+		}
+		if !found || graph.ParseTime(a.Created).After(graph.ParseTime(latest.Created)) {
+			latest, found = a, true
+		}
+	}
+	return latest, found
+}
+
+// Findings returns all findings of a given attack graph
+func (s *Scenario) Findings(agid string) []Finding {
+	findings := []Finding{}
+	for _, e := range graph.AppEntities(s.db, agid) {
+		f := Finding{
+			ID:     graph.TrimAppPrefix(agid, e.ID),
+			Title:  e.Name,
+			Entity: e.Attributes[ATTR_ENTITY],
+			Risk:   strings.ToLower(e.Attributes["Risk"]),
+			Technique: Technique{
+				TacticID:    e.Attributes[ATTR_MITRE_TACTIC_ID],
+				Tactic:      e.Attributes[ATTR_MITRE_TACTIC],
+				TechniqueID: e.Attributes[ATTR_MITRE_TECHNIQUE_ID],
+				Technique:   e.Attributes[ATTR_MITRE_TECHNIQUE],
+			},
+			Remediation: Remediation(e.Name),
+			Attributes:  e.Attributes,
+		}
+		if f.Risk == NONE_STR {
+			f.Risk = RISK_LOW
+		}
+		findings = append(findings, f)
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].ID < findings[j].ID })
+	return findings
+}
+
+// RuleID returns a stable rule id of an attack scenario title, e.g.
+// "initial-access-via-valid-accounts"
+func RuleID(title string) string {
+	id := strings.Builder{}
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			id.WriteRune(r)
+			dash = false
+		} else if !dash && id.Len() > 0 {
+			id.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(id.String(), "-")
+}
+
+// LatestFindings returns findings of the most recent attack graph of an app
+func (s *Scenario) LatestFindings(aid string) []Finding {
+	ag, ok := s.LatestAttackGraph(aid)
+	if !ok {
+		return []Finding{}
+	}
+	return s.Findings(ag.ID)
+}
+
+// EntityRiskLevels returns the highest risk level of findings of each entity
+// of an app, from its most recent attack graph
+func (s *Scenario) EntityRiskLevels(aid string) map[string]string {
+	levels := map[string]string{}
+	for _, f := range s.LatestFindings(aid) {
+		if cur, ok := levels[f.Entity]; !ok || RiskRank(f.Risk) > RiskRank(cur) {
+			levels[f.Entity] = f.Risk
+		}
+	}
+	return levels
+}
+
+// GetFindings is GET handler to retrieve findings of the latest attack graph of
+// an app, at or above an optional minimum risk
+func (s *Scenario) GetFindings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	aid := vars["id"]
+	if _, err := s.db.Get(graph.DB_TABLE_GRAPH, aid); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	minRisk := r.URL.Query().Get("risk")
+	if minRisk != "" && RiskRank(minRisk) < 0 {
+		http.Error(w, "invalid risk "+minRisk, http.StatusBadRequest)
+		return
+	}
+
+	list := FindingList{App: aid, Findings: []Finding{}}
+	if ag, ok := s.LatestAttackGraph(aid); ok {
+		list.AttackGraph = ag.ID
+		for _, f := range s.Findings(ag.ID) {
+			if RiskRank(f.Risk) >= RiskRank(minRisk) {
+				list.Findings = append(list.Findings, f)
+			}
+		}
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
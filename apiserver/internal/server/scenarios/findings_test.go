@@ -0,0 +1,83 @@
+package scenarios
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+func TestLatestAttackGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		created []string
+		latest  string
+	}{
+		{"fractional seconds", []string{"2024-11-01T10:00:00Z", "2024-11-01T10:00:00.5Z"}, "ag1"},
+		{"whole seconds", []string{"2024-11-01T10:00:00.999Z", "2024-11-01T10:00:00Z"}, "ag0"},
+		{"time zones", []string{"2024-11-01T10:00:00Z", "2024-11-01T11:30:00+02:00"}, "ag0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES)
+			for i, created := range tt.created {
+				ag := graph.AppData{
+					ID:         "ag" + string(rune('0'+i)),
+					Type:       APP_TYPE_ATTACK_GRAPH,
+					Created:    created,
+					Attributes: map[string]interface{}{ATTR_SOURCE_APP: "a1"},
+				}
+				graph.Apps(d).Put(ag.ID, ag)
+			}
+			ag, ok := NewScenario(d).LatestAttackGraph("a1")
+			if !ok || ag.ID != tt.latest {
+				t.Fatalf("expecting %v, got %v", tt.latest, ag.ID)
+			}
+		})
+	}
+}
+
+func TestFindingsPerEntity(t *testing.T) {
+	d := db.NewMemoryDb(graph.DB_TABLE_GRAPH, graph.DB_TABLE_ENTITIES, graph.DB_TABLE_ASSOCS)
+	if err := graph.CreateIndexes(d); err != nil {
+		t.Fatal(err)
+	}
+	graph.Apps(d).Put("a1", graph.AppData{ID: "a1", Name: "a1"})
+	users := map[string]map[string]string{
+		"u1": {"ConsoleAccess": "true", "AccessKeys": "none"},
+		"u2": {"MFAEnabledTime": "2024-01-01"},
+		"u3": {},
+	}
+	for uid, attrs := range users {
+		key := graph.GetEntityKey("a1", uid)
+		graph.Entities(d).Put(key, graph.Entity{ID: key, Name: uid, Kind: "user", Attributes: attrs})
+	}
+
+	s := NewScenario(d)
+	if _, err := s.BuildAppScenarios("a1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// both users lacking MFA are found, and valid account checks of u1 keep
+	// their highest risk
+	want := map[string]string{
+		"Credential Access Access via Brute Force (u1)": RISK_CRITICAL,
+		"Credential Access Access via Brute Force (u3)": RISK_CRITICAL,
+		"Initial Access via Valid Accounts (u1)":        RISK_MEDIUM,
+	}
+	got := map[string]string{}
+	for _, f := range s.LatestFindings("a1") {
+		got[f.ID] = f.Risk
+		if f.ID != f.Title+" ("+f.Entity+")" {
+			t.Errorf("expecting finding id of its title and entity, got %+v", f)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expecting findings %v, got %v", want, got)
+	}
+	levels := s.EntityRiskLevels("a1")
+	if !reflect.DeepEqual(levels, map[string]string{"u1": RISK_CRITICAL, "u3": RISK_CRITICAL}) {
+		t.Fatalf("expecting risk levels of u1 and u3, got %v", levels)
+	}
+}
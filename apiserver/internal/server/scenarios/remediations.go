@@ -0,0 +1,31 @@
+package scenarios
+
+// default remediation of scenarios without a specific one
+const DEFAULT_REMEDIATION = "Review the affected entity and apply least privilege."
+
+// ScenarioRemediations maps attack scenario titles onto remediation guidance
+var ScenarioRemediations = map[string]string{
+	"Credential Access Access via Brute Force":                                            "Enforce MFA on the user and an account lockout password policy.",
+	"Initial Access via Valid Accounts":                                                   "Remove unused console access, SSH public keys and access keys, and rotate the ones in use.",
+	"Defense Evasion via User activities Collection":                                      "Enable CloudTrail logging of the user activities across all regions.",
+	"Persistence via Resource Hijacking":                                                  "Set a permissions boundary limiting the maximum permissions of the user.",
+	"Credential Compromise and Lateral Movement due to PermissionsBoundaryUsageCount":     "Attach the permissions boundary policy to the roles and users it is meant for.",
+	"Credential Compromise and Lateral Movement due to PermissionsBoundary being not set": "Set a permissions boundary on the role.",
+	"Credential Compromise and Lateral Movement due to MaxSessionDuration is not set":     "Set a short maximum session duration on the role.",
+	"Initial Access with External Remote Services":                                        "Close ports open to 0.0.0.0/0 in security groups, or restrict them to known CIDRs.",
+	"Remote System Discovery network scanning or querying public IP Address":              "Remove the public IP address, or front the instance with a load balancer.",
+	"Remote System Discovery network scanning or querying public DNS records":             "Remove the public DNS name, or front the instance with a load balancer.",
+	"Privilege Escalation Exfiltration of Exposed Sensitive Information":                  "Block public access and scope down the bucket policy.",
+	"Initial Access via Exploit Public-Facing Application":                                "Patch exploitable CVEs to their fixed versions and remove internet exposure until patched.",
+	"Privilege Escalation via Exploitation of Vulnerable Software":                        "Patch exploitable CVEs to their fixed versions.",
+	"Persistence via Dormant Admin Credentials":                                           "Remove or disable the unused admin credentials.",
+	"Initial Access via Console Login without MFA Observed":                               "Enforce MFA for console sign-in and review the observed sessions.",
+}
+
+// Remediation returns remediation guidance of an attack scenario
+func Remediation(title string) string {
+	if r, ok := ScenarioRemediations[title]; ok {
+		return r
+	}
+	return DEFAULT_REMEDIATION
+}
@@ -7,13 +7,26 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/zetafence/zentaris/apiserver/internal/server/activity"
 	"github.com/zetafence/zentaris/apiserver/internal/server/db"
 	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+	"github.com/zetafence/zentaris/apiserver/internal/server/vulns"
 )
 
 const (
 	NONE_STR = ""
+
+	APP_TYPE_ATTACK_GRAPH = "attackGraph"
+
+	// attack graph attributes linking back to the scanned app and entity
+	ATTR_SOURCE_APP = graph.ATTR_SOURCE_APP
+	ATTR_ENTITY     = "Entity"
+
+	// thresholds of CVEs considered exploitable
+	EXPLOITABLE_EPSS = 0.5
+	EXPLOITABLE_CVSS = 9.0
 )
 
 // NewScenario returns a new graph element
@@ -31,15 +44,19 @@ func getRandomId(num int) string {
 	return fmt.Sprintf("%x", bytes)
 }
 
-// createAttackGraph creates an attack graph, stores in DB and returns id
-func (s *Scenario) createAttackGraph() string {
+// createAttackGraph creates an attack graph for a given app, stores in DB and returns id
+func (s *Scenario) createAttackGraph(app graph.AppData) string {
 	appData := graph.AppData{
-		ID:   fmt.Sprintf("attackGraph-%v", getRandomId(8)),
-		Type: "attackGraph",
+		ID:      fmt.Sprintf("attackGraph-%v", getRandomId(8)),
+		Type:    APP_TYPE_ATTACK_GRAPH,
+		Created: time.Now().UTC().Format(time.RFC3339Nano),
+		Attributes: map[string]interface{}{
+			ATTR_SOURCE_APP: app.ID,
+		},
 	}
 	appData.Name = appData.ID
 	appData.Description = appData.ID
-	s.db.Add(graph.DB_TABLE_GRAPH, appData.ID, appData)
+	graph.Apps(s.db).Put(appData.ID, appData)
 	log.Printf("new attack graph app %v\n", appData.ID)
 	return appData.ID
 }
@@ -52,11 +69,33 @@ func (s *Scenario) createAttackGraphEntity(aid, eid string, attributes map[strin
 		Description: eid,
 		Kind:        "attackGraph-entity",
 	}
-	entity.Attributes = attributes
 
+	// callers keep mutating their attributes, so store a copy
+	entity.Attributes = make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		entity.Attributes[k] = v
+	}
+
+	// MITRE ATT&CK tactic and technique of the scenario
+	if t, ok := ScenarioTechniques[eid]; ok {
+		entity.Attributes[ATTR_MITRE_TACTIC_ID] = t.TacticID
+		entity.Attributes[ATTR_MITRE_TACTIC] = t.Tactic
+		entity.Attributes[ATTR_MITRE_TECHNIQUE_ID] = t.TechniqueID
+		entity.Attributes[ATTR_MITRE_TECHNIQUE] = t.Technique
+	}
+
+	// same scenario on different entities are distinct vertices
+	if src, ok := attributes[ATTR_ENTITY]; ok {
+		entity.ID = fmt.Sprintf("%s (%s)", eid, src)
+	}
+
+	// checks of a scenario on an entity keep their highest risk
 	ekey := graph.GetEntityKey(aid, entity.ID)
+	if old, err := graph.Entities(s.db).Get(ekey); err == nil && RiskRank(old.Attributes["Risk"]) > RiskRank(entity.Attributes["Risk"]) {
+		entity.Attributes["Risk"] = old.Attributes["Risk"]
+	}
 	entity.ID = ekey
-	s.db.Add(graph.DB_TABLE_ENTITIES, ekey, entity)
+	graph.Entities(s.db).Put(ekey, entity)
 	log.Printf("new attack graph entity %v\n", eid)
 	return eid
 }
@@ -65,30 +104,37 @@ func (s *Scenario) createAttackGraphEntity(aid, eid string, attributes map[strin
 func (s *Scenario) createUserBruteForce(aid string, entity graph.Entity) error {
 	attrs := map[string]string{
 		"UserBruteForceType": entity.Kind,
+		ATTR_ENTITY:          entity.ID,
 		"App":                aid,
 	}
 	if _, ok := entity.Attributes["MFAEnabledTime"]; !ok {
 		attrs["MFAEnabled"] = "false"
+		attrs["Risk"] = "critical"
 		s.createAttackGraphEntity(aid, "Credential Access Access via Brute Force", attrs)
 	}
 	if c, ok := entity.Attributes["ConsoleAccess"]; ok {
 		attrs["ConsoleAccess"] = c
+		attrs["Risk"] = "medium"
 		s.createAttackGraphEntity(aid, "Initial Access via Valid Accounts", attrs)
 	}
 	if c, ok := entity.Attributes["SSHPublicKeys"]; ok {
 		attrs["SSHPublicKeys"] = c
+		attrs["Risk"] = "medium"
 		s.createAttackGraphEntity(aid, "Initial Access via Valid Accounts", attrs)
 	}
 	if c, ok := entity.Attributes["Monitored"]; ok && c == "none" {
 		attrs["Monitored"] = c
+		attrs["Risk"] = "critical"
 		s.createAttackGraphEntity(aid, "Defense Evasion via User activities Collection", attrs)
 	}
 	if c, ok := entity.Attributes["PermissionsBoundary"]; ok && c == "none" {
 		attrs["PermissionsBoundary"] = c
+		attrs["Risk"] = "high"
 		s.createAttackGraphEntity(aid, "Persistence via Resource Hijacking", attrs)
 	}
 	if c, ok := entity.Attributes["AccessKeys"]; ok && c == "none" {
 		attrs["AccessKeys"] = c
+		attrs["Risk"] = "low"
 		s.createAttackGraphEntity(aid, "Initial Access via Valid Accounts", attrs)
 	}
 	return nil
@@ -98,6 +144,7 @@ func (s *Scenario) createUserBruteForce(aid string, entity graph.Entity) error {
 func (s *Scenario) createPolicyCompromise(aid string, entity graph.Entity) error {
 	attrs := map[string]string{
 		"PolicyCompromiseType": entity.Kind,
+		ATTR_ENTITY:            entity.ID,
 		"App":                  aid,
 	}
 	if c, ok := entity.Attributes["PermissionsBoundaryUsageCount"]; ok && c == "0" {
@@ -112,6 +159,7 @@ func (s *Scenario) createPolicyCompromise(aid string, entity graph.Entity) error
 func (s *Scenario) createUnauthorizedAccess(aid string, entity graph.Entity) error {
 	attrs := map[string]string{
 		"UnauthorizedAccessType": entity.Kind,
+		ATTR_ENTITY:              entity.ID,
 		"App":                    aid,
 	}
 	c, ok := entity.Attributes["PermissionsBoundary"]
@@ -133,6 +181,7 @@ func (s *Scenario) createUnauthorizedAccess(aid string, entity graph.Entity) err
 func (s *Scenario) createPubliclyAccessibleResources(aid string, entity graph.Entity) error {
 	attrs := map[string]string{
 		"PubliclyAccessibleType": entity.Kind,
+		ATTR_ENTITY:              entity.ID,
 		"App":                    aid,
 	}
 	if c, ok := entity.Attributes["OpenPorts"]; ok && strings.Contains(c, "0.0.0.0") {
@@ -157,6 +206,7 @@ func (s *Scenario) createPubliclyAccessibleResources(aid string, entity graph.En
 func (s *Scenario) createExfiltration(aid string, entity graph.Entity) error {
 	attrs := map[string]string{
 		"ExFiltrationType": entity.Kind,
+		ATTR_ENTITY:        entity.ID,
 		"App":              aid,
 	}
 	if c, ok := entity.Attributes["OverlyPermissive"]; ok && c == "none" {
@@ -167,32 +217,124 @@ func (s *Scenario) createExfiltration(aid string, entity graph.Entity) error {
 	return nil
 }
 
-const APP_TYPE_ATTACK_GRAPH = "attackGraph"
-
-// createAttackScenarios creates attack scenarios from the given graph
-func (s *Scenario) createAttackScenarios(app graph.AppData) error {
-	if app.Type == APP_TYPE_ATTACK_GRAPH {
-		return nil
+// createVulnerabilityExploitation creates attack entities for exploitable
+// CVEs of instances and containers
+func (s *Scenario) createVulnerabilityExploitation(aid string, entity graph.Entity) error {
+	attrs := map[string]string{
+		"VulnerabilityType": entity.Kind,
+		ATTR_ENTITY:         entity.ID,
+		"App":               aid,
 	}
-
-	// traverse over app entities
-	appId := s.createAttackGraph()
-	for _, entity := range s.db.List(graph.DB_TABLE_ENTITIES) {
-		e, ok := entity.(graph.Entity)
-		if !ok {
+	exploitable := []string{}
+	for _, v := range vulns.EntityVulnerabilities(entity) {
+		switch {
+		case v.KnownExploited:
+			attrs["Risk"] = "critical"
+		case v.EPSS >= EXPLOITABLE_EPSS || v.CVSSScore >= EXPLOITABLE_CVSS:
+			if attrs["Risk"] != "critical" {
+				attrs["Risk"] = "high"
+			}
+		default:
 			continue
 		}
+		exploitable = append(exploitable, v.ID)
+	}
+	if len(exploitable) == 0 {
+		return nil
+	}
+	attrs["CVE"] = strings.Join(exploitable, ",")
+	_, ip := entity.Attributes["PublicIpAddress"]
+	_, dns := entity.Attributes["PublicDnsName"]
+	if ip || dns {
+		s.createAttackGraphEntity(aid, "Initial Access via Exploit Public-Facing Application", attrs)
+	} else {
+		s.createAttackGraphEntity(aid, "Privilege Escalation via Exploitation of Vulnerable Software", attrs)
+	}
+	return nil
+}
 
-		// filter out entities other than this app
-		if !strings.HasPrefix(e.ID, app.ID) {
+// isAdmin returns true if a principal holds administrator permissions, either
+// through its hyperedges or attached policies
+func isAdmin(h *graph.Hypergraph, entity graph.Entity) bool {
+	if strings.Contains(entity.Attributes["AttachedPolicies"], "AdministratorAccess") {
+		return true
+	}
+	for _, a := range h.Assocs {
+		member := false
+		for _, m := range a.Members() {
+			member = member || m == entity.ID
+		}
+		if !member {
 			continue
 		}
+		for _, p := range a.Permissions() {
+			lp := strings.ToLower(p)
+			if lp == "*" || lp == "*:*" || strings.Contains(lp, "administratoraccess") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// createObservedActivity creates attack entities from CloudTrail activity
+// observed on principals
+func (s *Scenario) createObservedActivity(aid string, h *graph.Hypergraph, entity graph.Entity) error {
+	attrs := map[string]string{
+		"ObservedActivityType": entity.Kind,
+		ATTR_ENTITY:            entity.ID,
+		"App":                  aid,
+	}
+	if activity.IsDormant(entity, time.Now()) && isAdmin(h, entity) {
+		attrs[activity.ATTR_LAST_USED] = entity.Attributes[activity.ATTR_LAST_USED]
+		attrs["Risk"] = "high"
+		s.createAttackGraphEntity(aid, "Persistence via Dormant Admin Credentials", attrs)
+	}
+	if c, ok := entity.Attributes[activity.ATTR_CONSOLE_LOGINS_WITHOUT_MFA]; ok && c != "0" {
+		attrs[activity.ATTR_CONSOLE_LOGINS_WITHOUT_MFA] = c
+		attrs["Risk"] = "critical"
+		s.createAttackGraphEntity(aid, "Initial Access via Console Login without MFA Observed", attrs)
+	}
+	return nil
+}
+
+// createAttackScenarios creates attack scenarios from the given graph and
+// returns the attack graph id, the attack graph being stored all at once
+func (s *Scenario) createAttackScenarios(app graph.AppData) (string, error) {
+	if app.Type == APP_TYPE_ATTACK_GRAPH {
+		return NONE_STR, fmt.Errorf("app %v is an attack graph", app.ID)
+	}
+
+	var appId string
+	err := db.RunTx(s.db, func(tx db.Tx) error {
+		appId = NewScenario(tx).buildAttackScenarios(app)
+		return nil
+	})
+	if err != nil {
+		return NONE_STR, err
+	}
+	return appId, nil
+}
+
+// buildAttackScenarios builds attack scenarios of an app into the DB and
+// returns the attack graph id
+func (s *Scenario) buildAttackScenarios(app graph.AppData) string {
+	// traverse over app entities
+	h := graph.LoadHypergraph(s.db, app.ID)
+	appId := s.createAttackGraph(app)
+	for _, e := range graph.AppEntities(s.db, app.ID) {
+		e.ID = graph.TrimAppPrefix(app.ID, e.ID)
 
 		// brute force scenarios
 		if strings.Contains(e.Kind, "user") {
 			s.createUserBruteForce(appId, e)
 		}
 
+		// observed activity scenarios
+		if strings.Contains(e.Kind, "user") || strings.Contains(e.Kind, "role") {
+			s.createObservedActivity(appId, h, e)
+		}
+
 		// policy compromise scenarios
 		if strings.Contains(e.Kind, "policy") {
 			s.createPolicyCompromise(appId, e)
@@ -208,31 +350,48 @@ func (s *Scenario) createAttackScenarios(app graph.AppData) error {
 			s.createPubliclyAccessibleResources(appId, e)
 		}
 
+		// exploitable vulnerability scenarios
+		if vulns.IsScannable(e) {
+			s.createVulnerabilityExploitation(appId, e)
+		}
+
 		// s3 scenarios
 		if strings.Contains(e.Kind, "s3") {
 			s.createExfiltration(appId, e)
 		}
 	}
-	return nil
+	return appId
+}
+
+// BuildAppScenarios builds attack scenarios of a single app and returns the
+// attack graph id
+func (s *Scenario) BuildAppScenarios(aid string) (string, error) {
+	a, err := graph.Apps(s.db).Get(aid)
+	if err != nil {
+		return NONE_STR, err
+	}
+	return s.createAttackScenarios(a)
 }
 
 // BuildScenarios builds several attack scenarios by traversing graph entities
 func (s *Scenario) BuildScenarios() error {
-	// collect graphs from the DB into a slice
-	for _, app := range s.db.List(graph.DB_TABLE_GRAPH) {
-		if a, ok := app.(graph.AppData); ok {
-			// attack scenarios go here
-			s.createAttackScenarios(a)
-		}
+	// collect graphs from the DB into a slice, apps failing to decode being
+	// reported once the others are built
+	apps, err := graph.Apps(s.db).List()
+	for _, a := range apps {
+		// attack scenarios go here
+		s.createAttackScenarios(a)
 	}
-	return nil
+	return err
 }
 
 // BuildAttackScenarios is POST handler to scan and build graph attack scenarios
 func (s *Scenario) BuildAttackScenarios(w http.ResponseWriter, r *http.Request) {
 	log.Printf("building attack scenarios\n")
 
-	s.BuildScenarios()
+	if err := s.BuildScenarios(); err != nil {
+		log.Printf("unable to build all attack scenarios: %v\n", err)
+	}
 
 	// Return the slice as JSON
 	w.Header().Set("Content-Type", "application/json")
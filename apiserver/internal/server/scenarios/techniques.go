@@ -0,0 +1,83 @@
+package scenarios
+
+const (
+	// attack graph attributes carrying MITRE ATT&CK identifiers
+	ATTR_MITRE_TACTIC_ID    = "MitreTacticID"
+	ATTR_MITRE_TACTIC       = "MitreTactic"
+	ATTR_MITRE_TECHNIQUE_ID = "MitreTechniqueID"
+	ATTR_MITRE_TECHNIQUE    = "MitreTechnique"
+)
+
+// Technique maps an attack scenario onto a MITRE ATT&CK tactic and
+// technique or sub-technique
+type Technique struct {
+	TacticID    string `json:"tacticId"`
+	Tactic      string `json:"tactic"`
+	TechniqueID string `json:"techniqueId"`
+	Technique   string `json:"technique"`
+}
+
+// ScenarioTechniques maps attack scenario titles onto ATT&CK Enterprise
+// (Cloud) tactics and techniques
+var ScenarioTechniques = map[string]Technique{
+	"Credential Access Access via Brute Force": {
+		TacticID: "TA0006", Tactic: "Credential Access",
+		TechniqueID: "T1110", Technique: "Brute Force",
+	},
+	"Initial Access via Valid Accounts": {
+		TacticID: "TA0001", Tactic: "Initial Access",
+		TechniqueID: "T1078.004", Technique: "Valid Accounts: Cloud Accounts",
+	},
+	"Defense Evasion via User activities Collection": {
+		TacticID: "TA0005", Tactic: "Defense Evasion",
+		TechniqueID: "T1562.008", Technique: "Impair Defenses: Disable or Modify Cloud Logs",
+	},
+	"Persistence via Resource Hijacking": {
+		TacticID: "TA0003", Tactic: "Persistence",
+		TechniqueID: "T1098.003", Technique: "Account Manipulation: Additional Cloud Roles",
+	},
+	"Credential Compromise and Lateral Movement due to PermissionsBoundaryUsageCount": {
+		TacticID: "TA0008", Tactic: "Lateral Movement",
+		TechniqueID: "T1550.001", Technique: "Use Alternate Authentication Material: Application Access Token",
+	},
+	"Credential Compromise and Lateral Movement due to PermissionsBoundary being not set": {
+		TacticID: "TA0008", Tactic: "Lateral Movement",
+		TechniqueID: "T1550.001", Technique: "Use Alternate Authentication Material: Application Access Token",
+	},
+	"Credential Compromise and Lateral Movement due to MaxSessionDuration is not set": {
+		TacticID: "TA0008", Tactic: "Lateral Movement",
+		TechniqueID: "T1550.001", Technique: "Use Alternate Authentication Material: Application Access Token",
+	},
+	"Initial Access with External Remote Services": {
+		TacticID: "TA0001", Tactic: "Initial Access",
+		TechniqueID: "T1133", Technique: "External Remote Services",
+	},
+	"Remote System Discovery network scanning or querying public IP Address": {
+		TacticID: "TA0007", Tactic: "Discovery",
+		TechniqueID: "T1018", Technique: "Remote System Discovery",
+	},
+	"Remote System Discovery network scanning or querying public DNS records": {
+		TacticID: "TA0007", Tactic: "Discovery",
+		TechniqueID: "T1018", Technique: "Remote System Discovery",
+	},
+	"Privilege Escalation Exfiltration of Exposed Sensitive Information": {
+		TacticID: "TA0010", Tactic: "Exfiltration",
+		TechniqueID: "T1537", Technique: "Transfer Data to Cloud Account",
+	},
+	"Initial Access via Exploit Public-Facing Application": {
+		TacticID: "TA0001", Tactic: "Initial Access",
+		TechniqueID: "T1190", Technique: "Exploit Public-Facing Application",
+	},
+	"Privilege Escalation via Exploitation of Vulnerable Software": {
+		TacticID: "TA0004", Tactic: "Privilege Escalation",
+		TechniqueID: "T1068", Technique: "Exploitation for Privilege Escalation",
+	},
+	"Persistence via Dormant Admin Credentials": {
+		TacticID: "TA0003", Tactic: "Persistence",
+		TechniqueID: "T1078.004", Technique: "Valid Accounts: Cloud Accounts",
+	},
+	"Initial Access via Console Login without MFA Observed": {
+		TacticID: "TA0001", Tactic: "Initial Access",
+		TechniqueID: "T1078.004", Technique: "Valid Accounts: Cloud Accounts",
+	},
+}
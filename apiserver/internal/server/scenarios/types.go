@@ -8,3 +8,21 @@ import (
 type Scenario struct {
 	db db.Db
 }
+
+// Finding is an attack graph vertex detected on an app entity
+type Finding struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Entity string `json:"entity"`
+	Risk   string `json:"risk"`
+	Technique
+	Remediation string            `json:"remediation"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+// list of findings of the latest attack graph of an app
+type FindingList struct {
+	App         string    `json:"app"`
+	AttackGraph string    `json:"attackGraph"`
+	Findings    []Finding `json:"findings"`
+}
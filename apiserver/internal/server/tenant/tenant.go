@@ -0,0 +1,234 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/auth"
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+const (
+	// org of system keys not giving one, and group of requests not giving one
+	DEFAULT_ORG   = "noorg"
+	DEFAULT_GROUP = "default"
+
+	// request headers selecting the tenant, unless given in the path, e.g.
+	// /v1/org/{org}/group/{group}/apps, or as org and group query parameters
+	HEADER_ORG   = "X-Zentaris-Org"
+	HEADER_GROUP = "X-Zentaris-Group"
+
+	// path prefix of tenant endpoints
+	TENANT_PATH = "/v1/org/{org}/group/{group}/"
+)
+
+// context key of the tenant of a request
+type contextKey struct{}
+
+// NewTenants returns tenants whose DBs are created by a given function, and
+// whose REST handlers are registered by another
+func NewTenants(newDb func(org, group string) db.Db, register func(t *Tenant) http.Handler) *Tenants {
+	return &Tenants{
+		tenants:  map[string]*Tenant{},
+		newDb:    newDb,
+		register: register,
+	}
+}
+
+// getTenantKey returns the key of a group of an org
+func getTenantKey(org, group string) string {
+	return org + "/" + group
+}
+
+// Get returns a tenant, creating it if asked to
+func (ts *Tenants) Get(org, group string, create bool) (*Tenant, bool) {
+	key := getTenantKey(org, group)
+	ts.mutex.RLock()
+	t, ok := ts.tenants[key]
+	ts.mutex.RUnlock()
+	if ok || !create {
+		return t, ok
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if t, ok := ts.tenants[key]; ok {
+		return t, true
+	}
+	t = &Tenant{
+		Org:     org,
+		Group:   group,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Db:      ts.newDb(org, group),
+	}
+	t.handler = ts.register(t)
+	ts.tenants[key] = t
+	log.Printf("new tenant %v\n", key)
+	return t, true
+}
+
+// List returns tenants a key may access, sorted by org and group
+func (ts *Tenants) List(k auth.APIKey) []*Tenant {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+
+	ret := []*Tenant{}
+	for _, t := range ts.tenants {
+		if k.CanAccess(t.Org, t.Group) {
+			ret = append(ret, t)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return getTenantKey(ret[i].Org, ret[i].Group) < getTenantKey(ret[j].Org, ret[j].Group)
+	})
+	return ret
+}
+
+// Requested returns org and group a request is for, from its path, query
+// parameters, headers, or else the org of its key and the default group
+func Requested(r *http.Request, k auth.APIKey) (string, string) {
+	vars := mux.Vars(r)
+	if vars["org"] != "" && vars["group"] != "" {
+		return vars["org"], vars["group"]
+	}
+	query := r.URL.Query()
+	org := query.Get("org")
+	if org == "" {
+		org = r.Header.Get(HEADER_ORG)
+	}
+	if org == "" {
+		org = k.Org
+		if k.IsSystem() {
+			org = DEFAULT_ORG
+		}
+	}
+	group := query.Get("group")
+	if group == "" {
+		group = r.Header.Get(HEADER_GROUP)
+	}
+	if group == "" {
+		group = DEFAULT_GROUP
+		if len(k.Groups) > 0 && !k.IsSystem() {
+			group = k.Groups[0]
+		}
+	}
+	return org, group
+}
+
+// FromContext returns the tenant of a request
+func FromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(contextKey{}).(*Tenant)
+	return t, ok
+}
+
+// canCreate returns true if a key may create a tenant on first use, i.e. the
+// default group of the org of the key, or any tenant granted to an admin key
+func canCreate(k auth.APIKey, org, group string) bool {
+	if auth.Allows(k.Scope, auth.SCOPE_ADMIN) {
+		return true
+	}
+	own := k.Org
+	if k.IsSystem() {
+		own = DEFAULT_ORG
+	}
+	return org == own && group == DEFAULT_GROUP
+}
+
+// ServeHTTP dispatches a request to the handlers of its tenant, once the
+// request key is granted the tenant. The default group of the org of a key
+// always exists, other tenants are created by admins, so that other keys
+// cannot create tenants by naming them.
+func (ts *Tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k, ok := auth.FromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	org, group := Requested(r, k)
+	if !auth.ValidName(org) || !auth.ValidName(group) {
+		http.Error(w, "Invalid org or group", http.StatusBadRequest)
+		return
+	}
+	if !k.CanAccess(org, group) {
+		http.Error(w, "Forbidden, org or group not granted", http.StatusForbidden)
+		return
+	}
+
+	t, ok := ts.Get(org, group, canCreate(k, org, group))
+	if !ok && auth.ReadOnly(r) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	if !ok {
+		http.Error(w, "Forbidden, tenant not created by an admin", http.StatusForbidden)
+		return
+	}
+
+	// tenant handlers serve unprefixed paths
+	r = r.Clone(context.WithValue(r.Context(), contextKey{}, t))
+	if _, ok := mux.Vars(r)["org"]; ok {
+		prefix := fmt.Sprintf("/v1/org/%s/group/%s", org, group)
+		r.URL.Path = "/v1" + strings.TrimPrefix(r.URL.Path, prefix)
+		r.URL.RawPath = ""
+	}
+	t.handler.ServeHTTP(w, r)
+}
+
+// CreateTenant is POST handler for admins to create a group of an org
+func (ts *Tenants) CreateTenant(w http.ResponseWriter, r *http.Request) {
+	k, _ := auth.FromContext(r.Context())
+	var req TenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Org == "" && !k.IsSystem() {
+		req.Org = k.Org
+	}
+	if !auth.ValidName(req.Org) || !auth.ValidName(req.Group) {
+		http.Error(w, "Invalid org or group", http.StatusBadRequest)
+		return
+	}
+	if !auth.Allows(k.Scope, auth.SCOPE_ADMIN) || !k.CanAccess(req.Org, req.Group) {
+		http.Error(w, "Forbidden, org or group not granted", http.StatusForbidden)
+		return
+	}
+	t, _ := ts.Get(req.Org, req.Group, true)
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// GetTenants is GET handler to list tenants granted to the request key
+func (ts *Tenants) GetTenants(w http.ResponseWriter, r *http.Request) {
+	k, _ := auth.FromContext(r.Context())
+	list := TenantList{Tenants: []TenantInfo{}}
+	for _, t := range ts.List(k) {
+		apps := 0
+		all, err := graph.Apps(t.Db).List()
+		if err != nil {
+			log.Printf("tenant %v/%v: skipped records: %v\n", t.Org, t.Group, err)
+		}
+		for _, a := range all {
+			if a.Type != graph.APP_TYPE_ATTACK_GRAPH {
+				apps++
+			}
+		}
+		list.Tenants = append(list.Tenants, TenantInfo{Tenant: t, Apps: apps})
+	}
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,48 @@
+package tenant
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Tenants holds the data of each org and group in its own DB, so that no
+// request of a tenant reaches data of another one
+type Tenants struct {
+	mutex   sync.RWMutex
+	tenants map[string]*Tenant
+
+	// creates the DB of a tenant
+	newDb func(org, group string) db.Db
+
+	// registers REST handlers of a tenant on its DB
+	register func(t *Tenant) http.Handler
+}
+
+// Tenant is a group of an org, with its apps, entities, assocs and attack graphs
+type Tenant struct {
+	Org     string `json:"org"`
+	Group   string `json:"group"`
+	Created string `json:"created"`
+	Db      db.Db  `json:"-"`
+
+	handler http.Handler
+}
+
+// TenantRequest is the request to create a group of an org
+type TenantRequest struct {
+	Org   string `json:"org"`
+	Group string `json:"group"`
+}
+
+// TenantInfo is a tenant with the number of its apps
+type TenantInfo struct {
+	*Tenant
+	Apps int `json:"apps"`
+}
+
+// list of tenants
+type TenantList struct {
+	Tenants []TenantInfo `json:"tenants"`
+}
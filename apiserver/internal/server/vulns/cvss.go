@@ -0,0 +1,112 @@
+package vulns
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CVSS v3.x base metric weights
+var cvssWeights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// privileges required weights depend on scope
+var cvssPrivileges = map[string]map[string]float64{
+	"U": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"C": {"N": 0.85, "L": 0.68, "H": 0.5},
+}
+
+// roundUp rounds up to one decimal as defined by CVSS v3.1
+func roundUp(x float64) float64 {
+	i := int64(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}
+
+// CVSSBaseScore computes the base score of a CVSS v3.0 or v3.1 vector, e.g.
+// CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H
+func CVSSBaseScore(vector string) (float64, error) {
+	parts := strings.Split(strings.TrimSpace(vector), "/")
+	if len(parts) < 9 || !strings.HasPrefix(parts[0], "CVSS:3") {
+		return 0, fmt.Errorf("unsupported CVSS vector %v", vector)
+	}
+	metrics := map[string]string{}
+	for _, p := range parts[1:] {
+		k, v, ok := strings.Cut(p, ":")
+		if !ok {
+			return 0, fmt.Errorf("invalid CVSS metric %v", p)
+		}
+		metrics[k] = v
+	}
+
+	value := func(metric string) (float64, error) {
+		w, ok := cvssWeights[metric][metrics[metric]]
+		if !ok {
+			return 0, fmt.Errorf("invalid CVSS metric %v:%v", metric, metrics[metric])
+		}
+		return w, nil
+	}
+	scope := metrics["S"]
+	pr, ok := cvssPrivileges[scope][metrics["PR"]]
+	if !ok {
+		return 0, fmt.Errorf("invalid CVSS scope or privileges %v/%v", scope, metrics["PR"])
+	}
+	w := map[string]float64{}
+	for _, m := range []string{"AV", "AC", "UI", "C", "I", "A"} {
+		v, err := value(m)
+		if err != nil {
+			return 0, err
+		}
+		w[m] = v
+	}
+
+	iss := 1 - (1-w["C"])*(1-w["I"])*(1-w["A"])
+	impact := 6.42 * iss
+	if scope == "C" {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	exploitability := 8.22 * w["AV"] * w["AC"] * pr * w["UI"]
+	if impact <= 0 {
+		return 0, nil
+	}
+	if scope == "C" {
+		return roundUp(math.Min(1.08*(impact+exploitability), 10)), nil
+	}
+	return roundUp(math.Min(impact+exploitability, 10)), nil
+}
+
+// severityScore approximates a CVSS score from a scanner severity
+func severityScore(severity string) float64 {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return 9.0
+	case "high":
+		return 7.5
+	case "medium":
+		return 5.0
+	case "low":
+		return 2.5
+	}
+	return 0
+}
+
+// Score returns the vulnerability severity score of a CVE on a 0-10 scale,
+// i.e. CVSS weighted by EPSS percentile unless the CVE is known exploited
+func (v Vulnerability) Score() float64 {
+	score := v.CVSSScore
+	if score == 0 {
+		score = severityScore(v.Severity)
+	}
+	if !v.KnownExploited && v.EPSSPercentile > 0 {
+		score *= 0.5 + 0.5*v.EPSSPercentile
+	}
+	return math.Round(score*100) / 100
+}
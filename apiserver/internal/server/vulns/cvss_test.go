@@ -0,0 +1,67 @@
+package vulns
+
+import "testing"
+
+func TestCVSSBaseScore(t *testing.T) {
+	tests := []struct {
+		vector string
+		score  float64
+	}{
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", 9.8},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", 10.0},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", 6.1},
+		{"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", 7.8},
+		{"CVSS:3.0/AV:N/AC:H/PR:N/UI:N/S:U/C:H/I:N/A:N", 5.9},
+		{"CVSS:3.1/AV:P/AC:H/PR:H/UI:R/S:U/C:L/I:N/A:N", 1.6},
+		{"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N", 0},
+		{" CVSS:3.1/C:H/I:H/A:H/AV:N/AC:L/PR:N/UI:N/S:U ", 9.8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.vector, func(t *testing.T) {
+			score, err := CVSSBaseScore(tt.vector)
+			if err != nil || score != tt.score {
+				t.Fatalf("expecting %v, got %v %v", tt.score, score, err)
+			}
+		})
+	}
+}
+
+func TestCVSSBaseScoreInvalid(t *testing.T) {
+	for _, vector := range []string{
+		"",
+		"CVSS:2.0/AV:N/AC:L/Au:N/C:P/I:P/A:P/E:U/RL:OF",
+		"AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H",
+		"CVSS:3.1/AVN/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:X/C:H/I:H/A:H",
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/E:H",
+	} {
+		t.Run(vector, func(t *testing.T) {
+			if score, err := CVSSBaseScore(vector); err == nil {
+				t.Fatalf("expecting invalid vector, got %v", score)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name  string
+		v     Vulnerability
+		score float64
+	}{
+		{"cvss", Vulnerability{CVSSScore: 9.8}, 9.8},
+		{"severity", Vulnerability{Severity: "HIGH"}, 7.5},
+		{"unknown severity", Vulnerability{Severity: "negligible"}, 0},
+		{"epss weighted", Vulnerability{CVSSScore: 8, EPSSPercentile: 0.5}, 6},
+		{"known exploited", Vulnerability{CVSSScore: 8, EPSSPercentile: 0.5, KnownExploited: true}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if score := tt.v.Score(); score != tt.score {
+				t.Fatalf("expecting %v, got %v", tt.score, score)
+			}
+		})
+	}
+}
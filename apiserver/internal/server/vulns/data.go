@@ -0,0 +1,168 @@
+package vulns
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// openFile opens a local data file, transparently decompressing .gz files
+func openFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+// LoadEPSS loads a FIRST EPSS CSV file with cve,epss,percentile columns
+func LoadEPSS(path string) (map[string]EPSS, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	epss := make(map[string]EPSS)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "cve,") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		score, err1 := strconv.ParseFloat(fields[1], 64)
+		pct, err2 := strconv.ParseFloat(fields[2], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		epss[strings.ToUpper(fields[0])] = EPSS{Score: score, Percentile: pct}
+	}
+	return epss, scanner.Err()
+}
+
+// LoadCVSS loads a CSV file of cve,vector CVSS v3 vectors
+func LoadCVSS(path string) (map[string]string, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	cvss := make(map[string]string)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 || !strings.HasPrefix(rec[1], "CVSS:") {
+			continue
+		}
+		cvss[strings.ToUpper(strings.TrimSpace(rec[0]))] = strings.TrimSpace(rec[1])
+	}
+	return cvss, nil
+}
+
+// LoadKEV loads the CISA known exploited vulnerabilities JSON catalog
+func LoadKEV(path string) (map[string]bool, error) {
+	f, err := openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var catalog struct {
+		Vulnerabilities []struct {
+			CveID string `json:"cveID"`
+		} `json:"vulnerabilities"`
+	}
+	if err := json.NewDecoder(f).Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("LoadKEV: %v", err)
+	}
+	kev := make(map[string]bool, len(catalog.Vulnerabilities))
+	for _, v := range catalog.Vulnerabilities {
+		kev[strings.ToUpper(v.CveID)] = true
+	}
+	return kev, nil
+}
+
+// LoadData loads local vulnerability data files, skipping empty paths and
+// logging files that fail to load
+func LoadData(cvssPath, epssPath, kevPath string) *Data {
+	d := &Data{
+		CVSS: map[string]string{},
+		EPSS: map[string]EPSS{},
+		KEV:  map[string]bool{},
+	}
+	if cvssPath != "" {
+		if cvss, err := LoadCVSS(cvssPath); err != nil {
+			log.Printf("unable to load CVSS data %v: %v\n", cvssPath, err)
+		} else {
+			d.CVSS = cvss
+			log.Printf("loaded %v CVSS vectors\n", len(cvss))
+		}
+	}
+	if epssPath != "" {
+		if epss, err := LoadEPSS(epssPath); err != nil {
+			log.Printf("unable to load EPSS data %v: %v\n", epssPath, err)
+		} else {
+			d.EPSS = epss
+			log.Printf("loaded %v EPSS scores\n", len(epss))
+		}
+	}
+	if kevPath != "" {
+		if kev, err := LoadKEV(kevPath); err != nil {
+			log.Printf("unable to load KEV catalog %v: %v\n", kevPath, err)
+		} else {
+			d.KEV = kev
+			log.Printf("loaded %v known exploited vulnerabilities\n", len(kev))
+		}
+	}
+	return d
+}
+
+// enrich fills CVSS, EPSS and known exploited data of a vulnerability
+func (d *Data) enrich(v *Vulnerability) {
+	id := strings.ToUpper(v.ID)
+	if vector, ok := d.CVSS[id]; ok {
+		v.CVSSVector = vector
+	}
+	if v.CVSSVector != "" {
+		if score, err := CVSSBaseScore(v.CVSSVector); err == nil {
+			v.CVSSScore = score
+		}
+	}
+	if e, ok := d.EPSS[id]; ok {
+		v.EPSS, v.EPSSPercentile = e.Score, e.Percentile
+	}
+	if d.KEV[id] {
+		v.KnownExploited = true
+	}
+}
@@ -0,0 +1,113 @@
+package vulns
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+const (
+	// kind of vulnerability child entities
+	KIND_VULNERABILITY = "vulnerability"
+
+	// vulnerability child entity attributes
+	ATTR_CVE               = "CVE"
+	ATTR_PACKAGE           = "Package"
+	ATTR_INSTALLED_VERSION = "InstalledVersion"
+	ATTR_FIXED_VERSION     = "FixedVersion"
+	ATTR_SEVERITY          = "Severity"
+	ATTR_CVSS_VECTOR       = "CVSSVector"
+	ATTR_CVSS_SCORE        = "CVSSScore"
+	ATTR_EPSS              = "EPSS"
+	ATTR_EPSS_PERCENTILE   = "EPSSPercentile"
+	ATTR_KNOWN_EXPLOITED   = "KnownExploited"
+	ATTR_SCANNER           = "Scanner"
+	ATTR_SCORE             = "Score"
+)
+
+// IsScannable returns true for entities scanners report vulnerabilities of,
+// i.e. compute instances and containers
+func IsScannable(e graph.Entity) bool {
+	return strings.Contains(e.Kind, "instance") || strings.Contains(e.Kind, "container")
+}
+
+// getVulnerabilityId returns id of a vulnerability child entity, unique
+// across entities and packages
+func getVulnerabilityId(eid string, v Vulnerability) string {
+	return eid + ":" + v.ID + ":" + v.Package
+}
+
+// formatFloat formats a score attribute
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ToEntity returns a child entity recording a vulnerability of an entity
+func ToEntity(eid string, v Vulnerability) graph.Entity {
+	return graph.Entity{
+		ID:          getVulnerabilityId(eid, v),
+		Name:        v.ID,
+		Description: strings.TrimSpace(v.Package + " " + v.InstalledVersion),
+		Kind:        KIND_VULNERABILITY,
+		Attributes: map[string]string{
+			ATTR_CVE:               v.ID,
+			ATTR_PACKAGE:           v.Package,
+			ATTR_INSTALLED_VERSION: v.InstalledVersion,
+			ATTR_FIXED_VERSION:     v.FixedVersion,
+			ATTR_SEVERITY:          v.Severity,
+			ATTR_CVSS_VECTOR:       v.CVSSVector,
+			ATTR_CVSS_SCORE:        formatFloat(v.CVSSScore),
+			ATTR_EPSS:              formatFloat(v.EPSS),
+			ATTR_EPSS_PERCENTILE:   formatFloat(v.EPSSPercentile),
+			ATTR_KNOWN_EXPLOITED:   strconv.FormatBool(v.KnownExploited),
+			ATTR_SCANNER:           v.Scanner,
+			ATTR_SCORE:             formatFloat(v.Score()),
+		},
+		Entities: []graph.Entity{},
+	}
+}
+
+// FromEntity returns the vulnerability recorded by a child entity
+func FromEntity(e graph.Entity) (Vulnerability, bool) {
+	if e.Kind != KIND_VULNERABILITY {
+		return Vulnerability{}, false
+	}
+	v := Vulnerability{
+		ID:               e.Attributes[ATTR_CVE],
+		Package:          e.Attributes[ATTR_PACKAGE],
+		InstalledVersion: e.Attributes[ATTR_INSTALLED_VERSION],
+		FixedVersion:     e.Attributes[ATTR_FIXED_VERSION],
+		Severity:         e.Attributes[ATTR_SEVERITY],
+		CVSSVector:       e.Attributes[ATTR_CVSS_VECTOR],
+		KnownExploited:   e.Attributes[ATTR_KNOWN_EXPLOITED] == "true",
+		Scanner:          e.Attributes[ATTR_SCANNER],
+	}
+	v.CVSSScore, _ = strconv.ParseFloat(e.Attributes[ATTR_CVSS_SCORE], 64)
+	v.EPSS, _ = strconv.ParseFloat(e.Attributes[ATTR_EPSS], 64)
+	v.EPSSPercentile, _ = strconv.ParseFloat(e.Attributes[ATTR_EPSS_PERCENTILE], 64)
+	v.RiskScore = v.Score()
+	return v, true
+}
+
+// EntityVulnerabilities returns vulnerabilities recorded on an entity
+func EntityVulnerabilities(e graph.Entity) []Vulnerability {
+	vulns := []Vulnerability{}
+	for _, child := range e.Entities {
+		if v, ok := FromEntity(child); ok {
+			vulns = append(vulns, v)
+		}
+	}
+	return vulns
+}
+
+// MaxScore returns the highest vulnerability score of an entity
+func MaxScore(e graph.Entity) float64 {
+	score := 0.0
+	for _, v := range EntityVulnerabilities(e) {
+		if s := v.Score(); s > score {
+			score = s
+		}
+	}
+	return score
+}
@@ -0,0 +1,194 @@
+package vulns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// supported scanner formats
+	FORMAT_TRIVY     = "trivy"
+	FORMAT_GRYPE     = "grype"
+	FORMAT_INSPECTOR = "inspector"
+)
+
+// trivy JSON report, schema version 2
+type trivyReport struct {
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			CVSS             map[string]struct {
+				V3Vector string  `json:"V3Vector"`
+				V3Score  float64 `json:"V3Score"`
+			} `json:"CVSS"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// grype JSON report
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID       string `json:"id"`
+			Severity string `json:"severity"`
+			CVSS     []struct {
+				Version string `json:"version"`
+				Vector  string `json:"vector"`
+				Metrics struct {
+					BaseScore float64 `json:"baseScore"`
+				} `json:"metrics"`
+			} `json:"cvss"`
+			Fix struct {
+				Versions []string `json:"versions"`
+			} `json:"fix"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+// Amazon Inspector v2 findings
+type inspectorReport struct {
+	Findings []struct {
+		Type                        string `json:"type"`
+		Severity                    string `json:"severity"`
+		ExploitAvailable            string `json:"exploitAvailable"`
+		PackageVulnerabilityDetails struct {
+			VulnerabilityID string `json:"vulnerabilityId"`
+			CVSS            []struct {
+				BaseScore     float64 `json:"baseScore"`
+				ScoringVector string  `json:"scoringVector"`
+				Version       string  `json:"version"`
+			} `json:"cvss"`
+			VulnerablePackages []struct {
+				Name           string `json:"name"`
+				Version        string `json:"version"`
+				FixedInVersion string `json:"fixedInVersion"`
+			} `json:"vulnerablePackages"`
+		} `json:"packageVulnerabilityDetails"`
+		Resources []struct {
+			ID string `json:"id"`
+		} `json:"resources"`
+		EPSS struct {
+			Score float64 `json:"score"`
+		} `json:"epss"`
+	} `json:"findings"`
+}
+
+// DetectFormat guesses the scanner format of a JSON report
+func DetectFormat(data []byte) (string, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return "", err
+	}
+	switch {
+	case keys["Results"] != nil || keys["SchemaVersion"] != nil:
+		return FORMAT_TRIVY, nil
+	case keys["matches"] != nil:
+		return FORMAT_GRYPE, nil
+	case keys["findings"] != nil:
+		return FORMAT_INSPECTOR, nil
+	}
+	return "", fmt.Errorf("unknown scanner report format")
+}
+
+// ParseReport parses vulnerabilities of a scanner JSON report
+func ParseReport(format string, data []byte) ([]Vulnerability, error) {
+	vulns := []Vulnerability{}
+	switch strings.ToLower(format) {
+	case FORMAT_TRIVY:
+		var report trivyReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, err
+		}
+		for _, res := range report.Results {
+			for _, tv := range res.Vulnerabilities {
+				v := Vulnerability{
+					ID:               tv.VulnerabilityID,
+					Package:          tv.PkgName,
+					InstalledVersion: tv.InstalledVersion,
+					FixedVersion:     tv.FixedVersion,
+					Severity:         strings.ToLower(tv.Severity),
+				}
+				// prefer NVD, then any vendor vector
+				for _, src := range []string{"nvd", ""} {
+					for name, c := range tv.CVSS {
+						if (src == "" || name == src) && c.V3Vector != "" && v.CVSSVector == "" {
+							v.CVSSVector, v.CVSSScore = c.V3Vector, c.V3Score
+						}
+					}
+				}
+				vulns = append(vulns, v)
+			}
+		}
+	case FORMAT_GRYPE:
+		var report grypeReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, err
+		}
+		for _, m := range report.Matches {
+			v := Vulnerability{
+				ID:               m.Vulnerability.ID,
+				Package:          m.Artifact.Name,
+				InstalledVersion: m.Artifact.Version,
+				FixedVersion:     strings.Join(m.Vulnerability.Fix.Versions, ","),
+				Severity:         strings.ToLower(m.Vulnerability.Severity),
+			}
+			for _, c := range m.Vulnerability.CVSS {
+				if strings.HasPrefix(c.Version, "3") && v.CVSSVector == "" {
+					v.CVSSVector, v.CVSSScore = c.Vector, c.Metrics.BaseScore
+				}
+			}
+			vulns = append(vulns, v)
+		}
+	case FORMAT_INSPECTOR:
+		var report inspectorReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, err
+		}
+		for _, f := range report.Findings {
+			if f.Type != "" && f.Type != "PACKAGE_VULNERABILITY" {
+				continue
+			}
+			d := f.PackageVulnerabilityDetails
+			base := Vulnerability{
+				ID:             d.VulnerabilityID,
+				Severity:       strings.ToLower(f.Severity),
+				EPSS:           f.EPSS.Score,
+				KnownExploited: f.ExploitAvailable == "YES",
+			}
+			for _, c := range d.CVSS {
+				if strings.HasPrefix(c.Version, "3") && base.CVSSVector == "" {
+					base.CVSSVector, base.CVSSScore = c.ScoringVector, c.BaseScore
+				}
+			}
+			pkgs := d.VulnerablePackages
+			if len(pkgs) == 0 {
+				pkgs = append(pkgs, struct {
+					Name           string `json:"name"`
+					Version        string `json:"version"`
+					FixedInVersion string `json:"fixedInVersion"`
+				}{})
+			}
+			for _, res := range f.Resources {
+				for _, pkg := range pkgs {
+					v := base
+					v.Package, v.InstalledVersion, v.FixedVersion = pkg.Name, pkg.Version, pkg.FixedInVersion
+					v.Resource = res.ID
+					vulns = append(vulns, v)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scanner format %v", format)
+	}
+	return vulns, nil
+}
@@ -0,0 +1,94 @@
+package vulns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		vulns  []Vulnerability
+	}{
+		{"trivy", FORMAT_TRIVY, `{"SchemaVersion": 2, "Results": [{"Target": "img", "Vulnerabilities": [{
+			"VulnerabilityID": "CVE-2024-1", "PkgName": "openssl", "InstalledVersion": "3.0.1", "FixedVersion": "3.0.2", "Severity": "HIGH",
+			"CVSS": {"ghsa": {"V3Vector": "CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", "V3Score": 7.8},
+				"nvd": {"V3Vector": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", "V3Score": 9.8}}}]}]}`,
+			[]Vulnerability{{ID: "CVE-2024-1", Package: "openssl", InstalledVersion: "3.0.1", FixedVersion: "3.0.2", Severity: "high",
+				CVSSVector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", CVSSScore: 9.8}}},
+		{"trivy without vulnerabilities", "Trivy", `{"Results": [{"Target": "img"}]}`, []Vulnerability{}},
+		{"grype", FORMAT_GRYPE, `{"matches": [{"vulnerability": {"id": "CVE-2024-2", "severity": "Medium",
+			"cvss": [{"version": "2.0", "vector": "AV:N/AC:L/Au:N/C:P/I:N/A:N", "metrics": {"baseScore": 5}},
+				{"version": "3.1", "vector": "CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:H/I:N/A:N", "metrics": {"baseScore": 5.9}}],
+			"fix": {"versions": ["1.2", "2.1"]}}, "artifact": {"name": "zlib", "version": "1.1"}}]}`,
+			[]Vulnerability{{ID: "CVE-2024-2", Package: "zlib", InstalledVersion: "1.1", FixedVersion: "1.2,2.1", Severity: "medium",
+				CVSSVector: "CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:H/I:N/A:N", CVSSScore: 5.9}}},
+		{"inspector", FORMAT_INSPECTOR, `{"findings": [
+			{"type": "NETWORK_REACHABILITY", "severity": "HIGH", "resources": [{"id": "i-1"}]},
+			{"type": "PACKAGE_VULNERABILITY", "severity": "CRITICAL", "exploitAvailable": "YES", "epss": {"score": 0.9},
+				"packageVulnerabilityDetails": {"vulnerabilityId": "CVE-2024-3",
+					"cvss": [{"baseScore": 9.8, "scoringVector": "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", "version": "3.1"}],
+					"vulnerablePackages": [{"name": "bash", "version": "5.0", "fixedInVersion": "5.1"}, {"name": "sh", "version": "1.0"}]},
+				"resources": [{"id": "i-1"}, {"id": "i-2"}]},
+			{"severity": "LOW", "packageVulnerabilityDetails": {"vulnerabilityId": "CVE-2024-4"}, "resources": [{"id": "i-3"}]}]}`,
+			[]Vulnerability{
+				{ID: "CVE-2024-3", Package: "bash", InstalledVersion: "5.0", FixedVersion: "5.1", Severity: "critical", EPSS: 0.9, KnownExploited: true,
+					CVSSVector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", CVSSScore: 9.8, Resource: "i-1"},
+				{ID: "CVE-2024-3", Package: "sh", InstalledVersion: "1.0", Severity: "critical", EPSS: 0.9, KnownExploited: true,
+					CVSSVector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", CVSSScore: 9.8, Resource: "i-1"},
+				{ID: "CVE-2024-3", Package: "bash", InstalledVersion: "5.0", FixedVersion: "5.1", Severity: "critical", EPSS: 0.9, KnownExploited: true,
+					CVSSVector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", CVSSScore: 9.8, Resource: "i-2"},
+				{ID: "CVE-2024-3", Package: "sh", InstalledVersion: "1.0", Severity: "critical", EPSS: 0.9, KnownExploited: true,
+					CVSSVector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", CVSSScore: 9.8, Resource: "i-2"},
+				{ID: "CVE-2024-4", Severity: "low", Resource: "i-3"},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vulns, err := ParseReport(tt.format, []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(vulns, tt.vulns) {
+				t.Fatalf("expecting %+v, got %+v", tt.vulns, vulns)
+			}
+
+			// the format is detected from the report
+			if format, err := DetectFormat([]byte(tt.data)); err != nil || format != strings.ToLower(tt.format) {
+				t.Fatalf("expecting format %v detected, got %v %v", tt.format, format, err)
+			}
+		})
+	}
+}
+
+func TestParseReportInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{"malformed trivy", FORMAT_TRIVY, `{"Results": [`},
+		{"trivy of wrong types", FORMAT_TRIVY, `{"Results": {"Target": "img"}}`},
+		{"malformed grype", FORMAT_GRYPE, `{"matches": [{"vulnerability": }]}`},
+		{"grype of wrong types", FORMAT_GRYPE, `{"matches": [{"vulnerability": {"fix": {"versions": "1.2"}}}]}`},
+		{"malformed inspector", FORMAT_INSPECTOR, `not json`},
+		{"inspector of wrong types", FORMAT_INSPECTOR, `{"findings": [{"epss": {"score": "high"}}]}`},
+		{"unknown format", "snyk", `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if vulns, err := ParseReport(tt.format, []byte(tt.data)); err == nil {
+				t.Fatalf("expecting error, got %+v", vulns)
+			}
+		})
+	}
+
+	for _, data := range []string{`[]`, `{"vulnerabilities": []}`, `{`} {
+		if format, err := DetectFormat([]byte(data)); err == nil {
+			t.Fatalf("%v: expecting unknown format, got %v", data, format)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package vulns
+
+import (
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+)
+
+// Vulns represents vulnerability ingestion and enrichment of app entities
+type Vulns struct {
+	db   db.Db
+	data *Data
+}
+
+// Data holds local CVSS, EPSS and known exploited vulnerability data
+type Data struct {
+	CVSS map[string]string // CVE -> CVSS v3 vector
+	EPSS map[string]EPSS   // CVE -> EPSS score
+	KEV  map[string]bool   // CVE -> known exploited
+}
+
+// EPSS is the exploit prediction score of a CVE
+type EPSS struct {
+	Score      float64 `json:"score"`
+	Percentile float64 `json:"percentile"`
+}
+
+// Vulnerability is a CVE found by a scanner on a package of an entity
+type Vulnerability struct {
+	ID               string  `json:"id"`
+	Package          string  `json:"package"`
+	InstalledVersion string  `json:"installedVersion"`
+	FixedVersion     string  `json:"fixedVersion"`
+	Severity         string  `json:"severity"`
+	CVSSVector       string  `json:"cvssVector"`
+	CVSSScore        float64 `json:"cvssScore"`
+	EPSS             float64 `json:"epss"`
+	EPSSPercentile   float64 `json:"epssPercentile"`
+	KnownExploited   bool    `json:"knownExploited"`
+	Scanner          string  `json:"scanner"`
+	Resource         string  `json:"resource,omitempty"` // scanned resource id, if reported
+	RiskScore        float64 `json:"riskScore"`          // see Score()
+}
+
+// ImportResult summarizes a scanner import
+type ImportResult struct {
+	App            string         `json:"app"`
+	Format         string         `json:"format"`
+	Entities       map[string]int `json:"entities"` // entity id -> vulnerabilities attached
+	Unmatched      int            `json:"unmatched"`
+	KnownExploited int            `json:"knownExploited"`
+}
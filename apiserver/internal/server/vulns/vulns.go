@@ -0,0 +1,203 @@
+package vulns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/zetafence/zentaris/apiserver/internal/server/db"
+	"github.com/zetafence/zentaris/apiserver/internal/server/graph"
+)
+
+// entity attributes matched against scanner reported resource ids
+var resourceAttributes = []string{"InstanceId", "Arn", "ImageId", "ContainerId"}
+
+// NewVulns returns a new Vulns object enriching CVEs with local data
+func NewVulns(db db.Db, data *Data) *Vulns {
+	if data == nil {
+		data = LoadData("", "", "")
+	}
+	return &Vulns{
+		db:   db,
+		data: data,
+	}
+}
+
+// getEntity returns an entity of an app
+func (v *Vulns) getEntity(aid, eid string) (graph.Entity, error) {
+	e, err := graph.Entities(v.db).Get(graph.GetEntityKey(aid, eid))
+	if errors.Is(err, db.ErrRecord) {
+		return graph.Entity{}, err
+	}
+	if err != nil {
+		return graph.Entity{}, fmt.Errorf("entity %v not found", eid)
+	}
+	return e, nil
+}
+
+// matchResource returns id of the scannable entity of an app matching a
+// scanner reported resource id
+func matchResource(h *graph.Hypergraph, resource string) (string, bool) {
+	if e, ok := h.Entities[resource]; ok && IsScannable(e) {
+		return resource, true
+	}
+	for id, e := range h.Entities {
+		if !IsScannable(e) {
+			continue
+		}
+		for _, attr := range resourceAttributes {
+			if e.Attributes[attr] == resource {
+				return id, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Import attaches vulnerabilities of a scanner report onto instance and
+// container entities of an app, replacing vulnerabilities those entities
+// had from the same scanner. Reports without resource ids are attached to
+// entity eid.
+func (v *Vulns) Import(aid, eid, format string, data []byte) (ImportResult, error) {
+	var err error
+	if format == "" {
+		if format, err = DetectFormat(data); err != nil {
+			return ImportResult{}, err
+		}
+	}
+	found, err := ParseReport(format, data)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if eid != "" {
+		e, err := v.getEntity(aid, eid)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		if !IsScannable(e) {
+			return ImportResult{}, fmt.Errorf("entity %v of kind %v is not an instance or container", eid, e.Kind)
+		}
+	}
+
+	result := ImportResult{
+		App:      aid,
+		Format:   strings.ToLower(format),
+		Entities: map[string]int{},
+	}
+	h := graph.LoadHypergraph(v.db, aid)
+	attached := map[string]map[string]Vulnerability{} // entity id -> child id -> CVE
+	for _, vuln := range found {
+		target := eid
+		if vuln.Resource != "" && eid == "" {
+			var ok bool
+			if target, ok = matchResource(h, vuln.Resource); !ok {
+				result.Unmatched++
+				continue
+			}
+		}
+		if target == "" {
+			result.Unmatched++
+			continue
+		}
+		vuln.Resource, vuln.Scanner = "", result.Format
+		v.data.enrich(&vuln)
+		if _, ok := attached[target]; !ok {
+			attached[target] = map[string]Vulnerability{}
+		}
+		attached[target][getVulnerabilityId(target, vuln)] = vuln
+	}
+
+	for target, vulns := range attached {
+		e, err := v.getEntity(aid, target)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		children := []graph.Entity{}
+		for _, child := range e.Entities {
+			if child.Kind != KIND_VULNERABILITY || child.Attributes[ATTR_SCANNER] != result.Format {
+				children = append(children, child)
+			}
+		}
+		ids := make([]string, 0, len(vulns))
+		for id := range vulns {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			children = append(children, ToEntity(target, vulns[id]))
+			if vulns[id].KnownExploited {
+				result.KnownExploited++
+			}
+		}
+		e.Entities = children
+		graph.Entities(v.db).Put(e.ID, e)
+		result.Entities[target] = len(ids)
+	}
+	return result, nil
+}
+
+// ImportVulns is POST handler to import a Trivy, Grype or Amazon Inspector
+// JSON report onto app entities
+func (v *Vulns) ImportVulns(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars   = mux.Vars(r)
+		aid    = vars["id"]
+		eid    = r.URL.Query().Get("entity")
+		format = r.URL.Query().Get("format")
+	)
+
+	if _, err := v.db.Get(graph.DB_TABLE_GRAPH, aid); err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := v.Import(aid, eid, format, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("new %v vulnerabilities on %v entities of app %v\n", result.Format, len(result.Entities), aid)
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetEntityVulns is GET handler to retrieve vulnerabilities of an entity,
+// highest score first
+func (v *Vulns) GetEntityVulns(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		aid  = vars["aid"]
+		eid  = vars["eid"]
+	)
+
+	e, err := v.getEntity(aid, eid)
+	if err != nil {
+		http.Error(w, "Data not found", http.StatusNotFound)
+		return
+	}
+	vulns := EntityVulnerabilities(e)
+	sort.SliceStable(vulns, func(i, j int) bool {
+		if vulns[i].RiskScore != vulns[j].RiskScore {
+			return vulns[i].RiskScore > vulns[j].RiskScore
+		}
+		return vulns[i].ID < vulns[j].ID
+	})
+
+	// return JSON response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vulns)
+}
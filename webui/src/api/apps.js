@@ -1,33 +1,52 @@
-import { GetDefaultOrg, GetDefaultHttpAgent, GetDefaultHeaders, BackendServer } from "./common";
+import { GetDefaultOrg, GetDefaultHttpAgent, GetDefaultHeaders, BackendServer, DefaultGroup } from "./common";
 const util = require('util');
 
 const DefaultOrg = GetDefaultOrg();
 
-// URL constants
-const _getAppUrl = "https://%s/v1/apps",
-    _getAttackGraphUrl = "https://%s/v1/attackGraphs",
-    _getAppEntitiesUrl = "https://%s/v1/app/%s/entities",
-    _getAppAssocsUrl = "https://%s/v1/app/%s/assocs",
-    _evalAppUrl = "https://%s/v1/app/%s/eval";
+// URL constants, scoped by tenant org and group
+const _getAppUrl = "https://%s/v1/org/%s/group/%s/apps",
+    _getAttackGraphUrl = "https://%s/v1/org/%s/group/%s/attackGraphs",
+    _getAppEntitiesUrl = "https://%s/v1/org/%s/group/%s/app/%s/entities",
+    _getAppAssocsUrl = "https://%s/v1/org/%s/group/%s/app/%s/assocs",
+    _evalAppUrl = "https://%s/v1/org/%s/group/%s/app/%s/eval",
+    _appTrendUrl = "https://%s/v1/org/%s/group/%s/app/%s/trend",
+    _eventsUrl = "https://%s/v1/org/%s/group/%s/events";
+
+// org used when no tenant is set
+const _noOrg = "noorg";
+
+// page size of list requests
+const _pageSize = 5000;
+
+// reconnection delay of change event streams, until told by the server
+const _eventsRetryMs = 3000;
 
 export function getappUrl(org, group) {
-    return util.format(_getAppUrl, BackendServer);
+    return util.format(_getAppUrl, BackendServer, org || _noOrg, group || DefaultGroup);
 }
 
 export function getAttackGraphUrl(org, group) {
-    return util.format(_getAttackGraphUrl, BackendServer);
+    return util.format(_getAttackGraphUrl, BackendServer, org || _noOrg, group || DefaultGroup);
 }
 
 export function getAppEntitiesUrl(org, group, appId) {
-    return util.format(_getAppEntitiesUrl, BackendServer, appId);
+    return util.format(_getAppEntitiesUrl, BackendServer, org || _noOrg, group || DefaultGroup, appId);
 }
 
 export function getAppAssocsUrl(org, group, appId) {
-    return util.format(_getAppAssocsUrl, BackendServer, appId);
+    return util.format(_getAppAssocsUrl, BackendServer, org || _noOrg, group || DefaultGroup, appId);
 }
 
 export function getAppEvalUrl(org, group, appId) {
-    return util.format(_evalAppUrl, BackendServer, appId);
+    return util.format(_evalAppUrl, BackendServer, org || _noOrg, group || DefaultGroup, appId);
+}
+
+export function getAppTrendUrl(org, group, appId) {
+    return util.format(_appTrendUrl, BackendServer, org || _noOrg, group || DefaultGroup, appId);
+}
+
+export function getEventsUrl(org, group) {
+    return util.format(_eventsUrl, BackendServer, org || _noOrg, group || DefaultGroup);
 }
 
 // GetAppDataFromJSON obtains a list of apps from given JSON
@@ -75,47 +94,50 @@ export async function FetchAppsData(group, isAttackGraph) {
     return apiData;
 };
 
-// FetchAppEntitiesData fetches all app entities async
-export async function FetchAppEntitiesData(group, appId) {
-    const dUrl = getAppEntitiesUrl(DefaultOrg, group, appId);
-    try {
-        var response = await fetch(dUrl, {
+// FetchAppPages fetches all pages of a list async, following next page cursors
+async function FetchAppPages(dUrl, listKey) {
+    var data = { total: 0 },
+        cursor = "";
+    data[listKey] = [];
+    do {
+        var pageUrl = `${dUrl}?limit=${_pageSize}` + (cursor ? `&cursor=${encodeURIComponent(cursor)}` : "");
+        var response = await fetch(pageUrl, {
             method: 'GET',
             credentials: 'omit',
             mode: 'cors',
             headers: GetDefaultHeaders(),
             agent: GetDefaultHttpAgent()
         });
+        var page = await response.json();
+        data[listKey] = data[listKey].concat(page[listKey] || []);
+        data.total = page.total;
+        cursor = page.nextCursor;
+    } while (cursor);
+    return data;
+};
+
+// FetchAppEntitiesData fetches all app entities async
+export async function FetchAppEntitiesData(group, appId) {
+    const dUrl = getAppEntitiesUrl(DefaultOrg, group, appId);
+    try {
+        return await FetchAppPages(dUrl, "entities");
     } catch (err) {
         var errStr = `unable to fetch apps for app ${appId}`;
         window.open(`/error?msg=${errStr}`, "_self");
         return null;
     }
-
-    var entitiesAPIData = await response.json();
-    return entitiesAPIData;
 };
 
 // FetchAppAssocsData fetches all app associations async
 export async function FetchAppAssocsData(group, appId) {
     const dUrl = getAppAssocsUrl(DefaultOrg, group, appId);
-
     try {
-        var response = await fetch(dUrl, {
-            method: 'GET',
-            credentials: 'omit',
-            mode: 'cors',
-            headers: GetDefaultHeaders(),
-            agent: GetDefaultHttpAgent()
-        });
+        return await FetchAppPages(dUrl, "assocs");
     } catch (err) {
         var errStr = `unable to fetch apps associations for ${appId}`;
         window.open(`/error?msg=${errStr}`, "_self");
         return null;
     }
-
-    var assocsAPIData = await response.json();
-    return assocsAPIData;
 };
 
 // EvalApp traverse an app entirely
@@ -136,6 +158,40 @@ export async function EvalApp(group, appId, schedule, from, attributes) {
     return evalApiData;
 }
 
+// FetchAppTrend fetches app risk evaluation history async, from and to are
+// optional RFC3339 or unix seconds time range
+export async function FetchAppTrend(group, appId, from, to) {
+    var dUrl = getAppTrendUrl(DefaultOrg, group, appId),
+        params = new URLSearchParams();
+
+    if (from !== undefined && from !== null) {
+        params.set("from", from);
+    }
+    if (to !== undefined && to !== null) {
+        params.set("to", to);
+    }
+    if (params.toString() !== "") {
+        dUrl = `${dUrl}?${params.toString()}`;
+    }
+
+    try {
+        var response = await fetch(dUrl, {
+            method: 'GET',
+            credentials: 'omit',
+            mode: 'cors',
+            headers: GetDefaultHeaders(),
+            agent: GetDefaultHttpAgent()
+        });
+    } catch (err) {
+        var errStr = `unable to fetch risk trend for ${appId}`;
+        window.open(`/error?msg=${errStr}`, "_self");
+        return null;
+    }
+
+    var trendAPIData = await response.json();
+    return trendAPIData;
+};
+
 // CreateEntityActions creates entity actions async
 export async function CreateEntityActions(group, appId, entityId, actions) {
 }
@@ -170,3 +226,91 @@ export async function UpdateAppAssocData(group, appId, assocId, newAssoc) {
 // DeleteAppAssocData deletes a specific app association async
 export async function DeleteAppAssocData(group, appId, assocId) {
 }
+
+// WatchEvents streams change events of an app, or of all apps when appId is
+// empty, calling onEvent with each event, and reconnects until stopped. The
+// stream is read with fetch since EventSource cannot send API key headers.
+// Returns a function stopping the stream.
+export function WatchEvents(group, appId, tables, onEvent) {
+    var params = new URLSearchParams(),
+        controller = new AbortController(),
+        retryMs = _eventsRetryMs,
+        stopped = false;
+    if (appId) {
+        params.append("app", appId);
+    }
+    (tables || []).forEach(t => params.append("table", t));
+    const dUrl = getEventsUrl(DefaultOrg, group) + "?" + params.toString();
+
+    // dispatch one event frame of "field: value" lines
+    const dispatch = (frame) => {
+        var name = "message",
+            data = "";
+        frame.split("\n").forEach(line => {
+            var i = line.indexOf(":");
+            if (i <= 0) {
+                return;
+            }
+            var field = line.substring(0, i),
+                value = line.substring(i + 1).replace(/^ /, "");
+            if (field === "event") {
+                name = value;
+            } else if (field === "data") {
+                data += value;
+            } else if (field === "retry" && !isNaN(parseInt(value))) {
+                retryMs = parseInt(value);
+            }
+        });
+        if (data === "") {
+            return;
+        }
+        try {
+            onEvent(name, JSON.parse(data));
+        } catch (err) {
+            console.error('error parsing change event ', err.message);
+        }
+    };
+
+    const connect = async () => {
+        try {
+            var response = await fetch(dUrl, {
+                method: 'GET',
+                credentials: 'omit',
+                mode: 'cors',
+                headers: GetDefaultHeaders(),
+                signal: controller.signal
+            });
+            if (response.status >= 400 && response.status < 500) {
+                console.error(`unable to watch events of ${appId || "all apps"}, status ${response.status}`);
+                return;
+            }
+            var reader = response.body.getReader(),
+                decoder = new TextDecoder(),
+                buf = "";
+            for (;;) {
+                var { value, done } = await reader.read();
+                if (done) {
+                    break;
+                }
+                buf += decoder.decode(value, { stream: true }).replace(/\r\n?/g, "\n");
+                var frames = buf.split("\n\n");
+                buf = frames.pop();
+                frames.forEach(dispatch);
+            }
+        } catch (err) {
+            if (stopped) {
+                return;
+            }
+            console.error('change event stream failed ', err.message);
+        }
+        if (!stopped) {
+            setTimeout(connect, retryMs);
+        }
+    };
+    connect();
+
+    return () => {
+        stopped = true;
+        controller.abort();
+    };
+};
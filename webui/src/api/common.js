@@ -9,6 +9,31 @@ export const IsPrivateTenant = (process.env.REACT_APP_IS_PRIVATE_TENANT !== unde
 
 var DefaultOrg = process.env.REACT_APP_MY_ORG || 'noorg';
 
+// API key of development servers only, never embedded in production builds
+const DevApiKey = (process.env.NODE_ENV === 'development' && process.env.REACT_APP_API_KEY) || '';
+
+// GetApiKey gets the API key supplied by the user for the session, falling
+// back to the development API key
+export function GetApiKey() {
+    return sessionStorage.getItem('zf_api_key') || DevApiKey;
+}
+
+// SetApiKey sets the API key of the session
+export function SetApiKey(key) {
+    sessionStorage.setItem('zf_api_key', key);
+}
+
+// ReadApiKeyFromLocation reads an API key supplied as #apiKey=... URL
+// fragment, which is never sent to servers, and removes it from the location
+export function ReadApiKeyFromLocation() {
+    const params = new URLSearchParams(window.location.hash.substring(1));
+    const key = params.get('apiKey');
+    if (key) {
+        SetApiKey(key);
+        window.history.replaceState(null, '', window.location.pathname + window.location.search);
+    }
+}
+
 // SetDefaultOrg sets the default org
 export function SetDefaultOrg(org) {
     DefaultOrg = org;
@@ -67,8 +92,13 @@ export function ObjectToMap(obj) {
 // GetDefaultHeaders obtains default HTTP request headers
 export function GetDefaultHeaders() {
     require('cors');
-    return new Headers({
+    var headers = new Headers({
     });
+    const apiKey = GetApiKey();
+    if (apiKey !== '') {
+        headers.set('Authorization', 'Bearer ' + apiKey);
+    }
+    return headers;
 }
 
 // GetDefaultLoginHeaders obtains default HTTP login request headers
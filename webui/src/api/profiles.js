@@ -8,7 +8,7 @@ const DefaultOrg = GetDefaultOrg();
 const profilesUrl = "https://%s/v1/org/%s/group/%s/behavioral",
     profileUrl = "https://%s/v1/org/%s/group/%s/behavioral/%s",
     profileIncidentUrl = "https://%s/v1/org/%s/group/%s/behavioral/%s/category/%s",
-    postureUrl = "https://%s/v1/risk",
+    postureUrl = "https://%s/v1/org/%s/group/%s/risk",
     statsUrl = "https://%s/v1/org/%s/group/%s/behavioral/%s/stats/%s",
     remUrl = "https://%s/v1/org/%s/group/%s/behavioral/%s/remediation/%s",
     profileExportUrl = "https://%s/v1/org/%s/group/%s/behavioral/%s/export";
@@ -29,7 +29,7 @@ export function getProfileIncidentUrl(org, group, pid, sid) {
 }
 
 export function getPostureUrl(org, group, aid) {
-    return util.format(postureUrl, BackendServer);
+    return util.format(postureUrl, BackendServer, org || "noorg", group || "default");
 }
 
 export function getProfileStatsUrl(org, group, pid, stats) {
@@ -1,6 +1,10 @@
 import React from 'react';
 import ReactDOM from 'react-dom';
 import App from './App';
+import { ReadApiKeyFromLocation } from './api/common';
+
+// API keys are supplied at runtime, not built into the bundle
+ReadApiKeyFromLocation();
 
 ReactDOM.render(
   <React.StrictMode>
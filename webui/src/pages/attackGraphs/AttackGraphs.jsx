@@ -1,7 +1,7 @@
 import React, { Component } from "react";
 import { withRouter } from "react-router";
 import "./attackgraphs.css";
-import { FetchAppsData } from '../../api';
+import { FetchAppsData, WatchEvents } from '../../api';
 import Paper from "@mui/material/Paper";
 import Button from '@mui/material/Button';
 import MenuItem from '@mui/material/MenuItem';
@@ -229,6 +229,20 @@ class AttackGraphs extends Component {
 
     componentDidMount() {
         this.fetchApps("default");
+
+        // reload when attack graphs are built or deleted, or events were missed
+        this.stopEvents = WatchEvents("default", "", ["graph"], (name, ev) => {
+            if (name === "reset" || (ev.value && ev.value.type === "attackGraph") ||
+                (name === "delete" && ev.id !== ev.app)) {
+                this.fetchApps("default");
+            }
+        });
+    }
+
+    componentWillUnmount() {
+        if (this.stopEvents) {
+            this.stopEvents();
+        }
     }
 
     // return Apps UX component
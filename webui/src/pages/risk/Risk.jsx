@@ -11,7 +11,7 @@ import { StyledTableCell, StyledTableRow } from "../../components/tables/Tables"
 import {
     Table, TableCell, TableBody, TableContainer, TableHead, TableRow, TablePagination, Typography
 } from '@mui/material';
-import { FetchProfilePosture } from '../../api';
+import { FetchProfilePosture, WatchEvents } from '../../api';
 
 const IGNORE_STR = "ignore";
 
@@ -49,6 +49,19 @@ class Risk extends Component {
         this.fetchPosture();
     }
 
+    componentDidMount() {
+        // reload once an evaluation of the app records a new risk trend
+        this.stopEvents = WatchEvents(this.state.group, this.state.profileId, ["trends"], () => {
+            this.fetchPosture();
+        });
+    }
+
+    componentWillUnmount() {
+        if (this.stopEvents) {
+            this.stopEvents();
+        }
+    }
+
     // fetchPosture fetches behavioral posture
     fetchPosture() {
         var prom = FetchProfilePosture(this.state.group, this.state.profileId, IGNORE_STR);